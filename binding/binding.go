@@ -0,0 +1,112 @@
+package binding
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	ginBinding "github.com/gin-gonic/gin/binding"
+	rumErrors "github.com/yangkushu/rum-go/errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const defaultMultipartMemory = 32 << 20
+
+// FieldError 字段级的校验错误，Field 使用 json tag 中的名字，嵌套字段用 . 连接，比如 items[0].name
+type FieldError struct {
+	Field   string `json:"field"`
+	Tag     string `json:"tag"`
+	Message string `json:"message"`
+}
+
+// Bind 依次把查询参数、body（JSON 或表单，按 Content-Type 判断）和路径参数绑定到 obj，然后校验
+// 查询参数和表单使用 form tag，路径参数使用 uri tag，校验规则使用 binding tag，和 gin 保持一致。
+// 绑定失败返回 errors.ErrBadRequest，校验失败返回带 []FieldError 详情的 errors.ErrValidation
+func Bind(c *gin.Context, obj interface{}) error {
+	if err := ginBinding.MapFormWithTag(obj, c.Request.URL.Query(), "form"); err != nil {
+		return rumErrors.ErrBadRequest.Wrap(err)
+	}
+	if err := bindBody(c, obj); err != nil {
+		return err
+	}
+	if len(c.Params) > 0 {
+		params := make(map[string][]string, len(c.Params))
+		for _, param := range c.Params {
+			params[param.Key] = []string{param.Value}
+		}
+		if err := ginBinding.MapFormWithTag(obj, params, "uri"); err != nil {
+			return rumErrors.ErrBadRequest.Wrap(err)
+		}
+	}
+	return Validate(c, obj)
+}
+
+// MustBind 调用 Bind，失败时直接返回 400 并中止后续的 handler，返回是否成功
+//
+//	var req CreateOrderRequest
+//	if !binding.MustBind(c, &req) {
+//		return
+//	}
+func MustBind(c *gin.Context, obj interface{}) bool {
+	err := Bind(c, obj)
+	if err == nil {
+		return true
+	}
+	e := rumErrors.From(err)
+	_ = c.Error(err)
+	body := gin.H{"error": e.Code, "message": e.Message}
+	if e.Details != nil {
+		body["details"] = e.Details
+	}
+	c.AbortWithStatusJSON(e.Status, body)
+	return false
+}
+
+func bindBody(c *gin.Context, obj interface{}) error {
+	if c.Request.Body == nil || c.Request.Body == http.NoBody || c.Request.ContentLength == 0 {
+		return nil
+	}
+	switch c.ContentType() {
+	case gin.MIMEJSON, "":
+		err := json.NewDecoder(c.Request.Body).Decode(obj)
+		if err == nil || errors.Is(err, io.EOF) {
+			return nil
+		}
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return rumErrors.ErrValidation.Wrap(err).WithDetails([]FieldError{
+				defaultValidator.typeError(c, typeErr.Field, typeErr.Value),
+			})
+		}
+		return rumErrors.ErrBadRequest.Wrap(err)
+	case gin.MIMEPOSTForm:
+		if err := c.Request.ParseForm(); err != nil {
+			return rumErrors.ErrBadRequest.Wrap(err)
+		}
+		if err := ginBinding.MapFormWithTag(obj, c.Request.PostForm, "form"); err != nil {
+			return rumErrors.ErrBadRequest.Wrap(err)
+		}
+	case gin.MIMEMultipartPOSTForm:
+		if err := c.Request.ParseMultipartForm(defaultMultipartMemory); err != nil {
+			return rumErrors.ErrBadRequest.Wrap(err)
+		}
+		if err := ginBinding.MapFormWithTag(obj, c.Request.MultipartForm.Value, "form"); err != nil {
+			return rumErrors.ErrBadRequest.Wrap(err)
+		}
+	default:
+		if strings.HasSuffix(c.ContentType(), "+json") {
+			if err := json.NewDecoder(c.Request.Body).Decode(obj); err != nil && !errors.Is(err, io.EOF) {
+				return rumErrors.ErrBadRequest.Wrap(err)
+			}
+			return nil
+		}
+		return rumErrors.New("unsupported_media_type", http.StatusUnsupportedMediaType, "Unsupported media type")
+	}
+	return nil
+}
+
+// Validate 按请求的 Accept-Language 校验已经绑定好的结构体
+func Validate(c *gin.Context, obj interface{}) error {
+	return defaultValidator.check(c.GetHeader("Accept-Language"), obj)
+}
@@ -0,0 +1,187 @@
+package binding
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+type orderStatus string
+
+func (s orderStatus) IsValid() bool {
+	return s == "paid" || s == "shipped"
+}
+
+type orderItem struct {
+	SKU      string `json:"sku" binding:"required"`
+	Quantity int    `json:"quantity" binding:"min=1"`
+}
+
+type createOrderRequest struct {
+	ShopID  int64       `uri:"shop_id" json:"-" binding:"required"`
+	Source  string      `form:"source" json:"-" binding:"omitempty,enum=app web"`
+	Phone   string      `json:"phone" binding:"required,phone"`
+	IDCard  string      `json:"id_card" binding:"omitempty,idcard"`
+	Status  orderStatus `json:"status" binding:"enum"`
+	Items   []orderItem `json:"items" binding:"required,min=1,dive"`
+	Comment string      `json:"comment" binding:"max=5,even_length"`
+}
+
+type validationResponse struct {
+	Error   string       `json:"error"`
+	Message string       `json:"message"`
+	Details []FieldError `json:"details"`
+}
+
+func TestMain(m *testing.M) {
+	err := RegisterValidation("even_length", func(fl validator.FieldLevel) bool {
+		return len(fl.Field().String())%2 == 0
+	}, map[string]string{LocaleEN: "{0} must have an even length", LocaleZH: "{0}长度必须是偶数"})
+	if err != nil {
+		panic(err)
+	}
+	m.Run()
+}
+
+func newBindingEngine(got *createOrderRequest) *gin.Engine {
+	r := gin.New()
+	r.POST("/shops/:shop_id/orders", func(c *gin.Context) {
+		if !MustBind(c, got) {
+			return
+		}
+		c.Status(http.StatusCreated)
+	})
+	return r
+}
+
+func postOrder(r *gin.Engine, path, body, acceptLanguage string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept-Language", acceptLanguage)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestBindSuccess(t *testing.T) {
+	var got createOrderRequest
+	r := newBindingEngine(&got)
+	body := `{"phone":"+8613800138000","id_card":"11010519491231002X","status":"paid","items":[{"sku":"a","quantity":2}],"comment":"ok"}`
+	w := postOrder(r, "/shops/42/orders?source=app", body, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if got.ShopID != 42 || got.Source != "app" || got.Items[0].Quantity != 2 {
+		t.Errorf("unexpected bound value %+v", got)
+	}
+}
+
+func TestBindValidationMessages(t *testing.T) {
+	r := newBindingEngine(&createOrderRequest{})
+	body := `{"phone":"123","id_card":"110105194912310021","status":"unknown","items":[{"quantity":0}],"comment":"odd"}`
+
+	tests := []struct {
+		acceptLanguage string
+		messages       map[string]string
+	}{
+		{"en-US", map[string]string{
+			"source":            "source must be one of the allowed values",
+			"phone":             "phone must be a valid mobile phone number",
+			"id_card":           "id_card must be a valid ID card number",
+			"status":            "status must be one of the allowed values",
+			"items[0].sku":      "sku is a required field",
+			"items[0].quantity": "quantity must be 1 or greater",
+			"comment":           "comment must have an even length",
+		}},
+		{"zh-CN,zh;q=0.9", map[string]string{
+			"phone":             "phone必须是有效的手机号码",
+			"items[0].sku":      "sku为必填字段",
+			"items[0].quantity": "quantity最小只能为1",
+			"comment":           "comment长度必须是偶数",
+		}},
+		{"zh-TW", map[string]string{
+			"phone": "phone必須是有效的手機號碼",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptLanguage, func(t *testing.T) {
+			w := postOrder(r, "/shops/42/orders?source=mini", body, tt.acceptLanguage)
+			var resp validationResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != http.StatusBadRequest || resp.Error != "validation_failed" {
+				t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+			}
+			got := make(map[string]string)
+			for _, detail := range resp.Details {
+				got[detail.Field] = detail.Message
+			}
+			for field, message := range tt.messages {
+				if got[field] != message {
+					t.Errorf("%s: expected %q, got %q", field, message, got[field])
+				}
+			}
+		})
+	}
+}
+
+func TestBindErrors(t *testing.T) {
+	r := newBindingEngine(&createOrderRequest{})
+
+	w := postOrder(r, "/shops/42/orders", `{"phone":13800138000}`, "zh")
+	var resp validationResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusBadRequest || len(resp.Details) != 1 || resp.Details[0].Field != "phone" || resp.Details[0].Message != "phone的类型必须是number" {
+		t.Errorf("expected type error for phone, got %d %s", w.Code, w.Body.String())
+	}
+
+	if w := postOrder(r, "/shops/42/orders", `{"phone":`, ""); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "bad_request") {
+		t.Errorf("expected 400 bad_request for malformed json, got %d %s", w.Code, w.Body.String())
+	}
+	if w := postOrder(r, "/shops/abc/orders", `{}`, ""); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "bad_request") {
+		t.Errorf("expected 400 bad_request for invalid path param, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestBindForm(t *testing.T) {
+	type loginRequest struct {
+		Phone string `form:"phone" binding:"required,phone"`
+		Code  string `form:"code" binding:"required,len=6"`
+	}
+	r := gin.New()
+	r.POST("/login", func(c *gin.Context) {
+		var req loginRequest
+		if !MustBind(c, &req) {
+			return
+		}
+		c.String(http.StatusOK, req.Phone)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader("phone=13800138000&code=123456"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "13800138000" {
+		t.Errorf("expected form bound, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestValidateMisconfiguredEnum(t *testing.T) {
+	type request struct {
+		Kind string `json:"kind" binding:"enum"`
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := Validate(c, &request{Kind: "a"}); err == nil {
+		t.Error("enum without values on a type that does not implement Enum should fail validation")
+	}
+}
@@ -0,0 +1,72 @@
+package binding
+
+import (
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Enum 实现了这个接口的类型可以直接使用 enum 规则，不需要在 tag 中列出所有取值
+type Enum interface {
+	IsValid() bool
+}
+
+var phoneRegexp = regexp.MustCompile(`^1[3-9]\d{9}$`)
+
+// isPhone 中国大陆手机号，允许 +86 前缀
+func isPhone(fl validator.FieldLevel) bool {
+	phone := strings.TrimPrefix(fl.Field().String(), "+86")
+	return phoneRegexp.MatchString(phone)
+}
+
+// 身份证校验码的加权因子和对应的校验码
+var (
+	idCardWeights    = []int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+	idCardCheckCodes = "10X98765432"
+)
+
+// isIDCard 18 位居民身份证号码，校验出生日期和校验码
+func isIDCard(fl validator.FieldLevel) bool {
+	id := strings.ToUpper(fl.Field().String())
+	if len(id) != 18 {
+		return false
+	}
+	sum := 0
+	for i := 0; i < 17; i++ {
+		if id[i] < '0' || id[i] > '9' {
+			return false
+		}
+		sum += int(id[i]-'0') * idCardWeights[i]
+	}
+	if id[17] != idCardCheckCodes[sum%11] {
+		return false
+	}
+	birthday, err := time.Parse("20060102", id[6:14])
+	return err == nil && birthday.Before(time.Now())
+}
+
+// isEnum 有参数时取值必须是参数之一（空格分隔），没有参数时字段需要实现 Enum 接口，
+// 没有实现时校验不通过，不在请求中 panic
+func isEnum(fl validator.FieldLevel) bool {
+	field := fl.Field()
+	if param := fl.Param(); param != "" {
+		value := fmt.Sprint(field.Interface())
+		for _, allowed := range strings.Fields(param) {
+			if value == allowed {
+				return true
+			}
+		}
+		return false
+	}
+	if enum, ok := field.Interface().(Enum); ok {
+		return enum.IsValid()
+	}
+	if field.CanAddr() {
+		if enum, ok := field.Addr().Interface().(Enum); ok {
+			return enum.IsValid()
+		}
+	}
+	return false
+}
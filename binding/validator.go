@@ -0,0 +1,191 @@
+package binding
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/zh"
+	"github.com/go-playground/locales/zh_Hant_TW"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	enTranslations "github.com/go-playground/validator/v10/translations/en"
+	zhTranslations "github.com/go-playground/validator/v10/translations/zh"
+	zhTwTranslations "github.com/go-playground/validator/v10/translations/zh_tw"
+	rumErrors "github.com/yangkushu/rum-go/errors"
+	"reflect"
+	"strings"
+)
+
+const (
+	LocaleEN   = "en"
+	LocaleZH   = "zh"
+	LocaleZHTW = "zh_Hant_TW"
+
+	typeErrorTag = "type"
+)
+
+var defaultValidator = newValidator()
+
+// 内置规则和类型错误的提示，{0} 为字段名，{1} 为规则参数
+var builtinMessages = map[string]map[string]string{
+	"phone": {
+		LocaleEN:   "{0} must be a valid mobile phone number",
+		LocaleZH:   "{0}必须是有效的手机号码",
+		LocaleZHTW: "{0}必須是有效的手機號碼",
+	},
+	"idcard": {
+		LocaleEN:   "{0} must be a valid ID card number",
+		LocaleZH:   "{0}必须是有效的身份证号码",
+		LocaleZHTW: "{0}必須是有效的身分證號碼",
+	},
+	"enum": {
+		LocaleEN:   "{0} must be one of the allowed values",
+		LocaleZH:   "{0}必须是允许的值之一",
+		LocaleZHTW: "{0}必須是允許的值之一",
+	},
+	typeErrorTag: {
+		LocaleEN:   "{0} must be of type {1}",
+		LocaleZH:   "{0}的类型必须是{1}",
+		LocaleZHTW: "{0}的類型必須是{1}",
+	},
+}
+
+type bindingValidator struct {
+	validate *validator.Validate
+	uni      *ut.UniversalTranslator
+}
+
+func newValidator() *bindingValidator {
+	v := &bindingValidator{
+		validate: validator.New(validator.WithRequiredStructEnabled()),
+		uni:      ut.New(en.New(), en.New(), zh.New(), zh_Hant_TW.New()),
+	}
+	// 和 gin 一样使用 binding tag，错误中的字段名使用 json tag
+	v.validate.SetTagName("binding")
+	v.validate.RegisterTagNameFunc(fieldName)
+
+	register := map[string]func(*validator.Validate, ut.Translator) error{
+		LocaleEN:   enTranslations.RegisterDefaultTranslations,
+		LocaleZH:   zhTranslations.RegisterDefaultTranslations,
+		LocaleZHTW: zhTwTranslations.RegisterDefaultTranslations,
+	}
+	for locale, fn := range register {
+		trans, _ := v.uni.GetTranslator(locale)
+		if err := fn(v.validate, trans); err != nil {
+			panic(fmt.Sprintf("register %s translations failed: %v", locale, err))
+		}
+	}
+
+	rules := map[string]validator.Func{"phone": isPhone, "idcard": isIDCard, "enum": isEnum}
+	for tag, fn := range rules {
+		if err := v.register(tag, fn, builtinMessages[tag]); err != nil {
+			panic(fmt.Sprintf("register %s validation failed: %v", tag, err))
+		}
+	}
+	for locale, message := range builtinMessages[typeErrorTag] {
+		trans, _ := v.uni.GetTranslator(locale)
+		if err := trans.Add(typeErrorTag, message, true); err != nil {
+			panic(fmt.Sprintf("register type error message failed: %v", err))
+		}
+	}
+	return v
+}
+
+// fieldName 依次使用 json、form、uri tag 中的名字，json:"-" 的字段可能来自查询参数或路径参数，继续查找
+func fieldName(field reflect.StructField) string {
+	for _, tag := range []string{"json", "form", "uri"} {
+		name, _, _ := strings.Cut(field.Tag.Get(tag), ",")
+		if name != "" && name != "-" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+// RegisterValidation 注册自定义校验规则，messages 为各语言的提示（en、zh、zh_Hant_TW），{0} 为字段名，{1} 为规则参数
+// 需要在程序启动时注册，不能和校验并发执行
+func RegisterValidation(tag string, fn validator.Func, messages map[string]string) error {
+	return defaultValidator.register(tag, fn, messages)
+}
+
+func (v *bindingValidator) register(tag string, fn validator.Func, messages map[string]string) error {
+	if err := v.validate.RegisterValidation(tag, fn); err != nil {
+		return err
+	}
+	for locale, message := range messages {
+		trans, found := v.uni.GetTranslator(locale)
+		if !found {
+			return fmt.Errorf("unsupported locale %q", locale)
+		}
+		message := message
+		err := v.validate.RegisterTranslation(tag, trans, func(t ut.Translator) error {
+			return t.Add(tag, message, true)
+		}, func(t ut.Translator, fe validator.FieldError) string {
+			text, _ := t.T(fe.Tag(), fe.Field(), fe.Param())
+			return text
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// translator 按 Accept-Language 选择翻译，没有匹配时使用英文
+func (v *bindingValidator) translator(acceptLanguage string) ut.Translator {
+	for _, lang := range rumErrors.ParseAcceptLanguage(acceptLanguage) {
+		var locale string
+		switch {
+		case lang == "zh-tw" || lang == "zh-hk" || lang == "zh-mo" || strings.HasPrefix(lang, "zh-hant"):
+			locale = LocaleZHTW
+		case lang == "zh" || strings.HasPrefix(lang, "zh-"):
+			locale = LocaleZH
+		case lang == "en" || strings.HasPrefix(lang, "en-"):
+			locale = LocaleEN
+		default:
+			continue
+		}
+		trans, _ := v.uni.GetTranslator(locale)
+		return trans
+	}
+	trans, _ := v.uni.GetTranslator(LocaleEN)
+	return trans
+}
+
+func (v *bindingValidator) check(acceptLanguage string, obj interface{}) error {
+	err := v.validate.Struct(obj)
+	if err == nil {
+		return nil
+	}
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		// 比如 obj 不是结构体
+		return rumErrors.ErrInternal.Wrap(err)
+	}
+	trans := v.translator(acceptLanguage)
+	details := make([]FieldError, 0, len(validationErrors))
+	for _, fe := range validationErrors {
+		details = append(details, FieldError{
+			Field:   namespace(fe),
+			Tag:     fe.Tag(),
+			Message: fe.Translate(trans),
+		})
+	}
+	return rumErrors.ErrValidation.Wrap(err).WithDetails(details)
+}
+
+// namespace 去掉最外层结构体的名字，比如 CreateOrderRequest.items[0].name 返回 items[0].name
+func namespace(fe validator.FieldError) string {
+	_, ns, found := strings.Cut(fe.Namespace(), ".")
+	if !found {
+		return fe.Field()
+	}
+	return ns
+}
+
+func (v *bindingValidator) typeError(c *gin.Context, field, typ string) FieldError {
+	trans := v.translator(c.GetHeader("Accept-Language"))
+	message, _ := trans.T(typeErrorTag, field, typ)
+	return FieldError{Field: field, Tag: typeErrorTag, Message: message}
+}
@@ -2,11 +2,15 @@ package rum
 
 import (
 	"github.com/yangkushu/rum-go/elasticsearch"
+	"github.com/yangkushu/rum-go/httpclient"
 	"github.com/yangkushu/rum-go/log"
 	"github.com/yangkushu/rum-go/messagequeue"
+	"github.com/yangkushu/rum-go/middleware"
 	//"github.com/yangkushu/rum-go/nacos"
 	"github.com/yangkushu/rum-go/postgres"
+	"github.com/yangkushu/rum-go/prom"
 	"github.com/yangkushu/rum-go/redis"
+	"github.com/yangkushu/rum-go/tracing"
 )
 
 type Config struct {
@@ -14,6 +18,10 @@ type Config struct {
 	Elasticsearch *elasticsearch.Config `mapstructure:"elasticsearch"`
 	Postgres      *postgres.Config      `mapstructure:"postgres"`
 	//Nacos         *nacos.Config             `mapstructure:"nacos"`
-	Log   *log.Config               `mapstructure:"log"`
-	Kafka *messagequeue.KafkaConfig `mapstructure:"kafka"`
+	Log        *log.Config                `mapstructure:"log"`
+	Kafka      *messagequeue.KafkaConfig  `mapstructure:"kafka"`
+	Prom       *prom.Config               `mapstructure:"prom"`
+	Tracing    *tracing.Config            `mapstructure:"tracing"`
+	HTTPClient *httpclient.Config         `mapstructure:"http_client"`
+	Middleware *middleware.PipelineConfig `mapstructure:"middleware"`
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/elastic/elastic-transport-go/v8/elastictransport"
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"net/http"
 	"os"
 	"strings"
 )
@@ -18,7 +19,17 @@ type Client struct {
 	config *Config
 }
 
-func NewClient(config *Config) (*Client, error) {
+// Option 修改 elasticsearch 客户端的配置
+type Option func(*elasticsearch.Config)
+
+// WithTransport 设置 HTTP Transport，比如 tracing.NewElasticsearchTransport
+func WithTransport(transport http.RoundTripper) Option {
+	return func(cfg *elasticsearch.Config) {
+		cfg.Transport = transport
+	}
+}
+
+func NewClient(config *Config, opts ...Option) (*Client, error) {
 	// 解析地址，为了适配之前的配置
 	addrs := make([]string, 0)
 	scheme := config.Scheme
@@ -40,6 +51,9 @@ func NewClient(config *Config) (*Client, error) {
 	if config.EnableLogger {
 		cfg.Logger = &elastictransport.ColorLogger{Output: os.Stdout, EnableRequestBody: config.EnableRequestBody, EnableResponseBody: config.EnableRequestBody}
 	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 
 	client, err := elasticsearch.NewTypedClient(cfg)
 
@@ -0,0 +1,89 @@
+package errors
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Catalog 按语言保存错误码对应的用户提示
+type Catalog struct {
+	mu          sync.RWMutex
+	defaultLang string
+	messages    map[string]map[string]string // 语言 -> 错误码 -> 提示
+}
+
+// NewCatalog 创建错误提示目录，defaultLang 在 Accept-Language 没有匹配时使用，可以为空
+func NewCatalog(defaultLang string) *Catalog {
+	return &Catalog{defaultLang: strings.ToLower(defaultLang), messages: make(map[string]map[string]string)}
+}
+
+// Add 添加一种语言的提示，语言使用 BCP 47 格式，比如 zh-CN、en
+func (c *Catalog) Add(lang string, messages map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	lang = strings.ToLower(lang)
+	if c.messages[lang] == nil {
+		c.messages[lang] = make(map[string]string)
+	}
+	for code, message := range messages {
+		c.messages[lang][code] = message
+	}
+}
+
+// Message 按 Accept-Language 查找提示，依次尝试完整语言（zh-cn）、主语言（zh）和默认语言，都没有时返回 fallback
+func (c *Catalog) Message(acceptLanguage, code, fallback string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, lang := range ParseAcceptLanguage(acceptLanguage) {
+		if message, ok := c.lookup(lang, code); ok {
+			return message
+		}
+		if base, _, found := strings.Cut(lang, "-"); found {
+			if message, ok := c.lookup(base, code); ok {
+				return message
+			}
+		}
+	}
+	if message, ok := c.lookup(c.defaultLang, code); ok {
+		return message
+	}
+	return fallback
+}
+
+func (c *Catalog) lookup(lang, code string) (string, bool) {
+	message, ok := c.messages[lang][code]
+	return message, ok
+}
+
+// ParseAcceptLanguage 解析 Accept-Language 请求头，按权重从高到低返回语言，忽略 q=0 和 *
+func ParseAcceptLanguage(header string) []string {
+	type langQ struct {
+		lang string
+		q    float64
+	}
+	var langs []langQ
+	for _, part := range strings.Split(header, ",") {
+		lang, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		lang = strings.ToLower(strings.TrimSpace(lang))
+		if lang == "" || lang == "*" {
+			continue
+		}
+		q := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > 0 {
+			langs = append(langs, langQ{lang, q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+	result := make([]string, len(langs))
+	for i, l := range langs {
+		result[i] = l.lang
+	}
+	return result
+}
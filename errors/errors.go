@@ -0,0 +1,180 @@
+package errors
+
+import (
+	"context"
+	stdErrors "errors"
+	"fmt"
+	"github.com/yangkushu/rum-go/redis"
+	"gorm.io/gorm"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// 通用错误，业务错误可以用 New 定义自己的 code
+var (
+	ErrBadRequest      = New("bad_request", http.StatusBadRequest, "Bad request")
+	ErrValidation      = New("validation_failed", http.StatusBadRequest, "Validation failed")
+	ErrUnauthorized    = New("unauthorized", http.StatusUnauthorized, "Unauthorized")
+	ErrForbidden       = New("forbidden", http.StatusForbidden, "Forbidden")
+	ErrNotFound        = New("not_found", http.StatusNotFound, "Resource not found")
+	ErrConflict        = New("conflict", http.StatusConflict, "Conflict")
+	ErrTooManyRequests = New("too_many_requests", http.StatusTooManyRequests, "Too many requests")
+	ErrCanceled        = New("canceled", 499, "Request canceled") // 499 客户端关闭连接，沿用 nginx 的约定
+	ErrInternal        = New("internal_error", http.StatusInternalServerError, "Internal server error")
+	ErrUnavailable     = New("service_unavailable", http.StatusServiceUnavailable, "Service unavailable")
+	ErrTimeout         = New("timeout", http.StatusGatewayTimeout, "Request timeout")
+)
+
+// Error 业务错误
+// Code 是给客户端判断的稳定错误码，Message 是默认的用户提示，可以通过 Catalog 按语言替换；
+// cause 是内部原因，只记录日志，不返回给客户端
+type Error struct {
+	Code    string
+	Status  int
+	Message string
+	Details interface{}
+
+	cause error
+	stack []uintptr
+}
+
+// New 定义一个错误，一般作为包级变量使用
+func New(code string, status int, message string) *Error {
+	return &Error{Code: code, Status: status, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.cause)
+	}
+	return e.Code + ": " + e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is code 相同即视为同一种错误，errors.Is(err, ErrNotFound) 对 Wrap 之后的错误同样成立
+func (e *Error) Is(target error) bool {
+	var t *Error
+	if stdErrors.As(target, &t) {
+		return t.Code == e.Code
+	}
+	return false
+}
+
+// Cause 内部原因
+func (e *Error) Cause() error {
+	return e.cause
+}
+
+// Wrap 返回带内部原因和调用栈的副本
+func (e *Error) Wrap(cause error) *Error {
+	c := e.clone()
+	c.cause = cause
+	c.stack = callers()
+	return c
+}
+
+// WithMessage 返回替换了用户提示的副本
+func (e *Error) WithMessage(format string, args ...interface{}) *Error {
+	c := e.clone()
+	c.Message = fmt.Sprintf(format, args...)
+	return c
+}
+
+// WithDetails 返回带详细信息的副本，比如参数校验失败的字段
+func (e *Error) WithDetails(details interface{}) *Error {
+	c := e.clone()
+	c.Details = details
+	return c
+}
+
+// Stack 返回 Wrap 时的调用栈，没有时返回空字符串
+func (e *Error) Stack() string {
+	if len(e.stack) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	frames := runtime.CallersFrames(e.stack)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return b.String()
+}
+
+func (e *Error) clone() *Error {
+	c := *e
+	return &c
+}
+
+func callers() []uintptr {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	return pcs[:n]
+}
+
+// Mapper 把其他错误转换为 *Error，不能转换时返回 nil
+type Mapper func(err error) *Error
+
+var (
+	mappersMu sync.RWMutex
+	mappers   = []Mapper{mapContext, mapGorm, mapRedis}
+)
+
+// RegisterMapper 注册错误转换函数，后注册的优先
+func RegisterMapper(mapper Mapper) {
+	mappersMu.Lock()
+	defer mappersMu.Unlock()
+	mappers = append([]Mapper{mapper}, mappers...)
+}
+
+func mapContext(err error) *Error {
+	switch {
+	case stdErrors.Is(err, context.DeadlineExceeded):
+		return ErrTimeout.Wrap(err)
+	case stdErrors.Is(err, context.Canceled):
+		return ErrCanceled.Wrap(err)
+	}
+	return nil
+}
+
+func mapGorm(err error) *Error {
+	if stdErrors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound.Wrap(err)
+	}
+	return nil
+}
+
+func mapRedis(err error) *Error {
+	if redis.IsKeyNotExist(err) {
+		return ErrNotFound.Wrap(err)
+	}
+	return nil
+}
+
+// From 把任意错误转换为 *Error：已经是 *Error 时直接返回，否则依次尝试注册的转换函数，
+// 都不能转换时作为 ErrInternal 的内部原因
+func From(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if stdErrors.As(err, &e) {
+		return e
+	}
+	mappersMu.RLock()
+	defer mappersMu.RUnlock()
+	for _, mapper := range mappers {
+		if e = mapper(err); e != nil {
+			return e
+		}
+	}
+	return ErrInternal.Wrap(err)
+}
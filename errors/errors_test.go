@@ -0,0 +1,94 @@
+package errors
+
+import (
+	"context"
+	stdErrors "errors"
+	"fmt"
+	goRedis "github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+var errOrderNotFound = New("order_not_found", http.StatusNotFound, "Order not found")
+
+func TestErrorWrap(t *testing.T) {
+	cause := stdErrors.New("row missing")
+	err := fmt.Errorf("load order: %w", errOrderNotFound.Wrap(cause).WithDetails(map[string]int{"id": 1}))
+
+	if !stdErrors.Is(err, errOrderNotFound) || stdErrors.Is(err, ErrNotFound) {
+		t.Error("expected errors.Is to match by code")
+	}
+	if !stdErrors.Is(err, cause) {
+		t.Error("expected cause to be unwrapped")
+	}
+	e := From(err)
+	if e.Code != "order_not_found" || e.Status != http.StatusNotFound || e.Details == nil {
+		t.Errorf("unexpected error %+v", e)
+	}
+	if !strings.Contains(e.Stack(), "TestErrorWrap") {
+		t.Errorf("expected stack to contain caller, got %s", e.Stack())
+	}
+	// Wrap 返回副本，不修改包级变量
+	if errOrderNotFound.Cause() != nil || errOrderNotFound.Details != nil {
+		t.Error("expected sentinel error unchanged")
+	}
+}
+
+func TestFrom(t *testing.T) {
+	custom := New("custom", http.StatusTeapot, "custom")
+	RegisterMapper(func(err error) *Error {
+		if err.Error() == "teapot" {
+			return custom
+		}
+		return nil
+	})
+
+	tests := []struct {
+		err  error
+		code string
+	}{
+		{fmt.Errorf("query: %w", gorm.ErrRecordNotFound), ErrNotFound.Code},
+		{goRedis.Nil, ErrNotFound.Code},
+		{context.DeadlineExceeded, ErrTimeout.Code},
+		{context.Canceled, ErrCanceled.Code},
+		{stdErrors.New("teapot"), custom.Code},
+		{stdErrors.New("boom"), ErrInternal.Code},
+	}
+	for _, tt := range tests {
+		if e := From(tt.err); e.Code != tt.code {
+			t.Errorf("%v: expected %s, got %s", tt.err, tt.code, e.Code)
+		}
+	}
+	if From(nil) != nil {
+		t.Error("expected nil for nil error")
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	catalog := NewCatalog("en")
+	catalog.Add("en", map[string]string{"not_found": "Not found"})
+	catalog.Add("zh", map[string]string{"not_found": "资源不存在"})
+	catalog.Add("zh-TW", map[string]string{"not_found": "資源不存在"})
+
+	tests := []struct {
+		acceptLanguage string
+		want           string
+	}{
+		{"zh-CN,zh;q=0.9,en;q=0.8", "资源不存在"},
+		{"zh-TW", "資源不存在"},
+		{"fr;q=0.5, zh-TW;q=0.9", "資源不存在"},
+		{"fr", "Not found"},
+		{"zh;q=0, fr", "Not found"},
+		{"", "Not found"},
+	}
+	for _, tt := range tests {
+		if got := catalog.Message(tt.acceptLanguage, "not_found", "fallback"); got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.acceptLanguage, tt.want, got)
+		}
+	}
+	if got := catalog.Message("zh", "unknown", "fallback"); got != "fallback" {
+		t.Errorf("expected fallback, got %q", got)
+	}
+}
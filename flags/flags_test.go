@@ -0,0 +1,215 @@
+package flags
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/yangkushu/rum-go/log/logtest"
+	"github.com/yangkushu/rum-go/redis/redistest"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func percent(p int) *int {
+	return &p
+}
+
+func TestFlagEvaluate(t *testing.T) {
+	tests := []struct {
+		name     string
+		flag     Flag
+		ec       EvalContext
+		expected bool
+	}{
+		{"disabled", Flag{Name: "a"}, EvalContext{UserID: "u1"}, false},
+		{"enabled", Flag{Name: "a", Enabled: true}, EvalContext{}, true},
+		{"user allow list", Flag{Name: "a", Users: []string{"u1"}}, EvalContext{UserID: "u1"}, true},
+		{"tenant allow list", Flag{Name: "a", Tenants: []string{"t1"}}, EvalContext{UserID: "u2", TenantID: "t1"}, true},
+		{"percentage without id", Flag{Name: "a", Enabled: true, Percentage: percent(50)}, EvalContext{}, false},
+		{"percentage 0", Flag{Name: "a", Enabled: true, Percentage: percent(0)}, EvalContext{UserID: "u1"}, false},
+		{"percentage 0 allow list", Flag{Name: "a", Enabled: true, Percentage: percent(0), Users: []string{"u1"}}, EvalContext{UserID: "u1"}, true},
+		{"percentage 100", Flag{Name: "a", Enabled: true, Percentage: percent(100)}, EvalContext{}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.flag.Evaluate(tt.ec); got != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+
+	flag := Flag{Name: "rollout", Enabled: true, Percentage: percent(30)}
+	on := 0
+	for i := 0; i < 10000; i++ {
+		ec := EvalContext{UserID: strconv.Itoa(i)}
+		result := flag.Evaluate(ec)
+		if result != flag.Evaluate(ec) {
+			t.Fatal("expected stable result for the same user")
+		}
+		if result {
+			on++
+		}
+	}
+	if on < 2700 || on > 3300 {
+		t.Errorf("expected about 30%% of users, got %d", on)
+	}
+
+	if err := (&Flag{Name: "a", Percentage: percent(101)}).Validate(); err == nil {
+		t.Error("expected percentage validation error")
+	}
+}
+
+func TestManagerRedisOverrides(t *testing.T) {
+	client, mr := redistest.New(t)
+	config := &Config{Flags: []Flag{{Name: "checkout_v2", Enabled: false, Users: []string{"alice"}}}}
+
+	a, err := NewManager(config, logtest.New(), WithStore(NewRedisStore(client, "")), WithMetrics("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := NewManager(config, logtest.New(), WithStore(NewRedisStore(client, "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if a.Enabled("checkout_v2", EvalContext{UserID: "bob"}) || !a.Enabled("checkout_v2", EvalContext{UserID: "alice"}) {
+		t.Fatal("unexpected static evaluation")
+	}
+	if a.Enabled("missing", EvalContext{}) {
+		t.Error("expected unknown flag to be off")
+	}
+
+	waitFor(t, func() bool { return mr.PubSubNumSub("feature_flags:changed")["feature_flags:changed"] == 2 })
+	if err := a.Set(context.Background(), Flag{Name: "checkout_v2", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !a.Enabled("checkout_v2", EvalContext{UserID: "bob"}) {
+		t.Error("expected override to apply locally")
+	}
+	waitFor(t, func() bool { return b.Enabled("checkout_v2", EvalContext{UserID: "bob"}) })
+	if state, _ := b.Get("checkout_v2"); !state.Overridden {
+		t.Error("expected flag to be marked as overridden")
+	}
+
+	if err := a.Delete(context.Background(), "checkout_v2"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return !b.Enabled("checkout_v2", EvalContext{UserID: "bob"}) })
+
+	if n := testutil.ToFloat64(a.evaluations.WithLabelValues("checkout_v2", "on")); n != 2 {
+		t.Errorf("expected 2 on evaluations, got %v", n)
+	}
+	if n := testutil.ToFloat64(a.evaluations.WithLabelValues("missing", "unknown")); n != 1 {
+		t.Errorf("expected 1 unknown evaluation, got %v", n)
+	}
+
+	// 新实例启动时加载已有的覆盖
+	if err := a.Set(context.Background(), Flag{Name: "new_search", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewManager(&Config{}, logtest.New(), WithStore(NewRedisStore(client, "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if !c.Enabled("new_search", EvalContext{}) {
+		t.Error("expected existing override to be loaded")
+	}
+}
+
+func TestManagerUpdate(t *testing.T) {
+	m, err := NewManager(&Config{Flags: []Flag{{Name: "a", Enabled: true}}}, logtest.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Update(&Config{Flags: []Flag{{Name: "a"}, {Name: "a"}}}); err == nil {
+		t.Error("expected duplicated flag error")
+	}
+	if !m.Enabled("a", EvalContext{}) {
+		t.Error("expected previous flags to be kept on invalid update")
+	}
+	if err := m.Update(&Config{Flags: []Flag{{Name: "a"}}}); err != nil || m.Enabled("a", EvalContext{}) {
+		t.Error("expected update to disable flag")
+	}
+}
+
+func TestGin(t *testing.T) {
+	m, err := NewManager(&Config{Flags: []Flag{{Name: "beta", Users: []string{"alice"}}}}, logtest.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set(UserIDKey, c.GetHeader("X-User"))
+		c.Next()
+	}, m.HandlerFunc())
+	r.GET("/beta", m.Require("beta"), func(c *gin.Context) { c.String(http.StatusOK, "beta") })
+	r.GET("/home", func(c *gin.Context) { c.String(http.StatusOK, strconv.FormatBool(Enabled(c, "beta"))) })
+	m.RegisterAdminRoutes(r.Group("/admin"))
+	serve := func(method, path, user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-User", user)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve("GET", "/beta", "bob", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for disabled flag, got %d", w.Code)
+	}
+	if w := serve("GET", "/beta", "alice", ""); w.Code != http.StatusOK {
+		t.Errorf("expected 200 for allowed user, got %d", w.Code)
+	}
+	if w := serve("GET", "/home", "alice", ""); w.Body.String() != "true" {
+		t.Errorf("expected flag enabled in handler, got %s", w.Body.String())
+	}
+
+	if w := serve("PUT", "/admin/flags/beta", "", `{"enabled":true,"percentage":101}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid flag, got %d", w.Code)
+	}
+	if w := serve("PUT", "/admin/flags/beta", "", `{"enabled":true}`); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"overridden":true`) {
+		t.Errorf("unexpected update response %d %s", w.Code, w.Body.String())
+	}
+	if w := serve("GET", "/beta", "bob", ""); w.Code != http.StatusOK {
+		t.Errorf("expected 200 after enabling flag, got %d", w.Code)
+	}
+	// 放量降到 0 时对名单外的用户关闭
+	if w := serve("PUT", "/admin/flags/beta", "", `{"enabled":true,"percentage":0}`); w.Code != http.StatusOK {
+		t.Errorf("unexpected update response %d %s", w.Code, w.Body.String())
+	}
+	if w := serve("GET", "/beta", "bob", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 with 0%% rollout, got %d", w.Code)
+	}
+	if w := serve("GET", "/admin/flags", "", ""); !strings.Contains(w.Body.String(), `"name":"beta"`) {
+		t.Errorf("unexpected list response %s", w.Body.String())
+	}
+	if w := serve("DELETE", "/admin/flags/beta", "", ""); w.Code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", w.Code)
+	}
+	if w := serve("GET", "/admin/flags/beta", "", ""); !strings.Contains(w.Body.String(), `"overridden":false`) {
+		t.Errorf("expected static flag after delete, got %s", w.Body.String())
+	}
+	if w := serve("GET", "/admin/flags/missing", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for missing flag, got %d", w.Code)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,119 @@
+package flags
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/log"
+	"net/http"
+)
+
+const (
+	// UserIDKey 默认从 gin.Context 中读取用户ID的 key，JWTAuth 会设置
+	UserIDKey = "user_id"
+	// TenantIDKey 默认从 gin.Context 中读取租户ID的 key
+	TenantIDKey = "tenant_id"
+	// ContextKey gin.Context 中保存当前请求开关的 key
+	ContextKey = "feature_flags"
+)
+
+// EvalContextFunc 从请求中获取判断开关使用的用户和租户
+type EvalContextFunc func(c *gin.Context) EvalContext
+
+func defaultEvalContext(c *gin.Context) EvalContext {
+	return EvalContext{UserID: c.GetString(UserIDKey), TenantID: c.GetString(TenantIDKey)}
+}
+
+type requestFlags struct {
+	manager *Manager
+	ec      EvalContext
+}
+
+// HandlerFunc 把开关保存到 gin.Context 中，handler 中使用 Enabled(c, name) 判断。
+// 需要放在认证中间件之后，才能获取到用户
+func (m *Manager) HandlerFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(ContextKey, &requestFlags{manager: m, ec: m.evalContext(c)})
+		c.Next()
+	}
+}
+
+// Require 开关对当前请求关闭时返回 404，用于隐藏未发布的接口
+func (m *Manager) Require(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !m.Enabled(name, m.evalContext(c)) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "not_found", "message": "not found"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Enabled 判断开关对当前请求是否开启，没有使用 HandlerFunc 时返回 false
+func Enabled(c *gin.Context, name string) bool {
+	v, ok := c.Get(ContextKey)
+	if !ok {
+		return false
+	}
+	flags, ok := v.(*requestFlags)
+	if !ok {
+		return false
+	}
+	return flags.manager.Enabled(name, flags.ec)
+}
+
+// RegisterAdminRoutes 注册开关管理接口，调用方负责认证和授权：
+//
+//	GET    /flags        所有开关
+//	GET    /flags/:name  单个开关
+//	PUT    /flags/:name  覆盖开关，请求体为 Flag
+//	DELETE /flags/:name  删除覆盖，恢复为配置中的值
+func (m *Manager) RegisterAdminRoutes(r gin.IRoutes) {
+	r.GET("/flags", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"flags": m.All()})
+	})
+	r.GET("/flags/:name", func(c *gin.Context) {
+		state, found := m.Get(c.Param("name"))
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not_found", "message": "feature flag not found"})
+			return
+		}
+		c.JSON(http.StatusOK, state)
+	})
+	r.PUT("/flags/:name", func(c *gin.Context) {
+		var flag Flag
+		if err := c.ShouldBindJSON(&flag); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request", "message": err.Error()})
+			return
+		}
+		flag.Name = c.Param("name")
+		if err := flag.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request", "message": err.Error()})
+			return
+		}
+		if err := m.Set(c.Request.Context(), flag); err != nil {
+			m.adminError(c, "set", flag.Name, err)
+			return
+		}
+		m.log.Info("feature flag updated",
+			log.String("flag", flag.Name),
+			log.Bool("enabled", flag.Enabled),
+			log.Int("percentage", flag.Rollout()),
+			log.String("client_ip", c.ClientIP()),
+		)
+		state, _ := m.Get(flag.Name)
+		c.JSON(http.StatusOK, state)
+	})
+	r.DELETE("/flags/:name", func(c *gin.Context) {
+		name := c.Param("name")
+		if err := m.Delete(c.Request.Context(), name); err != nil {
+			m.adminError(c, "delete", name, err)
+			return
+		}
+		m.log.Info("feature flag override deleted", log.String("flag", name), log.String("client_ip", c.ClientIP()))
+		c.Status(http.StatusNoContent)
+	})
+}
+
+func (m *Manager) adminError(c *gin.Context, action, name string, err error) {
+	m.log.Error("feature flag "+action+" failed", log.String("flag", name), log.ErrorField(err))
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal_error", "message": "internal error"})
+}
@@ -0,0 +1,281 @@
+package flags
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yangkushu/rum-go/iface"
+	"github.com/yangkushu/rum-go/log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultRefreshInterval = time.Minute
+	watchRetryInterval     = 5 * time.Second
+)
+
+// State 开关当前的值和来源
+type State struct {
+	Flag
+	Overridden bool `json:"overridden"` // 是否被运行时的覆盖替换
+}
+
+// Manager 功能开关
+// 配置中的开关作为默认值，store 中的覆盖按名称整体替换配置中的开关。
+// 配置了 store 时会监听变更通知并定期重新加载，需要调用 Close 停止
+type Manager struct {
+	store   Store
+	log     iface.ILogger
+	refresh time.Duration
+
+	mu        sync.Mutex
+	static    map[string]Flag
+	overrides map[string]Flag
+	merged    atomic.Pointer[map[string]*State]
+
+	evalContext EvalContextFunc
+	evaluations *prometheus.CounterVec
+
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// Option 定义配置函数类型
+type Option func(*Manager)
+
+// WithStore 使用 store 保存运行时的覆盖，比如 NewRedisStore。未设置时覆盖只保存在内存中
+func WithStore(store Store) Option {
+	return func(m *Manager) {
+		m.store = store
+	}
+}
+
+// WithEvalContextFunc 设置从请求中获取用户和租户的方法，默认读取 gin.Context 中的 user_id 和 tenant_id
+func WithEvalContextFunc(fn EvalContextFunc) Option {
+	return func(m *Manager) {
+		m.evalContext = fn
+	}
+}
+
+// WithMetrics 统计开关的判断次数，需要将 Collectors() 注册到 prom.Prom
+func WithMetrics(namespace string) Option {
+	return func(m *Manager) {
+		m.evaluations = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "feature_flag_evaluations_total",
+			Help:      "Total number of feature flag evaluations, partitioned by flag and result.",
+		}, []string{"flag", "result"})
+	}
+}
+
+// NewManager 创建功能开关，配置有误时返回错误
+func NewManager(config *Config, logger iface.ILogger, opts ...Option) (*Manager, error) {
+	m := &Manager{
+		log:         logger,
+		refresh:     time.Duration(config.RefreshSecond) * time.Second,
+		overrides:   make(map[string]Flag),
+		evalContext: defaultEvalContext,
+	}
+	if m.refresh <= 0 {
+		m.refresh = defaultRefreshInterval
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if err := m.Update(config); err != nil {
+		return nil, err
+	}
+	if m.store != nil {
+		if err := m.Reload(context.Background()); err != nil {
+			m.log.Warn("feature flags load overrides failed", log.ErrorField(err))
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		m.cancel = cancel
+		m.wg.Add(2)
+		go m.watchLoop(ctx)
+		go m.refreshLoop(ctx)
+	}
+	return m, nil
+}
+
+// Update 替换配置中的开关，用于配置重新加载。配置有误时返回错误，保留原来的开关
+func (m *Manager) Update(config *Config) error {
+	static := make(map[string]Flag, len(config.Flags))
+	for _, flag := range config.Flags {
+		if err := flag.Validate(); err != nil {
+			return err
+		}
+		if _, found := static[flag.Name]; found {
+			return fmt.Errorf("feature flag %q is duplicated", flag.Name)
+		}
+		static[flag.Name] = flag
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.static = static
+	m.rebuildLocked()
+	return nil
+}
+
+// Reload 从 store 重新加载覆盖
+func (m *Manager) Reload(ctx context.Context) error {
+	if m.store == nil {
+		return nil
+	}
+	flags, err := m.store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	overrides := make(map[string]Flag, len(flags))
+	for _, flag := range flags {
+		if err := flag.Validate(); err != nil {
+			m.log.Warn("feature flags ignore invalid override", log.String("flag", flag.Name), log.ErrorField(err))
+			continue
+		}
+		overrides[flag.Name] = flag
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.overrides = overrides
+	m.rebuildLocked()
+	return nil
+}
+
+func (m *Manager) rebuildLocked() {
+	merged := make(map[string]*State, len(m.static)+len(m.overrides))
+	for name, flag := range m.static {
+		merged[name] = &State{Flag: flag}
+	}
+	for name, flag := range m.overrides {
+		merged[name] = &State{Flag: flag, Overridden: true}
+	}
+	m.merged.Store(&merged)
+}
+
+// Set 覆盖开关，立即在当前实例生效，其他实例收到通知后生效
+func (m *Manager) Set(ctx context.Context, flag Flag) error {
+	if err := flag.Validate(); err != nil {
+		return err
+	}
+	if m.store != nil {
+		if err := m.store.Save(ctx, &flag); err != nil {
+			return err
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.overrides[flag.Name] = flag
+	m.rebuildLocked()
+	return nil
+}
+
+// Delete 删除开关的覆盖，恢复为配置中的值
+func (m *Manager) Delete(ctx context.Context, name string) error {
+	if m.store != nil {
+		if err := m.store.Delete(ctx, name); err != nil {
+			return err
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.overrides, name)
+	m.rebuildLocked()
+	return nil
+}
+
+// Get 返回开关当前的值
+func (m *Manager) Get(name string) (State, bool) {
+	state, found := (*m.merged.Load())[name]
+	if !found {
+		return State{}, false
+	}
+	return *state, true
+}
+
+// All 返回所有开关，按名称排序
+func (m *Manager) All() []State {
+	merged := *m.merged.Load()
+	states := make([]State, 0, len(merged))
+	for _, state := range merged {
+		states = append(states, *state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Name < states[j].Name
+	})
+	return states
+}
+
+// Enabled 判断开关对 ec 是否开启，不存在的开关返回 false
+func (m *Manager) Enabled(name string, ec EvalContext) bool {
+	state, found := (*m.merged.Load())[name]
+	enabled := found && state.Evaluate(ec)
+	if m.evaluations != nil {
+		result := "off"
+		switch {
+		case !found:
+			result = "unknown"
+		case enabled:
+			result = "on"
+		}
+		m.evaluations.WithLabelValues(name, result).Inc()
+	}
+	return enabled
+}
+
+// Collectors 返回开关指标，未开启指标时返回空
+func (m *Manager) Collectors() []prometheus.Collector {
+	if m.evaluations == nil {
+		return nil
+	}
+	return []prometheus.Collector{m.evaluations}
+}
+
+func (m *Manager) watchLoop(ctx context.Context) {
+	defer m.wg.Done()
+	onChange := func() {
+		if err := m.Reload(ctx); err != nil && ctx.Err() == nil {
+			m.log.Warn("feature flags reload overrides failed", log.ErrorField(err))
+		}
+	}
+	for {
+		if err := m.store.Watch(ctx, onChange); err != nil && ctx.Err() == nil {
+			m.log.Warn("feature flags watch overrides failed", log.ErrorField(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchRetryInterval):
+		}
+	}
+}
+
+func (m *Manager) refreshLoop(ctx context.Context) {
+	defer m.wg.Done()
+	ticker := time.NewTicker(m.refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.Reload(ctx); err != nil && ctx.Err() == nil {
+				m.log.Warn("feature flags reload overrides failed", log.ErrorField(err))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Close 停止监听和定期加载覆盖
+func (m *Manager) Close() error {
+	m.closeOnce.Do(func() {
+		if m.cancel != nil {
+			m.cancel()
+		}
+	})
+	m.wg.Wait()
+	return nil
+}
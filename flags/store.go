@@ -0,0 +1,94 @@
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/yangkushu/rum-go/redis"
+)
+
+const defaultRedisPrefix = "feature_flags:"
+
+// Store 保存在运行时修改的开关覆盖
+type Store interface {
+	// Load 读取所有覆盖
+	Load(ctx context.Context) ([]Flag, error)
+	// Save 保存覆盖并通知其他实例
+	Save(ctx context.Context, flag *Flag) error
+	// Delete 删除覆盖，恢复为配置中的值，并通知其他实例
+	Delete(ctx context.Context, name string) error
+	// Watch 阻塞直到 ctx 结束，开始监听后和收到变更通知时调用 onChange
+	Watch(ctx context.Context, onChange func()) error
+}
+
+// RedisStore 把覆盖保存在 redis 哈希 <prefix>overrides 中，字段为开关名称，值为 JSON。
+// 修改后向 <prefix>changed 频道发布开关名称，其他实例收到后重新加载
+type RedisStore struct {
+	client  *redis.Client
+	key     string
+	channel string
+}
+
+// NewRedisStore 创建 redis 覆盖存储，prefix 为空时使用 feature_flags:
+func NewRedisStore(client *redis.Client, prefix string) *RedisStore {
+	if prefix == "" {
+		prefix = defaultRedisPrefix
+	}
+	return &RedisStore{client: client, key: prefix + "overrides", channel: prefix + "changed"}
+}
+
+func (s *RedisStore) Load(ctx context.Context) ([]Flag, error) {
+	values, err := s.client.HGetAll(ctx, s.key).Result()
+	if err != nil {
+		return nil, err
+	}
+	flags := make([]Flag, 0, len(values))
+	for name, value := range values {
+		var flag Flag
+		if err := json.Unmarshal([]byte(value), &flag); err != nil {
+			return nil, err
+		}
+		flag.Name = name
+		flags = append(flags, flag)
+	}
+	return flags, nil
+}
+
+func (s *RedisStore) Save(ctx context.Context, flag *Flag) error {
+	data, err := json.Marshal(flag)
+	if err != nil {
+		return err
+	}
+	if err := s.client.HSet(ctx, s.key, flag.Name, data).Err(); err != nil {
+		return err
+	}
+	return s.client.Publish(ctx, s.channel, flag.Name).Err()
+}
+
+func (s *RedisStore) Delete(ctx context.Context, name string) error {
+	if err := s.client.HDel(ctx, s.key, name).Err(); err != nil {
+		return err
+	}
+	return s.client.Publish(ctx, s.channel, name).Err()
+}
+
+func (s *RedisStore) Watch(ctx context.Context, onChange func()) error {
+	sub := s.client.Subscribe(ctx, s.channel)
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		return err
+	}
+	// 订阅成功后加载一次，补上订阅之前的变更
+	onChange()
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case _, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			onChange()
+		}
+	}
+}
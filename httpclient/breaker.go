@@ -0,0 +1,147 @@
+package httpclient
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultOpenDuration     = 30 * time.Second
+
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// ErrCircuitOpen 上游的 host 处于熔断状态，请求没有发出
+var ErrCircuitOpen = errors.New("http client circuit breaker is open")
+
+// breaker 单个 host 的熔断器
+type breaker struct {
+	mu       sync.Mutex
+	state    string
+	failures int       // closed 状态下连续失败的次数
+	openedAt time.Time // 进入 open 状态的时间
+	probes   int       // half_open 状态下正在进行的探测请求
+}
+
+// breakers 按 host 保存熔断器
+type breakers struct {
+	threshold    int
+	openDuration time.Duration
+	probes       int
+	now          func() time.Time
+	onChange     func(host, state string)
+
+	mu    sync.Mutex
+	hosts map[string]*breaker
+}
+
+func newBreakers(config *CircuitBreakerConfig, onChange func(host, state string)) *breakers {
+	if config.FailureThreshold < 0 {
+		return nil
+	}
+	b := &breakers{
+		threshold:    config.FailureThreshold,
+		openDuration: time.Duration(config.OpenSecond) * time.Second,
+		probes:       config.HalfOpenRequests,
+		now:          time.Now,
+		onChange:     onChange,
+		hosts:        make(map[string]*breaker),
+	}
+	if b.threshold == 0 {
+		b.threshold = defaultFailureThreshold
+	}
+	if b.openDuration <= 0 {
+		b.openDuration = defaultOpenDuration
+	}
+	if b.probes <= 0 {
+		b.probes = 1
+	}
+	return b
+}
+
+func (b *breakers) get(host string) *breaker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	br, found := b.hosts[host]
+	if !found {
+		br = &breaker{state: breakerClosed}
+		b.hosts[host] = br
+	}
+	return br
+}
+
+// allow 判断是否可以向 host 发送请求，返回 true 时需要调用 done 报告结果
+func (b *breakers) allow(host string) bool {
+	br := b.get(host)
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	switch br.state {
+	case breakerOpen:
+		if b.now().Sub(br.openedAt) < b.openDuration {
+			return false
+		}
+		b.setState(host, br, breakerHalfOpen)
+		br.probes = 1
+		return true
+	case breakerHalfOpen:
+		if br.probes >= b.probes {
+			return false
+		}
+		br.probes++
+		return true
+	default:
+		return true
+	}
+}
+
+// done 报告请求的结果
+func (b *breakers) done(host string, success bool) {
+	br := b.get(host)
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	switch br.state {
+	case breakerHalfOpen:
+		br.probes--
+		if success {
+			br.failures = 0
+			b.setState(host, br, breakerClosed)
+		} else {
+			br.openedAt = b.now()
+			b.setState(host, br, breakerOpen)
+		}
+	case breakerClosed:
+		if success {
+			br.failures = 0
+			return
+		}
+		br.failures++
+		if br.failures >= b.threshold {
+			br.openedAt = b.now()
+			b.setState(host, br, breakerOpen)
+		}
+	}
+}
+
+// release 放弃报告结果，比如调用方取消了请求，只归还探测名额
+func (b *breakers) release(host string) {
+	br := b.get(host)
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	if br.state == breakerHalfOpen && br.probes > 0 {
+		br.probes--
+	}
+}
+
+func (b *breakers) setState(host string, br *breaker, state string) {
+	if br.state == state {
+		return
+	}
+	br.state = state
+	if b.onChange != nil {
+		b.onChange(host, state)
+	}
+}
@@ -0,0 +1,425 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yangkushu/rum-go/iface"
+	"github.com/yangkushu/rum-go/log"
+	"github.com/yangkushu/rum-go/reqctx"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout        = 10 * time.Second
+	defaultRetryBaseDelay = 100 * time.Millisecond
+	defaultRetryMaxDelay  = 5 * time.Second
+	maxDrainBody          = 64 << 10 // 重试前最多读取的响应 body，读完才能复用连接
+)
+
+var defaultRetryStatusCodes = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// Client 一个上游的 HTTP 客户端，实现了 http.RoundTripper
+// 每个请求会带上 ctx 中的请求ID和剩余超时时间；幂等请求按配置重试，每个 host 单独熔断。
+// 需要链路追踪时使用 WithTransportWrapper 包装 tracing.NewTransport，每次尝试创建一个 client span
+type Client struct {
+	name        string
+	baseURL     string
+	headers     map[string]string
+	timeout     time.Duration
+	maxRetries  int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	statusCodes map[int]bool
+	retryAll    bool
+	breakers    *breakers
+	transport   http.RoundTripper
+	wrappers    []func(http.RoundTripper) http.RoundTripper
+	client      *http.Client
+	log         iface.ILogger
+
+	metricsNamespace string
+	metrics          *metrics
+}
+
+// Option 定义配置函数类型
+type Option func(*Client)
+
+// WithTransport 设置发送请求的 Transport，默认使用 NewTransport 根据配置创建的连接池。
+// 测试时可以使用 httpclienttest.NewTransport 不经过网络返回响应
+func WithTransport(transport http.RoundTripper) Option {
+	return func(c *Client) {
+		c.transport = transport
+	}
+}
+
+// WithTransportWrapper 包装发送请求的 Transport，每次尝试都会经过，比如链路追踪：
+//
+//	httpclient.WithTransportWrapper(func(t http.RoundTripper) http.RoundTripper { return tracing.NewTransport(t) })
+func WithTransportWrapper(wrapper func(http.RoundTripper) http.RoundTripper) Option {
+	return func(c *Client) {
+		c.wrappers = append(c.wrappers, wrapper)
+	}
+}
+
+// WithMetrics 开启请求指标，需要将 Collectors() 注册到 prom.Prom
+func WithMetrics(namespace string) Option {
+	return func(c *Client) {
+		c.metricsNamespace = namespace
+	}
+}
+
+func withSharedMetrics(m *metrics) Option {
+	return func(c *Client) {
+		c.metrics = m
+	}
+}
+
+// NewClient 创建一个上游的 HTTP 客户端
+func NewClient(config *UpstreamConfig, logger iface.ILogger, opts ...Option) (*Client, error) {
+	c := &Client{
+		name:        config.Name,
+		baseURL:     strings.TrimRight(config.BaseURL, "/"),
+		headers:     config.Headers,
+		timeout:     durationMs(config.TimeoutMs, defaultTimeout),
+		maxRetries:  config.Retry.MaxRetries,
+		baseDelay:   durationMs(config.Retry.BaseDelayMs, defaultRetryBaseDelay),
+		maxDelay:    durationMs(config.Retry.MaxDelayMs, defaultRetryMaxDelay),
+		statusCodes: make(map[int]bool),
+		retryAll:    config.Retry.RetryNonIdempotent,
+		log:         logger,
+	}
+	if c.name == "" {
+		c.name = "default"
+	}
+	if c.baseURL != "" {
+		if u, err := url.Parse(c.baseURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid http client %q base url %q", c.name, config.BaseURL)
+		}
+	}
+	statusCodes := config.Retry.StatusCodes
+	if len(statusCodes) == 0 {
+		statusCodes = defaultRetryStatusCodes
+	}
+	for _, code := range statusCodes {
+		c.statusCodes[code] = true
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.metrics == nil && c.metricsNamespace != "" {
+		c.metrics = newMetrics(c.metricsNamespace)
+	}
+	if c.transport == nil {
+		transport, err := NewTransport(config)
+		if err != nil {
+			return nil, err
+		}
+		c.transport = transport
+	}
+	for _, wrap := range c.wrappers {
+		c.transport = wrap(c.transport)
+	}
+	c.breakers = newBreakers(&config.CircuitBreaker, func(host, state string) {
+		c.log.Warn("http client circuit breaker state changed",
+			log.String("client", c.name),
+			log.String("host", host),
+			log.String("state", state),
+		)
+		c.metrics.setBreakerState(c.name, host, state)
+	})
+	c.client = &http.Client{Transport: c}
+	return c, nil
+}
+
+// HTTPClient 返回使用这个客户端发送请求的 http.Client，可以传给第三方 SDK
+func (c *Client) HTTPClient() *http.Client {
+	return c.client
+}
+
+// NewRequest 创建请求，path 为相对路径时基于配置的 BaseURL
+func (c *Client) NewRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	if c.baseURL != "" && !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		path = c.baseURL + "/" + strings.TrimLeft(path, "/")
+	}
+	return http.NewRequestWithContext(ctx, method, path, body)
+}
+
+// Do 发送请求，和 http.Client.Do 相同
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	return c.client.Do(req)
+}
+
+// Get 发送 GET 请求
+func (c *Client) Get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := c.NewRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Post 发送 POST 请求，默认不会重试，需要重试时设置 Idempotency-Key 请求头或者开启 RetryNonIdempotent
+func (c *Client) Post(ctx context.Context, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := c.NewRequest(ctx, http.MethodPost, path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return c.Do(req)
+}
+
+// CloseIdleConnections 关闭空闲连接
+func (c *Client) CloseIdleConnections() {
+	c.client.CloseIdleConnections()
+}
+
+// Collectors 返回请求指标，未开启指标时返回空
+func (c *Client) Collectors() []prometheus.Collector {
+	return c.metrics.collectors()
+}
+
+func (c *Client) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	// RoundTripper 不能修改传入的请求
+	req = req.Clone(ctx)
+	for name, value := range c.headers {
+		if req.Header.Get(name) == "" {
+			req.Header.Set(name, value)
+		}
+	}
+	if req.Header.Get(reqctx.RequestIDHeaderFromContext(ctx)) == "" {
+		reqctx.InjectRequestID(ctx, req)
+	}
+	if req.Header.Get(reqctx.RequestTimeoutHeader) == "" {
+		reqctx.PropagateDeadline(ctx, req.Header)
+	}
+
+	host := req.URL.Host
+	retryable := c.maxRetries > 0 && c.retryableRequest(req)
+	start := time.Now()
+	var resp *http.Response
+	var err error
+	attempt := 0
+	for ; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		if c.breakers != nil && !c.breakers.allow(host) {
+			if req.Body != nil {
+				_ = req.Body.Close()
+			}
+			resp, err = nil, fmt.Errorf("%w: %s", ErrCircuitOpen, host)
+			break
+		}
+		resp, err = c.attempt(req)
+		if c.breakers != nil {
+			// 调用方取消的请求不代表上游有问题
+			if err != nil && ctx.Err() != nil {
+				c.breakers.release(host)
+			} else {
+				c.breakers.done(host, err == nil && resp.StatusCode < http.StatusInternalServerError)
+			}
+		}
+		if !retryable || attempt >= c.maxRetries || !c.shouldRetry(ctx, resp, err) {
+			break
+		}
+		delay, ok := c.retryDelay(attempt, resp)
+		if !ok {
+			break
+		}
+		if deadline, found := ctx.Deadline(); found && time.Until(deadline) < delay {
+			break
+		}
+		c.logRetry(req, attempt, resp, err, delay)
+		c.metrics.retry(c.name)
+		if resp != nil {
+			_, _ = io.CopyN(io.Discard, resp.Body, maxDrainBody)
+			_ = resp.Body.Close()
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	c.metrics.observe(c.name, req.Method, resp, err, time.Since(start))
+	c.logResult(req, attempt+1, resp, err, time.Since(start))
+	return resp, err
+}
+
+// attempt 发送一次请求，超时时间只作用于这一次尝试，读取 body 时仍然有效
+func (c *Client) attempt(req *http.Request) (*http.Response, error) {
+	if c.timeout <= 0 {
+		return c.transport.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), c.timeout)
+	resp, err := c.transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// retryableRequest 幂等的请求，并且 body 可以重新读取
+func (c *Client) retryableRequest(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	if c.retryAll || req.Header.Get(reqctx.IdempotencyKeyHeader) != "" {
+		return true
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func (c *Client) shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		// 调用方取消或者超时后不再重试
+		return ctx.Err() == nil
+	}
+	return c.statusCodes[resp.StatusCode]
+}
+
+// retryDelay 指数退避加随机抖动，响应中有 Retry-After 时使用它，超过 maxDelay 时不重试
+func (c *Client) retryDelay(attempt int, resp *http.Response) (time.Duration, bool) {
+	if resp != nil {
+		if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			return delay, delay <= c.maxDelay
+		}
+	}
+	ceiling := c.baseDelay << attempt
+	if ceiling <= 0 || ceiling > c.maxDelay {
+		ceiling = c.maxDelay
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1)), true
+}
+
+// parseRetryAfter 解析秒数或者 HTTP 日期格式的 Retry-After
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(at); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func (c *Client) logFields(req *http.Request) []iface.Field {
+	fields := []iface.Field{
+		log.String("client", c.name),
+		log.String("method", req.Method),
+		log.String("host", req.URL.Host),
+		log.String("path", req.URL.Path),
+	}
+	if id := reqctx.RequestIDFromContext(req.Context()); id != "" {
+		fields = append(fields, log.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(req.Context()); sc.IsValid() {
+		fields = append(fields, log.String("trace_id", sc.TraceID().String()), log.String("span_id", sc.SpanID().String()))
+	}
+	return fields
+}
+
+func (c *Client) logRetry(req *http.Request, attempt int, resp *http.Response, err error, delay time.Duration) {
+	fields := append(c.logFields(req), log.Int("attempt", attempt+1), log.Any("delay", delay))
+	if err != nil {
+		fields = append(fields, log.ErrorField(err))
+	} else {
+		fields = append(fields, log.Int("status", resp.StatusCode))
+	}
+	c.log.Warn("http client retry", fields...)
+}
+
+func (c *Client) logResult(req *http.Request, attempts int, resp *http.Response, err error, latency time.Duration) {
+	fields := append(c.logFields(req), log.Int("attempts", attempts), log.Any("latency", latency))
+	switch {
+	case err != nil:
+		c.log.Warn("http client request failed", append(fields, log.ErrorField(err))...)
+	case resp.StatusCode >= http.StatusInternalServerError:
+		c.log.Warn("http client request failed", append(fields, log.Int("status", resp.StatusCode))...)
+	default:
+		c.log.Debug("http client request", append(fields, log.Int("status", resp.StatusCode))...)
+	}
+}
+
+// cancelBody 关闭 body 时取消这次尝试的 context
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// Clients 按名称保存多个上游的客户端
+type Clients struct {
+	clients map[string]*Client
+	metrics *metrics
+}
+
+// NewClients 根据配置创建所有上游的客户端，opts 作用于每个客户端，指标在客户端之间共享
+func NewClients(config *Config, logger iface.ILogger, opts ...Option) (*Clients, error) {
+	probe := &Client{}
+	for _, opt := range opts {
+		opt(probe)
+	}
+	clients := &Clients{clients: make(map[string]*Client)}
+	if probe.metricsNamespace != "" {
+		clients.metrics = newMetrics(probe.metricsNamespace)
+		opts = append(opts, withSharedMetrics(clients.metrics))
+	}
+	for i := range config.Upstreams {
+		upstream := &config.Upstreams[i]
+		if upstream.Name == "" {
+			return nil, errors.New("http client upstream name is empty")
+		}
+		if _, found := clients.clients[upstream.Name]; found {
+			return nil, fmt.Errorf("http client upstream %q is duplicated", upstream.Name)
+		}
+		client, err := NewClient(upstream, logger, opts...)
+		if err != nil {
+			return nil, err
+		}
+		clients.clients[upstream.Name] = client
+	}
+	return clients, nil
+}
+
+// Get 返回指定名称的客户端，不存在时返回空
+func (c *Clients) Get(name string) *Client {
+	return c.clients[name]
+}
+
+// Collectors 返回所有客户端共享的请求指标，未开启指标时返回空
+func (c *Clients) Collectors() []prometheus.Collector {
+	return c.metrics.collectors()
+}
@@ -0,0 +1,50 @@
+package httpclient
+
+// Config 多个上游的 HTTP 客户端配置
+type Config struct {
+	Upstreams []UpstreamConfig `mapstructure:"upstreams" yaml:"upstreams"`
+}
+
+// UpstreamConfig 一个上游的 HTTP 客户端配置
+type UpstreamConfig struct {
+	Name                    string               `mapstructure:"name" yaml:"name"`                                             // 上游名称，用于日志和指标
+	BaseURL                 string               `mapstructure:"base_url" yaml:"base_url"`                                     // 不为空时相对路径基于这个地址，比如 https://api.partner.com/v1
+	Headers                 map[string]string    `mapstructure:"headers" yaml:"headers"`                                       // 每个请求默认添加的请求头，请求中已有时不覆盖
+	TimeoutMs               int                  `mapstructure:"timeout_ms" yaml:"timeout_ms"`                                 // 每次尝试的超时时间，默认 10 秒，小于 0 不限制
+	DialTimeoutMs           int                  `mapstructure:"dial_timeout_ms" yaml:"dial_timeout_ms"`                       // 建立连接的超时时间，默认 5 秒
+	TLSHandshakeTimeoutMs   int                  `mapstructure:"tls_handshake_timeout_ms" yaml:"tls_handshake_timeout_ms"`     // TLS 握手超时时间，默认 5 秒
+	ResponseHeaderTimeoutMs int                  `mapstructure:"response_header_timeout_ms" yaml:"response_header_timeout_ms"` // 等待响应头的超时时间，默认不限制
+	IdleConnTimeoutSecond   int                  `mapstructure:"idle_conn_timeout_second" yaml:"idle_conn_timeout_second"`     // 空闲连接保留时间，默认 90 秒
+	MaxIdleConns            int                  `mapstructure:"max_idle_conns" yaml:"max_idle_conns"`                         // 最大空闲连接数，默认 100
+	MaxIdleConnsPerHost     int                  `mapstructure:"max_idle_conns_per_host" yaml:"max_idle_conns_per_host"`       // 每个 host 最大空闲连接数，默认 10
+	MaxConnsPerHost         int                  `mapstructure:"max_conns_per_host" yaml:"max_conns_per_host"`                 // 每个 host 最大连接数，默认不限制
+	Proxy                   string               `mapstructure:"proxy" yaml:"proxy"`                                           // 代理地址，比如 http://proxy:3128，为空时使用 HTTP_PROXY 等环境变量，- 表示不使用代理
+	TLS                     TLSConfig            `mapstructure:"tls" yaml:"tls"`                                               // TLS 配置
+	Retry                   RetryConfig          `mapstructure:"retry" yaml:"retry"`                                           // 重试配置
+	CircuitBreaker          CircuitBreakerConfig `mapstructure:"circuit_breaker" yaml:"circuit_breaker"`                       // 熔断配置，每个 host 单独计算
+}
+
+// TLSConfig 连接上游的 TLS 配置
+type TLSConfig struct {
+	CAFile             string `mapstructure:"ca_file" yaml:"ca_file"`                           // 校验服务端证书使用的 CA，为空时使用系统 CA
+	CertFile           string `mapstructure:"cert_file" yaml:"cert_file"`                       // 双向 TLS 的客户端证书
+	KeyFile            string `mapstructure:"key_file" yaml:"key_file"`                         // 双向 TLS 的客户端私钥
+	ServerName         string `mapstructure:"server_name" yaml:"server_name"`                   // 校验证书使用的域名，默认使用请求的 host
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify" yaml:"insecure_skip_verify"` // 不校验服务端证书，只用于测试环境
+}
+
+// RetryConfig 重试配置，默认只重试幂等的请求（GET、HEAD、OPTIONS、PUT、DELETE 和带 Idempotency-Key 的请求）
+type RetryConfig struct {
+	MaxRetries         int   `mapstructure:"max_retries" yaml:"max_retries"`                   // 最多重试次数，默认 0 不重试
+	BaseDelayMs        int   `mapstructure:"base_delay_ms" yaml:"base_delay_ms"`               // 第一次重试前的最大等待时间，之后每次翻倍，实际等待时间随机，默认 100 毫秒
+	MaxDelayMs         int   `mapstructure:"max_delay_ms" yaml:"max_delay_ms"`                 // 最长等待时间，Retry-After 超过时不再重试，默认 5 秒
+	StatusCodes        []int `mapstructure:"status_codes" yaml:"status_codes"`                 // 重试的响应状态码，默认 429、502、503、504
+	RetryNonIdempotent bool  `mapstructure:"retry_non_idempotent" yaml:"retry_non_idempotent"` // 是否重试 POST、PATCH 等非幂等请求
+}
+
+// CircuitBreakerConfig 熔断配置，连续失败（网络错误或 5xx）达到阈值后在一段时间内直接返回 ErrCircuitOpen
+type CircuitBreakerConfig struct {
+	FailureThreshold int `mapstructure:"failure_threshold" yaml:"failure_threshold"`   // 连续失败多少次后熔断，默认 5，小于 0 不启用
+	OpenSecond       int `mapstructure:"open_second" yaml:"open_second"`               // 熔断持续时间，之后放行少量请求探测，默认 30 秒
+	HalfOpenRequests int `mapstructure:"half_open_requests" yaml:"half_open_requests"` // 探测时同时放行的请求数，默认 1
+}
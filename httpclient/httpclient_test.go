@@ -0,0 +1,329 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/yangkushu/rum-go/httpclient/httpclienttest"
+	"github.com/yangkushu/rum-go/log/logtest"
+	"github.com/yangkushu/rum-go/reqctx"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, config *UpstreamConfig, handler http.Handler, opts ...Option) (*Client, *httpclienttest.Transport, *logtest.Logger) {
+	transport := httpclienttest.NewTransport(handler)
+	logger := logtest.New()
+	client, err := NewClient(config, logger, append(opts, WithTransport(transport))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, transport, logger
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestClientRetriesIdempotentRequests(t *testing.T) {
+	config := &UpstreamConfig{
+		Name:    "partner",
+		BaseURL: "https://partner.example.com/v1/",
+		Headers: map[string]string{"X-Api-Key": "key"},
+		Retry:   RetryConfig{MaxRetries: 3, BaseDelayMs: 1, MaxDelayMs: 10},
+	}
+	handler := httpclienttest.Sequence(
+		httpclienttest.Response{Status: http.StatusServiceUnavailable},
+		httpclienttest.Response{Status: http.StatusBadGateway},
+		httpclienttest.Response{Status: http.StatusOK, Body: "ok"},
+	)
+	client, transport, logger := newTestClient(t, config, handler, WithMetrics("test"))
+
+	resp, err := client.Get(context.Background(), "/users/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || readBody(t, resp) != "ok" {
+		t.Fatalf("unexpected response %d", resp.StatusCode)
+	}
+	requests := transport.Requests()
+	if len(requests) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(requests))
+	}
+	if requests[0].URL != "https://partner.example.com/v1/users/1" {
+		t.Fatalf("unexpected url %s", requests[0].URL)
+	}
+	if requests[2].Header.Get("X-Api-Key") != "key" {
+		t.Fatal("default header not added")
+	}
+	if len(logger.ByMessage("http client retry")) != 2 {
+		t.Fatalf("expected 2 retry logs, got %v", logger.Entries())
+	}
+	if v := testutil.ToFloat64(client.metrics.retries.WithLabelValues("partner")); v != 2 {
+		t.Fatalf("expected 2 retries, got %v", v)
+	}
+	if v := testutil.ToFloat64(client.metrics.requests.WithLabelValues("partner", http.MethodGet, "2xx")); v != 1 {
+		t.Fatalf("expected 1 successful request, got %v", v)
+	}
+}
+
+func TestClientRetryReplaysBody(t *testing.T) {
+	config := &UpstreamConfig{Retry: RetryConfig{MaxRetries: 1, BaseDelayMs: 1}}
+	handler := httpclienttest.Sequence(
+		httpclienttest.Response{Status: http.StatusTooManyRequests},
+		httpclienttest.Response{Status: http.StatusOK},
+	)
+	client, transport, _ := newTestClient(t, config, handler)
+
+	req, _ := http.NewRequest(http.MethodPut, "http://svc/items/1", strings.NewReader(`{"a":1}`))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	requests := transport.Requests()
+	if len(requests) != 2 || string(requests[1].Body) != `{"a":1}` {
+		t.Fatalf("body not replayed: %+v", requests)
+	}
+}
+
+func TestClientDoesNotRetryNonIdempotent(t *testing.T) {
+	config := &UpstreamConfig{Retry: RetryConfig{MaxRetries: 3, BaseDelayMs: 1}}
+	handler := httpclienttest.Sequence(httpclienttest.Response{Status: http.StatusServiceUnavailable})
+	client, transport, _ := newTestClient(t, config, handler)
+
+	resp, err := client.Post(context.Background(), "http://svc/orders", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if n := len(transport.Requests()); n != 1 {
+		t.Fatalf("POST should not be retried, got %d attempts", n)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, "http://svc/orders", strings.NewReader("{}"))
+	req.Header.Set(reqctx.IdempotencyKeyHeader, "order-1")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if n := len(transport.Requests()); n != 5 {
+		t.Fatalf("POST with idempotency key should be retried, got %d attempts", n)
+	}
+}
+
+func TestClientRetryAfter(t *testing.T) {
+	config := &UpstreamConfig{Retry: RetryConfig{MaxRetries: 2, BaseDelayMs: 1, MaxDelayMs: 2000}}
+	handler := httpclienttest.Sequence(
+		httpclienttest.Response{Status: http.StatusTooManyRequests, Header: map[string]string{"Retry-After": "1"}},
+		httpclienttest.Response{Status: http.StatusOK},
+	)
+	client, transport, _ := newTestClient(t, config, handler)
+
+	start := time.Now()
+	resp, err := client.Get(context.Background(), "http://svc/limited")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("Retry-After not honored, elapsed %v", elapsed)
+	}
+	if n := len(transport.Requests()); n != 2 {
+		t.Fatalf("expected 2 attempts, got %d", n)
+	}
+
+	// Retry-After 超过 MaxDelay 时直接返回
+	config.Retry.MaxDelayMs = 500
+	handler = httpclienttest.Sequence(httpclienttest.Response{Status: http.StatusTooManyRequests, Header: map[string]string{"Retry-After": "60"}})
+	client, transport, _ = newTestClient(t, config, handler)
+	resp, err = client.Get(context.Background(), "http://svc/limited")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || len(transport.Requests()) != 1 {
+		t.Fatalf("expected no retry, got status %d after %d attempts", resp.StatusCode, len(transport.Requests()))
+	}
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	config := &UpstreamConfig{Name: "flaky", CircuitBreaker: CircuitBreakerConfig{FailureThreshold: 2, OpenSecond: 30}}
+	handler := httpclienttest.Sequence(
+		httpclienttest.Response{Status: http.StatusInternalServerError},
+		httpclienttest.Response{Status: http.StatusInternalServerError},
+		httpclienttest.Response{Status: http.StatusOK},
+	)
+	client, transport, logger := newTestClient(t, config, handler, WithMetrics("test"))
+	now := time.Now()
+	client.breakers.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(context.Background(), "http://svc/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+	}
+	if _, err := client.Get(context.Background(), "http://svc/"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	if n := len(transport.Requests()); n != 2 {
+		t.Fatalf("open circuit should not send requests, got %d", n)
+	}
+	if !logger.HasField("http client circuit breaker state changed", "state", breakerOpen) {
+		t.Fatal("expected open state log")
+	}
+	if v := testutil.ToFloat64(client.metrics.breaker.WithLabelValues("flaky", "svc")); v != 2 {
+		t.Fatalf("expected open gauge, got %v", v)
+	}
+
+	// 熔断时间过后放行一个探测请求，成功后恢复
+	now = now.Add(31 * time.Second)
+	resp, err := client.Get(context.Background(), "http://svc/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if v := testutil.ToFloat64(client.metrics.breaker.WithLabelValues("flaky", "svc")); v != 0 {
+		t.Fatalf("expected closed gauge, got %v", v)
+	}
+	if v := testutil.ToFloat64(client.metrics.requests.WithLabelValues("flaky", http.MethodGet, "circuit_open")); v != 1 {
+		t.Fatalf("expected 1 rejected request, got %v", v)
+	}
+}
+
+func TestBreakerHalfOpenFailureReopens(t *testing.T) {
+	b := newBreakers(&CircuitBreakerConfig{FailureThreshold: 1, OpenSecond: 1}, nil)
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	b.done("h", false)
+	if b.allow("h") {
+		t.Fatal("expected open")
+	}
+	now = now.Add(2 * time.Second)
+	if !b.allow("h") {
+		t.Fatal("expected probe to be allowed")
+	}
+	if b.allow("h") {
+		t.Fatal("only one probe should be allowed")
+	}
+	b.done("h", false)
+	if b.allow("h") {
+		t.Fatal("failed probe should reopen the circuit")
+	}
+
+	if newBreakers(&CircuitBreakerConfig{FailureThreshold: -1}, nil) != nil {
+		t.Fatal("negative threshold should disable the breaker")
+	}
+}
+
+func TestClientPropagatesRequestIDAndDeadline(t *testing.T) {
+	client, transport, _ := newTestClient(t, &UpstreamConfig{}, httpclienttest.Sequence())
+
+	ctx := reqctx.ContextWithRequestID(context.Background(), "req-1")
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	resp, err := client.Get(ctx, "http://svc/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	header := transport.Requests()[0].Header
+	if header.Get(reqctx.RequestIDHeader) != "req-1" {
+		t.Fatalf("request id not propagated: %v", header)
+	}
+	if header.Get(reqctx.RequestTimeoutHeader) == "" {
+		t.Fatalf("deadline not propagated: %v", header)
+	}
+}
+
+func TestClientTransportWrapper(t *testing.T) {
+	var wrapped int
+	wrapper := WithTransportWrapper(func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			wrapped++
+			return next.RoundTrip(req)
+		})
+	})
+	config := &UpstreamConfig{Retry: RetryConfig{MaxRetries: 1, BaseDelayMs: 1}}
+	client, _, _ := newTestClient(t, config, httpclienttest.Sequence(
+		httpclienttest.Response{Status: http.StatusServiceUnavailable},
+		httpclienttest.Response{Status: http.StatusOK},
+	), wrapper)
+	resp, err := client.Get(context.Background(), "http://svc/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if wrapped != 2 {
+		t.Fatalf("wrapper should see every attempt, got %d", wrapped)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestClientAttemptTimeout(t *testing.T) {
+	config := &UpstreamConfig{TimeoutMs: 20, Retry: RetryConfig{MaxRetries: 1, BaseDelayMs: 1}}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	client, transport, logger := newTestClient(t, config, handler)
+	if _, err := client.Get(context.Background(), "http://svc/slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if n := len(transport.Requests()); n != 2 {
+		t.Fatalf("timed out attempt should be retried, got %d attempts", n)
+	}
+	if len(logger.ByMessage("http client request failed")) != 1 {
+		t.Fatal("expected failure log")
+	}
+}
+
+func TestNewClients(t *testing.T) {
+	config := &Config{Upstreams: []UpstreamConfig{{Name: "a"}, {Name: "b"}}}
+	clients, err := NewClients(config, logtest.New(), WithMetrics("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clients.Get("a") == nil || clients.Get("b") == nil || clients.Get("c") != nil {
+		t.Fatal("unexpected clients")
+	}
+	if clients.Get("a").metrics != clients.Get("b").metrics || len(clients.Collectors()) != 4 {
+		t.Fatal("metrics should be shared")
+	}
+
+	config.Upstreams = append(config.Upstreams, UpstreamConfig{Name: "a"})
+	if _, err := NewClients(config, logtest.New()); err == nil {
+		t.Fatal("expected duplicate name error")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if d, ok := parseRetryAfter("3"); !ok || d != 3*time.Second {
+		t.Fatalf("unexpected %v %v", d, ok)
+	}
+	if d, ok := parseRetryAfter(time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)); !ok || d != 0 {
+		t.Fatalf("unexpected %v %v", d, ok)
+	}
+	if _, ok := parseRetryAfter("soon"); ok {
+		t.Fatal("expected invalid")
+	}
+}
@@ -0,0 +1,105 @@
+package httpclienttest
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// Request 一个被记录的请求
+type Request struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+}
+
+// Transport 用于测试的 http.RoundTripper，不经过网络，直接在进程内调用 handler 并记录所有请求
+type Transport struct {
+	handler http.Handler
+
+	mu       sync.Mutex
+	requests []Request
+}
+
+// NewTransport 创建测试用的 Transport，配合 httpclient.WithTransport 使用
+func NewTransport(handler http.Handler) *Transport {
+	return &Transport{handler: handler}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	t.mu.Lock()
+	t.requests = append(t.requests, Request{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Header: req.Header.Clone(),
+		Body:   body,
+	})
+	t.mu.Unlock()
+
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	r := req.Clone(req.Context())
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.RequestURI = req.URL.RequestURI()
+	recorder := httptest.NewRecorder()
+	t.handler.ServeHTTP(recorder, r)
+	// 和真实的 Transport 一样，请求被取消或者超时后返回错误
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	resp := recorder.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+// Requests 返回已经收到的请求
+func (t *Transport) Requests() []Request {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Request(nil), t.requests...)
+}
+
+// Response 预设的响应
+type Response struct {
+	Status int
+	Header map[string]string
+	Body   string
+}
+
+// Sequence 按顺序返回预设的响应，用完后一直返回最后一个
+func Sequence(responses ...Response) http.Handler {
+	var mu sync.Mutex
+	next := 0
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		resp := Response{Status: http.StatusOK}
+		if len(responses) > 0 {
+			resp = responses[next]
+			if next < len(responses)-1 {
+				next++
+			}
+		}
+		mu.Unlock()
+		for name, value := range resp.Header {
+			w.Header().Set(name, value)
+		}
+		if resp.Status == 0 {
+			resp.Status = http.StatusOK
+		}
+		w.WriteHeader(resp.Status)
+		_, _ = io.WriteString(w, resp.Body)
+	})
+}
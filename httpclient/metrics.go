@@ -0,0 +1,85 @@
+package httpclient
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type metrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	retries  *prometheus.CounterVec
+	breaker  *prometheus.GaugeVec
+}
+
+func newMetrics(namespace string) *metrics {
+	return &metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_client_requests_total",
+			Help:      "Outbound HTTP requests, partitioned by client, method and status class.",
+		}, []string{"client", "method", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_client_request_duration_seconds",
+			Help:      "Latency of outbound HTTP requests including retries.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"client"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_client_retries_total",
+			Help:      "Outbound HTTP request retries, partitioned by client.",
+		}, []string{"client"}),
+		breaker: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "http_client_circuit_breaker_state",
+			Help:      "Circuit breaker state per host: 0 closed, 1 half open, 2 open.",
+		}, []string{"client", "host"}),
+	}
+}
+
+func (m *metrics) collectors() []prometheus.Collector {
+	if m == nil {
+		return nil
+	}
+	return []prometheus.Collector{m.requests, m.duration, m.retries, m.breaker}
+}
+
+func (m *metrics) observe(client, method string, resp *http.Response, err error, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	status := "error"
+	switch {
+	case errors.Is(err, ErrCircuitOpen):
+		status = "circuit_open"
+	case err == nil:
+		status = strconv.Itoa(resp.StatusCode/100) + "xx"
+	}
+	m.requests.WithLabelValues(client, method, status).Inc()
+	m.duration.WithLabelValues(client).Observe(latency.Seconds())
+}
+
+func (m *metrics) retry(client string) {
+	if m == nil {
+		return
+	}
+	m.retries.WithLabelValues(client).Inc()
+}
+
+func (m *metrics) setBreakerState(client, host, state string) {
+	if m == nil {
+		return
+	}
+	value := 0.0
+	switch state {
+	case breakerHalfOpen:
+		value = 1
+	case breakerOpen:
+		value = 2
+	}
+	m.breaker.WithLabelValues(client, host).Set(value)
+}
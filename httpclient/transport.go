@@ -0,0 +1,107 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	defaultDialTimeout         = 5 * time.Second
+	defaultTLSHandshakeTimeout = 5 * time.Second
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 10
+)
+
+// NewTransport 根据配置创建连接池，Client 默认使用，也可以单独给第三方 SDK 使用
+func NewTransport(config *UpstreamConfig) (*http.Transport, error) {
+	dialer := &net.Dialer{
+		Timeout:   durationMs(config.DialTimeoutMs, defaultDialTimeout),
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          config.MaxIdleConns,
+		MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       config.MaxConnsPerHost,
+		IdleConnTimeout:       time.Duration(config.IdleConnTimeoutSecond) * time.Second,
+		TLSHandshakeTimeout:   durationMs(config.TLSHandshakeTimeoutMs, defaultTLSHandshakeTimeout),
+		ResponseHeaderTimeout: time.Duration(config.ResponseHeaderTimeoutMs) * time.Millisecond,
+		ExpectContinueTimeout: time.Second,
+	}
+	if transport.MaxIdleConns <= 0 {
+		transport.MaxIdleConns = defaultMaxIdleConns
+	}
+	if transport.MaxIdleConnsPerHost <= 0 {
+		transport.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+	if transport.IdleConnTimeout <= 0 {
+		transport.IdleConnTimeout = defaultIdleConnTimeout
+	}
+
+	switch config.Proxy {
+	case "":
+	case "-":
+		transport.Proxy = nil
+	default:
+		proxyURL, err := url.Parse(config.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid http client proxy %q", config.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig, err := newTLSConfig(&config.TLS)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+func newTLSConfig(config *TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read http client ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("http client ca file contains no certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.CertFile != "" || config.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load http client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// durationMs 把毫秒转换为时间，0 时使用默认值，小于 0 时返回 0 表示不限制
+func durationMs(ms int, defaultValue time.Duration) time.Duration {
+	switch {
+	case ms == 0:
+		return defaultValue
+	case ms < 0:
+		return 0
+	}
+	return time.Duration(ms) * time.Millisecond
+}
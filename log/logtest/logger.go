@@ -0,0 +1,247 @@
+package logtest
+
+import (
+	"fmt"
+	"github.com/yangkushu/rum-go/iface"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 日志级别，与 zapcore 的小写级别名保持一致
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+// Entry 一条被记录的日志
+type Entry struct {
+	Level   string
+	Message string
+	Fields  map[string]interface{} // 解码后的字段，key 为字段名
+}
+
+// Field 获取字段值，不存在时 ok 为 false
+func (e Entry) Field(key string) (interface{}, bool) {
+	v, ok := e.Fields[key]
+	return v, ok
+}
+
+// String 方便在测试失败时输出
+func (e Entry) String() string {
+	return fmt.Sprintf("[%s] %s %v", e.Level, e.Message, e.Fields)
+}
+
+// Logger 用于测试的 iface.ILogger 实现，会记录所有日志条目以便断言
+type Logger struct {
+	mu      sync.RWMutex
+	entries []Entry
+	level   zapcore.Level
+	t       testing.TB
+}
+
+// Option 定义配置函数类型
+type Option func(*Logger)
+
+// WithT 将日志同时输出到 t.Log，便于 go test -v 时查看
+func WithT(t testing.TB) Option {
+	return func(l *Logger) {
+		l.t = t
+	}
+}
+
+// WithLevel 设置最低记录级别，默认 debug
+func WithLevel(level string) Option {
+	return func(l *Logger) {
+		if lvl, err := zapcore.ParseLevel(level); err == nil {
+			l.level = lvl
+		}
+	}
+}
+
+// New 创建一个可观察的测试日志
+func New(options ...Option) *Logger {
+	l := &Logger{level: zapcore.DebugLevel}
+	for _, option := range options {
+		option(l)
+	}
+	return l
+}
+
+func (l *Logger) Sync() error {
+	return nil
+}
+
+func (l *Logger) Info(msg string, fields ...iface.Field) {
+	l.record(zapcore.InfoLevel, msg, fields)
+}
+
+func (l *Logger) Warn(msg string, fields ...iface.Field) {
+	l.record(zapcore.WarnLevel, msg, fields)
+}
+
+func (l *Logger) Error(msg string, fields ...iface.Field) {
+	l.record(zapcore.ErrorLevel, msg, fields)
+}
+
+func (l *Logger) Debug(msg string, fields ...iface.Field) {
+	l.record(zapcore.DebugLevel, msg, fields)
+}
+
+func (l *Logger) GetLevel() string {
+	return l.level.String()
+}
+
+// Entries 返回所有日志条目的副本
+func (l *Logger) Entries() []Entry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	entries := make([]Entry, len(l.entries))
+	copy(entries, l.entries)
+	return entries
+}
+
+// Len 日志条目数量
+func (l *Logger) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.entries)
+}
+
+// Reset 清空已记录的日志
+func (l *Logger) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = nil
+}
+
+// Filter 返回满足条件的日志条目
+func (l *Logger) Filter(fn func(Entry) bool) []Entry {
+	var result []Entry
+	for _, e := range l.Entries() {
+		if fn(e) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// ByLevel 返回指定级别的日志条目
+func (l *Logger) ByLevel(level string) []Entry {
+	return l.Filter(func(e Entry) bool {
+		return e.Level == level
+	})
+}
+
+// ByMessage 返回消息完全相同的日志条目
+func (l *Logger) ByMessage(msg string) []Entry {
+	return l.Filter(func(e Entry) bool {
+		return e.Message == msg
+	})
+}
+
+// CountByLevel 统计指定级别的日志数量
+func (l *Logger) CountByLevel(level string) int {
+	return len(l.ByLevel(level))
+}
+
+// ContainsMessage 是否存在包含 substr 的日志消息
+func (l *Logger) ContainsMessage(substr string) bool {
+	return len(l.Filter(func(e Entry) bool {
+		return strings.Contains(e.Message, substr)
+	})) > 0
+}
+
+// HasField 是否存在消息为 msg 且字段 key 等于 value 的日志。msg 为空时匹配所有消息
+// 数值类型按字符串形式比较，避免 int/int64 等类型差异导致误判
+func (l *Logger) HasField(msg, key string, value interface{}) bool {
+	return len(l.Filter(func(e Entry) bool {
+		if msg != "" && e.Message != msg {
+			return false
+		}
+		v, ok := e.Fields[key]
+		return ok && equalValue(v, value)
+	})) > 0
+}
+
+// AssertContainsMessage 断言存在包含 substr 的日志
+func (l *Logger) AssertContainsMessage(t testing.TB, substr string) {
+	t.Helper()
+	if !l.ContainsMessage(substr) {
+		t.Errorf("logtest: no entry contains message %q, entries: %v", substr, l.Entries())
+	}
+}
+
+// AssertField 断言存在消息为 msg 且字段 key 等于 value 的日志
+func (l *Logger) AssertField(t testing.TB, msg, key string, value interface{}) {
+	t.Helper()
+	if !l.HasField(msg, key, value) {
+		t.Errorf("logtest: no entry %q with field %s=%v, entries: %v", msg, key, value, l.Entries())
+	}
+}
+
+// AssertCount 断言指定级别的日志数量
+func (l *Logger) AssertCount(t testing.TB, level string, count int) {
+	t.Helper()
+	if n := l.CountByLevel(level); n != count {
+		t.Errorf("logtest: expected %d %s entries, got %d, entries: %v", count, level, n, l.Entries())
+	}
+}
+
+func (l *Logger) record(level zapcore.Level, msg string, fields []iface.Field) {
+	if !l.level.Enabled(level) {
+		return
+	}
+	entry := Entry{
+		Level:   level.String(),
+		Message: msg,
+		Fields:  decodeFields(fields),
+	}
+	l.mu.Lock()
+	l.entries = append(l.entries, entry)
+	l.mu.Unlock()
+
+	if l.t != nil {
+		l.t.Helper()
+		l.t.Log(entry.String())
+	}
+}
+
+// decodeFields 将 zap.Field 解码成 map，非 zap.Field 的字段会被忽略，与 log.Logger 的行为一致
+func decodeFields(fields []iface.Field) map[string]interface{} {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		if zf, ok := f.(zap.Field); ok {
+			zf.AddTo(enc)
+		}
+	}
+	return enc.Fields
+}
+
+// equalValue 比较字段值，切片、map 这类不能用 == 比较的值使用 reflect.DeepEqual
+func equalValue(actual, expected interface{}) bool {
+	if reflect.DeepEqual(actual, expected) {
+		return true
+	}
+	return fmt.Sprint(actual) == fmt.Sprint(expected)
+}
+
+// Nop 丢弃所有日志的 iface.ILogger
+type Nop struct{}
+
+// NewNop 创建一个丢弃所有日志的 ILogger，用来替代测试中的 nil
+func NewNop() iface.ILogger {
+	return Nop{}
+}
+
+func (Nop) Sync() error                             { return nil }
+func (Nop) Info(msg string, fields ...iface.Field)  {}
+func (Nop) Warn(msg string, fields ...iface.Field)  {}
+func (Nop) Error(msg string, fields ...iface.Field) {}
+func (Nop) Debug(msg string, fields ...iface.Field) {}
+func (Nop) GetLevel() string                        { return LevelInfo }
@@ -0,0 +1,66 @@
+package logtest
+
+import (
+	"errors"
+	"github.com/yangkushu/rum-go/iface"
+	"github.com/yangkushu/rum-go/log"
+	"testing"
+)
+
+func TestLoggerRecordsEntries(t *testing.T) {
+	l := New(WithT(t))
+
+	var logger iface.ILogger = l
+	logger.Info("request done", log.String("path", "/ping"), log.Int("status", 200))
+	logger.Error("request failed", log.ErrorField(errors.New("boom")))
+	logger.Debug("debug message")
+
+	if l.Len() != 3 {
+		t.Fatalf("expected 3 entries, got %d", l.Len())
+	}
+	l.AssertContainsMessage(t, "done")
+	l.AssertField(t, "request done", "path", "/ping")
+	l.AssertField(t, "request done", "status", 200)
+	l.AssertField(t, "", "error", "boom")
+	l.AssertCount(t, LevelError, 1)
+	l.AssertCount(t, LevelWarn, 0)
+
+	// 切片和 map 不能用 == 比较
+	logger.Info("batch", log.Any("ids", []interface{}{"a", "b"}), log.Any("tags", map[string]interface{}{"env": "test"}))
+	l.AssertField(t, "batch", "ids", []interface{}{"a", "b"})
+	l.AssertField(t, "batch", "tags", map[string]interface{}{"env": "test"})
+	if l.HasField("batch", "ids", []interface{}{"a"}) {
+		t.Error("unexpected match on a different slice")
+	}
+
+	if l.HasField("request failed", "path", "/ping") {
+		t.Error("unexpected field match on a different message")
+	}
+
+	l.Reset()
+	if l.Len() != 0 {
+		t.Errorf("expected no entries after reset, got %d", l.Len())
+	}
+}
+
+func TestLoggerLevel(t *testing.T) {
+	l := New(WithLevel("warn"))
+	l.Debug("ignored")
+	l.Info("ignored")
+	l.Warn("kept")
+
+	if l.Len() != 1 || l.Entries()[0].Level != LevelWarn {
+		t.Errorf("expected only the warn entry, got %v", l.Entries())
+	}
+	if l.GetLevel() != LevelWarn {
+		t.Errorf("expected level warn, got %s", l.GetLevel())
+	}
+}
+
+func TestNop(t *testing.T) {
+	logger := NewNop()
+	logger.Error("nothing", log.String("k", "v"))
+	if err := logger.Sync(); err != nil {
+		t.Error(err)
+	}
+}
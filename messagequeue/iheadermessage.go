@@ -0,0 +1,32 @@
+package messagequeue
+
+// IHeaderMessage 带消息头的消息，发送到 kafka 时会写入消息的 headers，用来传递请求ID等上下文信息
+type IHeaderMessage interface {
+	IKeyMessage
+	GetHeaders() map[string]string
+}
+
+func NewHeaderMessage(key []byte, message []byte) *HeaderMessage {
+	return &HeaderMessage{
+		KeyMessage: KeyMessage{
+			key:     key,
+			message: message,
+		},
+		headers: make(map[string]string),
+	}
+}
+
+type HeaderMessage struct {
+	KeyMessage
+	headers map[string]string
+}
+
+// SetHeader 设置消息头，返回自身方便链式调用
+func (m *HeaderMessage) SetHeader(key, value string) *HeaderMessage {
+	m.headers[key] = value
+	return m
+}
+
+func (m *HeaderMessage) GetHeaders() map[string]string {
+	return m.headers
+}
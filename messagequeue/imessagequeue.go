@@ -1,9 +1,25 @@
 package messagequeue
 
-import "io"
+import (
+	"context"
+	"io"
+)
 
 type IMessageQueue interface {
 	Publish(topic Topic, message interface{}) error
 	Subscribe(topic Topic, groupId string, handler IMessageSubscriber) error
 	io.Closer
 }
+
+// IContextPublisher 支持 context 的发布，ctx 超时或取消时停止发送
+type IContextPublisher interface {
+	PublishContext(ctx context.Context, topic Topic, message interface{}) error
+}
+
+// PublishContext 消息队列实现了 IContextPublisher 时使用 ctx 发布，否则调用 Publish
+func PublishContext(ctx context.Context, mq IMessageQueue, topic Topic, message interface{}) error {
+	if publisher, ok := mq.(IContextPublisher); ok {
+		return publisher.PublishContext(ctx, topic, message)
+	}
+	return mq.Publish(topic, message)
+}
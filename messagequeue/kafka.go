@@ -152,12 +152,18 @@ func (k *Kafka) Close() error {
 // Publish sends a message to the specified topic.
 // message 会根据类型做不同的处理
 func (k *Kafka) Publish(topic Topic, message interface{}) error {
+	return k.PublishContext(context.Background(), topic, message)
+}
+
+// PublishContext 和 Publish 相同，ctx 超时或取消时停止发送，比如使用请求的 context
+func (k *Kafka) PublishContext(ctx context.Context, topic Topic, message interface{}) error {
 	if k.writer == nil {
 		return errors.New("kafka writer (producer) is not initialized")
 	}
 
 	var value []byte
 	var key []byte
+	var headers []kafka.Header
 
 	// Transform message to appropriate format
 	switch msg := message.(type) {
@@ -165,6 +171,16 @@ func (k *Kafka) Publish(topic Topic, message interface{}) error {
 		value = []byte(msg)
 	case []byte:
 		value = msg
+	case IHeaderMessage:
+		var err error
+		value, err = msg.GetMessageData()
+		if err != nil {
+			return fmt.Errorf("failed to get message data:%w", err)
+		}
+		key = msg.GetKey()
+		for k, v := range msg.GetHeaders() {
+			headers = append(headers, kafka.Header{Key: k, Value: []byte(v)})
+		}
 	case IKeyMessage:
 		var err error
 		value, err = msg.GetMessageData()
@@ -178,7 +194,7 @@ func (k *Kafka) Publish(topic Topic, message interface{}) error {
 			return fmt.Errorf("failed to marshal message to JSON:%w", err)
 		}
 		// Recursively call Publish with the JSON string
-		return k.Publish(topic, string(encoded))
+		return k.PublishContext(ctx, topic, string(encoded))
 	}
 
 	if value == nil {
@@ -196,8 +212,12 @@ func (k *Kafka) Publish(topic Topic, message interface{}) error {
 		kafkaMsg.Key = key
 	}
 
+	if len(headers) > 0 {
+		kafkaMsg.Headers = headers
+	}
+
 	// Send the message to Kafka
-	err := k.writer.WriteMessages(context.Background(), kafkaMsg)
+	err := k.writer.WriteMessages(ctx, kafkaMsg)
 	if err != nil {
 		return fmt.Errorf("failed to send message to Kafka:%w", err)
 	}
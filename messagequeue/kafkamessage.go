@@ -29,3 +29,13 @@ func (m *KafKaMessage) GetTopic() string {
 func (m *KafKaMessage) GetKey() []byte {
 	return m.originalMsg.Key
 }
+
+// GetHeader 获取消息头，不存在时返回空字符串
+func (m *KafKaMessage) GetHeader(key string) string {
+	for _, h := range m.originalMsg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
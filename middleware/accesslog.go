@@ -1,42 +1,278 @@
 package middleware
 
 import (
-	"fmt"
+	"bytes"
 	"github.com/gin-gonic/gin"
 	"github.com/yangkushu/rum-go/iface"
+	"github.com/yangkushu/rum-go/log"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
 	"time"
 )
 
-// AccessLog 请求日志中间件
+const (
+	defaultAccessLogBodyLimit = 4 << 10 // 默认最多记录 4KB 的 body
+	defaultAccessLogUserIDKey = "user_id"
+)
+
+// 默认允许记录 body 的内容类型
+var defaultAccessLogBodyContentTypes = []string{
+	"application/json",
+	"application/x-www-form-urlencoded",
+	"application/xml",
+	"text/",
+}
+
+// AccessLog 请求日志中间件，每个请求输出一条结构化日志
 type AccessLog struct {
-	log iface.ILogger
+	log              iface.ILogger
+	skipPaths        map[string]struct{}
+	sampleRate       float64       // 成功请求的采样率，(0,1]，默认 1 全部记录
+	slowThreshold    time.Duration // 慢请求阈值，超过时以 warn 级别记录，0 表示不启用
+	levelByStatus    bool          // 按状态码决定日志级别，5xx error，4xx warn
+	userIDKey        string        // gin.Context 中用户ID的 key
+	logRequestBody   bool
+	logResponseBody  bool
+	bodyLimit        int      // 记录的 body 最大字节数
+	bodyContentTypes []string // 允许记录 body 的内容类型前缀
+}
+
+// OptionAccessLog 定义配置函数类型
+type OptionAccessLog func(*AccessLog)
+
+// WithAccessLogSkipPaths 不记录日志的路径，比如健康检查
+func WithAccessLogSkipPaths(paths ...string) OptionAccessLog {
+	return func(a *AccessLog) {
+		for _, path := range paths {
+			a.skipPaths[path] = struct{}{}
+		}
+	}
+}
+
+// WithAccessLogSampleRate 成功请求的采样率，失败和慢请求始终记录
+func WithAccessLogSampleRate(rate float64) OptionAccessLog {
+	return func(a *AccessLog) {
+		a.sampleRate = rate
+	}
+}
+
+// WithAccessLogSlowThreshold 慢请求阈值，超过阈值的请求以 warn 级别记录
+func WithAccessLogSlowThreshold(threshold time.Duration) OptionAccessLog {
+	return func(a *AccessLog) {
+		a.slowThreshold = threshold
+	}
 }
 
-func NewAccessLog(log iface.ILogger) *AccessLog {
-	return &AccessLog{
-		log: log,
+// WithAccessLogLevelByStatus 按状态码决定日志级别
+func WithAccessLogLevelByStatus(enable bool) OptionAccessLog {
+	return func(a *AccessLog) {
+		a.levelByStatus = enable
 	}
 }
 
+// WithAccessLogUserIDKey 设置从 gin.Context 中读取用户ID的 key，默认 user_id
+func WithAccessLogUserIDKey(key string) OptionAccessLog {
+	return func(a *AccessLog) {
+		a.userIDKey = key
+	}
+}
+
+// WithAccessLogRequestBody 记录请求 body
+func WithAccessLogRequestBody(enable bool) OptionAccessLog {
+	return func(a *AccessLog) {
+		a.logRequestBody = enable
+	}
+}
+
+// WithAccessLogResponseBody 记录响应 body
+func WithAccessLogResponseBody(enable bool) OptionAccessLog {
+	return func(a *AccessLog) {
+		a.logResponseBody = enable
+	}
+}
+
+// WithAccessLogBodyLimit 记录 body 的最大字节数，超出部分会被截断
+func WithAccessLogBodyLimit(limit int) OptionAccessLog {
+	return func(a *AccessLog) {
+		a.bodyLimit = limit
+	}
+}
+
+// WithAccessLogBodyContentTypes 允许记录 body 的内容类型前缀，比如 application/json、text/
+func WithAccessLogBodyContentTypes(contentTypes ...string) OptionAccessLog {
+	return func(a *AccessLog) {
+		a.bodyContentTypes = contentTypes
+	}
+}
+
+func NewAccessLog(log iface.ILogger, options ...OptionAccessLog) *AccessLog {
+	a := &AccessLog{
+		log:              log,
+		skipPaths:        make(map[string]struct{}),
+		sampleRate:       1,
+		levelByStatus:    true,
+		userIDKey:        defaultAccessLogUserIDKey,
+		bodyLimit:        defaultAccessLogBodyLimit,
+		bodyContentTypes: defaultAccessLogBodyContentTypes,
+	}
+	for _, option := range options {
+		option(a)
+	}
+	return a
+}
+
 func (a *AccessLog) HandlerFunc() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		start := time.Now()
 		path := c.Request.URL.Path
-		//query := c.Request.URL.RawQuery
+		if _, ok := a.skipPaths[path]; ok {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+
+		var requestBody []byte
+		if a.logRequestBody && a.allowBody(c.ContentType()) {
+			requestBody = a.peekRequestBody(c)
+		}
+
+		var bodyWriter *accessLogBodyWriter
+		if a.logResponseBody {
+			bodyWriter = &accessLogBodyWriter{ResponseWriter: c.Writer, limit: a.bodyLimit}
+			c.Writer = bodyWriter
+		}
+
 		c.Next()
-		end := time.Now()
-		latency := end.Sub(start)
-		clientIP := c.ClientIP()
-		method := c.Request.Method
+
+		latency := time.Since(start)
 		statusCode := c.Writer.Status()
-		comment := c.Errors.ByType(gin.ErrorTypePrivate).String()
-		a.log.Info(fmt.Sprintf("| %3d | %13v | %15s | %s  %s | %s |",
-			statusCode,
-			latency,
-			clientIP,
-			method,
-			path,
-			comment,
-		))
+		slow := a.slowThreshold > 0 && latency >= a.slowThreshold
+		if statusCode < http.StatusBadRequest && !slow && !a.sampled() {
+			return
+		}
+
+		fields := []iface.Field{
+			log.String("method", c.Request.Method),
+			log.String("route", c.FullPath()),
+			log.String("path", path),
+			log.String("query", c.Request.URL.RawQuery),
+			log.Int("status", statusCode),
+			log.Any("latency", latency),
+			log.Int64("bytes_in", max(c.Request.ContentLength, 0)), // chunked 请求为 -1
+			log.Int("bytes_out", max(c.Writer.Size(), 0)),          // 没有写入响应时为 -1
+			log.String("client_ip", c.ClientIP()),
+			log.String("user_agent", c.Request.UserAgent()),
+		}
+		if requestID := GetRequestID(c); requestID != "" {
+			fields = append(fields, log.String("request_id", requestID))
+		}
+		if sc := trace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+			fields = append(fields, log.String("trace_id", sc.TraceID().String()))
+		}
+		if userID, ok := c.Get(a.userIDKey); ok {
+			fields = append(fields, log.Any("user_id", userID))
+		}
+		if len(c.Errors) > 0 {
+			fields = append(fields, log.Any("errors", c.Errors.Errors()))
+		}
+		if requestBody != nil {
+			fields = append(fields, log.String("request_body", string(requestBody)))
+		}
+		if bodyWriter != nil && a.allowBody(c.Writer.Header().Get("Content-Type")) {
+			fields = append(fields, log.String("response_body", bodyWriter.body.String()))
+		}
+		if slow {
+			fields = append(fields, log.Bool("slow", true))
+		}
+
+		a.write(statusCode, slow, fields)
+	}
+}
+
+// write 按状态码和是否慢请求选择日志级别
+func (a *AccessLog) write(statusCode int, slow bool, fields []iface.Field) {
+	const msg = "access log"
+	switch {
+	case a.levelByStatus && statusCode >= http.StatusInternalServerError:
+		a.log.Error(msg, fields...)
+	case a.levelByStatus && statusCode >= http.StatusBadRequest, slow:
+		a.log.Warn(msg, fields...)
+	default:
+		a.log.Info(msg, fields...)
+	}
+}
+
+// sampled 成功请求是否需要记录
+func (a *AccessLog) sampled() bool {
+	if a.sampleRate >= 1 {
+		return true
+	}
+	if a.sampleRate <= 0 {
+		return false
+	}
+	return rand.Float64() < a.sampleRate
+}
+
+// allowBody 判断内容类型是否允许记录 body
+func (a *AccessLog) allowBody(contentType string) bool {
+	if contentType == "" {
+		return false
+	}
+	contentType = strings.ToLower(contentType)
+	for _, allowed := range a.bodyContentTypes {
+		if strings.HasPrefix(contentType, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// peekRequestBody 读取请求 body 的前 bodyLimit 个字节，并还原 body 供后续处理函数使用
+func (a *AccessLog) peekRequestBody(c *gin.Context) []byte {
+	if c.Request.Body == nil || c.Request.Body == http.NoBody {
+		return nil
+	}
+	buf, err := io.ReadAll(io.LimitReader(c.Request.Body, int64(a.bodyLimit)))
+	if err != nil {
+		return nil
+	}
+	c.Request.Body = &accessLogReadCloser{
+		Reader: io.MultiReader(bytes.NewReader(buf), c.Request.Body),
+		Closer: c.Request.Body,
+	}
+	return buf
+}
+
+type accessLogReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// accessLogBodyWriter 在写出响应的同时保留前 limit 个字节
+type accessLogBodyWriter struct {
+	gin.ResponseWriter
+	body  bytes.Buffer
+	limit int
+}
+
+func (w *accessLogBodyWriter) Write(b []byte) (int, error) {
+	w.capture(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *accessLogBodyWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *accessLogBodyWriter) capture(b []byte) {
+	if remain := w.limit - w.body.Len(); remain > 0 {
+		if len(b) > remain {
+			b = b[:remain]
+		}
+		w.body.Write(b)
 	}
 }
@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/log/logtest"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestAccessLogStructuredFields(t *testing.T) {
+	logger := logtest.New()
+	r := gin.New()
+	r.Use(NewAccessLog(logger,
+		WithAccessLogRequestBody(true),
+		WithAccessLogResponseBody(true),
+		WithAccessLogBodyLimit(8),
+	).HandlerFunc())
+	r.POST("/users/:id", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.Set("user_id", 42)
+		c.String(http.StatusOK, string(body))
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/users/7?verbose=1", strings.NewReader(`{"name":"rum-go"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "test-agent")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	// 中间件读取 body 后处理函数仍然能拿到完整的 body
+	if w.Body.String() != `{"name":"rum-go"}` {
+		t.Fatalf("unexpected body %q", w.Body.String())
+	}
+
+	logger.AssertCount(t, logtest.LevelInfo, 1)
+	logger.AssertField(t, "access log", "route", "/users/:id")
+	logger.AssertField(t, "access log", "path", "/users/7")
+	logger.AssertField(t, "access log", "query", "verbose=1")
+	logger.AssertField(t, "access log", "status", 200)
+	logger.AssertField(t, "access log", "user_agent", "test-agent")
+	logger.AssertField(t, "access log", "user_id", 42)
+	logger.AssertField(t, "access log", "request_body", `{"name":`)
+	logger.AssertField(t, "access log", "response_body", `{"name":`)
+}
+
+func TestAccessLogUnknownSizes(t *testing.T) {
+	logger := logtest.New()
+	r := gin.New()
+	r.Use(NewAccessLog(logger).HandlerFunc())
+	r.POST("/upload", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	// chunked 请求的 ContentLength 和没有写入的响应大小都是 -1
+	req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("data"))
+	req.ContentLength = -1
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	logger.AssertField(t, "access log", "bytes_in", 0)
+	logger.AssertField(t, "access log", "bytes_out", 0)
+}
+
+func TestAccessLogLevelsAndFilters(t *testing.T) {
+	logger := logtest.New()
+	r := gin.New()
+	r.Use(NewAccessLog(logger,
+		WithAccessLogSkipPaths("/health"),
+		WithAccessLogSampleRate(0),
+		WithAccessLogSlowThreshold(10*time.Millisecond),
+	).HandlerFunc())
+	r.GET("/health", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/ok", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/slow", func(c *gin.Context) {
+		time.Sleep(20 * time.Millisecond)
+		c.Status(http.StatusOK)
+	})
+	r.GET("/bad", func(c *gin.Context) { c.Status(http.StatusBadRequest) })
+	r.GET("/fail", func(c *gin.Context) { c.Status(http.StatusInternalServerError) })
+
+	for _, path := range []string{"/health", "/ok", "/slow", "/bad", "/fail"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// /health 被跳过，/ok 被采样丢弃
+	logger.AssertCount(t, logtest.LevelInfo, 0)
+	logger.AssertCount(t, logtest.LevelWarn, 2)
+	logger.AssertCount(t, logtest.LevelError, 1)
+	logger.AssertField(t, "access log", "slow", true)
+}
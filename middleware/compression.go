@@ -0,0 +1,381 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	CompressionGzip    = "gzip"
+	CompressionDeflate = "deflate"
+	CompressionBrotli  = "br"
+
+	defaultCompressionMinLength     = 1024
+	defaultMaxDecompressedSize      = 10 << 20
+	defaultCompressionAcceptPenalty = 0.001 // 客户端 q 值相同时按服务端的顺序选择
+)
+
+var (
+	defaultCompressionEncodings    = []string{CompressionBrotli, CompressionGzip, CompressionDeflate}
+	defaultCompressionContentTypes = []string{
+		"application/json",
+		"application/javascript",
+		"application/xml",
+		"application/problem+json",
+		"image/svg+xml",
+		"text/html",
+		"text/css",
+		"text/plain",
+		"text/xml",
+		"text/javascript",
+	}
+)
+
+// CompressionConfig 响应压缩配置
+type CompressionConfig struct {
+	Level               int      `mapstructure:"level" yaml:"level"`                                 // gzip 和 deflate 的压缩级别，1-9，默认 -1（DefaultCompression）
+	MinLength           int      `mapstructure:"min_length" yaml:"min_length"`                       // 响应超过这个大小才压缩，默认 1024
+	ContentTypes        []string `mapstructure:"content_types" yaml:"content_types"`                 // 允许压缩的内容类型前缀，默认 JSON、文本、JS、XML、SVG
+	Encodings           []string `mapstructure:"encodings" yaml:"encodings"`                         // 服务端优先的编码顺序，默认 br、gzip、deflate，br 需要通过 WithCompressionEncoder 注册
+	SkipPaths           []string `mapstructure:"skip_paths" yaml:"skip_paths"`                       // 不压缩的路径前缀，比如 SSE 和文件下载
+	DecompressRequest   bool     `mapstructure:"decompress_request" yaml:"decompress_request"`       // 解压 Content-Encoding: gzip 的请求 body
+	MaxDecompressedSize int64    `mapstructure:"max_decompressed_size" yaml:"max_decompressed_size"` // 解压后的请求 body 最大字节数，默认 10MB
+}
+
+// CompressionEncoder 压缩编码器，gzip.Writer、flate.Writer 和 brotli.Writer 都实现了这个接口
+type CompressionEncoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// Compression 响应压缩中间件
+// 按 Accept-Encoding 协商编码，响应先缓冲到 MinLength 再决定是否压缩；
+// 已经设置了 Content-Encoding 的响应、text/event-stream 和 HEAD 请求不会压缩。编码器通过 sync.Pool 复用
+type Compression struct {
+	level        int
+	minLength    int
+	contentTypes []string
+	encodings    []string
+	skipPaths    []string
+	decompress   bool
+	maxBodySize  int64
+	factories    map[string]func() CompressionEncoder
+	pools        map[string]*sync.Pool
+}
+
+// OptionCompression 定义配置函数类型
+type OptionCompression func(*Compression)
+
+// WithCompressionEncoder 注册编码器，比如 brotli：
+//
+//	middleware.WithCompressionEncoder(middleware.CompressionBrotli, func() middleware.CompressionEncoder {
+//		return brotli.NewWriterLevel(io.Discard, brotli.DefaultCompression)
+//	})
+func WithCompressionEncoder(encoding string, newEncoder func() CompressionEncoder) OptionCompression {
+	return func(m *Compression) {
+		m.factories[strings.ToLower(encoding)] = newEncoder
+	}
+}
+
+func NewCompression(config *CompressionConfig, opts ...OptionCompression) *Compression {
+	m := &Compression{
+		level:        config.Level,
+		minLength:    config.MinLength,
+		contentTypes: config.ContentTypes,
+		skipPaths:    config.SkipPaths,
+		decompress:   config.DecompressRequest,
+		maxBodySize:  config.MaxDecompressedSize,
+		factories:    make(map[string]func() CompressionEncoder),
+		pools:        make(map[string]*sync.Pool),
+	}
+	if m.level == 0 || m.level < gzip.HuffmanOnly || m.level > gzip.BestCompression {
+		m.level = gzip.DefaultCompression
+	}
+	if m.minLength <= 0 {
+		m.minLength = defaultCompressionMinLength
+	}
+	if len(m.contentTypes) == 0 {
+		m.contentTypes = defaultCompressionContentTypes
+	}
+	if m.maxBodySize <= 0 {
+		m.maxBodySize = defaultMaxDecompressedSize
+	}
+	level := m.level
+	m.factories[CompressionGzip] = func() CompressionEncoder {
+		w, _ := gzip.NewWriterLevel(io.Discard, level)
+		return w
+	}
+	m.factories[CompressionDeflate] = func() CompressionEncoder {
+		w, _ := flate.NewWriter(io.Discard, level)
+		return w
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	encodings := config.Encodings
+	if len(encodings) == 0 {
+		encodings = defaultCompressionEncodings
+	}
+	for _, encoding := range encodings {
+		encoding = strings.ToLower(encoding)
+		factory, ok := m.factories[encoding]
+		if !ok {
+			continue
+		}
+		m.encodings = append(m.encodings, encoding)
+		m.pools[encoding] = &sync.Pool{New: func() interface{} { return factory() }}
+	}
+	return m
+}
+
+func (m *Compression) HandlerFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if m.decompress && !m.decompressRequest(c) {
+			return
+		}
+		for _, prefix := range m.skipPaths {
+			if strings.HasPrefix(c.Request.URL.Path, prefix) {
+				c.Next()
+				return
+			}
+		}
+		encoding := m.negotiate(c.GetHeader("Accept-Encoding"))
+		if encoding == "" || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+
+		original := c.Writer
+		writer := &compressWriter{ResponseWriter: original, m: m, encoding: encoding, status: http.StatusOK}
+		c.Writer = writer
+		defer func() {
+			writer.close()
+			c.Writer = original
+		}()
+		c.Next()
+	}
+}
+
+// decompressRequest 解压 gzip 请求 body，返回是否继续处理
+func (m *Compression) decompressRequest(c *gin.Context) bool {
+	encoding := strings.ToLower(strings.TrimSpace(c.GetHeader("Content-Encoding")))
+	switch encoding {
+	case "", "identity":
+		return true
+	case CompressionGzip, "x-gzip":
+	default:
+		c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{"error": "unsupported_media_type", "message": "unsupported content encoding " + encoding})
+		return false
+	}
+	reader, err := gzip.NewReader(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "bad_request", "message": "invalid gzip body"})
+		return false
+	}
+	// 超过大小时读取返回 *http.MaxBytesError，防止解压炸弹
+	c.Request.Body = http.MaxBytesReader(c.Writer, reader, m.maxBodySize)
+	c.Request.Header.Del("Content-Encoding")
+	c.Request.Header.Del("Content-Length")
+	c.Request.ContentLength = -1
+	return true
+}
+
+// negotiate 按 Accept-Encoding 的 q 值选择编码，q 值相同时按服务端的顺序
+func (m *Compression) negotiate(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+	best, bestQ := "", 0.0
+	for i, encoding := range m.encodings {
+		q, found := accepted[encoding]
+		if !found {
+			if q, found = accepted["*"]; !found {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		if score := q - float64(i)*defaultCompressionAcceptPenalty; score > bestQ {
+			best, bestQ = encoding, score
+		}
+	}
+	return best
+}
+
+func (m *Compression) compressible(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, prefix := range m.contentTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter 缓冲响应直到超过 MinLength，再决定压缩或者原样写出
+type compressWriter struct {
+	gin.ResponseWriter
+	m           *Compression
+	encoding    string
+	encoder     CompressionEncoder
+	status      int
+	buf         bytes.Buffer
+	size        int
+	wroteHeader bool
+	decided     bool
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if code > 0 && !w.wroteHeader {
+		w.status = code
+	}
+}
+
+func (w *compressWriter) WriteHeaderNow() {
+	if w.decided {
+		w.ResponseWriter.WriteHeaderNow()
+		return
+	}
+	w.wroteHeader = true
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	w.size += len(b)
+	if !w.decided {
+		w.buf.Write(b)
+		if w.buf.Len() < w.m.minLength {
+			return len(b), nil
+		}
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.encoder != nil {
+		return w.encoder.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *compressWriter) Status() int {
+	if w.decided {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+// Size 返回未压缩的大小，和 gin 的语义一致（未写入时为 -1）
+func (w *compressWriter) Size() int {
+	if !w.wroteHeader {
+		return -1
+	}
+	return w.size
+}
+
+func (w *compressWriter) Written() bool {
+	if w.decided {
+		return w.ResponseWriter.Written()
+	}
+	return w.wroteHeader
+}
+
+// Flush 流式响应立即决定是否压缩，压缩时先刷新编码器
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		_ = w.decide()
+	}
+	if w.encoder != nil {
+		_ = w.encoder.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// decide 根据状态码、已有的 Content-Encoding 和内容类型决定是否压缩，然后写出缓冲的内容
+func (w *compressWriter) decide() error {
+	w.decided = true
+	header := w.ResponseWriter.Header()
+	contentType := header.Get("Content-Type")
+	if contentType == "" && w.buf.Len() > 0 {
+		contentType = http.DetectContentType(w.buf.Bytes())
+	}
+	compressible := w.m.compressible(contentType)
+	if compressible {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	if compressible && w.buf.Len() >= w.m.minLength && header.Get("Content-Encoding") == "" &&
+		w.status != http.StatusNoContent && w.status != http.StatusNotModified && w.status >= http.StatusOK {
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		// 压缩后原来的强 ETag 不再对应响应的字节
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		w.encoder = w.m.pools[w.encoding].Get().(CompressionEncoder)
+		w.encoder.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buf.Len() == 0 {
+		if w.wroteHeader {
+			w.ResponseWriter.WriteHeaderNow()
+		}
+		return nil
+	}
+	var err error
+	if w.encoder != nil {
+		_, err = w.encoder.Write(w.buf.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf.Bytes())
+	}
+	w.buf.Reset()
+	return err
+}
+
+// close 写出剩余的内容并归还编码器
+func (w *compressWriter) close() {
+	if !w.decided {
+		if !w.wroteHeader {
+			// handler 没有写响应，保留状态码交给 gin 处理
+			if w.status != http.StatusOK {
+				w.ResponseWriter.WriteHeader(w.status)
+			}
+			return
+		}
+		_ = w.decide()
+	}
+	if w.encoder != nil {
+		_ = w.encoder.Close()
+		w.encoder.Reset(io.Discard)
+		w.m.pools[w.encoding].Put(w.encoder)
+		w.encoder = nil
+	}
+}
@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompressionNegotiate(t *testing.T) {
+	m := NewCompression(&CompressionConfig{})
+	tests := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"", ""},
+		{"gzip", CompressionGzip},
+		{"deflate, gzip", CompressionGzip},
+		{"gzip;q=0.5, deflate", CompressionDeflate},
+		{"br", ""}, // 没有注册 brotli
+		{"*", CompressionGzip},
+		{"gzip;q=0, *", CompressionDeflate},
+		{"identity", ""},
+	}
+	for _, tt := range tests {
+		if got := m.negotiate(tt.acceptEncoding); got != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.acceptEncoding, tt.expected, got)
+		}
+	}
+
+	brotli := NewCompression(&CompressionConfig{}, WithCompressionEncoder(CompressionBrotli, func() CompressionEncoder {
+		w, _ := flate.NewWriter(io.Discard, flate.BestSpeed)
+		return w
+	}))
+	if got := brotli.negotiate("gzip, br"); got != CompressionBrotli {
+		t.Errorf("expected registered br to be preferred, got %q", got)
+	}
+}
+
+func TestCompressionResponse(t *testing.T) {
+	large := strings.Repeat(`{"id":1,"name":"item"},`, 200)
+	m := NewCompression(&CompressionConfig{MinLength: 100, SkipPaths: []string{"/events"}})
+	r := gin.New()
+	r.Use(m.HandlerFunc())
+	r.GET("/large", func(c *gin.Context) {
+		c.Header("ETag", `"v1"`)
+		c.Data(http.StatusOK, "application/json", []byte(large))
+	})
+	r.GET("/small", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"ok": true}) })
+	r.GET("/image", func(c *gin.Context) { c.Data(http.StatusOK, "image/png", []byte(large)) })
+	r.GET("/encoded", func(c *gin.Context) {
+		c.Header("Content-Encoding", "gzip")
+		c.Data(http.StatusOK, "application/json", []byte(large))
+	})
+	r.GET("/events", func(c *gin.Context) { c.Data(http.StatusOK, "application/json", []byte(large)) })
+	r.GET("/empty", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	serve := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/large")
+	if w.Header().Get("Content-Encoding") != CompressionGzip || w.Header().Get("Vary") != "Accept-Encoding" || w.Header().Get("ETag") != `W/"v1"` {
+		t.Fatalf("expected gzip response, got %v", w.Header())
+	}
+	reader, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(reader)
+	if string(body) != large {
+		t.Error("unexpected decompressed body")
+	}
+
+	w = serve("/small")
+	if w.Header().Get("Content-Encoding") != "" || w.Header().Get("Vary") != "Accept-Encoding" || w.Body.String() != `{"ok":true}` {
+		t.Errorf("small response should not be compressed but should vary, got %v %s", w.Header(), w.Body.String())
+	}
+	for _, path := range []string{"/image", "/encoded", "/events"} {
+		if w := serve(path); w.Header().Get("Content-Encoding") == CompressionGzip && path != "/encoded" || w.Body.String() != large {
+			t.Errorf("%s should not be compressed, got %v", path, w.Header())
+		}
+	}
+	if w := serve("/empty"); w.Code != http.StatusNoContent || w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("unexpected empty response %d %v", w.Code, w.Header())
+	}
+}
+
+func TestCompressionDecompressRequest(t *testing.T) {
+	m := NewCompression(&CompressionConfig{DecompressRequest: true, MaxDecompressedSize: 1000})
+	r := gin.New()
+	r.Use(m.HandlerFunc())
+	r.POST("/upload", func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.String(http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		c.String(http.StatusOK, string(body))
+	})
+	post := func(body []byte, encoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/upload", bytes.NewReader(body))
+		req.Header.Set("Content-Encoding", encoding)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	compress := func(s string) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, _ = zw.Write([]byte(s))
+		_ = zw.Close()
+		return buf.Bytes()
+	}
+
+	if w := post(compress("hello"), "gzip"); w.Code != http.StatusOK || w.Body.String() != "hello" {
+		t.Errorf("expected decompressed body, got %d %s", w.Code, w.Body.String())
+	}
+	// 压缩后很小，解压后超过限制
+	if w := post(compress(strings.Repeat("a", 2000)), "gzip"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected decompressed size limit, got %d", w.Code)
+	}
+	if w := post([]byte("not gzip"), "gzip"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid gzip, got %d", w.Code)
+	}
+	if w := post([]byte("x"), "br"); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected 415 for unsupported encoding, got %d", w.Code)
+	}
+}
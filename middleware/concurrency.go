@@ -0,0 +1,356 @@
+package middleware
+
+import (
+	"container/list"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ConcurrencyAlgorithmFixed    = "fixed"
+	ConcurrencyAlgorithmAIMD     = "aimd"
+	ConcurrencyAlgorithmGradient = "gradient"
+
+	// 请求优先级，critical 不受并发限制（比如健康检查），其余优先级排队时高优先级先获得名额
+	ConcurrencyPriorityCritical = "critical"
+	ConcurrencyPriorityHigh     = "high"
+	ConcurrencyPriorityNormal   = "normal"
+	ConcurrencyPriorityLow      = "low"
+
+	defaultConcurrencyLimit        = 100
+	defaultConcurrencyMinLimit     = 10
+	defaultConcurrencyMaxLimit     = 1000
+	defaultConcurrencyQueueTimeout = 100 * time.Millisecond
+	defaultConcurrencyRetryAfter   = 1
+	defaultAIMDLatencyThreshold    = 500 * time.Millisecond
+	defaultAIMDBackoffRatio        = 0.9
+	gradientSmoothing              = 0.2
+	gradientLongWindow             = 600 // 长期延迟的指数平均窗口（样本数）
+	gradientSampleWindow           = 10  // 每多少个样本调整一次并发上限
+)
+
+// 各优先级最多可以占用的排队比例，队列接近满时先拒绝低优先级的请求
+var concurrencyQueueShare = map[string]float64{
+	ConcurrencyPriorityHigh:   1,
+	ConcurrencyPriorityNormal: 0.75,
+	ConcurrencyPriorityLow:    0.5,
+}
+
+var concurrencyPriorities = []string{ConcurrencyPriorityHigh, ConcurrencyPriorityNormal, ConcurrencyPriorityLow}
+
+// ConcurrencyLimiterConfig 并发限制配置
+type ConcurrencyLimiterConfig struct {
+	Algorithm          string                    `mapstructure:"algorithm" yaml:"algorithm"`                       // fixed、aimd、gradient，默认 fixed
+	Limit              int                       `mapstructure:"limit" yaml:"limit"`                               // 固定并发上限，自适应算法的初始上限，默认 100
+	MinLimit           int                       `mapstructure:"min_limit" yaml:"min_limit"`                       // 自适应算法的最小上限，默认 10
+	MaxLimit           int                       `mapstructure:"max_limit" yaml:"max_limit"`                       // 自适应算法的最大上限，默认 1000
+	QueueSize          int                       `mapstructure:"queue_size" yaml:"queue_size"`                     // 最多排队的请求数，默认等于 Limit，小于 0 不排队
+	QueueTimeoutMs     int                       `mapstructure:"queue_timeout_ms" yaml:"queue_timeout_ms"`         // 排队超时时间，默认 100 毫秒
+	RetryAfterSecond   int                       `mapstructure:"retry_after_second" yaml:"retry_after_second"`     // 拒绝时返回的 Retry-After，默认 1 秒
+	LatencyThresholdMs int                       `mapstructure:"latency_threshold_ms" yaml:"latency_threshold_ms"` // aimd 超过这个延迟时减小上限，默认 500 毫秒
+	BackoffRatio       float64                   `mapstructure:"backoff_ratio" yaml:"backoff_ratio"`               // aimd 减小上限的比例，默认 0.9
+	Routes             []ConcurrencyLimiterRoute `mapstructure:"routes" yaml:"routes"`                             // 按路径前缀设置优先级，最长前缀优先
+}
+
+// ConcurrencyLimiterRoute 路径前缀对应的优先级
+type ConcurrencyLimiterRoute struct {
+	PathPrefix string `mapstructure:"path_prefix" yaml:"path_prefix"`
+	Priority   string `mapstructure:"priority" yaml:"priority"` // critical、high、normal、low，默认 normal
+}
+
+type concurrencyWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// ConcurrencyLimiter 限制同时处理的请求数，超出的请求排队等待，排队满或者超时返回 503
+// 自适应算法根据观察到的延迟调整上限：aimd 延迟超过阈值时按比例减小，否则逐步增加；
+// gradient 比较短期和长期的平均延迟，延迟升高时按比例减小上限
+type ConcurrencyLimiter struct {
+	mu         sync.Mutex
+	algorithm  string
+	limit      float64
+	minLimit   float64
+	maxLimit   float64
+	inflight   int
+	queueSize  int
+	queued     int
+	waiters    map[string]*list.List // 优先级 -> 等待队列
+	timeout    time.Duration
+	retryAfter string
+	routes     []ConcurrencyLimiterRoute
+
+	latencyThreshold time.Duration
+	backoffRatio     float64
+	longRTT          float64 // gradient 长期平均延迟（秒）
+	sampleSum        float64
+	sampleCount      int
+
+	limitGauge prometheus.GaugeFunc
+	inflightG  prometheus.GaugeFunc
+	rejections *prometheus.CounterVec
+}
+
+// OptionConcurrencyLimiter 定义配置函数类型
+type OptionConcurrencyLimiter func(*ConcurrencyLimiter)
+
+// WithConcurrencyLimiterMetrics 导出当前并发上限、处理中的请求数和拒绝次数，需要将 Collectors() 注册到 prom.Prom
+func WithConcurrencyLimiterMetrics(namespace string) OptionConcurrencyLimiter {
+	return func(l *ConcurrencyLimiter) {
+		l.limitGauge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "concurrency_limit",
+			Help:      "Current concurrency limit.",
+		}, func() float64 { return float64(l.Limit()) })
+		l.inflightG = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "concurrency_inflight",
+			Help:      "Requests currently holding a concurrency slot.",
+		}, func() float64 { return float64(l.Inflight()) })
+		l.rejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "concurrency_rejected_total",
+			Help:      "Requests shed by the concurrency limiter, partitioned by priority and reason.",
+		}, []string{"priority", "reason"})
+	}
+}
+
+// NewConcurrencyLimiter 创建并发限制中间件
+func NewConcurrencyLimiter(config *ConcurrencyLimiterConfig, opts ...OptionConcurrencyLimiter) (*ConcurrencyLimiter, error) {
+	l := &ConcurrencyLimiter{
+		algorithm:        config.Algorithm,
+		limit:            float64(config.Limit),
+		minLimit:         float64(config.MinLimit),
+		maxLimit:         float64(config.MaxLimit),
+		queueSize:        config.QueueSize,
+		waiters:          make(map[string]*list.List),
+		timeout:          time.Duration(config.QueueTimeoutMs) * time.Millisecond,
+		retryAfter:       strconv.Itoa(config.RetryAfterSecond),
+		latencyThreshold: time.Duration(config.LatencyThresholdMs) * time.Millisecond,
+		backoffRatio:     config.BackoffRatio,
+	}
+	switch l.algorithm {
+	case "":
+		l.algorithm = ConcurrencyAlgorithmFixed
+	case ConcurrencyAlgorithmFixed, ConcurrencyAlgorithmAIMD, ConcurrencyAlgorithmGradient:
+	default:
+		return nil, fmt.Errorf("unknown concurrency algorithm %q", l.algorithm)
+	}
+	if l.limit <= 0 {
+		l.limit = defaultConcurrencyLimit
+	}
+	if l.minLimit <= 0 {
+		l.minLimit = defaultConcurrencyMinLimit
+	}
+	if l.maxLimit <= 0 {
+		l.maxLimit = defaultConcurrencyMaxLimit
+	}
+	if l.minLimit > l.maxLimit {
+		return nil, fmt.Errorf("concurrency min limit %v is greater than max limit %v", l.minLimit, l.maxLimit)
+	}
+	if l.algorithm != ConcurrencyAlgorithmFixed {
+		l.limit = math.Min(math.Max(l.limit, l.minLimit), l.maxLimit)
+	}
+	if l.queueSize == 0 {
+		l.queueSize = int(l.limit)
+	}
+	if l.timeout <= 0 {
+		l.timeout = defaultConcurrencyQueueTimeout
+	}
+	if config.RetryAfterSecond <= 0 {
+		l.retryAfter = strconv.Itoa(defaultConcurrencyRetryAfter)
+	}
+	if l.latencyThreshold <= 0 {
+		l.latencyThreshold = defaultAIMDLatencyThreshold
+	}
+	if l.backoffRatio <= 0 || l.backoffRatio >= 1 {
+		l.backoffRatio = defaultAIMDBackoffRatio
+	}
+	for _, priority := range concurrencyPriorities {
+		l.waiters[priority] = list.New()
+	}
+	for _, route := range config.Routes {
+		if route.Priority == "" {
+			route.Priority = ConcurrencyPriorityNormal
+		}
+		if _, ok := concurrencyQueueShare[route.Priority]; !ok && route.Priority != ConcurrencyPriorityCritical {
+			return nil, fmt.Errorf("unknown concurrency priority %q", route.Priority)
+		}
+		l.routes = append(l.routes, route)
+	}
+	sort.SliceStable(l.routes, func(i, j int) bool { return len(l.routes[i].PathPrefix) > len(l.routes[j].PathPrefix) })
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l, nil
+}
+
+// Limit 当前的并发上限
+func (l *ConcurrencyLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// Inflight 正在处理的请求数
+func (l *ConcurrencyLimiter) Inflight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inflight
+}
+
+// Collectors 返回并发限制的指标，未开启指标时返回空
+func (l *ConcurrencyLimiter) Collectors() []prometheus.Collector {
+	if l.rejections == nil {
+		return nil
+	}
+	return []prometheus.Collector{l.limitGauge, l.inflightG, l.rejections}
+}
+
+func (l *ConcurrencyLimiter) HandlerFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		priority := l.priority(c.Request.URL.Path)
+		if priority == ConcurrencyPriorityCritical {
+			c.Next()
+			return
+		}
+		if reason := l.acquire(c, priority); reason != "" {
+			if l.rejections != nil {
+				l.rejections.WithLabelValues(priority, reason).Inc()
+			}
+			c.Header("Retry-After", l.retryAfter)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "service_unavailable", "message": "server is overloaded"})
+			return
+		}
+
+		start := time.Now()
+		defer func() {
+			l.release(time.Since(start), c.Writer.Status())
+		}()
+		c.Next()
+	}
+}
+
+func (l *ConcurrencyLimiter) priority(path string) string {
+	for _, route := range l.routes {
+		if strings.HasPrefix(path, route.PathPrefix) {
+			return route.Priority
+		}
+	}
+	return ConcurrencyPriorityNormal
+}
+
+// acquire 获取一个名额，失败时返回原因：queue_full 或 timeout
+func (l *ConcurrencyLimiter) acquire(c *gin.Context, priority string) string {
+	l.mu.Lock()
+	if l.inflight < int(l.limit) && l.queued == 0 {
+		l.inflight++
+		l.mu.Unlock()
+		return ""
+	}
+	if float64(l.queued) >= float64(l.queueSize)*concurrencyQueueShare[priority] {
+		l.mu.Unlock()
+		return "queue_full"
+	}
+	waiter := &concurrencyWaiter{ready: make(chan struct{})}
+	element := l.waiters[priority].PushBack(waiter)
+	l.queued++
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+	select {
+	case <-waiter.ready:
+		return ""
+	case <-timer.C:
+	case <-c.Request.Context().Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// 超时的同时可能刚好拿到名额
+	if waiter.granted {
+		return ""
+	}
+	l.waiters[priority].Remove(element)
+	l.queued--
+	return "timeout"
+}
+
+// release 释放名额，根据延迟调整上限，然后按优先级唤醒等待的请求
+func (l *ConcurrencyLimiter) release(latency time.Duration, status int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+	switch l.algorithm {
+	case ConcurrencyAlgorithmAIMD:
+		l.updateAIMD(latency, status)
+	case ConcurrencyAlgorithmGradient:
+		l.updateGradient(latency)
+	}
+	l.grantLocked()
+}
+
+func (l *ConcurrencyLimiter) grantLocked() {
+	for l.inflight < int(l.limit) && l.queued > 0 {
+		for _, priority := range concurrencyPriorities {
+			front := l.waiters[priority].Front()
+			if front == nil {
+				continue
+			}
+			waiter := l.waiters[priority].Remove(front).(*concurrencyWaiter)
+			waiter.granted = true
+			close(waiter.ready)
+			l.queued--
+			l.inflight++
+			break
+		}
+	}
+}
+
+// updateAIMD 延迟超过阈值或者下游超时时乘性减小，并发接近上限时加性增加
+func (l *ConcurrencyLimiter) updateAIMD(latency time.Duration, status int) {
+	if latency > l.latencyThreshold || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout {
+		l.limit = math.Max(l.minLimit, l.limit*l.backoffRatio)
+		return
+	}
+	if float64(l.inflight+1)*2 >= l.limit {
+		l.limit = math.Min(l.maxLimit, l.limit+1/l.limit*math.Max(1, math.Sqrt(l.limit)))
+	}
+}
+
+// updateGradient 梯度算法：上限按长期延迟与短期延迟的比值缩放，再加上 sqrt(limit) 的余量用于探测
+func (l *ConcurrencyLimiter) updateGradient(latency time.Duration) {
+	rtt := latency.Seconds()
+	if l.longRTT == 0 {
+		l.longRTT = rtt
+	} else {
+		l.longRTT += (rtt - l.longRTT) / gradientLongWindow
+	}
+	l.sampleSum += rtt
+	l.sampleCount++
+	if l.sampleCount < gradientSampleWindow {
+		return
+	}
+	shortRTT := l.sampleSum / float64(l.sampleCount)
+	l.sampleSum, l.sampleCount = 0, 0
+	if shortRTT <= 0 {
+		return
+	}
+	// 短期延迟长时间低于长期延迟时，让长期平均更快地跟上
+	if l.longRTT/shortRTT > 2 {
+		l.longRTT *= 0.95
+	}
+	gradient := math.Max(0.5, math.Min(1, l.longRTT/shortRTT))
+	newLimit := l.limit*gradient + math.Sqrt(l.limit)
+	newLimit = l.limit*(1-gradientSmoothing) + newLimit*gradientSmoothing
+	l.limit = math.Min(l.maxLimit, math.Max(l.minLimit, newLimit))
+}
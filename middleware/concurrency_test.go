@@ -0,0 +1,210 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiterShedding(t *testing.T) {
+	limiter, err := NewConcurrencyLimiter(&ConcurrencyLimiterConfig{
+		Limit:            1,
+		QueueSize:        1,
+		QueueTimeoutMs:   50,
+		RetryAfterSecond: 2,
+		Routes:           []ConcurrencyLimiterRoute{{PathPrefix: "/health", Priority: ConcurrencyPriorityCritical}},
+	}, WithConcurrencyLimiterMetrics("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entered, unblock := make(chan struct{}), make(chan struct{})
+	r := gin.New()
+	r.Use(limiter.HandlerFunc())
+	r.GET("/slow", func(c *gin.Context) {
+		entered <- struct{}{}
+		<-unblock
+		c.Status(http.StatusOK)
+	})
+	r.GET("/fast", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/health", func(c *gin.Context) { c.Status(http.StatusOK) })
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		serve("/slow")
+	}()
+	<-entered
+
+	w := serve("/fast")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "2" {
+		t.Fatalf("expected 503 with Retry-After, got %d %v", w.Code, w.Header())
+	}
+	if w := serve("/health"); w.Code != http.StatusOK {
+		t.Errorf("critical route should never be shed, got %d", w.Code)
+	}
+	if v := testutil.ToFloat64(limiter.rejections.WithLabelValues(ConcurrencyPriorityNormal, "timeout")); v != 1 {
+		t.Errorf("expected 1 timeout rejection, got %v", v)
+	}
+
+	// 排队中的请求在名额释放后继续处理
+	done := make(chan int)
+	limiter.timeout = time.Second
+	go func() { done <- serve("/fast").Code }()
+	waitFor(t, func() bool { return limiter.queuedCount() == 1 })
+	close(unblock)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("queued request: expected 200, got %d", code)
+	}
+	wg.Wait()
+	if limiter.Inflight() != 0 {
+		t.Errorf("expected no inflight requests, got %d", limiter.Inflight())
+	}
+}
+
+func TestConcurrencyLimiterPriority(t *testing.T) {
+	limiter, err := NewConcurrencyLimiter(&ConcurrencyLimiterConfig{
+		Limit:          1,
+		QueueSize:      2,
+		QueueTimeoutMs: 1000,
+		Routes: []ConcurrencyLimiterRoute{
+			{PathPrefix: "/api", Priority: ConcurrencyPriorityLow},
+			{PathPrefix: "/api/pay", Priority: ConcurrencyPriorityHigh},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unblock := make(chan struct{})
+	var mu sync.Mutex
+	var order []string
+	r := gin.New()
+	r.Use(limiter.HandlerFunc())
+	r.GET("/block", func(c *gin.Context) { <-unblock })
+	r.GET("/api/:name", func(c *gin.Context) {
+		mu.Lock()
+		order = append(order, c.Param("name"))
+		mu.Unlock()
+	})
+	r.GET("/api/pay/:name", func(c *gin.Context) {
+		mu.Lock()
+		order = append(order, "pay")
+		mu.Unlock()
+	})
+	serve := func(path string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() { defer wg.Done(); serve("/block") }()
+	waitFor(t, func() bool { return limiter.Inflight() == 1 })
+	go func() { defer wg.Done(); serve("/api/list") }()
+	waitFor(t, func() bool { return limiter.queuedCount() == 1 })
+
+	// low 最多占用一半的队列
+	if code := serve("/api/other"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected low priority request to be shed, got %d", code)
+	}
+	go func() { defer wg.Done(); serve("/api/pay/x") }()
+	waitFor(t, func() bool { return limiter.queuedCount() == 2 })
+
+	close(unblock)
+	wg.Wait()
+	if len(order) != 2 || order[0] != "pay" || order[1] != "list" {
+		t.Errorf("expected high priority first, got %v", order)
+	}
+}
+
+func TestConcurrencyLimiterAIMD(t *testing.T) {
+	limiter, err := NewConcurrencyLimiter(&ConcurrencyLimiterConfig{
+		Algorithm:          ConcurrencyAlgorithmAIMD,
+		Limit:              20,
+		MinLimit:           10,
+		LatencyThresholdMs: 100,
+		BackoffRatio:       0.5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limiter.inflight = 1
+	limiter.release(200*time.Millisecond, http.StatusOK)
+	if limiter.Limit() != 10 {
+		t.Errorf("expected limit to back off to 10, got %d", limiter.Limit())
+	}
+	limiter.inflight = 1
+	limiter.release(200*time.Millisecond, http.StatusOK)
+	if limiter.Limit() != 10 {
+		t.Errorf("expected limit to stay at min limit, got %d", limiter.Limit())
+	}
+	for i := 0; i < 50; i++ {
+		limiter.inflight = 10
+		limiter.release(time.Millisecond, http.StatusOK)
+	}
+	if limiter.Limit() <= 10 {
+		t.Errorf("expected limit to grow, got %d", limiter.Limit())
+	}
+}
+
+func TestConcurrencyLimiterGradient(t *testing.T) {
+	limiter, err := NewConcurrencyLimiter(&ConcurrencyLimiterConfig{
+		Algorithm: ConcurrencyAlgorithmGradient,
+		Limit:     100,
+		MinLimit:  10,
+		MaxLimit:  200,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		limiter.inflight = 1
+		limiter.release(10*time.Millisecond, http.StatusOK)
+	}
+	stable := limiter.Limit()
+	if stable <= 100 {
+		t.Errorf("expected limit to grow while latency is stable, got %d", stable)
+	}
+	for i := 0; i < 100; i++ {
+		limiter.inflight = 1
+		limiter.release(100*time.Millisecond, http.StatusOK)
+	}
+	if limiter.Limit() >= stable {
+		t.Errorf("expected limit to shrink when latency rises, got %d (was %d)", limiter.Limit(), stable)
+	}
+
+	if _, err := NewConcurrencyLimiter(&ConcurrencyLimiterConfig{Algorithm: "vegas"}); err == nil {
+		t.Error("expected error for unknown algorithm")
+	}
+}
+
+func (l *ConcurrencyLimiter) queuedCount() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.queued
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
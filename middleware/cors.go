@@ -1,50 +1,228 @@
 package middleware
 
 import (
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/yangkushu/rum-go/iface"
 	"github.com/yangkushu/rum-go/log"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
+const (
+	CorsModeReject = "reject" // 不允许的跨域请求返回 403
+	CorsModeOmit   = "omit"   // 不允许的跨域请求不返回 CORS 响应头，由浏览器拦截
+)
+
+var (
+	defaultCorsAllowedMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"}
+	defaultCorsAllowedHeaders = []string{"Content-Type", "Authorization", "X-Requested-With", "X-Request-Id", "X-CSRF-Token"}
+)
+
+// CorsConfig 跨域配置
+// AllowedOrigins 支持三种写法：完整的 origin（https://a.example.com）、通配子域名（https://*.example.com）、
+// 以 regex: 开头的正则（regex:^https://[a-z]+\.example\.com$）。单独一个 * 表示允许所有 origin
+type CorsConfig struct {
+	AllowedOrigins      []string `mapstructure:"allowed_origins" yaml:"allowed_origins"`
+	AllowedMethods      []string `mapstructure:"allowed_methods" yaml:"allowed_methods"`
+	AllowedHeaders      []string `mapstructure:"allowed_headers" yaml:"allowed_headers"` // 包含 * 时回显预检请求中的请求头
+	ExposedHeaders      []string `mapstructure:"exposed_headers" yaml:"exposed_headers"`
+	AllowCredentials    bool     `mapstructure:"allow_credentials" yaml:"allow_credentials"`
+	MaxAgeSecond        int      `mapstructure:"max_age_second" yaml:"max_age_second"` // 预检结果缓存时间，0 不返回 Max-Age
+	Mode                string   `mapstructure:"mode" yaml:"mode"`                     // reject 或 omit，默认 omit
+	EnableLog           bool     `mapstructure:"enable_log" yaml:"enable_log"`
+	TrustForwardedProto bool     `mapstructure:"trust_forwarded_proto" yaml:"trust_forwarded_proto"` // 判断同源时信任 X-Forwarded-Proto，服务在 TLS 终止的代理后面时开启
+}
+
 // Cors 处理跨域中间件
 type Cors struct {
 	AllowedOrigins []string
 	enableLog      bool
 	logger         iface.ILogger
+
+	config         CorsConfig
+	allowAll       bool
+	exactOrigins   map[string]struct{}
+	originPatterns []*regexp.Regexp
+	allowedMethods string
+	allowedHeaders string
+	exposedHeaders string
+	reflectHeaders bool
+	legacy         bool // 旧版本行为：空列表允许所有 origin，请求头写死，非法 origin 一律 403
 }
 
 // OptionCors 定义配置函数类型
 type OptionCors func(*Cors)
 
 // NewCors 创建一个新的Cors中间件实例
+// Deprecated: 请求头等配置是写死的，请使用 NewCorsWithConfig
 func NewCors(allowedOrigins []string) *Cors {
-	return &Cors{AllowedOrigins: allowedOrigins}
+	return newLegacyCors(allowedOrigins, nil)
 }
 
+// Deprecated: 请使用 NewCorsWithConfig，AllowedOrigins 配置为 *
 func NewCorsAllowAll() *Cors {
-	return &Cors{AllowedOrigins: []string{}}
+	return newLegacyCors([]string{}, nil)
 }
 
+// Deprecated: 请使用 NewCorsWithConfig
 func NewCorsWithLogger(allowedOrigins []string, logger iface.ILogger) *Cors {
-	return &Cors{AllowedOrigins: allowedOrigins, enableLog: true, logger: logger}
+	return newLegacyCors(allowedOrigins, logger)
+}
+
+// NewCorsWithConfig 根据配置创建Cors中间件，logger 可以为空
+func NewCorsWithConfig(config *CorsConfig, logger iface.ILogger) (*Cors, error) {
+	cfg := *config
+	if len(cfg.AllowedMethods) == 0 {
+		cfg.AllowedMethods = defaultCorsAllowedMethods
+	}
+	if len(cfg.AllowedHeaders) == 0 {
+		cfg.AllowedHeaders = defaultCorsAllowedHeaders
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = CorsModeOmit
+	}
+	if cfg.Mode != CorsModeOmit && cfg.Mode != CorsModeReject {
+		return nil, fmt.Errorf("invalid cors mode %q", cfg.Mode)
+	}
+
+	c := &Cors{
+		AllowedOrigins: cfg.AllowedOrigins,
+		enableLog:      cfg.EnableLog && logger != nil,
+		logger:         logger,
+		config:         cfg,
+		exactOrigins:   make(map[string]struct{}),
+		allowedMethods: strings.Join(cfg.AllowedMethods, ", "),
+		allowedHeaders: strings.Join(cfg.AllowedHeaders, ", "),
+		exposedHeaders: strings.Join(cfg.ExposedHeaders, ", "),
+	}
+	for _, header := range cfg.AllowedHeaders {
+		if header == "*" {
+			c.reflectHeaders = true
+		}
+	}
+	for _, origin := range cfg.AllowedOrigins {
+		if err := c.addOrigin(origin); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func newLegacyCors(allowedOrigins []string, logger iface.ILogger) *Cors {
+	c := &Cors{
+		AllowedOrigins: allowedOrigins,
+		enableLog:      logger != nil,
+		logger:         logger,
+		legacy:         true,
+		allowAll:       len(allowedOrigins) == 0,
+		exactOrigins:   make(map[string]struct{}),
+		allowedHeaders: "Content-Type, AccessToken, X-CSRF-Token, Authorization, Token, access-token, Psbc-Center, psbc-center",
+		allowedMethods: "POST, GET, OPTIONS, PUT, PATCH, DELETE",
+		exposedHeaders: "Content-Length, Access-Control-Allow-Origin, Access-Control-Allow-Headers, Content-Type",
+	}
+	for _, origin := range allowedOrigins {
+		c.exactOrigins[strings.ToLower(origin)] = struct{}{}
+	}
+	return c
+}
+
+// addOrigin 解析一条 origin 配置
+func (c *Cors) addOrigin(origin string) error {
+	origin = strings.TrimSpace(origin)
+	switch {
+	case origin == "*":
+		c.allowAll = true
+	case strings.HasPrefix(origin, "regex:"):
+		re, err := regexp.Compile(strings.TrimPrefix(origin, "regex:"))
+		if err != nil {
+			return fmt.Errorf("invalid cors origin pattern %q: %w", origin, err)
+		}
+		c.originPatterns = append(c.originPatterns, re)
+	case strings.Contains(origin, "*"):
+		// https://*.example.com 匹配任意层级的子域名，但不匹配 example.com 本身
+		pattern := "^" + strings.ReplaceAll(regexp.QuoteMeta(strings.ToLower(origin)), `\*`, `[a-z0-9.-]+`) + "$"
+		c.originPatterns = append(c.originPatterns, regexp.MustCompile(pattern))
+	default:
+		c.exactOrigins[strings.ToLower(origin)] = struct{}{}
+	}
+	return nil
 }
 
 // HandlerFunc 返回Gin中间件处理函数
 func (c *Cors) HandlerFunc() gin.HandlerFunc {
+	if c.legacy {
+		return c.legacyHandlerFunc()
+	}
 	return func(ctx *gin.Context) {
+		// 响应内容随 Origin 变化时需要告诉缓存，没有 Origin 的响应也要加，否则缓存会把它返回给跨域请求
+		if !c.allowAll || c.config.AllowCredentials {
+			ctx.Writer.Header().Add("Vary", "Origin")
+		}
+
 		origin := ctx.Request.Header.Get("Origin")
+		if origin == "" {
+			ctx.Next()
+			return
+		}
+
 		isOriginAllowed := c.isOriginAllowed(origin)
 		if c.enableLog {
 			c.logger.Info("request origin", log.String("origin", origin), log.Any("AllowedOrigins", c.AllowedOrigins), log.Bool("isOriginAllowed", isOriginAllowed))
 		}
 
+		preflight := ctx.Request.Method == http.MethodOptions && ctx.GetHeader("Access-Control-Request-Method") != ""
+
+		if !isOriginAllowed {
+			// 同源请求也可能携带 Origin，不应该被拦截
+			if c.config.Mode == CorsModeReject && !isSameOrigin(ctx.Request, origin, c.config.TrustForwardedProto) {
+				ctx.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			if preflight {
+				ctx.AbortWithStatus(http.StatusNoContent)
+				return
+			}
+			ctx.Next()
+			return
+		}
+
+		if c.allowAll && !c.config.AllowCredentials {
+			ctx.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			ctx.Header("Access-Control-Allow-Origin", origin)
+		}
+		if c.config.AllowCredentials {
+			ctx.Header("Access-Control-Allow-Credentials", "true")
+		}
+		if c.exposedHeaders != "" {
+			ctx.Header("Access-Control-Expose-Headers", c.exposedHeaders)
+		}
+
+		if preflight {
+			c.handlePreflight(ctx)
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// legacyHandlerFunc 旧版本的处理逻辑，保持原有行为不变
+func (c *Cors) legacyHandlerFunc() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		origin := ctx.Request.Header.Get("Origin")
+		isOriginAllowed := origin == "" || c.isOriginAllowed(origin)
+		if c.enableLog {
+			c.logger.Info("request origin", log.String("origin", origin), log.Any("AllowedOrigins", c.AllowedOrigins), log.Bool("isOriginAllowed", isOriginAllowed))
+		}
+
 		if isOriginAllowed {
 			ctx.Header("Access-Control-Allow-Origin", origin)
-			ctx.Header("Access-Control-Allow-Headers", "Content-Type, AccessToken, X-CSRF-Token, Authorization, Token, access-token, Psbc-Center, psbc-center")
-			ctx.Header("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, PATCH, DELETE")
-			ctx.Header("Access-Control-Expose-Headers", "Content-Length, Access-Control-Allow-Origin, Access-Control-Allow-Headers, Content-Type")
+			ctx.Header("Access-Control-Allow-Headers", c.allowedHeaders)
+			ctx.Header("Access-Control-Allow-Methods", c.allowedMethods)
+			ctx.Header("Access-Control-Expose-Headers", c.exposedHeaders)
 			ctx.Header("Access-Control-Allow-Credentials", "true")
 			if ctx.Request.Method == http.MethodOptions {
 				ctx.AbortWithStatus(http.StatusNoContent)
@@ -55,26 +233,52 @@ func (c *Cors) HandlerFunc() gin.HandlerFunc {
 		}
 
 		ctx.AbortWithStatus(http.StatusForbidden)
-		return
 	}
 }
 
+// handlePreflight 处理预检请求
+func (c *Cors) handlePreflight(ctx *gin.Context) {
+	header := ctx.Writer.Header()
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+
+	ctx.Header("Access-Control-Allow-Methods", c.allowedMethods)
+	if c.reflectHeaders {
+		if requested := ctx.GetHeader("Access-Control-Request-Headers"); requested != "" {
+			ctx.Header("Access-Control-Allow-Headers", requested)
+		}
+	} else {
+		ctx.Header("Access-Control-Allow-Headers", c.allowedHeaders)
+	}
+	if c.config.MaxAgeSecond > 0 {
+		ctx.Header("Access-Control-Max-Age", strconv.Itoa(c.config.MaxAgeSecond))
+	}
+	ctx.AbortWithStatus(http.StatusNoContent)
+}
+
 // isOriginAllowed 检查请求的来源是否被允许
 func (c *Cors) isOriginAllowed(origin string) bool {
-	// 如果 Origin 不为空，且不在允许的列表中，就返回403。否则返回200
-	if len(c.AllowedOrigins) == 0 {
+	if c.allowAll {
 		return true
 	}
 
-	if origin == "" {
+	origin = strings.ToLower(origin)
+	if _, ok := c.exactOrigins[origin]; ok {
 		return true
 	}
-
-	for _, allowedOrigin := range c.AllowedOrigins {
-		if strings.EqualFold(allowedOrigin, origin) {
+	for _, pattern := range c.originPatterns {
+		if pattern.MatchString(origin) {
 			return true
 		}
 	}
-
 	return false
 }
+
+// isSameOrigin 判断 Origin 是否与请求的 Host 相同，trustForwardedProto 为 true 时才使用客户端可以伪造的 X-Forwarded-Proto
+func isSameOrigin(r *http.Request, origin string, trustForwardedProto bool) bool {
+	scheme := "http"
+	if r.TLS != nil || (trustForwardedProto && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")) {
+		scheme = "https"
+	}
+	return strings.EqualFold(origin, scheme+"://"+r.Host)
+}
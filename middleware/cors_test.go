@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newCorsEngine(t *testing.T, cfg *CorsConfig) *gin.Engine {
+	cors, err := NewCorsWithConfig(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.Use(cors.HandlerFunc())
+	r.Any("/api", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r
+}
+
+func corsRequest(r *gin.Engine, method, origin string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "http://api.example.com/api", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestCorsOriginPatterns(t *testing.T) {
+	r := newCorsEngine(t, &CorsConfig{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org", `regex:^https://[a-z]+\.example\.net$`},
+		AllowCredentials: true,
+	})
+
+	for origin, allowed := range map[string]bool{
+		"https://app.example.com":   true,
+		"https://APP.example.com":   true,
+		"https://a.b.example.org":   true,
+		"https://example.org":       false,
+		"https://evil.com":          false,
+		"https://shop.example.net":  true,
+		"https://shop1.example.net": false,
+	} {
+		w := corsRequest(r, http.MethodGet, origin, nil)
+		got := w.Header().Get("Access-Control-Allow-Origin") == origin
+		if got != allowed {
+			t.Errorf("origin %s: expected allowed=%v, got headers %v", origin, allowed, w.Header())
+		}
+		// omit 模式下不允许的 origin 也不会被拒绝
+		if w.Code != http.StatusOK {
+			t.Errorf("origin %s: expected 200 in omit mode, got %d", origin, w.Code)
+		}
+		if w.Header().Get("Vary") != "Origin" {
+			t.Errorf("origin %s: expected Vary: Origin, got %q", origin, w.Header().Get("Vary"))
+		}
+	}
+
+	// 没有 Origin 的响应也需要 Vary，避免共享缓存把它返回给跨域请求
+	if w := corsRequest(r, http.MethodGet, "", nil); w.Header().Get("Vary") != "Origin" {
+		t.Errorf("expected Vary: Origin without origin, got %q", w.Header().Get("Vary"))
+	}
+	allowAll := newCorsEngine(t, &CorsConfig{AllowedOrigins: []string{"*"}})
+	if w := corsRequest(allowAll, http.MethodGet, "", nil); w.Header().Get("Vary") != "" {
+		t.Errorf("expected no Vary for credential-less *, got %q", w.Header().Get("Vary"))
+	}
+}
+
+func TestCorsPreflight(t *testing.T) {
+	r := newCorsEngine(t, &CorsConfig{
+		AllowedOrigins: []string{"*"},
+		AllowedHeaders: []string{"*"},
+		AllowedMethods: []string{"GET", "POST"},
+		MaxAgeSecond:   600,
+	})
+	w := corsRequest(r, http.MethodOptions, "https://any.com", map[string]string{
+		"Access-Control-Request-Method":  "POST",
+		"Access-Control-Request-Headers": "X-Custom",
+	})
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	for k, v := range map[string]string{
+		"Access-Control-Allow-Origin":  "*",
+		"Access-Control-Allow-Methods": "GET, POST",
+		"Access-Control-Allow-Headers": "X-Custom",
+		"Access-Control-Max-Age":       "600",
+	} {
+		if got := w.Header().Get(k); got != v {
+			t.Errorf("%s: expected %q, got %q", k, v, got)
+		}
+	}
+}
+
+func TestCorsRejectMode(t *testing.T) {
+	r := newCorsEngine(t, &CorsConfig{AllowedOrigins: []string{"https://app.example.com"}, Mode: CorsModeReject})
+	if w := corsRequest(r, http.MethodPost, "https://evil.com", nil); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403, got %d", w.Code)
+	}
+	// 同源请求不受影响
+	if w := corsRequest(r, http.MethodPost, "http://api.example.com", nil); w.Code != http.StatusOK {
+		t.Errorf("expected same origin request to pass, got %d", w.Code)
+	}
+	if w := corsRequest(r, http.MethodGet, "", nil); w.Code != http.StatusOK {
+		t.Errorf("expected request without origin to pass, got %d", w.Code)
+	}
+
+	// 默认不信任客户端传入的 X-Forwarded-Proto
+	forwarded := map[string]string{"X-Forwarded-Proto": "https"}
+	if w := corsRequest(r, http.MethodPost, "https://api.example.com", forwarded); w.Code != http.StatusForbidden {
+		t.Errorf("expected forwarded proto to be ignored, got %d", w.Code)
+	}
+	r = newCorsEngine(t, &CorsConfig{Mode: CorsModeReject, TrustForwardedProto: true})
+	if w := corsRequest(r, http.MethodPost, "https://api.example.com", forwarded); w.Code != http.StatusOK {
+		t.Errorf("expected same origin behind a TLS proxy to pass, got %d", w.Code)
+	}
+}
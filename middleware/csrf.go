@@ -0,0 +1,374 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/iface"
+	"github.com/yangkushu/rum-go/log"
+	"github.com/yangkushu/rum-go/redis"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	CSRFModeDoubleSubmit = "double_submit" // token 保存在 cookie 中，请求需要在请求头或表单中带上相同的 token
+	CSRFModeSynchronizer = "synchronizer"  // token 保存在服务端，需要设置 CSRFTokenStore
+
+	// CSRFTokenKey gin.Context 中保存当前 CSRF token 的 key，模板中可以通过 GetCSRFToken 获取
+	CSRFTokenKey = "csrf_token"
+
+	defaultCSRFCookieName = "csrf_token"
+	defaultCSRFHeader     = "X-CSRF-Token"
+	defaultCSRFFormField  = "csrf_token"
+	defaultCSRFMaxAge     = 12 * time.Hour
+	defaultCSRFPrefix     = "csrf:"
+	csrfTokenSize         = 32
+)
+
+var (
+	ErrCSRFTokenMissing = errors.New("csrf token missing")
+	ErrCSRFTokenInvalid = errors.New("csrf token invalid")
+	ErrCSRFOrigin       = errors.New("csrf origin not allowed")
+	ErrCSRFNoSession    = errors.New("csrf session is empty")
+)
+
+// CSRFConfig CSRF 防护配置
+type CSRFConfig struct {
+	Mode                string   `mapstructure:"mode" yaml:"mode"`                                   // double_submit 或 synchronizer，默认 double_submit
+	Secret              string   `mapstructure:"secret" yaml:"secret"`                               // double_submit 模式对 token 签名的密钥，防止子域名写入伪造的 cookie
+	CookieName          string   `mapstructure:"cookie_name" yaml:"cookie_name"`                     // 默认 csrf_token，前端需要能读取，不设置 HttpOnly
+	CookieDomain        string   `mapstructure:"cookie_domain" yaml:"cookie_domain"`                 // cookie 的域名
+	CookiePath          string   `mapstructure:"cookie_path" yaml:"cookie_path"`                     // cookie 的路径，默认 /
+	CookieSecure        bool     `mapstructure:"cookie_secure" yaml:"cookie_secure"`                 // 只通过 HTTPS 发送 cookie
+	CookieSameSite      string   `mapstructure:"cookie_same_site" yaml:"cookie_same_site"`           // lax、strict、none，默认 lax
+	Header              string   `mapstructure:"header" yaml:"header"`                               // 提交 token 的请求头，默认 X-CSRF-Token
+	FormField           string   `mapstructure:"form_field" yaml:"form_field"`                       // 提交 token 的表单字段，默认 csrf_token
+	MaxAgeSecond        int      `mapstructure:"max_age_second" yaml:"max_age_second"`               // token 有效期，过期后重新签发，默认 12 小时
+	ExemptPaths         []string `mapstructure:"exempt_paths" yaml:"exempt_paths"`                   // 不检查的路径前缀，比如使用 token 认证的 /api/
+	RotateOnUse         bool     `mapstructure:"rotate_on_use" yaml:"rotate_on_use"`                 // 每次校验通过后签发新的 token
+	TrustForwardedProto bool     `mapstructure:"trust_forwarded_proto" yaml:"trust_forwarded_proto"` // 检查 Origin 时信任 X-Forwarded-Proto，服务在 TLS 终止的代理后面时开启
+}
+
+// CSRFTokenStore synchronizer 模式保存 token 的存储，通常和会话绑定
+type CSRFTokenStore interface {
+	// Token 返回当前会话的 token，不存在时返回空字符串
+	Token(c *gin.Context) (string, error)
+	// SaveToken 保存当前会话的 token，没有会话（比如未登录）时返回 ErrCSRFNoSession
+	SaveToken(c *gin.Context, token string, ttl time.Duration) error
+}
+
+// RedisCSRFTokenStore 把 token 保存在 redis 中，会话由 keyFunc 确定，比如 KeyByContext("user_id")
+type RedisCSRFTokenStore struct {
+	client  *redis.Client
+	prefix  string
+	keyFunc RateLimitKeyFunc
+}
+
+// NewRedisCSRFTokenStore 创建 redis token 存储，prefix 为空时使用 csrf:
+func NewRedisCSRFTokenStore(client *redis.Client, prefix string, keyFunc RateLimitKeyFunc) *RedisCSRFTokenStore {
+	if prefix == "" {
+		prefix = defaultCSRFPrefix
+	}
+	return &RedisCSRFTokenStore{client: client, prefix: prefix, keyFunc: keyFunc}
+}
+
+func (s *RedisCSRFTokenStore) key(c *gin.Context) (string, error) {
+	session := s.keyFunc(c)
+	if session == "" {
+		return "", ErrCSRFNoSession
+	}
+	return s.prefix + session, nil
+}
+
+func (s *RedisCSRFTokenStore) Token(c *gin.Context) (string, error) {
+	key, err := s.key(c)
+	if err != nil {
+		// 未登录的会话没有 token，不安全的请求会因为缺少 token 被拒绝
+		return "", nil
+	}
+	token, err := s.client.Get(c.Request.Context(), key).Result()
+	if redis.IsKeyNotExist(err) {
+		return "", nil
+	}
+	return token, err
+}
+
+func (s *RedisCSRFTokenStore) SaveToken(c *gin.Context, token string, ttl time.Duration) error {
+	key, err := s.key(c)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(c.Request.Context(), key, token, ttl).Err()
+}
+
+// CSRF 跨站请求伪造防护中间件
+// GET、HEAD、OPTIONS、TRACE 请求只签发 token，其他请求需要在请求头或表单中提交 token。
+// CORS 预检请求是 OPTIONS，不受影响；带 Origin 或 Referer 的请求还需要是同源或 Cors 允许的 origin
+type CSRF struct {
+	config   CSRFConfig
+	secret   []byte
+	maxAge   time.Duration
+	sameSite http.SameSite
+	store    CSRFTokenStore
+	cors     *Cors
+	log      iface.ILogger
+}
+
+// OptionCSRF 定义配置函数类型
+type OptionCSRF func(*CSRF)
+
+// WithCSRFTokenStore 设置 synchronizer 模式的 token 存储
+func WithCSRFTokenStore(store CSRFTokenStore) OptionCSRF {
+	return func(x *CSRF) {
+		x.store = store
+	}
+}
+
+// WithCSRFCors 跨域请求的 Origin 被 Cors 允许时视为可信，否则只允许同源请求
+func WithCSRFCors(cors *Cors) OptionCSRF {
+	return func(x *CSRF) {
+		x.cors = cors
+	}
+}
+
+// NewCSRF 创建 CSRF 中间件
+func NewCSRF(config *CSRFConfig, logger iface.ILogger, opts ...OptionCSRF) (*CSRF, error) {
+	x := &CSRF{
+		config: *config,
+		secret: []byte(config.Secret),
+		maxAge: time.Duration(config.MaxAgeSecond) * time.Second,
+		log:    logger,
+	}
+	if x.config.Mode == "" {
+		x.config.Mode = CSRFModeDoubleSubmit
+	}
+	if x.config.CookieName == "" {
+		x.config.CookieName = defaultCSRFCookieName
+	}
+	if x.config.CookiePath == "" {
+		x.config.CookiePath = "/"
+	}
+	if x.config.Header == "" {
+		x.config.Header = defaultCSRFHeader
+	}
+	if x.config.FormField == "" {
+		x.config.FormField = defaultCSRFFormField
+	}
+	if x.maxAge <= 0 {
+		x.maxAge = defaultCSRFMaxAge
+	}
+	switch strings.ToLower(x.config.CookieSameSite) {
+	case "", "lax":
+		x.sameSite = http.SameSiteLaxMode
+	case "strict":
+		x.sameSite = http.SameSiteStrictMode
+	case "none":
+		x.sameSite = http.SameSiteNoneMode
+	default:
+		return nil, fmt.Errorf("invalid csrf cookie same site %q", x.config.CookieSameSite)
+	}
+	for _, opt := range opts {
+		opt(x)
+	}
+
+	switch x.config.Mode {
+	case CSRFModeDoubleSubmit:
+		if len(x.secret) == 0 {
+			return nil, errors.New("csrf double submit mode requires secret")
+		}
+	case CSRFModeSynchronizer:
+		if x.store == nil {
+			return nil, errors.New("csrf synchronizer mode requires token store")
+		}
+	default:
+		return nil, fmt.Errorf("invalid csrf mode %q", x.config.Mode)
+	}
+	return x, nil
+}
+
+func (x *CSRF) HandlerFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// CORS 预检请求交给 Cors 处理，不签发 token
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			c.Next()
+			return
+		}
+		for _, prefix := range x.config.ExemptPaths {
+			if strings.HasPrefix(c.Request.URL.Path, prefix) {
+				c.Next()
+				return
+			}
+		}
+
+		token, err := x.currentToken(c)
+		if err != nil {
+			x.log.Error("csrf load token failed", log.ErrorField(err), log.String("path", c.Request.URL.Path))
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "service_unavailable", "message": "unable to check csrf token"})
+			return
+		}
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			if token == "" {
+				// 没有会话时不签发 token，登录后再签发
+				if token, err = x.issue(c); err != nil && !errors.Is(err, ErrCSRFNoSession) {
+					x.log.Error("csrf issue token failed", log.ErrorField(err))
+				}
+			}
+			c.Set(CSRFTokenKey, token)
+			c.Next()
+			return
+		}
+
+		if err := x.verify(c, token); err != nil {
+			x.log.Warn("csrf check failed",
+				log.ErrorField(err),
+				log.String("method", c.Request.Method),
+				log.String("path", c.Request.URL.Path),
+				log.String("origin", c.GetHeader("Origin")),
+				log.String("request_id", GetRequestID(c)),
+			)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "csrf_failed", "message": err.Error()})
+			return
+		}
+		if x.config.RotateOnUse {
+			if token, err = x.issue(c); err != nil {
+				x.log.Error("csrf rotate token failed", log.ErrorField(err))
+			}
+		}
+		c.Set(CSRFTokenKey, token)
+		c.Next()
+	}
+}
+
+func (x *CSRF) verify(c *gin.Context, token string) error {
+	if !x.originAllowed(c) {
+		return ErrCSRFOrigin
+	}
+	if token == "" {
+		return ErrCSRFTokenMissing
+	}
+	submitted := c.GetHeader(x.config.Header)
+	if submitted == "" {
+		submitted = c.PostForm(x.config.FormField)
+	}
+	if submitted == "" {
+		return ErrCSRFTokenMissing
+	}
+	if subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) != 1 {
+		return ErrCSRFTokenInvalid
+	}
+	return nil
+}
+
+// originAllowed 检查 Origin，没有 Origin 时检查 Referer，都没有时只依赖 token
+func (x *CSRF) originAllowed(c *gin.Context) bool {
+	origin := c.GetHeader("Origin")
+	if origin == "" || origin == "null" {
+		referer, err := url.Parse(c.GetHeader("Referer"))
+		if err != nil || referer.Host == "" {
+			return origin == ""
+		}
+		origin = referer.Scheme + "://" + referer.Host
+	}
+	if isSameOrigin(c.Request, origin, x.config.TrustForwardedProto) {
+		return true
+	}
+	return x.cors != nil && x.cors.isOriginAllowed(origin)
+}
+
+// currentToken 返回当前有效的 token，double_submit 模式下签名错误或者过期的 token 视为不存在
+func (x *CSRF) currentToken(c *gin.Context) (string, error) {
+	if x.config.Mode == CSRFModeSynchronizer {
+		return x.store.Token(c)
+	}
+	cookie, err := c.Cookie(x.config.CookieName)
+	if err != nil || !x.validSignedToken(cookie) {
+		return "", nil
+	}
+	return cookie, nil
+}
+
+// issue 签发新的 token
+func (x *CSRF) issue(c *gin.Context) (string, error) {
+	if x.config.Mode == CSRFModeSynchronizer {
+		token := randomCSRFToken()
+		if err := x.store.SaveToken(c, token, x.maxAge); err != nil {
+			return "", err
+		}
+		return token, nil
+	}
+	token := x.signedToken(time.Now())
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     x.config.CookieName,
+		Value:    token,
+		Path:     x.config.CookiePath,
+		Domain:   x.config.CookieDomain,
+		MaxAge:   int(x.maxAge / time.Second),
+		Secure:   x.config.CookieSecure,
+		SameSite: x.sameSite,
+	})
+	return token, nil
+}
+
+// Rotate 签发新的 token，比如登录或者权限变化后调用
+func (x *CSRF) Rotate(c *gin.Context) (string, error) {
+	token, err := x.issue(c)
+	if err != nil {
+		return "", err
+	}
+	c.Set(CSRFTokenKey, token)
+	return token, nil
+}
+
+// signedToken 格式为 base64(随机值 + 签发时间).base64(hmac)
+func (x *CSRF) signedToken(now time.Time) string {
+	payload := make([]byte, csrfTokenSize+8)
+	_, _ = rand.Read(payload[:csrfTokenSize])
+	binary.BigEndian.PutUint64(payload[csrfTokenSize:], uint64(now.Unix()))
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(x.sign(encoded))
+}
+
+func (x *CSRF) validSignedToken(token string) bool {
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found {
+		return false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, x.sign(encoded)) {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(payload) != csrfTokenSize+8 {
+		return false
+	}
+	issuedAt := time.Unix(int64(binary.BigEndian.Uint64(payload[csrfTokenSize:])), 0)
+	return time.Since(issuedAt) < x.maxAge
+}
+
+func (x *CSRF) sign(data string) []byte {
+	mac := hmac.New(sha256.New, x.secret)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// GetCSRFToken 获取当前请求的 CSRF token，用于模板中的隐藏字段或者 meta 标签
+func GetCSRFToken(c *gin.Context) string {
+	return c.GetString(CSRFTokenKey)
+}
+
+func randomCSRFToken() string {
+	buf := make([]byte, csrfTokenSize)
+	_, _ = rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
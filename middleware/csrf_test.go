@@ -0,0 +1,217 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/log/logtest"
+	"github.com/yangkushu/rum-go/redis/redistest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newCSRFEngine(t *testing.T, config *CSRFConfig, opts ...OptionCSRF) (*gin.Engine, *CSRF) {
+	t.Helper()
+	cors, err := NewCorsWithConfig(&CorsConfig{AllowedOrigins: []string{"https://app.example.com"}, AllowCredentials: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrf, err := NewCSRF(config, logtest.New(), append(opts, WithCSRFCors(cors))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.Use(cors.HandlerFunc(), csrf.HandlerFunc())
+	r.GET("/form", func(c *gin.Context) { c.String(http.StatusOK, GetCSRFToken(c)) })
+	r.POST("/form", func(c *gin.Context) { c.String(http.StatusOK, GetCSRFToken(c)) })
+	r.POST("/api/orders", func(c *gin.Context) { c.Status(http.StatusCreated) })
+	return r, csrf
+}
+
+func csrfCookie(w *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == defaultCSRFCookieName {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestCSRFDoubleSubmit(t *testing.T) {
+	r, _ := newCSRFEngine(t, &CSRFConfig{Secret: "secret", ExemptPaths: []string{"/api/"}})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/form", nil))
+	cookie := csrfCookie(w)
+	if cookie == nil || cookie.Value != w.Body.String() || cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("expected readable csrf cookie, got %+v", cookie)
+	}
+
+	post := func(token, origin string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/form", nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		if token != "" {
+			req.Header.Set(defaultCSRFHeader, token)
+		}
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := post(cookie.Value, "", cookie); w.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	if w := post(cookie.Value, "http://example.com", cookie); w.Code != http.StatusOK {
+		t.Errorf("same origin: expected 200, got %d", w.Code)
+	}
+	if w := post(cookie.Value, "https://app.example.com", cookie); w.Code != http.StatusOK {
+		t.Errorf("cors allowed origin: expected 200, got %d", w.Code)
+	}
+	if w := post(cookie.Value, "https://evil.example.com", cookie); w.Code != http.StatusForbidden {
+		t.Errorf("foreign origin: expected 403, got %d", w.Code)
+	}
+	if w := post("", "", cookie); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "csrf_failed") {
+		t.Errorf("missing token: expected 403, got %d %s", w.Code, w.Body.String())
+	}
+	// 没有签名的 cookie 无效
+	forged := &http.Cookie{Name: defaultCSRFCookieName, Value: "forged"}
+	if w := post("forged", "", forged); w.Code != http.StatusForbidden {
+		t.Errorf("forged cookie: expected 403, got %d", w.Code)
+	}
+
+	// 表单字段
+	req := httptest.NewRequest(http.MethodPost, "/form", strings.NewReader(url.Values{"csrf_token": {cookie.Value}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("form field: expected 200, got %d", w.Code)
+	}
+
+	// API 路由豁免
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/orders", nil))
+	if w.Code != http.StatusCreated {
+		t.Errorf("exempt path: expected 201, got %d", w.Code)
+	}
+
+	// 预检请求由 Cors 处理，不签发 token
+	req = httptest.NewRequest(http.MethodOptions, "/form", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent || csrfCookie(w) != nil {
+		t.Errorf("preflight: expected 204 without cookie, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestCSRFForwardedProto(t *testing.T) {
+	post := func(r *gin.Engine) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/form", nil))
+		cookie := csrfCookie(w)
+
+		req := httptest.NewRequest(http.MethodPost, "/form", nil)
+		req.AddCookie(cookie)
+		req.Header.Set(defaultCSRFHeader, cookie.Value)
+		req.Header.Set("Origin", "https://example.com")
+		req.Header.Set("X-Forwarded-Proto", "https")
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// 默认不信任客户端传入的 X-Forwarded-Proto
+	r, _ := newCSRFEngine(t, &CSRFConfig{Secret: "secret"})
+	if code := post(r); code != http.StatusForbidden {
+		t.Errorf("expected forwarded proto to be ignored, got %d", code)
+	}
+	r, _ = newCSRFEngine(t, &CSRFConfig{Secret: "secret", TrustForwardedProto: true})
+	if code := post(r); code != http.StatusOK {
+		t.Errorf("expected same origin behind a TLS proxy to pass, got %d", code)
+	}
+}
+
+func TestCSRFExpiredToken(t *testing.T) {
+	_, csrf := newCSRFEngine(t, &CSRFConfig{Secret: "secret", MaxAgeSecond: 60})
+	if !csrf.validSignedToken(csrf.signedToken(time.Now())) {
+		t.Error("expected fresh token to be valid")
+	}
+	if csrf.validSignedToken(csrf.signedToken(time.Now().Add(-2 * time.Minute))) {
+		t.Error("expected expired token to be invalid")
+	}
+	other, _ := NewCSRF(&CSRFConfig{Secret: "other"}, logtest.New())
+	if other.validSignedToken(csrf.signedToken(time.Now())) {
+		t.Error("expected token signed with another secret to be invalid")
+	}
+}
+
+func TestCSRFSynchronizer(t *testing.T) {
+	client, mr := redistest.New(t)
+	store := NewRedisCSRFTokenStore(client, "", KeyByHeader("X-Session"))
+	r, _ := newCSRFEngine(t, &CSRFConfig{Mode: CSRFModeSynchronizer, RotateOnUse: true}, WithCSRFTokenStore(store))
+
+	serve := func(method, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/form", nil)
+		req.Header.Set("X-Session", "s1")
+		if token != "" {
+			req.Header.Set(defaultCSRFHeader, token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(http.MethodGet, "")
+	token := w.Body.String()
+	if token == "" || csrfCookie(w) != nil {
+		t.Fatalf("expected server side token, got %q", token)
+	}
+	if stored, _ := mr.Get("csrf:s1"); stored != token {
+		t.Errorf("expected token in redis, got %q", stored)
+	}
+	if w := serve(http.MethodGet, ""); w.Body.String() != token {
+		t.Error("expected token to be reused")
+	}
+
+	w = serve(http.MethodPost, token)
+	rotated := w.Body.String()
+	if w.Code != http.StatusOK || rotated == token {
+		t.Fatalf("expected rotated token, got %d %q", w.Code, rotated)
+	}
+	if w := serve(http.MethodPost, token); w.Code != http.StatusForbidden {
+		t.Errorf("expected used token to be rejected, got %d", w.Code)
+	}
+	if w := serve(http.MethodPost, rotated); w.Code != http.StatusOK {
+		t.Errorf("expected rotated token to be accepted, got %d", w.Code)
+	}
+
+	// 没有会话的请求不签发 token，也不记录错误
+	logger := logtest.New()
+	csrf, err := NewCSRF(&CSRFConfig{Mode: CSRFModeSynchronizer}, logger, WithCSRFTokenStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	anonymous := gin.New()
+	anonymous.Use(csrf.HandlerFunc())
+	anonymous.GET("/form", func(c *gin.Context) { c.String(http.StatusOK, GetCSRFToken(c)) })
+	w = httptest.NewRecorder()
+	anonymous.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/form", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "" {
+		t.Errorf("expected no token without session, got %d %q", w.Code, w.Body.String())
+	}
+	logger.AssertCount(t, logtest.LevelError, 0)
+
+	if _, err := NewCSRF(&CSRFConfig{Mode: CSRFModeSynchronizer}, logtest.New()); err == nil {
+		t.Error("expected error without token store")
+	}
+}
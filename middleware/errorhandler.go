@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	rumErrors "github.com/yangkushu/rum-go/errors"
+	"github.com/yangkushu/rum-go/iface"
+	"github.com/yangkushu/rum-go/log"
+	"net/http"
+)
+
+// ErrorHandler 把 c.Errors 和 panic 统一渲染为 JSON：
+//
+//	{"error": "<code>", "message": "<用户提示>", "details": ..., "request_id": "..."}
+//
+// 5xx 错误的内部原因和调用栈会记录到日志中，不返回给客户端
+type ErrorHandler struct {
+	log     iface.ILogger
+	catalog *rumErrors.Catalog
+}
+
+// OptionErrorHandler 定义配置函数类型
+type OptionErrorHandler func(*ErrorHandler)
+
+// WithErrorHandlerCatalog 按 Accept-Language 从目录中查找用户提示
+func WithErrorHandlerCatalog(catalog *rumErrors.Catalog) OptionErrorHandler {
+	return func(h *ErrorHandler) {
+		h.catalog = catalog
+	}
+}
+
+func NewErrorHandler(log iface.ILogger, opts ...OptionErrorHandler) *ErrorHandler {
+	h := &ErrorHandler{log: log}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// AbortWithError 记录错误并中止后续的 handler，由 ErrorHandler 渲染响应
+func AbortWithError(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
+
+func (h *ErrorHandler) HandlerFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if r := recover(); r != nil {
+				if r == http.ErrAbortHandler {
+					panic(r)
+				}
+				err, ok := r.(error)
+				if !ok {
+					err = fmt.Errorf("%v", r)
+				}
+				e := rumErrors.ErrInternal.Wrap(err)
+				h.log.Error("on recovery", h.fields(c, e, getStack())...)
+				if !c.Writer.Written() {
+					h.render(c, e)
+				}
+				c.Abort()
+			}
+		}()
+
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		e := rumErrors.From(c.Errors.Last().Err)
+		if e.Status >= http.StatusInternalServerError {
+			h.log.Error("request failed", h.fields(c, e, e.Stack())...)
+		}
+		h.render(c, e)
+	}
+}
+
+func (h *ErrorHandler) fields(c *gin.Context, e *rumErrors.Error, stack string) []iface.Field {
+	fields := []iface.Field{
+		log.String("code", e.Code),
+		log.String("method", c.Request.Method),
+		log.String("path", c.Request.URL.Path),
+	}
+	if cause := e.Cause(); cause != nil {
+		fields = append(fields, log.ErrorField(cause))
+	}
+	if stack != "" {
+		fields = append(fields, log.String("stack", stack))
+	}
+	if requestID := GetRequestID(c); requestID != "" {
+		fields = append(fields, log.String("request_id", requestID))
+	}
+	return fields
+}
+
+func (h *ErrorHandler) render(c *gin.Context, e *rumErrors.Error) {
+	message := e.Message
+	if h.catalog != nil {
+		message = h.catalog.Message(c.GetHeader("Accept-Language"), e.Code, message)
+	}
+	body := gin.H{"error": e.Code, "message": message}
+	if e.Details != nil {
+		body["details"] = e.Details
+	}
+	if requestID := GetRequestID(c); requestID != "" {
+		body["request_id"] = requestID
+	}
+	c.JSON(e.Status, body)
+}
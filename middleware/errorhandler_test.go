@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	rumErrors "github.com/yangkushu/rum-go/errors"
+	"github.com/yangkushu/rum-go/log/logtest"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorHandler(t *testing.T) {
+	logger := logtest.New()
+	catalog := rumErrors.NewCatalog("")
+	catalog.Add("zh", map[string]string{"not_found": "资源不存在"})
+
+	r := gin.New()
+	r.Use(NewRequestID().HandlerFunc(), NewErrorHandler(logger, WithErrorHandlerCatalog(catalog)).HandlerFunc())
+	r.GET("/validation", func(c *gin.Context) {
+		AbortWithError(c, rumErrors.ErrValidation.WithDetails(map[string]string{"name": "required"}))
+	})
+	r.GET("/gorm", func(c *gin.Context) {
+		_ = c.Error(gorm.ErrRecordNotFound)
+	})
+	r.GET("/internal", func(c *gin.Context) {
+		_ = c.Error(errors.New("db password is wrong"))
+	})
+	r.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+	r.GET("/written", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+		_ = c.Error(errors.New("after write"))
+	})
+
+	tests := []struct {
+		path           string
+		acceptLanguage string
+		status         int
+		code           string
+		message        string
+	}{
+		{"/validation", "", http.StatusBadRequest, "validation_failed", "Validation failed"},
+		{"/gorm", "zh-CN", http.StatusNotFound, "not_found", "资源不存在"},
+		{"/internal", "", http.StatusInternalServerError, "internal_error", "Internal server error"},
+		{"/panic", "", http.StatusInternalServerError, "internal_error", "Internal server error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("Accept-Language", tt.acceptLanguage)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			var body map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.status || body["error"] != tt.code || body["message"] != tt.message {
+				t.Errorf("unexpected response %d %v", w.Code, body)
+			}
+			if body["request_id"] == nil || body["request_id"] != w.Header().Get(RequestIDHeader) {
+				t.Errorf("expected request_id in body, got %v", body)
+			}
+		})
+	}
+
+	// 内部原因只记录到日志
+	logger.AssertField(t, "request failed", "error", "db password is wrong")
+	logger.AssertContainsMessage(t, "on recovery")
+	logger.AssertCount(t, logtest.LevelError, 2)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/written", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Errorf("expected written response untouched, got %d %s", w.Code, w.Body.String())
+	}
+}
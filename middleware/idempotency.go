@@ -0,0 +1,312 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/iface"
+	"github.com/yangkushu/rum-go/log"
+	"github.com/yangkushu/rum-go/redis"
+	"github.com/yangkushu/rum-go/reqctx"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// IdempotencyKeyHeader 默认的幂等键请求头
+	IdempotencyKeyHeader = reqctx.IdempotencyKeyHeader
+	// IdempotencyReplayedHeader 重放的响应会带上这个响应头
+	IdempotencyReplayedHeader = "Idempotent-Replayed"
+
+	defaultIdempotencyPrefix       = "idempotency"
+	defaultIdempotencyTTL          = 24 * time.Hour
+	defaultIdempotencyLockExpiry   = 30 * time.Second
+	defaultIdempotencyMaxBodySize  = 1 << 20
+	defaultIdempotencyRedisTimeout = 100 * time.Millisecond
+	maxIdempotencyKeyLength        = 255
+)
+
+var defaultIdempotencyMethods = []string{http.MethodPost, http.MethodPatch}
+
+// 重放时不恢复的响应头
+var idempotencySkipHeaders = map[string]bool{
+	"Date":           true,
+	"Content-Length": true,
+}
+
+// IdempotencyConfig 幂等键配置
+type IdempotencyConfig struct {
+	Header            string   `mapstructure:"header" yaml:"header"`                           // 默认 Idempotency-Key
+	Methods           []string `mapstructure:"methods" yaml:"methods"`                         // 默认 POST、PATCH
+	TTLSecond         int      `mapstructure:"ttl_second" yaml:"ttl_second"`                   // 响应保存时长，默认 86400 秒
+	LockTimeoutSecond int      `mapstructure:"lock_timeout_second" yaml:"lock_timeout_second"` // 处理中的锁过期时间，应大于接口的最长处理时间，默认 30 秒
+	Prefix            string   `mapstructure:"prefix" yaml:"prefix"`                           // redis key 前缀，默认 idempotency
+	MaxBodySize       int64    `mapstructure:"max_body_size" yaml:"max_body_size"`             // 请求和响应 body 的最大长度，超过时响应不保存，默认 1MB
+	FailOpen          bool     `mapstructure:"fail_open" yaml:"fail_open"`                     // redis 不可用时是否直接执行请求
+}
+
+// idempotencyRecord 保存在 redis 中的第一次响应
+type idempotencyRecord struct {
+	Fingerprint string              `json:"fingerprint"`
+	Status      int                 `json:"status"`
+	Header      map[string][]string `json:"header"`
+	Body        []byte              `json:"body"`
+}
+
+// Idempotency 幂等键中间件
+// 第一次请求执行后保存响应，相同幂等键和相同请求内容的重复请求直接返回保存的响应；
+// 请求还在处理中时返回 409，相同幂等键但请求内容不同返回 422。5xx 响应不保存，客户端可以重试
+type Idempotency struct {
+	client      *redis.Client
+	log         iface.ILogger
+	header      string
+	methods     map[string]bool
+	ttl         time.Duration
+	lockExpiry  time.Duration
+	prefix      string
+	maxBodySize int64
+	failOpen    bool
+	scopeFunc   func(c *gin.Context) string
+}
+
+// OptionIdempotency 定义配置函数类型
+type OptionIdempotency func(*Idempotency)
+
+// WithIdempotencyScopeFunc 设置幂等键的作用域，比如按用户隔离，避免不同用户使用相同的幂等键互相影响
+// 默认使用 gin.Context 中的 user_id
+func WithIdempotencyScopeFunc(fn func(c *gin.Context) string) OptionIdempotency {
+	return func(i *Idempotency) {
+		i.scopeFunc = fn
+	}
+}
+
+// NewIdempotency 创建幂等键中间件
+func NewIdempotency(config *IdempotencyConfig, client *redis.Client, logger iface.ILogger, opts ...OptionIdempotency) (*Idempotency, error) {
+	if client == nil {
+		return nil, errors.New("idempotency requires redis client")
+	}
+	i := &Idempotency{
+		client:      client,
+		log:         logger,
+		header:      config.Header,
+		methods:     make(map[string]bool),
+		ttl:         time.Duration(config.TTLSecond) * time.Second,
+		lockExpiry:  time.Duration(config.LockTimeoutSecond) * time.Second,
+		prefix:      config.Prefix,
+		maxBodySize: config.MaxBodySize,
+		failOpen:    config.FailOpen,
+		scopeFunc:   KeyByContext(defaultAccessLogUserIDKey),
+	}
+	if i.header == "" {
+		i.header = IdempotencyKeyHeader
+	}
+	methods := config.Methods
+	if len(methods) == 0 {
+		methods = defaultIdempotencyMethods
+	}
+	for _, method := range methods {
+		i.methods[method] = true
+	}
+	if i.ttl <= 0 {
+		i.ttl = defaultIdempotencyTTL
+	}
+	if i.lockExpiry <= 0 {
+		i.lockExpiry = defaultIdempotencyLockExpiry
+	}
+	if i.prefix == "" {
+		i.prefix = defaultIdempotencyPrefix
+	}
+	if i.maxBodySize <= 0 {
+		i.maxBodySize = defaultIdempotencyMaxBodySize
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i, nil
+}
+
+func (i *Idempotency) HandlerFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(i.header)
+		if key == "" || !i.methods[c.Request.Method] {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "bad_request", "message": "idempotency key is too long"})
+			return
+		}
+
+		fingerprint, err := i.fingerprint(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request_too_large", "message": err.Error()})
+			return
+		}
+		storeKey := i.prefix + ":{" + i.scopeFunc(c) + ":" + key + "}"
+
+		// 已经有保存的响应时直接重放，不需要加锁
+		record, err := i.load(c.Request.Context(), storeKey)
+		if err != nil {
+			i.onRedisError(c, key, err)
+			return
+		}
+		if record != nil {
+			i.replay(c, record, fingerprint)
+			return
+		}
+
+		lock := i.client.NewLockWithExpiry(storeKey+":lock", i.lockExpiry)
+		if err := lock.TryLock(); err != nil {
+			if redis.IsLockAlreadyExist(err) {
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "conflict", "message": "a request with the same idempotency key is in progress"})
+				return
+			}
+			i.onRedisError(c, key, err)
+			return
+		}
+		defer func() {
+			if _, err := lock.Unlock(); err != nil {
+				i.log.Warn("idempotency unlock failed", log.String("key", key), log.ErrorField(err))
+			}
+		}()
+
+		// 加锁前第一个请求可能刚好处理完，需要再检查一次
+		if record, err = i.load(c.Request.Context(), storeKey); err != nil {
+			i.onRedisError(c, key, err)
+			return
+		}
+		if record != nil {
+			i.replay(c, record, fingerprint)
+			return
+		}
+
+		writer := &idempotencyWriter{ResponseWriter: c.Writer, limit: i.maxBodySize}
+		c.Writer = writer
+		c.Next()
+
+		status := writer.Status()
+		if status >= http.StatusInternalServerError || writer.overflow {
+			return
+		}
+		record = &idempotencyRecord{
+			Fingerprint: fingerprint,
+			Status:      status,
+			Header:      make(map[string][]string),
+			Body:        writer.body.Bytes(),
+		}
+		for name, values := range writer.Header() {
+			if !idempotencySkipHeaders[name] {
+				record.Header[name] = values
+			}
+		}
+		if err := i.save(storeKey, record); err != nil {
+			i.log.Error("idempotency save response failed", log.String("key", key), log.ErrorField(err))
+		}
+	}
+}
+
+// fingerprint 请求指纹：方法、路径、查询参数和 body 的 sha256，会读取并还原 body
+func (i *Idempotency) fingerprint(c *gin.Context) (string, error) {
+	h := sha256.New()
+	h.Write([]byte(c.Request.Method + "\n" + c.Request.URL.Path + "\n" + c.Request.URL.RawQuery + "\n"))
+	if c.Request.Body != nil && c.Request.Body != http.NoBody {
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, i.maxBodySize+1))
+		if err != nil {
+			return "", err
+		}
+		if int64(len(body)) > i.maxBodySize {
+			return "", errors.New("request body is too large")
+		}
+		_ = c.Request.Body.Close()
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		h.Write(body)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (i *Idempotency) load(ctx context.Context, storeKey string) (*idempotencyRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultIdempotencyRedisTimeout)
+	defer cancel()
+	data, err := i.client.Get(ctx, storeKey).Bytes()
+	if err != nil {
+		if redis.IsKeyNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var record idempotencyRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// save 请求已经处理完，即使客户端断开也要保存，所以不使用请求的 context
+func (i *Idempotency) save(storeKey string, record *idempotencyRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultIdempotencyRedisTimeout)
+	defer cancel()
+	return i.client.Set(ctx, storeKey, data, i.ttl).Err()
+}
+
+func (i *Idempotency) replay(c *gin.Context, record *idempotencyRecord, fingerprint string) {
+	if record.Fingerprint != fingerprint {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "unprocessable_entity", "message": "idempotency key was used with a different request"})
+		return
+	}
+	header := c.Writer.Header()
+	for name, values := range record.Header {
+		header[name] = values
+	}
+	header.Set(IdempotencyReplayedHeader, "true")
+	c.Status(record.Status)
+	_, _ = c.Writer.Write(record.Body)
+	c.Abort()
+}
+
+func (i *Idempotency) onRedisError(c *gin.Context, key string, err error) {
+	i.log.Error("idempotency redis failed", log.String("key", key), log.ErrorField(err))
+	if i.failOpen {
+		c.Next()
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "service_unavailable", "message": "unable to check idempotency key"})
+}
+
+// idempotencyWriter 记录响应 body，超过 limit 时标记 overflow 不再记录
+type idempotencyWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func (w *idempotencyWriter) Write(b []byte) (int, error) {
+	w.capture(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *idempotencyWriter) capture(b []byte) {
+	if w.overflow {
+		return
+	}
+	if int64(w.body.Len()+len(b)) > w.limit {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(b)
+}
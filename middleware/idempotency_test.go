@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/log/logtest"
+	"github.com/yangkushu/rum-go/redis/redistest"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func newIdempotencyEngine(t *testing.T, handler gin.HandlerFunc) *gin.Engine {
+	client, _ := redistest.New(t)
+	idempotency, err := NewIdempotency(&IdempotencyConfig{}, client, logtest.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.Use(idempotency.HandlerFunc())
+	r.POST("/orders", handler)
+	r.GET("/orders", handler)
+	return r
+}
+
+func doIdempotentRequest(r *gin.Engine, method, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/orders", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	var calls atomic.Int32
+	r := newIdempotencyEngine(t, func(c *gin.Context) {
+		n := calls.Add(1)
+		c.Header("X-Order-Id", strconv.Itoa(int(n)))
+		c.JSON(http.StatusCreated, gin.H{"order": n})
+	})
+
+	first := doIdempotentRequest(r, http.MethodPost, "k1", `{"sku":"a"}`)
+	if first.Code != http.StatusCreated || first.Header().Get(IdempotencyReplayedHeader) != "" {
+		t.Fatalf("unexpected first response %d %v", first.Code, first.Header())
+	}
+
+	second := doIdempotentRequest(r, http.MethodPost, "k1", `{"sku":"a"}`)
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() {
+		t.Errorf("expected replayed response, got %d %s", second.Code, second.Body.String())
+	}
+	if second.Header().Get("X-Order-Id") != "1" || second.Header().Get(IdempotencyReplayedHeader) != "true" {
+		t.Errorf("expected replayed headers, got %v", second.Header())
+	}
+
+	if w := doIdempotentRequest(r, http.MethodPost, "k1", `{"sku":"b"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for different payload, got %d", w.Code)
+	}
+	if w := doIdempotentRequest(r, http.MethodPost, "k2", `{"sku":"a"}`); w.Code != http.StatusCreated {
+		t.Errorf("expected 201 for new key, got %d", w.Code)
+	}
+	// 没有幂等键或者不是配置的请求方法时不处理
+	doIdempotentRequest(r, http.MethodPost, "", `{"sku":"a"}`)
+	doIdempotentRequest(r, http.MethodGet, "k1", "")
+	if n := calls.Load(); n != 4 {
+		t.Errorf("expected handler called 4 times, got %d", n)
+	}
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	r := newIdempotencyEngine(t, func(c *gin.Context) {
+		close(started)
+		<-release
+		c.Status(http.StatusNoContent)
+	})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- doIdempotentRequest(r, http.MethodPost, "k1", "a")
+	}()
+	<-started
+	if w := doIdempotentRequest(r, http.MethodPost, "k1", "a"); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 while in flight, got %d", w.Code)
+	}
+	close(release)
+	if w := <-done; w.Code != http.StatusNoContent {
+		t.Errorf("expected 204 for first request, got %d", w.Code)
+	}
+	if w := doIdempotentRequest(r, http.MethodPost, "k1", "a"); w.Code != http.StatusNoContent || w.Header().Get(IdempotencyReplayedHeader) != "true" {
+		t.Errorf("expected replayed 204, got %d", w.Code)
+	}
+}
+
+func TestIdempotencyServerErrorNotStored(t *testing.T) {
+	var calls atomic.Int32
+	r := newIdempotencyEngine(t, func(c *gin.Context) {
+		if calls.Add(1) == 1 {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Status(http.StatusCreated)
+	})
+	if w := doIdempotentRequest(r, http.MethodPost, "k1", "a"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", w.Code)
+	}
+	if w := doIdempotentRequest(r, http.MethodPost, "k1", "a"); w.Code != http.StatusCreated {
+		t.Errorf("expected retry to execute again, got %d", w.Code)
+	}
+}
@@ -0,0 +1,370 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/iface"
+	"github.com/yangkushu/rum-go/log"
+	"github.com/yangkushu/rum-go/redis"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultIPFilterRedisPrefix  = "ip_filter:"
+	defaultIPFilterRedisRefresh = 30 * time.Second
+	ipFilterRuleNotAllowed      = "not_in_allow_list"
+)
+
+// IPFilterConfig IP 黑白名单配置
+type IPFilterConfig struct {
+	TrustedProxies     []string        `mapstructure:"trusted_proxies" yaml:"trusted_proxies"`           // 可信代理的 IP 或 CIDR，只有来自可信代理的请求才使用 X-Forwarded-For
+	Groups             []IPFilterGroup `mapstructure:"groups" yaml:"groups"`                             // 路由分组
+	RedisPrefix        string          `mapstructure:"redis_prefix" yaml:"redis_prefix"`                 // redis 集合的前缀，默认 ip_filter:，集合为 <prefix><group>:allow 和 <prefix><group>:deny
+	RedisRefreshSecond int             `mapstructure:"redis_refresh_second" yaml:"redis_refresh_second"` // 从 redis 刷新名单的间隔，默认 30 秒
+}
+
+// IPFilterGroup 一组路由的黑白名单，支持 IPv4、IPv6 地址和 CIDR
+// 命中 Deny 时拒绝；Allow 不为空时只允许名单中的 IP
+type IPFilterGroup struct {
+	Name       string   `mapstructure:"name" yaml:"name"`
+	PathPrefix string   `mapstructure:"path_prefix" yaml:"path_prefix"` // HandlerFunc 按路径前缀匹配分组，最长前缀优先，为空时只能通过 Group 使用
+	Allow      []string `mapstructure:"allow" yaml:"allow"`
+	Deny       []string `mapstructure:"deny" yaml:"deny"`
+}
+
+type ipRule struct {
+	prefix netip.Prefix
+	raw    string
+}
+
+type ipRules []ipRule
+
+// match 返回命中的规则
+func (rules ipRules) match(ip netip.Addr) (string, bool) {
+	for _, rule := range rules {
+		if rule.prefix.Contains(ip) {
+			return rule.raw, true
+		}
+	}
+	return "", false
+}
+
+type ipFilterGroup struct {
+	name       string
+	pathPrefix string
+	allow      ipRules
+	deny       ipRules
+}
+
+type ipFilterState struct {
+	trusted ipRules
+	groups  map[string]*ipFilterGroup
+	ordered []*ipFilterGroup // 按路径前缀长度倒序
+}
+
+// IPFilter IP 黑白名单中间件
+// 客户端 IP 从 RemoteAddr 获取，只有 RemoteAddr 是可信代理时才从右往左解析 X-Forwarded-For，
+// 跳过可信代理后的第一个地址作为客户端 IP。名单可以通过 Update 或 redis 集合热更新
+type IPFilter struct {
+	state  atomic.Pointer[ipFilterState]
+	remote atomic.Pointer[map[string]*ipFilterGroup] // 从 redis 加载的名单
+	log    iface.ILogger
+
+	redis   *redis.Client
+	prefix  string
+	refresh time.Duration
+
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// OptionIPFilter 定义配置函数类型
+type OptionIPFilter func(*IPFilter)
+
+// WithIPFilterRedis 定期从 redis 集合加载名单，和配置中的名单合并
+func WithIPFilterRedis(client *redis.Client) OptionIPFilter {
+	return func(f *IPFilter) {
+		f.redis = client
+	}
+}
+
+// NewIPFilter 创建 IP 黑白名单中间件，配置了 redis 时需要调用 Close 停止刷新
+func NewIPFilter(config *IPFilterConfig, logger iface.ILogger, opts ...OptionIPFilter) (*IPFilter, error) {
+	f := &IPFilter{
+		log:     logger,
+		prefix:  config.RedisPrefix,
+		refresh: time.Duration(config.RedisRefreshSecond) * time.Second,
+		stop:    make(chan struct{}),
+	}
+	if f.prefix == "" {
+		f.prefix = defaultIPFilterRedisPrefix
+	}
+	if f.refresh <= 0 {
+		f.refresh = defaultIPFilterRedisRefresh
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if err := f.Update(config); err != nil {
+		return nil, err
+	}
+	if f.redis != nil {
+		if err := f.Refresh(context.Background()); err != nil {
+			f.log.Warn("ip filter load redis lists failed", log.ErrorField(err))
+		}
+		f.wg.Add(1)
+		go f.refreshLoop()
+	}
+	return f, nil
+}
+
+// Update 替换可信代理和名单，用于配置重新加载。配置有误时返回错误，保留原来的名单
+func (f *IPFilter) Update(config *IPFilterConfig) error {
+	trusted, err := parseIPRules(config.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("ip filter trusted proxies: %w", err)
+	}
+	state := &ipFilterState{trusted: trusted, groups: make(map[string]*ipFilterGroup)}
+	for _, group := range config.Groups {
+		if group.Name == "" {
+			return fmt.Errorf("ip filter group name is empty")
+		}
+		if _, found := state.groups[group.Name]; found {
+			return fmt.Errorf("ip filter group %q is duplicated", group.Name)
+		}
+		g := &ipFilterGroup{name: group.Name, pathPrefix: group.PathPrefix}
+		if g.allow, err = parseIPRules(group.Allow); err != nil {
+			return fmt.Errorf("ip filter group %q allow: %w", group.Name, err)
+		}
+		if g.deny, err = parseIPRules(group.Deny); err != nil {
+			return fmt.Errorf("ip filter group %q deny: %w", group.Name, err)
+		}
+		state.groups[group.Name] = g
+		if g.pathPrefix != "" {
+			state.ordered = append(state.ordered, g)
+		}
+	}
+	sort.SliceStable(state.ordered, func(i, j int) bool {
+		return len(state.ordered[i].pathPrefix) > len(state.ordered[j].pathPrefix)
+	})
+	f.state.Store(state)
+	return nil
+}
+
+// Refresh 从 redis 集合加载配置中各分组的名单，无效的地址会被忽略
+func (f *IPFilter) Refresh(ctx context.Context) error {
+	if f.redis == nil {
+		return nil
+	}
+	remote := make(map[string]*ipFilterGroup)
+	for name := range f.state.Load().groups {
+		g := &ipFilterGroup{name: name}
+		allow, err := f.redis.SMembers(ctx, f.prefix+name+":allow").Result()
+		if err != nil {
+			return err
+		}
+		deny, err := f.redis.SMembers(ctx, f.prefix+name+":deny").Result()
+		if err != nil {
+			return err
+		}
+		g.allow = f.parseRemoteRules(name, allow)
+		g.deny = f.parseRemoteRules(name, deny)
+		remote[name] = g
+	}
+	f.remote.Store(&remote)
+	return nil
+}
+
+func (f *IPFilter) parseRemoteRules(group string, values []string) ipRules {
+	rules := make(ipRules, 0, len(values))
+	for _, value := range values {
+		rule, err := parseIPRule(value)
+		if err != nil {
+			f.log.Warn("ip filter ignore invalid redis rule", log.String("group", group), log.String("rule", value))
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func (f *IPFilter) refreshLoop() {
+	defer f.wg.Done()
+	ticker := time.NewTicker(f.refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := f.Refresh(context.Background()); err != nil {
+				f.log.Warn("ip filter refresh redis lists failed", log.ErrorField(err))
+			}
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+// Close 停止从 redis 刷新名单
+func (f *IPFilter) Close() error {
+	f.closeOnce.Do(func() {
+		close(f.stop)
+	})
+	f.wg.Wait()
+	return nil
+}
+
+// HandlerFunc 按路径前缀匹配分组，没有匹配的分组时放行
+func (f *IPFilter) HandlerFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		state := f.state.Load()
+		for _, g := range state.ordered {
+			if strings.HasPrefix(c.Request.URL.Path, g.pathPrefix) {
+				f.check(c, state, g.name)
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// Group 返回指定分组的中间件，用于路由分组
+//
+//	admin := r.Group("/admin", filter.Group("admin"))
+func (f *IPFilter) Group(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		f.check(c, f.state.Load(), name)
+	}
+}
+
+func (f *IPFilter) check(c *gin.Context, state *ipFilterState, name string) {
+	group, found := state.groups[name]
+	if !found {
+		// 分组可能在热更新时被删除，此时拒绝访问更安全
+		f.deny(c, netip.Addr{}, name, "unknown_group")
+		return
+	}
+	ip, ok := state.clientIP(c.Request)
+	if !ok {
+		f.deny(c, ip, name, "invalid_client_ip")
+		return
+	}
+	var remote *ipFilterGroup
+	if m := f.remote.Load(); m != nil {
+		remote = (*m)[name]
+	}
+
+	if rule, matched := group.deny.match(ip); matched {
+		f.deny(c, ip, name, rule)
+		return
+	}
+	if remote != nil {
+		if rule, matched := remote.deny.match(ip); matched {
+			f.deny(c, ip, name, rule)
+			return
+		}
+	}
+	if len(group.allow) > 0 || (remote != nil && len(remote.allow) > 0) {
+		_, allowed := group.allow.match(ip)
+		if !allowed && remote != nil {
+			_, allowed = remote.allow.match(ip)
+		}
+		if !allowed {
+			f.deny(c, ip, name, ipFilterRuleNotAllowed)
+			return
+		}
+	}
+	c.Next()
+}
+
+func (f *IPFilter) deny(c *gin.Context, ip netip.Addr, group, rule string) {
+	f.log.Warn("ip filter denied",
+		log.String("client_ip", ip.String()),
+		log.String("remote_addr", c.Request.RemoteAddr),
+		log.String("group", group),
+		log.String("rule", rule),
+		log.String("method", c.Request.Method),
+		log.String("path", c.Request.URL.Path),
+		log.String("request_id", GetRequestID(c)),
+	)
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden", "message": "access denied"})
+}
+
+// ClientIP 返回按可信代理解析出的客户端 IP
+func (f *IPFilter) ClientIP(req *http.Request) (netip.Addr, bool) {
+	return f.state.Load().clientIP(req)
+}
+
+func (s *ipFilterState) clientIP(req *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	// 带 zone 的 IPv6 地址（比如 fe80::1%eth0）不会被任何规则匹配，需要去掉 zone
+	ip = ip.Unmap().WithZone("")
+	if _, trusted := s.trusted.match(ip); !trusted {
+		return ip, true
+	}
+	// 从右往左跳过可信代理，左边的地址可以被客户端伪造
+	forwarded := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		value := strings.TrimSpace(forwarded[i])
+		if value == "" {
+			continue
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		ip = addr.Unmap().WithZone("")
+		if _, trusted := s.trusted.match(ip); !trusted {
+			return ip, true
+		}
+	}
+	return ip, true
+}
+
+func parseIPRules(values []string) (ipRules, error) {
+	rules := make(ipRules, 0, len(values))
+	for _, value := range values {
+		rule, err := parseIPRule(value)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// parseIPRule 解析 IP 或 CIDR，单个 IP 视为 /32 或 /128
+func parseIPRule(value string) (ipRule, error) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return ipRule{}, fmt.Errorf("invalid cidr %q", value)
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		return ipRule{prefix: prefix.Masked(), raw: value}, nil
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return ipRule{}, fmt.Errorf("invalid ip %q", value)
+	}
+	addr = addr.Unmap().WithZone("")
+	return ipRule{prefix: netip.PrefixFrom(addr, addr.BitLen()), raw: value}, nil
+}
@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/log/logtest"
+	"github.com/yangkushu/rum-go/redis/redistest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newIPFilterEngine(filter *IPFilter) *gin.Engine {
+	r := gin.New()
+	r.Use(filter.HandlerFunc())
+	r.GET("/admin/users", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/public", func(c *gin.Context) { c.Status(http.StatusOK) })
+	internal := r.Group("/internal", filter.Group("internal"))
+	internal.GET("/stats", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r
+}
+
+func serveFrom(r *gin.Engine, path, remoteAddr, forwardedFor string) int {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestIPFilter(t *testing.T) {
+	logger := logtest.New()
+	filter, err := NewIPFilter(&IPFilterConfig{
+		TrustedProxies: []string{"10.0.0.0/8"},
+		Groups: []IPFilterGroup{
+			{Name: "admin", PathPrefix: "/admin", Allow: []string{"203.0.113.0/24", "2001:db8::/32"}, Deny: []string{"203.0.113.66"}},
+			{Name: "internal", Allow: []string{"192.168.1.1"}},
+		},
+	}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newIPFilterEngine(filter)
+
+	tests := []struct {
+		name         string
+		path         string
+		remoteAddr   string
+		forwardedFor string
+		code         int
+	}{
+		{"allowed ipv4", "/admin/users", "203.0.113.10:1234", "", http.StatusOK},
+		{"allowed ipv6", "/admin/users", "[2001:db8::1]:1234", "", http.StatusOK},
+		{"ipv4 mapped ipv6", "/admin/users", "[::ffff:203.0.113.10]:1234", "", http.StatusOK},
+		{"denied rule wins", "/admin/users", "203.0.113.66:1234", "", http.StatusForbidden},
+		{"not in allow list", "/admin/users", "198.51.100.1:1234", "", http.StatusForbidden},
+		{"forwarded by trusted proxy", "/admin/users", "10.0.0.2:1234", "198.51.100.1, 203.0.113.10, 10.0.0.3", http.StatusOK},
+		{"spoofed forwarded for", "/admin/users", "198.51.100.1:1234", "203.0.113.10", http.StatusForbidden},
+		{"spoofed leftmost forwarded for", "/admin/users", "10.0.0.2:1234", "203.0.113.10, 198.51.100.1", http.StatusForbidden},
+		{"ungrouped path", "/public", "198.51.100.1:1234", "", http.StatusOK},
+		{"route group", "/internal/stats", "192.168.1.1:1234", "", http.StatusOK},
+		{"route group denied", "/internal/stats", "192.168.1.2:1234", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := serveFrom(r, tt.path, tt.remoteAddr, tt.forwardedFor); code != tt.code {
+				t.Errorf("expected %d, got %d", tt.code, code)
+			}
+		})
+	}
+
+	entries := logger.ByMessage("ip filter denied")
+	if len(entries) == 0 {
+		t.Fatal("expected denied attempts to be logged")
+	}
+	if rule, _ := entries[0].Field("rule"); rule != "203.0.113.66" {
+		t.Errorf("expected matched rule to be logged, got %v", rule)
+	}
+
+	// 热更新
+	err = filter.Update(&IPFilterConfig{Groups: []IPFilterGroup{
+		{Name: "admin", PathPrefix: "/admin", Allow: []string{"198.51.100.0/24"}},
+		{Name: "internal"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := serveFrom(r, "/admin/users", "198.51.100.1:1234", ""); code != http.StatusOK {
+		t.Errorf("expected 200 after update, got %d", code)
+	}
+	if err := filter.Update(&IPFilterConfig{Groups: []IPFilterGroup{{Name: "admin", Allow: []string{"bad"}}}}); err == nil {
+		t.Error("expected error for invalid rule")
+	}
+	if code := serveFrom(r, "/admin/users", "198.51.100.1:1234", ""); code != http.StatusOK {
+		t.Errorf("invalid update should keep previous lists, got %d", code)
+	}
+}
+
+func TestIPFilterZonedIPv6(t *testing.T) {
+	filter, err := NewIPFilter(&IPFilterConfig{
+		TrustedProxies: []string{"fe80::1"},
+		Groups:         []IPFilterGroup{{Name: "admin", PathPrefix: "/admin", Deny: []string{"fe80::/10", "2001:db8::66"}}},
+	}, logtest.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newIPFilterEngine(filter)
+	if code := serveFrom(r, "/admin/users", "[fe80::2%eth0]:1234", ""); code != http.StatusForbidden {
+		t.Errorf("zoned address should match deny rules, got %d", code)
+	}
+	if code := serveFrom(r, "/admin/users", "[fe80::1%eth0]:1234", "2001:db8::66"); code != http.StatusForbidden {
+		t.Errorf("zoned trusted proxy should be recognized, got %d", code)
+	}
+	if code := serveFrom(r, "/admin/users", "[2001:db8::1]:1234", ""); code != http.StatusOK {
+		t.Errorf("expected 200, got %d", code)
+	}
+}
+
+func TestIPFilterRedis(t *testing.T) {
+	client, mr := redistest.New(t)
+	_, _ = mr.SAdd("ip_filter:admin:deny", "203.0.113.10")
+
+	filter, err := NewIPFilter(&IPFilterConfig{
+		Groups: []IPFilterGroup{{Name: "admin", PathPrefix: "/admin", Allow: []string{"203.0.113.0/24"}}, {Name: "internal"}},
+	}, logtest.New(), WithIPFilterRedis(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer filter.Close()
+	r := newIPFilterEngine(filter)
+
+	if code := serveFrom(r, "/admin/users", "203.0.113.10:1234", ""); code != http.StatusForbidden {
+		t.Errorf("expected redis deny list to apply, got %d", code)
+	}
+	_, _ = mr.SAdd("ip_filter:admin:allow", "198.51.100.7", "not-an-ip")
+	if err := filter.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if code := serveFrom(r, "/admin/users", "198.51.100.7:1234", ""); code != http.StatusOK {
+		t.Errorf("expected redis allow list to apply, got %d", code)
+	}
+	if code := serveFrom(r, "/admin/users", "198.51.100.8:1234", ""); code != http.StatusForbidden {
+		t.Errorf("expected 403, got %d", code)
+	}
+}
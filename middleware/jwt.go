@@ -0,0 +1,386 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	JWTAlgorithmHS256 = "HS256"
+	JWTAlgorithmRS256 = "RS256"
+	JWTAlgorithmES256 = "ES256"
+
+	defaultJWKSRefreshInterval = 5 * time.Minute
+	minJWKSRefreshInterval     = 10 * time.Second // 遇到未知 kid 时触发刷新的最小间隔
+	jwksFetchTimeout           = 5 * time.Second
+)
+
+var (
+	ErrTokenMissing       = errors.New("token is missing")
+	ErrTokenMalformed     = errors.New("token is malformed")
+	ErrTokenAlgorithm     = errors.New("token algorithm is not allowed")
+	ErrTokenKeyNotFound   = errors.New("token signing key not found")
+	ErrTokenSignature     = errors.New("token signature is invalid")
+	ErrTokenExpired       = errors.New("token is expired")
+	ErrTokenNotValidYet   = errors.New("token is not valid yet")
+	ErrTokenIssuer        = errors.New("token issuer is invalid")
+	ErrTokenAudience      = errors.New("token audience is invalid")
+	ErrTokenRevoked       = errors.New("token has been revoked")
+	ErrInsufficientScope  = errors.New("insufficient scope")
+	ErrInsufficientRole   = errors.New("insufficient role")
+	errJWKSUnsupportedKey = errors.New("unsupported jwk")
+)
+
+// JWTClaims 解析后的 token 声明，Raw 保存全部原始声明，自定义字段从 Raw 中读取
+type JWTClaims struct {
+	Subject   string
+	Issuer    string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	ID        string
+	Scopes    []string // 来自 scope（空格分隔的字符串）或 scp（数组）
+	Roles     []string // 来自 roles
+	Raw       map[string]interface{}
+}
+
+// HasScope 是否包含指定的 scope
+func (c *JWTClaims) HasScope(scope string) bool {
+	return containsString(c.Scopes, scope)
+}
+
+// HasRole 是否包含指定的角色
+func (c *JWTClaims) HasRole(role string) bool {
+	return containsString(c.Roles, role)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// jwtKey 一个验签公钥或 HMAC 密钥，alg 为空时可以用于同类型的任意算法
+type jwtKey struct {
+	kid   string
+	alg   string
+	hmac  []byte
+	rsa   *rsa.PublicKey
+	ecdsa *ecdsa.PublicKey
+}
+
+// supports 密钥类型必须和算法匹配，防止用公钥当作 HMAC 密钥的算法混淆攻击
+func (k *jwtKey) supports(alg string) bool {
+	if k.alg != "" && k.alg != alg {
+		return false
+	}
+	switch alg {
+	case JWTAlgorithmHS256:
+		return k.hmac != nil
+	case JWTAlgorithmRS256:
+		return k.rsa != nil
+	case JWTAlgorithmES256:
+		return k.ecdsa != nil && k.ecdsa.Curve == elliptic.P256()
+	}
+	return false
+}
+
+func (k *jwtKey) verify(alg string, signingInput, signature []byte) bool {
+	switch alg {
+	case JWTAlgorithmHS256:
+		mac := hmac.New(sha256.New, k.hmac)
+		mac.Write(signingInput)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case JWTAlgorithmRS256:
+		digest := sha256.Sum256(signingInput)
+		return rsa.VerifyPKCS1v15(k.rsa, crypto.SHA256, digest[:], signature) == nil
+	case JWTAlgorithmES256:
+		// ES256 的签名是定长的 r||s，不是 ASN.1
+		if len(signature) != 64 {
+			return false
+		}
+		digest := sha256.Sum256(signingInput)
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(k.ecdsa, digest[:], r, s)
+	}
+	return false
+}
+
+// parsePublicKeyPEM 解析 PEM 格式的 RSA 或 ECDSA 公钥，也支持证书
+func parsePublicKeyPEM(data []byte) (*jwtKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid pem public key")
+	}
+	var pub interface{}
+	var err error
+	switch block.Type {
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			pub = cert.PublicKey
+		}
+	case "RSA PUBLIC KEY":
+		pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse pem public key failed: %w", err)
+	}
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return &jwtKey{rsa: key}, nil
+	case *ecdsa.PublicKey:
+		return &jwtKey{ecdsa: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
+
+// jwk JWKS 文档中的一个密钥
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (j *jwk) key() (*jwtKey, error) {
+	if j.Use != "" && j.Use != "sig" {
+		return nil, errJWKSUnsupportedKey
+	}
+	switch j.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil {
+			return nil, err
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		return &jwtKey{kid: j.Kid, alg: j.Alg, rsa: pub}, nil
+	case "EC":
+		if j.Crv != "P-256" {
+			return nil, errJWKSUnsupportedKey
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("jwk point is not on curve")
+		}
+		return &jwtKey{kid: j.Kid, alg: j.Alg, ecdsa: pub}, nil
+	default:
+		return nil, errJWKSUnsupportedKey
+	}
+}
+
+// jwks 从 JWKS 地址拉取的密钥，定期刷新，遇到未知 kid 时也会触发刷新
+type jwks struct {
+	url      string
+	client   *http.Client
+	mu       sync.RWMutex
+	keys     map[string]*jwtKey
+	lastLoad time.Time
+	loadMu   sync.Mutex
+}
+
+func (j *jwks) get(kid string) *jwtKey {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.keys[kid]
+}
+
+func (j *jwks) all() []*jwtKey {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	keys := make([]*jwtKey, 0, len(j.keys))
+	for _, k := range j.keys {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// refresh 重新拉取 JWKS 文档，force 为 false 时距离上次拉取不足最小间隔则跳过
+func (j *jwks) refresh(ctx context.Context, force bool) error {
+	j.loadMu.Lock()
+	defer j.loadMu.Unlock()
+	if !force && time.Since(j.lastLoad) < minJWKSRefreshInterval {
+		return nil
+	}
+	j.lastLoad = time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, jwksFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetch jwks failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch jwks failed: status %d", resp.StatusCode)
+	}
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("decode jwks failed: %w", err)
+	}
+	keys := make(map[string]*jwtKey, len(doc.Keys))
+	for i := range doc.Keys {
+		key, err := doc.Keys[i].key()
+		if err != nil {
+			continue
+		}
+		keys[key.kid] = key
+	}
+	j.mu.Lock()
+	j.keys = keys
+	j.mu.Unlock()
+	return nil
+}
+
+// jwtHeader token 头部
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// splitJWT 拆分并解码 token，返回头部、声明、签名原文和签名
+func splitJWT(token string) (*jwtHeader, map[string]interface{}, []byte, []byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil, nil, nil, ErrTokenMalformed
+	}
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, nil, nil, ErrTokenMalformed
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return nil, nil, nil, nil, ErrTokenMalformed
+	}
+	payloadBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, nil, nil, ErrTokenMalformed
+	}
+	decoder := json.NewDecoder(bytes.NewReader(payloadBytes))
+	decoder.UseNumber()
+	var raw map[string]interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, nil, nil, nil, ErrTokenMalformed
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil, nil, nil, ErrTokenMalformed
+	}
+	return &header, raw, []byte(parts[0] + "." + parts[1]), signature, nil
+}
+
+// newJWTClaims 从原始声明中取出标准字段
+func newJWTClaims(raw map[string]interface{}) (*JWTClaims, error) {
+	claims := &JWTClaims{Raw: raw}
+	claims.Subject, _ = raw["sub"].(string)
+	claims.Issuer, _ = raw["iss"].(string)
+	claims.ID, _ = raw["jti"].(string)
+	claims.Audience = stringOrList(raw["aud"])
+	if scope, ok := raw["scope"].(string); ok {
+		claims.Scopes = strings.Fields(scope)
+	} else {
+		claims.Scopes = stringOrList(raw["scp"])
+	}
+	claims.Roles = stringOrList(raw["roles"])
+
+	var err error
+	if claims.ExpiresAt, err = numericDate(raw["exp"]); err != nil {
+		return nil, err
+	}
+	if claims.NotBefore, err = numericDate(raw["nbf"]); err != nil {
+		return nil, err
+	}
+	if claims.IssuedAt, err = numericDate(raw["iat"]); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func stringOrList(v interface{}) []string {
+	switch value := v.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		list := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// maxNumericDate 允许的最大时间戳，9999-12-31T23:59:59Z
+const maxNumericDate = 253402300799
+
+// numericDate 解析秒级时间戳，不存在时返回零值，负数、超出范围或者不是有限数时返回 ErrTokenMalformed
+func numericDate(v interface{}) (time.Time, error) {
+	if v == nil {
+		return time.Time{}, nil
+	}
+	number, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, ErrTokenMalformed
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, ErrTokenMalformed
+	}
+	if math.IsNaN(seconds) || seconds < 0 || seconds > maxNumericDate {
+		return time.Time{}, ErrTokenMalformed
+	}
+	sec, frac := math.Modf(seconds)
+	return time.Unix(int64(sec), int64(frac*float64(time.Second))), nil
+}
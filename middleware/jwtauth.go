@@ -0,0 +1,463 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/iface"
+	"github.com/yangkushu/rum-go/log"
+	"github.com/yangkushu/rum-go/redis"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// JWTClaimsKey gin.Context 中保存 *JWTClaims 的 key
+	JWTClaimsKey = "jwt_claims"
+
+	defaultJWTTokenLookup       = "header:Authorization"
+	defaultJWTClockSkew         = 30 * time.Second
+	defaultJWTRevocationPrefix  = "jwt:revoked"
+	defaultJWTRevocationTimeout = 50 * time.Millisecond
+)
+
+type jwtClaimsContextKey struct{}
+
+// JWTAuthConfig JWT 认证配置
+// 密钥可以同时配置多种来源：Secret 用于 HS256，PublicKey/PublicKeyFile 用于 RS256/ES256，JWKSURL 按 kid 选择密钥
+type JWTAuthConfig struct {
+	Algorithms         []string `mapstructure:"algorithms" yaml:"algorithms"`                     // 允许的算法，默认 HS256、RS256、ES256
+	Secret             string   `mapstructure:"secret" yaml:"secret"`                             // HS256 密钥
+	PublicKey          string   `mapstructure:"public_key" yaml:"public_key"`                     // PEM 格式的公钥或证书
+	PublicKeyFile      string   `mapstructure:"public_key_file" yaml:"public_key_file"`           // PEM 公钥文件路径
+	JWKSURL            string   `mapstructure:"jwks_url" yaml:"jwks_url"`                         // JWKS 文档地址
+	JWKSRefreshSecond  int      `mapstructure:"jwks_refresh_second" yaml:"jwks_refresh_second"`   // JWKS 刷新间隔，默认 300 秒
+	Issuer             string   `mapstructure:"issuer" yaml:"issuer"`                             // 不为空时校验 iss
+	Audience           []string `mapstructure:"audience" yaml:"audience"`                         // 不为空时 aud 至少包含其中一个
+	ClockSkewSecond    int      `mapstructure:"clock_skew_second" yaml:"clock_skew_second"`       // 校验 exp、nbf 时允许的时钟误差，默认 30 秒，小于 0 不允许误差
+	TokenLookup        string   `mapstructure:"token_lookup" yaml:"token_lookup"`                 // header:<name>、query:<name>、cookie:<name>，默认 header:Authorization
+	RevocationPrefix   string   `mapstructure:"revocation_prefix" yaml:"revocation_prefix"`       // 吊销列表在 redis 中的 key 前缀，默认 jwt:revoked
+	RevocationFailOpen bool     `mapstructure:"revocation_fail_open" yaml:"revocation_fail_open"` // 查询吊销列表失败时是否放行
+}
+
+// JWTAuth JWT 认证中间件，校验通过后把声明保存到 gin.Context 和 request context 中
+type JWTAuth struct {
+	config     JWTAuthConfig
+	algorithms map[string]bool
+	keys       []*jwtKey
+	jwks       *jwks
+	clockSkew  time.Duration
+	lookup     func(c *gin.Context) string
+	redis      *redis.Client
+	log        iface.ILogger
+	skipPaths  map[string]bool
+	optional   bool
+	httpClient *http.Client
+
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// OptionJWTAuth 定义配置函数类型
+type OptionJWTAuth func(*JWTAuth)
+
+// WithJWTAuthRedis 启用吊销检查，token 的 jti 存在于 redis 中时拒绝
+func WithJWTAuthRedis(client *redis.Client) OptionJWTAuth {
+	return func(a *JWTAuth) {
+		a.redis = client
+	}
+}
+
+// WithJWTAuthSkipPaths 跳过认证的路径
+func WithJWTAuthSkipPaths(paths ...string) OptionJWTAuth {
+	return func(a *JWTAuth) {
+		for _, path := range paths {
+			a.skipPaths[path] = true
+		}
+	}
+}
+
+// WithJWTAuthOptional 没有 token 时放行（不写入声明），有 token 但校验失败时依然返回 401
+func WithJWTAuthOptional() OptionJWTAuth {
+	return func(a *JWTAuth) {
+		a.optional = true
+	}
+}
+
+// WithJWTAuthHTTPClient 设置拉取 JWKS 使用的 http.Client
+func WithJWTAuthHTTPClient(client *http.Client) OptionJWTAuth {
+	return func(a *JWTAuth) {
+		a.httpClient = client
+	}
+}
+
+// NewJWTAuth 创建JWT认证中间件。配置了 JWKSURL 时会先拉取一次，拉取失败返回错误
+// 不再使用时需要调用 Close 停止 JWKS 刷新
+func NewJWTAuth(config *JWTAuthConfig, logger iface.ILogger, opts ...OptionJWTAuth) (*JWTAuth, error) {
+	a := &JWTAuth{
+		config:     *config,
+		algorithms: make(map[string]bool),
+		log:        logger,
+		skipPaths:  make(map[string]bool),
+		httpClient: &http.Client{},
+		stop:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	algorithms := a.config.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = []string{JWTAlgorithmHS256, JWTAlgorithmRS256, JWTAlgorithmES256}
+	}
+	for _, alg := range algorithms {
+		switch alg {
+		case JWTAlgorithmHS256, JWTAlgorithmRS256, JWTAlgorithmES256:
+			a.algorithms[alg] = true
+		default:
+			return nil, fmt.Errorf("unsupported jwt algorithm %q", alg)
+		}
+	}
+
+	switch {
+	case a.config.ClockSkewSecond > 0:
+		a.clockSkew = time.Duration(a.config.ClockSkewSecond) * time.Second
+	case a.config.ClockSkewSecond == 0:
+		a.clockSkew = defaultJWTClockSkew
+	}
+	if a.config.RevocationPrefix == "" {
+		a.config.RevocationPrefix = defaultJWTRevocationPrefix
+	}
+
+	lookup, err := newTokenLookup(a.config.TokenLookup)
+	if err != nil {
+		return nil, err
+	}
+	a.lookup = lookup
+
+	if err := a.loadKeys(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// loadKeys 加载配置中的密钥，至少需要一种
+func (a *JWTAuth) loadKeys() error {
+	if a.config.Secret != "" {
+		a.keys = append(a.keys, &jwtKey{hmac: []byte(a.config.Secret)})
+	}
+	if a.config.PublicKey != "" {
+		key, err := parsePublicKeyPEM([]byte(a.config.PublicKey))
+		if err != nil {
+			return err
+		}
+		a.keys = append(a.keys, key)
+	}
+	if a.config.PublicKeyFile != "" {
+		data, err := os.ReadFile(a.config.PublicKeyFile)
+		if err != nil {
+			return fmt.Errorf("read jwt public key file failed: %w", err)
+		}
+		key, err := parsePublicKeyPEM(data)
+		if err != nil {
+			return err
+		}
+		a.keys = append(a.keys, key)
+	}
+	if a.config.JWKSURL != "" {
+		a.jwks = &jwks{url: a.config.JWKSURL, client: a.httpClient}
+		if err := a.jwks.refresh(context.Background(), true); err != nil {
+			return err
+		}
+		interval := time.Duration(a.config.JWKSRefreshSecond) * time.Second
+		if interval <= 0 {
+			interval = defaultJWKSRefreshInterval
+		}
+		a.wg.Add(1)
+		go a.refreshJWKS(interval)
+	}
+	if len(a.keys) == 0 && a.jwks == nil {
+		return errors.New("jwt auth requires secret, public key or jwks url")
+	}
+	return nil
+}
+
+func (a *JWTAuth) refreshJWKS(interval time.Duration) {
+	defer a.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.jwks.refresh(context.Background(), true); err != nil {
+				a.log.Warn("jwt auth refresh jwks failed", log.String("url", a.config.JWKSURL), log.ErrorField(err))
+			}
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// Close 停止 JWKS 刷新
+func (a *JWTAuth) Close() error {
+	a.closeOnce.Do(func() {
+		close(a.stop)
+	})
+	a.wg.Wait()
+	return nil
+}
+
+func newTokenLookup(lookup string) (func(c *gin.Context) string, error) {
+	if lookup == "" {
+		lookup = defaultJWTTokenLookup
+	}
+	source, name, found := strings.Cut(lookup, ":")
+	if !found || name == "" {
+		return nil, fmt.Errorf("invalid jwt token lookup %q", lookup)
+	}
+	switch source {
+	case "header":
+		return func(c *gin.Context) string {
+			value := c.GetHeader(name)
+			if len(value) > 7 && strings.EqualFold(value[:7], "Bearer ") {
+				return strings.TrimSpace(value[7:])
+			}
+			if strings.EqualFold(name, "Authorization") {
+				return ""
+			}
+			return value
+		}, nil
+	case "query":
+		return func(c *gin.Context) string {
+			return c.Query(name)
+		}, nil
+	case "cookie":
+		return func(c *gin.Context) string {
+			value, _ := c.Cookie(name)
+			return value
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid jwt token lookup %q", lookup)
+	}
+}
+
+// Parse 校验 token 的签名和 exp、nbf、iss、aud，返回声明，不检查吊销
+func (a *JWTAuth) Parse(ctx context.Context, token string) (*JWTClaims, error) {
+	if token == "" {
+		return nil, ErrTokenMissing
+	}
+	header, raw, signingInput, signature, err := splitJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	if !a.algorithms[header.Alg] {
+		return nil, ErrTokenAlgorithm
+	}
+	key := a.findKey(ctx, header)
+	if key == nil {
+		return nil, ErrTokenKeyNotFound
+	}
+	if !key.verify(header.Alg, signingInput, signature) {
+		return nil, ErrTokenSignature
+	}
+
+	claims, err := newJWTClaims(raw)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if !claims.ExpiresAt.IsZero() && now.After(claims.ExpiresAt.Add(a.clockSkew)) {
+		return nil, ErrTokenExpired
+	}
+	if !claims.NotBefore.IsZero() && now.Add(a.clockSkew).Before(claims.NotBefore) {
+		return nil, ErrTokenNotValidYet
+	}
+	if a.config.Issuer != "" && claims.Issuer != a.config.Issuer {
+		return nil, ErrTokenIssuer
+	}
+	if len(a.config.Audience) > 0 && !matchAudience(claims.Audience, a.config.Audience) {
+		return nil, ErrTokenAudience
+	}
+	return claims, nil
+}
+
+// findKey 选择验签密钥：有 kid 时优先从 JWKS 中查找，未知 kid 触发一次刷新；否则使用第一个匹配算法的密钥
+func (a *JWTAuth) findKey(ctx context.Context, header *jwtHeader) *jwtKey {
+	if a.jwks != nil && header.Kid != "" {
+		key := a.jwks.get(header.Kid)
+		if key == nil {
+			if err := a.jwks.refresh(ctx, false); err != nil {
+				a.log.Warn("jwt auth refresh jwks failed", log.String("url", a.config.JWKSURL), log.ErrorField(err))
+			}
+			key = a.jwks.get(header.Kid)
+		}
+		if key != nil && key.supports(header.Alg) {
+			return key
+		}
+	}
+	for _, key := range a.keys {
+		if key.supports(header.Alg) {
+			return key
+		}
+	}
+	if a.jwks != nil && header.Kid == "" {
+		for _, key := range a.jwks.all() {
+			if key.supports(header.Alg) {
+				return key
+			}
+		}
+	}
+	return nil
+}
+
+func matchAudience(tokenAudience, allowed []string) bool {
+	for _, aud := range tokenAudience {
+		if containsString(allowed, aud) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRevoked 查询 token 是否已被吊销，没有 jti 的 token 无法吊销
+func (a *JWTAuth) IsRevoked(ctx context.Context, claims *JWTClaims) (bool, error) {
+	if a.redis == nil || claims.ID == "" {
+		return false, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, defaultJWTRevocationTimeout)
+	defer cancel()
+	n, err := a.redis.Exists(ctx, a.revocationKey(claims.ID)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Revoke 吊销一个 token，ttl 一般设置为 token 剩余的有效期
+func (a *JWTAuth) Revoke(ctx context.Context, jti string, ttl time.Duration) error {
+	if a.redis == nil {
+		return errors.New("jwt auth redis is not configured")
+	}
+	return a.redis.Set(ctx, a.revocationKey(jti), 1, ttl).Err()
+}
+
+func (a *JWTAuth) revocationKey(jti string) string {
+	return a.config.RevocationPrefix + ":" + jti
+}
+
+func (a *JWTAuth) HandlerFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a.skipPaths[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+		token := a.lookup(c)
+		if token == "" && a.optional {
+			c.Next()
+			return
+		}
+
+		claims, err := a.Parse(c.Request.Context(), token)
+		if err != nil {
+			a.log.Debug("jwt auth rejected", log.String("path", c.Request.URL.Path), log.ErrorField(err))
+			abortUnauthorized(c, err)
+			return
+		}
+		revoked, err := a.IsRevoked(c.Request.Context(), claims)
+		if err != nil {
+			a.log.Error("jwt auth check revocation failed", log.String("jti", claims.ID), log.ErrorField(err))
+			if !a.config.RevocationFailOpen {
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "service_unavailable", "message": "unable to verify token"})
+				return
+			}
+		}
+		if revoked {
+			abortUnauthorized(c, ErrTokenRevoked)
+			return
+		}
+
+		c.Set(JWTClaimsKey, claims)
+		// 访问日志默认从 user_id 读取用户标识
+		if _, exists := c.Get(defaultAccessLogUserIDKey); !exists && claims.Subject != "" {
+			c.Set(defaultAccessLogUserIDKey, claims.Subject)
+		}
+		c.Request = c.Request.WithContext(ContextWithJWTClaims(c.Request.Context(), claims))
+		c.Next()
+	}
+}
+
+// RequireScopes 要求 token 包含全部 scope，需要放在 JWTAuth 之后
+func RequireScopes(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := GetJWTClaims(c)
+		if claims == nil {
+			abortUnauthorized(c, ErrTokenMissing)
+			return
+		}
+		for _, scope := range scopes {
+			if !claims.HasScope(scope) {
+				c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, strings.Join(scopes, " ")))
+				abortForbidden(c, ErrInsufficientScope)
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// RequireRoles 要求 token 至少包含其中一个角色，需要放在 JWTAuth 之后
+func RequireRoles(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := GetJWTClaims(c)
+		if claims == nil {
+			abortUnauthorized(c, ErrTokenMissing)
+			return
+		}
+		for _, role := range roles {
+			if claims.HasRole(role) {
+				c.Next()
+				return
+			}
+		}
+		abortForbidden(c, ErrInsufficientRole)
+	}
+}
+
+func abortUnauthorized(c *gin.Context, err error) {
+	if errors.Is(err, ErrTokenMissing) {
+		c.Header("WWW-Authenticate", "Bearer")
+	} else {
+		c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="invalid_token", error_description="%s"`, err.Error()))
+	}
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized", "message": err.Error()})
+}
+
+func abortForbidden(c *gin.Context, err error) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden", "message": err.Error()})
+}
+
+// GetJWTClaims 获取 JWTAuth 保存的声明，未认证时返回 nil
+func GetJWTClaims(c *gin.Context) *JWTClaims {
+	if v, exists := c.Get(JWTClaimsKey); exists {
+		if claims, ok := v.(*JWTClaims); ok {
+			return claims
+		}
+	}
+	return JWTClaimsFromContext(c.Request.Context())
+}
+
+// ContextWithJWTClaims 把声明保存到 context 中
+func ContextWithJWTClaims(ctx context.Context, claims *JWTClaims) context.Context {
+	return context.WithValue(ctx, jwtClaimsContextKey{}, claims)
+}
+
+// JWTClaimsFromContext 从 context 中获取声明，不存在时返回 nil
+func JWTClaimsFromContext(ctx context.Context) *JWTClaims {
+	claims, _ := ctx.Value(jwtClaimsContextKey{}).(*JWTClaims)
+	return claims
+}
@@ -0,0 +1,262 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/log/logtest"
+	"github.com/yangkushu/rum-go/redis/redistest"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// signJWT 测试用的签名函数，key 为 []byte、*rsa.PrivateKey 或 *ecdsa.PrivateKey
+func signJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	h, _ := json.Marshal(header)
+	p, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(p)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func newJWTAuthEngine(t *testing.T, auth *JWTAuth, handlers ...gin.HandlerFunc) *gin.Engine {
+	t.Cleanup(func() { _ = auth.Close() })
+	r := gin.New()
+	r.Use(auth.HandlerFunc())
+	handlers = append(handlers, func(c *gin.Context) {
+		claims := JWTClaimsFromContext(c.Request.Context())
+		c.String(http.StatusOK, claims.Subject+"|"+c.GetString(defaultAccessLogUserIDKey))
+	})
+	r.GET("/", handlers...)
+	return r
+}
+
+func doJWTRequest(r *gin.Engine, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestJWTAuthHS256Claims(t *testing.T) {
+	secret := []byte("secret")
+	auth, err := NewJWTAuth(&JWTAuthConfig{
+		Secret:          string(secret),
+		Issuer:          "rum",
+		Audience:        []string{"api"},
+		ClockSkewSecond: 5,
+	}, logtest.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newJWTAuthEngine(t, auth)
+	now := time.Now().Unix()
+	valid := map[string]interface{}{"sub": "u1", "iss": "rum", "aud": []string{"web", "api"}, "exp": now + 60}
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+		body   string
+	}{
+		{"valid", signJWT(t, "HS256", "", secret, valid), http.StatusOK, "u1|u1"},
+		{"missing", "", http.StatusUnauthorized, ErrTokenMissing.Error()},
+		{"malformed", "abc.def", http.StatusUnauthorized, ErrTokenMalformed.Error()},
+		{"bad signature", signJWT(t, "HS256", "", []byte("other"), valid), http.StatusUnauthorized, ErrTokenSignature.Error()},
+		{"expired", signJWT(t, "HS256", "", secret, map[string]interface{}{"sub": "u1", "iss": "rum", "aud": "api", "exp": now - 10}), http.StatusUnauthorized, ErrTokenExpired.Error()},
+		{"expired within skew", signJWT(t, "HS256", "", secret, map[string]interface{}{"sub": "u1", "iss": "rum", "aud": "api", "exp": now - 2}), http.StatusOK, "u1|u1"},
+		{"not valid yet", signJWT(t, "HS256", "", secret, map[string]interface{}{"sub": "u1", "iss": "rum", "aud": "api", "nbf": now + 60}), http.StatusUnauthorized, ErrTokenNotValidYet.Error()},
+		{"expires after 2262", signJWT(t, "HS256", "", secret, map[string]interface{}{"sub": "u1", "iss": "rum", "aud": "api", "exp": 1e10}), http.StatusOK, "u1|u1"},
+		{"nbf out of range", signJWT(t, "HS256", "", secret, map[string]interface{}{"sub": "u1", "iss": "rum", "aud": "api", "nbf": 1e19}), http.StatusUnauthorized, ErrTokenMalformed.Error()},
+		{"negative exp", signJWT(t, "HS256", "", secret, map[string]interface{}{"sub": "u1", "iss": "rum", "aud": "api", "exp": -1}), http.StatusUnauthorized, ErrTokenMalformed.Error()},
+		{"wrong issuer", signJWT(t, "HS256", "", secret, map[string]interface{}{"sub": "u1", "iss": "x", "aud": "api"}), http.StatusUnauthorized, ErrTokenIssuer.Error()},
+		{"wrong audience", signJWT(t, "HS256", "", secret, map[string]interface{}{"sub": "u1", "iss": "rum", "aud": "web"}), http.StatusUnauthorized, ErrTokenAudience.Error()},
+		{"alg none", "eyJhbGciOiJub25lIn0.eyJzdWIiOiJ1MSJ9.", http.StatusUnauthorized, ErrTokenAlgorithm.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doJWTRequest(r, tt.token)
+			if w.Code != tt.status {
+				t.Fatalf("expected %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			if tt.status == http.StatusOK {
+				if w.Body.String() != tt.body {
+					t.Errorf("expected body %q, got %q", tt.body, w.Body.String())
+				}
+				return
+			}
+			var body map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body["error"] != "unauthorized" || body["message"] != tt.body {
+				t.Errorf("unexpected body %v", body)
+			}
+			if w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestJWTAuthRS256AndAlgorithmConfusion(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	auth, err := NewJWTAuth(&JWTAuthConfig{PublicKey: string(publicPEM)}, logtest.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newJWTAuthEngine(t, auth)
+
+	if w := doJWTRequest(r, signJWT(t, "RS256", "", key, map[string]interface{}{"sub": "u1"})); w.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	// 用公钥作为 HMAC 密钥伪造的 token 必须被拒绝
+	if w := doJWTRequest(r, signJWT(t, "HS256", "", publicPEM, map[string]interface{}{"sub": "u1"})); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for algorithm confusion, got %d", w.Code)
+	}
+}
+
+func TestJWTAuthJWKS(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	keys := []map[string]string{{
+		"kty": "EC", "kid": "ec1", "crv": "P-256", "use": "sig",
+		"x": base64.RawURLEncoding.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))),
+		"y": base64.RawURLEncoding.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32))),
+	}}
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	defer server.Close()
+
+	auth, err := NewJWTAuth(&JWTAuthConfig{JWKSURL: server.URL}, logtest.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newJWTAuthEngine(t, auth)
+	if w := doJWTRequest(r, signJWT(t, "ES256", "ec1", ecKey, map[string]interface{}{"sub": "u1"})); w.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// 密钥轮换后，未知的 kid 触发一次刷新
+	keys = append(keys, map[string]string{
+		"kty": "RSA", "kid": "rsa1", "alg": "RS256",
+		"n": base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+		"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+	})
+	auth.jwks.lastLoad = time.Time{}
+	if w := doJWTRequest(r, signJWT(t, "RS256", "rsa1", rsaKey, map[string]interface{}{"sub": "u2"})); w.Code != http.StatusOK {
+		t.Errorf("expected 200 after key rotation, got %d: %s", w.Code, w.Body.String())
+	}
+	if fetches != 2 {
+		t.Errorf("expected 2 jwks fetches, got %d", fetches)
+	}
+	// 刚刚刷新过，未知 kid 直接拒绝，不会再次拉取
+	if w := doJWTRequest(r, signJWT(t, "RS256", "unknown", rsaKey, map[string]interface{}{"sub": "u3"})); w.Code != http.StatusUnauthorized || fetches != 2 {
+		t.Errorf("expected 401 without refetch, got %d with %d fetches", w.Code, fetches)
+	}
+}
+
+func TestJWTAuthScopesAndRoles(t *testing.T) {
+	secret := []byte("secret")
+	auth, err := NewJWTAuth(&JWTAuthConfig{Secret: string(secret)}, logtest.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newJWTAuthEngine(t, auth, RequireScopes("orders:read", "orders:write"), RequireRoles("admin", "ops"))
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		status int
+	}{
+		{"scope string", map[string]interface{}{"sub": "u1", "scope": "orders:read orders:write", "roles": []string{"ops"}}, http.StatusOK},
+		{"scp list", map[string]interface{}{"sub": "u1", "scp": []string{"orders:read", "orders:write"}, "roles": []string{"admin"}}, http.StatusOK},
+		{"missing scope", map[string]interface{}{"sub": "u1", "scope": "orders:read", "roles": []string{"admin"}}, http.StatusForbidden},
+		{"missing role", map[string]interface{}{"sub": "u1", "scope": "orders:read orders:write", "roles": []string{"user"}}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doJWTRequest(r, signJWT(t, "HS256", "", secret, tt.claims))
+			if w.Code != tt.status {
+				t.Errorf("expected %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestJWTAuthRevocation(t *testing.T) {
+	client, mr := redistest.New(t)
+	secret := []byte("secret")
+	auth, err := NewJWTAuth(&JWTAuthConfig{Secret: string(secret)}, logtest.New(), WithJWTAuthRedis(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newJWTAuthEngine(t, auth)
+	token := signJWT(t, "HS256", "", secret, map[string]interface{}{"sub": "u1", "jti": "t1"})
+
+	if w := doJWTRequest(r, token); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if err := auth.Revoke(context.Background(), "t1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if w := doJWTRequest(r, token); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for revoked token, got %d", w.Code)
+	}
+
+	// redis 不可用时默认拒绝
+	mr.Close()
+	if w := doJWTRequest(r, token); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 when redis is down, got %d", w.Code)
+	}
+}
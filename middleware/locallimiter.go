@@ -2,53 +2,173 @@ package middleware
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/time/rate"
+	"math"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const (
+	defaultLocalRateLimiterMaxEntries  = 10000
+	defaultLocalRateLimiterIdleTimeout = 10 * time.Minute
+	localRateLimiterEvictSamples       = 5 // 数量达到上限时随机检查的限速器数量
+
+	rateLimitClassDefault = "default"
+)
+
+// LocalRateLimiterConfig 本地限速器配置
+type LocalRateLimiterConfig struct {
+	Limit             int                     `mapstructure:"limit" yaml:"limit"`                             // 每秒允许的请求数
+	Burst             int                     `mapstructure:"burst" yaml:"burst"`                             // 允许的突发请求数
+	KeyBy             string                  `mapstructure:"key_by" yaml:"key_by"`                           // 限速 key，比如 route、ip、header:X-Api-Key、route+ip
+	MaxEntries        int                     `mapstructure:"max_entries" yaml:"max_entries"`                 // 最多保留的限速器数量
+	IdleTimeoutSecond int                     `mapstructure:"idle_timeout_second" yaml:"idle_timeout_second"` // 限速器空闲多久后回收，单位秒
+	EnableHeaders     bool                    `mapstructure:"enable_headers" yaml:"enable_headers"`           // 是否返回 RateLimit-* 响应头
+	Routes            []LocalRateLimiterRoute `mapstructure:"routes" yaml:"routes"`                           // 按路由覆盖限额
+}
+
+// LocalRateLimiterRoute 单个路由的限额
+type LocalRateLimiterRoute struct {
+	Method string `mapstructure:"method" yaml:"method"` // 为空或 * 匹配所有方法
+	Path   string `mapstructure:"path" yaml:"path"`     // 路由模板，比如 /users/:id
+	Limit  int    `mapstructure:"limit" yaml:"limit"`
+	Burst  int    `mapstructure:"burst" yaml:"burst"`
+}
+
+type localLimiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen atomic.Int64 // 最后一次使用的时间，UnixNano
+}
+
 type LocalRateLimiter struct {
-	limiterMap map[string]*rate.Limiter
-	lock       sync.RWMutex         // 使用RWMutex以优化读取性能
-	limit      int                  // 限速器的速率
-	burst      int                  // 限速器的临时最大值
-	prohibitFn func(c *gin.Context) // 限速器超过限制时的处理函数，非指针类型
+	limiterMap    map[string]*localLimiterEntry
+	lock          sync.RWMutex         // 使用RWMutex以优化读取性能
+	limit         int                  // 限速器的速率
+	burst         int                  // 限速器的临时最大值
+	prohibitFn    func(c *gin.Context) // 限速器超过限制时的处理函数，非指针类型
+	keyFunc       RateLimitKeyFunc
+	routes        map[string]LocalRateLimiterRoute // method:path -> 路由限额
+	maxEntries    int
+	idleTimeout   time.Duration
+	lastSweep     time.Time
+	enableHeaders bool
+	requests      *prometheus.CounterVec
+}
+
+// OptionLocalRateLimiter 定义配置函数类型
+type OptionLocalRateLimiter func(*LocalRateLimiter)
+
+// WithLocalRateLimiterKeyFunc 设置限速 key 的生成函数，默认 KeyByRoute
+func WithLocalRateLimiterKeyFunc(keyFunc RateLimitKeyFunc) OptionLocalRateLimiter {
+	return func(l *LocalRateLimiter) {
+		l.keyFunc = keyFunc
+	}
+}
+
+// WithLocalRateLimiterRoute 为指定路由设置单独的限额，method 为空或 * 时匹配所有方法
+func WithLocalRateLimiterRoute(method, path string, limit, burst int) OptionLocalRateLimiter {
+	return func(l *LocalRateLimiter) {
+		if method == "" {
+			method = "*"
+		}
+		route := LocalRateLimiterRoute{Method: strings.ToUpper(method), Path: path, Limit: limit, Burst: burst}
+		l.routes[route.Method+":"+route.Path] = route
+	}
+}
+
+// WithLocalRateLimiterMaxEntries 最多保留的限速器数量，超出时优先回收空闲的限速器
+func WithLocalRateLimiterMaxEntries(maxEntries int) OptionLocalRateLimiter {
+	return func(l *LocalRateLimiter) {
+		l.maxEntries = maxEntries
+	}
+}
+
+// WithLocalRateLimiterIdleTimeout 限速器空闲多久后回收
+func WithLocalRateLimiterIdleTimeout(idleTimeout time.Duration) OptionLocalRateLimiter {
+	return func(l *LocalRateLimiter) {
+		l.idleTimeout = idleTimeout
+	}
+}
+
+// WithLocalRateLimiterHeaders 返回 RateLimit-* 响应头
+func WithLocalRateLimiterHeaders(enable bool) OptionLocalRateLimiter {
+	return func(l *LocalRateLimiter) {
+		l.enableHeaders = enable
+	}
+}
+
+// WithLocalRateLimiterMetrics 统计每类限额的放行和拒绝次数，需要将 Collectors() 注册到 prom.Prom
+func WithLocalRateLimiterMetrics(namespace string) OptionLocalRateLimiter {
+	return func(l *LocalRateLimiter) {
+		l.requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_limit_requests_total",
+			Help:      "Requests checked by the local rate limiter, partitioned by limit class and result.",
+		}, []string{"class", "result"})
+	}
 }
 
 // NewLocalRateLimiter 创建一个新的LocalRateLimiter实例
-func NewLocalRateLimiter(limit int, burst int, prohibitFn func(c *gin.Context)) *LocalRateLimiter {
-	limiterMap := make(map[string]*rate.Limiter)
+func NewLocalRateLimiter(limit int, burst int, prohibitFn func(c *gin.Context), options ...OptionLocalRateLimiter) *LocalRateLimiter {
+	limiterMap := make(map[string]*localLimiterEntry)
 
-	return &LocalRateLimiter{
-		limit:      limit,
-		limiterMap: limiterMap,
-		burst:      burst,
-		prohibitFn: prohibitFn,
+	l := &LocalRateLimiter{
+		limit:       limit,
+		limiterMap:  limiterMap,
+		burst:       burst,
+		prohibitFn:  prohibitFn,
+		keyFunc:     KeyByRoute(),
+		routes:      make(map[string]LocalRateLimiterRoute),
+		maxEntries:  defaultLocalRateLimiterMaxEntries,
+		idleTimeout: defaultLocalRateLimiterIdleTimeout,
+		lastSweep:   time.Now(),
+	}
+	for _, option := range options {
+		option(l)
 	}
+	return l
+}
+
+// NewLocalRateLimiterWithConfig 从配置创建LocalRateLimiter，options 会覆盖配置中的值
+func NewLocalRateLimiterWithConfig(cfg *LocalRateLimiterConfig, prohibitFn func(c *gin.Context), options ...OptionLocalRateLimiter) (*LocalRateLimiter, error) {
+	keyFunc, err := ParseRateLimitKeyFunc(cfg.KeyBy)
+	if err != nil {
+		return nil, err
+	}
+	opts := []OptionLocalRateLimiter{
+		WithLocalRateLimiterKeyFunc(keyFunc),
+		WithLocalRateLimiterHeaders(cfg.EnableHeaders),
+	}
+	if cfg.MaxEntries > 0 {
+		opts = append(opts, WithLocalRateLimiterMaxEntries(cfg.MaxEntries))
+	}
+	if cfg.IdleTimeoutSecond > 0 {
+		opts = append(opts, WithLocalRateLimiterIdleTimeout(time.Duration(cfg.IdleTimeoutSecond)*time.Second))
+	}
+	for _, route := range cfg.Routes {
+		opts = append(opts, WithLocalRateLimiterRoute(route.Method, route.Path, route.Limit, route.Burst))
+	}
+	return NewLocalRateLimiter(cfg.Limit, cfg.Burst, prohibitFn, append(opts, options...)...), nil
 }
 
 // HandlerFunc 限速器的中间件
 func (l *LocalRateLimiter) HandlerFunc() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		method := c.Request.Method // 当前请求的方法
-		path := c.Request.URL.Path // 当前请求的路径
+		class, limit, burst := l.match(c)
+		key := class + "|" + l.keyFunc(c)
 
-		key := method + ":" + path
+		limiter := l.getLimiter(key, limit, burst)
+		allowed := limiter.Allow()
+		l.observe(class, allowed)
 
-		l.lock.RLock()
-		limiter, exists := l.limiterMap[key]
-		l.lock.RUnlock()
-
-		if !exists {
-			l.lock.Lock()
-			// 双重检查锁定，以防在获取写锁的过程中limiter被创建
-			if limiter, exists = l.limiterMap[key]; !exists {
-				limiter = rate.NewLimiter(rate.Limit(l.limit), l.burst)
-				l.limiterMap[key] = limiter
-			}
-			l.lock.Unlock()
+		if l.enableHeaders {
+			setRateLimitHeaders(c, localRateLimitResult(limiter, allowed))
 		}
 
-		if !limiter.Allow() {
+		if !allowed {
 			if l.prohibitFn != nil {
 				l.prohibitFn(c)
 			} else {
@@ -60,3 +180,120 @@ func (l *LocalRateLimiter) HandlerFunc() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// Collectors 返回限速器的指标，未开启指标时返回空
+func (l *LocalRateLimiter) Collectors() []prometheus.Collector {
+	if l.requests == nil {
+		return nil
+	}
+	return []prometheus.Collector{l.requests}
+}
+
+// Len 当前保留的限速器数量
+func (l *LocalRateLimiter) Len() int {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return len(l.limiterMap)
+}
+
+// match 查找请求对应的路由限额，没有配置时使用默认限额
+func (l *LocalRateLimiter) match(c *gin.Context) (class string, limit int, burst int) {
+	if len(l.routes) > 0 {
+		fullPath := c.FullPath()
+		if route, ok := l.routes[c.Request.Method+":"+fullPath]; ok {
+			return route.Method + ":" + route.Path, route.Limit, route.Burst
+		}
+		if route, ok := l.routes["*:"+fullPath]; ok {
+			return route.Method + ":" + route.Path, route.Limit, route.Burst
+		}
+	}
+	return rateLimitClassDefault, l.limit, l.burst
+}
+
+// allow 判断 key 对应的限速器是否允许通过，使用默认限额
+func (l *LocalRateLimiter) allow(key string) bool {
+	return l.getLimiter(rateLimitClassDefault+"|"+key, l.limit, l.burst).Allow()
+}
+
+// getLimiter 获取 key 对应的限速器，不存在时创建
+func (l *LocalRateLimiter) getLimiter(key string, limit int, burst int) *rate.Limiter {
+	now := time.Now()
+
+	l.lock.RLock()
+	entry, exists := l.limiterMap[key]
+	l.lock.RUnlock()
+
+	if !exists {
+		l.lock.Lock()
+		// 双重检查锁定，以防在获取写锁的过程中limiter被创建
+		if entry, exists = l.limiterMap[key]; !exists {
+			l.evictLocked(now)
+			entry = &localLimiterEntry{limiter: rate.NewLimiter(rate.Limit(limit), burst)}
+			l.limiterMap[key] = entry
+		}
+		l.lock.Unlock()
+	}
+
+	entry.lastSeen.Store(now.UnixNano())
+	return entry.limiter
+}
+
+// evictLocked 每隔 idleTimeout 回收一次空闲的限速器；数量达到上限时随机取几个限速器，回收其中最久未使用的一个，
+// 不需要每个新 key 都遍历所有限速器。调用方需持有写锁
+func (l *LocalRateLimiter) evictLocked(now time.Time) {
+	if now.Sub(l.lastSweep) >= l.idleTimeout {
+		l.lastSweep = now
+		idleBefore := now.Add(-l.idleTimeout).UnixNano()
+		for key, entry := range l.limiterMap {
+			if entry.lastSeen.Load() < idleBefore {
+				delete(l.limiterMap, key)
+			}
+		}
+	}
+	if l.maxEntries <= 0 || len(l.limiterMap) < l.maxEntries {
+		return
+	}
+
+	// map 的遍历起点是随机的，取前几个作为样本
+	oldestKey, oldest, sampled := "", int64(math.MaxInt64), 0
+	for key, entry := range l.limiterMap {
+		if lastSeen := entry.lastSeen.Load(); lastSeen < oldest {
+			oldestKey, oldest = key, lastSeen
+		}
+		if sampled++; sampled >= localRateLimiterEvictSamples {
+			break
+		}
+	}
+	delete(l.limiterMap, oldestKey)
+}
+
+func (l *LocalRateLimiter) observe(class string, allowed bool) {
+	if l.requests == nil {
+		return
+	}
+	result := "allowed"
+	if !allowed {
+		result = "rejected"
+	}
+	l.requests.WithLabelValues(class, result).Inc()
+}
+
+// localRateLimitResult 根据令牌桶当前状态计算响应头需要的值
+func localRateLimitResult(limiter *rate.Limiter, allowed bool) *rateLimitResult {
+	tokens := limiter.Tokens()
+	burst := limiter.Burst()
+	result := &rateLimitResult{
+		allowed:   allowed,
+		limit:     burst,
+		remaining: int(math.Max(0, math.Floor(tokens))),
+	}
+	if limit := float64(limiter.Limit()); limit > 0 {
+		// 拒绝时返回获得下一个令牌的等待时间，允许时返回令牌桶装满的时间
+		missing := float64(burst) - tokens
+		if !allowed {
+			missing = 1 - tokens
+		}
+		result.reset = time.Duration(math.Max(0, missing) / limit * float64(time.Second))
+	}
+	return result
+}
@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLocalRateLimiterRouteKeyAndOverrides(t *testing.T) {
+	cfg := &LocalRateLimiterConfig{
+		Limit:         1,
+		Burst:         1,
+		KeyBy:         "route",
+		EnableHeaders: true,
+		Routes:        []LocalRateLimiterRoute{{Method: "GET", Path: "/search", Limit: 1, Burst: 3}},
+	}
+	limiter, err := NewLocalRateLimiterWithConfig(cfg, nil, WithLocalRateLimiterMetrics("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.Use(limiter.HandlerFunc())
+	r.GET("/users/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/search", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	// 不同的路径参数共享同一个路由限额
+	if w := serve("/users/1"); w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "1" {
+		t.Fatalf("unexpected first response %d %v", w.Code, w.Header())
+	}
+	w := serve("/users/2")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header")
+	}
+
+	// 路由覆盖的限额
+	for i := 0; i < 3; i++ {
+		if w := serve("/search"); w.Code != http.StatusOK {
+			t.Fatalf("search request %d: expected 200, got %d", i, w.Code)
+		}
+	}
+	if w := serve("/search"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 after burst, got %d", w.Code)
+	}
+
+	if limiter.Len() != 2 {
+		t.Errorf("expected 2 limiters, got %d", limiter.Len())
+	}
+	if v := testutil.ToFloat64(limiter.requests.WithLabelValues("GET:/search", "rejected")); v != 1 {
+		t.Errorf("expected 1 rejected search request, got %v", v)
+	}
+	if v := testutil.ToFloat64(limiter.requests.WithLabelValues(rateLimitClassDefault, "allowed")); v != 1 {
+		t.Errorf("expected 1 allowed default request, got %v", v)
+	}
+}
+
+func TestLocalRateLimiterEviction(t *testing.T) {
+	limiter := NewLocalRateLimiter(10, 10, nil,
+		WithLocalRateLimiterKeyFunc(KeyByHeader("X-Api-Key")),
+		WithLocalRateLimiterMaxEntries(3),
+		WithLocalRateLimiterIdleTimeout(time.Hour),
+	)
+	r := gin.New()
+	r.Use(limiter.HandlerFunc())
+	r.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, key := range []string{"a", "b", "c", "a", "d"} {
+		req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		req.Header.Set("X-Api-Key", key)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if limiter.Len() != 3 {
+		t.Errorf("expected limiter count capped at 3, got %d", limiter.Len())
+	}
+	// 样本数大于上限时一定回收最久未使用的 b
+	for _, key := range []string{"a", "c", "d"} {
+		if _, found := limiter.limiterMap[rateLimitClassDefault+"|"+key]; !found {
+			t.Errorf("expected %s to be kept, got %v", key, limiter.limiterMap)
+		}
+	}
+}
+
+func TestParseRateLimitKeyFunc(t *testing.T) {
+	if _, err := ParseRateLimitKeyFunc("route+ip+header:X-Api-Key+context:user_id"); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseRateLimitKeyFunc("unknown"); err == nil {
+		t.Error("expected error for unknown key")
+	}
+}
@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yangkushu/rum-go/prom"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const unmatchedRoute = "unmatched"
+
+// 请求/响应大小的默认分桶，100B ~ 10MB
+var defaultSizeBuckets = prometheus.ExponentialBuckets(100, 10, 6)
+
+// 标准 method 之外的请求统一记为 other，控制标签基数
+var knownMethods = map[string]struct{}{
+	http.MethodGet: {}, http.MethodHead: {}, http.MethodPost: {}, http.MethodPut: {},
+	http.MethodPatch: {}, http.MethodDelete: {}, http.MethodOptions: {},
+}
+
+// HTTPMetrics HTTP 指标中间件，按路由模板、method 和状态码类别统计请求
+type HTTPMetrics struct {
+	requests      *prometheus.CounterVec
+	duration      *prometheus.HistogramVec
+	requestSize   *prometheus.HistogramVec
+	responseSize  *prometheus.HistogramVec
+	inFlight      prometheus.Gauge
+	skipPaths     map[string]struct{}
+	latencyBucket []float64
+}
+
+// OptionHTTPMetrics 定义配置函数类型
+type OptionHTTPMetrics func(*HTTPMetrics)
+
+// WithHTTPMetricsSkipPaths 不统计的路径，比如 /metrics 本身
+func WithHTTPMetricsSkipPaths(paths ...string) OptionHTTPMetrics {
+	return func(m *HTTPMetrics) {
+		for _, path := range paths {
+			m.skipPaths[path] = struct{}{}
+		}
+	}
+}
+
+// WithHTTPMetricsLatencyBuckets 设置耗时直方图的分桶，单位秒
+func WithHTTPMetricsLatencyBuckets(buckets []float64) OptionHTTPMetrics {
+	return func(m *HTTPMetrics) {
+		m.latencyBucket = buckets
+	}
+}
+
+// NewHTTPMetrics 创建 HTTP 指标中间件，指标注册到 p 的 registry 上，并使用 p 的命名空间
+func NewHTTPMetrics(p *prom.Prom, options ...OptionHTTPMetrics) (*HTTPMetrics, error) {
+	m := &HTTPMetrics{
+		skipPaths:     make(map[string]struct{}),
+		latencyBucket: prometheus.DefBuckets,
+	}
+	for _, option := range options {
+		option(m)
+	}
+
+	namespace := p.Namespace()
+	labels := []string{"route", "method", "status"}
+	m.requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Total number of HTTP requests.",
+	}, labels)
+	m.duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency in seconds.",
+		Buckets:   m.latencyBucket,
+	}, labels)
+	m.requestSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_size_bytes",
+		Help:      "HTTP request body size in bytes.",
+		Buckets:   defaultSizeBuckets,
+	}, labels)
+	m.responseSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_response_size_bytes",
+		Help:      "HTTP response body size in bytes.",
+		Buckets:   defaultSizeBuckets,
+	}, labels)
+	m.inFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "Number of HTTP requests currently being served.",
+	})
+
+	if err := p.Register(m.requests, m.duration, m.requestSize, m.responseSize, m.inFlight); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Collectors 返回 HTTP 指标
+func (m *HTTPMetrics) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.requests, m.duration, m.requestSize, m.responseSize, m.inFlight}
+}
+
+func (m *HTTPMetrics) HandlerFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := m.skipPaths[c.Request.URL.Path]; ok {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		method := c.Request.Method
+		if _, ok := knownMethods[method]; !ok {
+			method = "other"
+		}
+		labels := prometheus.Labels{
+			"route":  route,
+			"method": method,
+			"status": statusClass(c.Writer.Status()),
+		}
+
+		m.requests.With(labels).Inc()
+		m.duration.With(labels).Observe(time.Since(start).Seconds())
+		if c.Request.ContentLength > 0 {
+			m.requestSize.With(labels).Observe(float64(c.Request.ContentLength))
+		}
+		if size := c.Writer.Size(); size > 0 {
+			m.responseSize.With(labels).Observe(float64(size))
+		}
+	}
+}
+
+// statusClass 将状态码转换为 2xx、4xx 这样的类别
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/prom"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPMetrics(t *testing.T) {
+	p, err := prom.NewPromWithConfig(&prom.Config{Namespace: "rum"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics, err := NewHTTPMetrics(p, WithHTTPMetricsSkipPaths("/metrics"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.Use(metrics.HandlerFunc())
+	r.GET("/metrics", p.Handler())
+	r.GET("/users/:id", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	for _, path := range []string{"/users/1", "/users/2", "/missing"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+
+	for _, expected := range []string{
+		`rum_http_requests_total{method="GET",route="/users/:id",status="2xx"} 2`,
+		`rum_http_requests_total{method="GET",route="unmatched",status="4xx"} 1`,
+		`rum_http_request_duration_seconds_count{method="GET",route="/users/:id",status="2xx"} 2`,
+		`rum_http_requests_in_flight 0`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("metrics output missing %q", expected)
+		}
+	}
+	if strings.Contains(body, `route="/metrics"`) {
+		t.Error("skipped path should not be recorded")
+	}
+}
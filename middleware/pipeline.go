@@ -0,0 +1,271 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/iface"
+	"github.com/yangkushu/rum-go/log"
+	"io"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrPipelineStructureChanged 重新加载时中间件的名称、顺序或者分组发生了变化，需要重启服务才能生效
+var ErrPipelineStructureChanged = errors.New("middleware pipeline structure changed, restart required")
+
+// PipelineConfig 中间件流水线配置，按顺序执行
+//
+//	middleware:
+//	  global:
+//	    - name: recovery
+//	    - name: request_id
+//	    - name: access_log
+//	      options:
+//	        skip_paths: [/healthz]
+//	  groups:
+//	    - path: /api
+//	      middlewares:
+//	        - name: jwt_auth
+//	          options:
+//	            secret: xxx
+type PipelineConfig struct {
+	Global []MiddlewareConfig    `mapstructure:"global" yaml:"global"` // 作用于所有路由的中间件
+	Groups []PipelineGroupConfig `mapstructure:"groups" yaml:"groups"` // 路由分组的中间件，在全局中间件之后执行
+}
+
+// PipelineGroupConfig 路由分组的中间件配置
+type PipelineGroupConfig struct {
+	Path        string             `mapstructure:"path" yaml:"path"` // 分组路径，比如 /api，和 Pipeline.Group 的参数对应
+	Middlewares []MiddlewareConfig `mapstructure:"middlewares" yaml:"middlewares"`
+}
+
+// MiddlewareConfig 单个中间件的配置
+type MiddlewareConfig struct {
+	Name    string                 `mapstructure:"name" yaml:"name"`       // 注册表中的名称
+	Options map[string]interface{} `mapstructure:"options" yaml:"options"` // 中间件自己的配置，字段和中间件的配置结构体一致
+}
+
+// pipelineSlot 流水线中的一个位置，重新加载时只替换这个位置的中间件，gin 中注册的处理函数不变
+type pipelineSlot struct {
+	location   string
+	name       string
+	options    map[string]interface{}
+	middleware iface.IMiddleware
+	handler    atomic.Value // gin.HandlerFunc
+}
+
+func (s *pipelineSlot) handlerFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s.handler.Load().(gin.HandlerFunc)(c)
+	}
+}
+
+// Pipeline 根据配置创建的中间件流水线
+// 启动时校验所有中间件的名称和配置；重新加载时只允许修改 options，修改过的中间件会重新创建并原子替换
+type Pipeline struct {
+	registry *Registry
+	log      iface.ILogger
+
+	mu     sync.Mutex
+	global []*pipelineSlot
+	groups map[string][]*pipelineSlot
+	paths  []string // 分组配置的顺序
+}
+
+// NewPipeline 根据配置创建所有中间件，名称未注册或者配置错误时返回错误
+func NewPipeline(config *PipelineConfig, registry *Registry, logger iface.ILogger) (*Pipeline, error) {
+	p := &Pipeline{
+		registry: registry,
+		log:      logger,
+		groups:   make(map[string][]*pipelineSlot),
+	}
+	global, err := p.buildSlots("global", config.Global, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	p.global = global
+	for _, group := range config.Groups {
+		if _, found := p.groups[group.Path]; found {
+			p.closeSlots(p.allSlots())
+			return nil, fmt.Errorf("middleware group %q is duplicated", group.Path)
+		}
+		slots, err := p.buildSlots("groups["+group.Path+"]", group.Middlewares, nil, nil)
+		if err != nil {
+			p.closeSlots(p.allSlots())
+			return nil, err
+		}
+		p.groups[group.Path] = slots
+		p.paths = append(p.paths, group.Path)
+	}
+	return p, nil
+}
+
+// buildSlots 创建中间件，old 不为空时 options 没有变化的位置复用原来的中间件，新中间件的指标记录到 updates
+func (p *Pipeline) buildSlots(location string, configs []MiddlewareConfig, old []*pipelineSlot, updates *collectorUpdates) ([]*pipelineSlot, error) {
+	slots := make([]*pipelineSlot, 0, len(configs))
+	for i, config := range configs {
+		slot := &pipelineSlot{
+			location: fmt.Sprintf("%s[%d]", location, i),
+			name:     config.Name,
+			options:  config.Options,
+		}
+		if old != nil && reflect.DeepEqual(old[i].options, config.Options) {
+			slot.middleware = old[i].middleware
+		} else {
+			m, err := p.registry.build(config.Name, config.Options, updates)
+			if err != nil {
+				p.closeSlots(newSlots(slots, old))
+				return nil, fmt.Errorf("%s: %w", slot.location, err)
+			}
+			slot.middleware = m
+		}
+		slot.handler.Store(slot.middleware.HandlerFunc())
+		slots = append(slots, slot)
+	}
+	return slots, nil
+}
+
+// Use 把全局中间件注册到 engine 上
+func (p *Pipeline) Use(engine gin.IRoutes) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	engine.Use(handlerFuncs(p.global)...)
+}
+
+// Group 创建路由分组并注册分组的中间件，配置中没有这个分组时返回不带中间件的分组
+func (p *Pipeline) Group(router gin.IRouter, path string) *gin.RouterGroup {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return router.Group(path, handlerFuncs(p.groups[path])...)
+}
+
+// Engine 创建 gin.Engine 并注册全局中间件
+func (p *Pipeline) Engine() *gin.Engine {
+	engine := gin.New()
+	p.Use(engine)
+	return engine
+}
+
+// Reload 使用新的配置重新创建 options 变化的中间件
+// 中间件的名称、顺序或者分组变化时返回 ErrPipelineStructureChanged，任何中间件创建失败时保留原来的流水线
+func (p *Pipeline) Reload(config *PipelineConfig) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.sameStructure(config) {
+		return ErrPipelineStructureChanged
+	}
+
+	// 重新创建的中间件和原来的中间件使用同名的指标，全部创建成功后才切换
+	updates := &collectorUpdates{}
+	global, err := p.buildSlots("global", config.Global, p.global, updates)
+	if err != nil {
+		updates.rollback()
+		return err
+	}
+	groups := make(map[string][]*pipelineSlot, len(config.Groups))
+	for _, group := range config.Groups {
+		slots, err := p.buildSlots("groups["+group.Path+"]", group.Middlewares, p.groups[group.Path], updates)
+		if err != nil {
+			updates.rollback()
+			p.closeSlots(newSlots(global, p.global))
+			for path, built := range groups {
+				p.closeSlots(newSlots(built, p.groups[path]))
+			}
+			return err
+		}
+		groups[group.Path] = slots
+	}
+
+	updates.commit()
+	var replaced []*pipelineSlot
+	swap := func(current, next []*pipelineSlot) {
+		for i, slot := range current {
+			if slot.middleware == next[i].middleware {
+				continue
+			}
+			replaced = append(replaced, &pipelineSlot{location: slot.location, name: slot.name, middleware: slot.middleware})
+			slot.options = next[i].options
+			slot.middleware = next[i].middleware
+			slot.handler.Store(next[i].handler.Load())
+			p.log.Info("middleware reloaded", log.String("location", slot.location), log.String("name", slot.name))
+		}
+	}
+	swap(p.global, global)
+	for path, slots := range groups {
+		swap(p.groups[path], slots)
+	}
+	p.closeSlots(replaced)
+	return nil
+}
+
+func (p *Pipeline) sameStructure(config *PipelineConfig) bool {
+	sameNames := func(slots []*pipelineSlot, configs []MiddlewareConfig) bool {
+		if len(slots) != len(configs) {
+			return false
+		}
+		for i, slot := range slots {
+			if slot.name != configs[i].Name {
+				return false
+			}
+		}
+		return true
+	}
+	if !sameNames(p.global, config.Global) || len(p.paths) != len(config.Groups) {
+		return false
+	}
+	for i, group := range config.Groups {
+		if p.paths[i] != group.Path || !sameNames(p.groups[group.Path], group.Middlewares) {
+			return false
+		}
+	}
+	return true
+}
+
+// Close 关闭需要释放资源的中间件，比如 ip_filter 和 jwt_auth 的后台刷新
+func (p *Pipeline) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closeSlots(p.allSlots())
+}
+
+func (p *Pipeline) allSlots() []*pipelineSlot {
+	slots := append([]*pipelineSlot(nil), p.global...)
+	for _, path := range p.paths {
+		slots = append(slots, p.groups[path]...)
+	}
+	return slots
+}
+
+func (p *Pipeline) closeSlots(slots []*pipelineSlot) error {
+	var errs []error
+	for _, slot := range slots {
+		if closer, ok := slot.middleware.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				p.log.Warn("close middleware failed", log.String("location", slot.location), log.String("name", slot.name), log.ErrorField(err))
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// newSlots 返回 slots 中新创建的中间件，用于创建失败时释放
+func newSlots(slots, old []*pipelineSlot) []*pipelineSlot {
+	var created []*pipelineSlot
+	for i, slot := range slots {
+		if old == nil || slot.middleware != old[i].middleware {
+			created = append(created, slot)
+		}
+	}
+	return created
+}
+
+func handlerFuncs(slots []*pipelineSlot) []gin.HandlerFunc {
+	handlers := make([]gin.HandlerFunc, 0, len(slots))
+	for _, slot := range slots {
+		handlers = append(handlers, slot.handlerFunc())
+	}
+	return handlers
+}
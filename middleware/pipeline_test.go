@@ -0,0 +1,226 @@
+package middleware
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/iface"
+	"github.com/yangkushu/rum-go/log/logtest"
+	"github.com/yangkushu/rum-go/prom"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// orderMiddleware 在响应头 X-Order 中追加自己的名字，关闭时记录
+type orderMiddleware struct {
+	tag    string
+	closed bool
+}
+
+func (m *orderMiddleware) HandlerFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer.Header().Add("X-Order", m.tag)
+		c.Next()
+	}
+}
+
+func (m *orderMiddleware) Close() error {
+	m.closed = true
+	return nil
+}
+
+func newPipelineRegistry(created *[]*orderMiddleware) *Registry {
+	registry := NewRegistry(logtest.New())
+	registry.Register("order", func(o *MiddlewareOptions) (iface.IMiddleware, error) {
+		var config struct {
+			Tag string `mapstructure:"tag"`
+		}
+		if err := o.Decode(&config); err != nil {
+			return nil, err
+		}
+		m := &orderMiddleware{tag: config.Tag}
+		*created = append(*created, m)
+		return m, nil
+	})
+	return registry
+}
+
+func servePipeline(r *gin.Engine, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestPipelineBuildsEngineFromConfig(t *testing.T) {
+	var created []*orderMiddleware
+	config := &PipelineConfig{
+		Global: []MiddlewareConfig{
+			{Name: "request_id", Options: map[string]interface{}{"header": "X-Trace"}},
+			{Name: "order", Options: map[string]interface{}{"tag": "global"}},
+			{Name: "security_headers", Options: map[string]interface{}{"frame_options": "SAMEORIGIN"}},
+		},
+		Groups: []PipelineGroupConfig{
+			{Path: "/api", Middlewares: []MiddlewareConfig{{Name: "order", Options: map[string]interface{}{"tag": "api"}}}},
+		},
+	}
+	p, err := NewPipeline(config, newPipelineRegistry(&created), logtest.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := p.Engine()
+	p.Group(r, "/api").GET("/users", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := servePipeline(r, "/api/users")
+	if got := strings.Join(w.Header().Values("X-Order"), ","); got != "global,api" {
+		t.Fatalf("unexpected order %q", got)
+	}
+	if w.Header().Get("X-Trace") == "" || w.Header().Get("X-Frame-Options") != "SAMEORIGIN" {
+		t.Fatalf("global middlewares not applied: %v", w.Header())
+	}
+	w = servePipeline(r, "/ping")
+	if got := strings.Join(w.Header().Values("X-Order"), ","); got != "global" {
+		t.Fatalf("group middleware applied outside group: %q", got)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !created[0].closed || !created[1].closed {
+		t.Fatal("middlewares not closed")
+	}
+}
+
+func TestPipelineValidatesConfig(t *testing.T) {
+	var created []*orderMiddleware
+	registry := newPipelineRegistry(&created)
+	cases := map[string]*PipelineConfig{
+		"unknown middleware":        {Global: []MiddlewareConfig{{Name: "nope"}}},
+		"has invalid keys":          {Global: []MiddlewareConfig{{Name: "cors", Options: map[string]interface{}{"allowed_origin": "x"}}}},
+		"invalid request id format": {Global: []MiddlewareConfig{{Name: "request_id", Options: map[string]interface{}{"format": "uuidv1"}}}},
+		"requires redis":            {Global: []MiddlewareConfig{{Name: "idempotency"}}},
+		"is duplicated": {Groups: []PipelineGroupConfig{
+			{Path: "/api", Middlewares: []MiddlewareConfig{{Name: "order"}}},
+			{Path: "/api"},
+		}},
+	}
+	for want, config := range cases {
+		_, err := NewPipeline(config, registry, logtest.New())
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected error containing %q, got %v", want, err)
+		}
+	}
+	for _, m := range created {
+		if !m.closed {
+			t.Fatal("middlewares created before a failure should be closed")
+		}
+	}
+}
+
+func TestPipelineReload(t *testing.T) {
+	var created []*orderMiddleware
+	logger := logtest.New()
+	config := &PipelineConfig{
+		Global: []MiddlewareConfig{
+			{Name: "order", Options: map[string]interface{}{"tag": "a"}},
+			{Name: "order", Options: map[string]interface{}{"tag": "b"}},
+		},
+	}
+	p, err := NewPipeline(config, newPipelineRegistry(&created), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := p.Engine()
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	err = p.Reload(&PipelineConfig{
+		Global: []MiddlewareConfig{
+			{Name: "order", Options: map[string]interface{}{"tag": "a"}},
+			{Name: "order", Options: map[string]interface{}{"tag": "c"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(servePipeline(r, "/").Header().Values("X-Order"), ","); got != "a,c" {
+		t.Fatalf("unexpected order after reload %q", got)
+	}
+	if len(created) != 3 || created[0].closed || !created[1].closed {
+		t.Fatal("only the changed middleware should be rebuilt and the old one closed")
+	}
+	logger.AssertField(t, "middleware reloaded", "location", "global[1]")
+
+	// 结构变化和创建失败时保留原来的流水线
+	err = p.Reload(&PipelineConfig{Global: []MiddlewareConfig{{Name: "order"}}})
+	if !errors.Is(err, ErrPipelineStructureChanged) {
+		t.Fatalf("expected ErrPipelineStructureChanged, got %v", err)
+	}
+	err = p.Reload(&PipelineConfig{
+		Global: []MiddlewareConfig{
+			{Name: "order", Options: map[string]interface{}{"tag": "d"}},
+			{Name: "order", Options: map[string]interface{}{"unknown": true}},
+		},
+	})
+	if err == nil {
+		t.Fatal("expected invalid options error")
+	}
+	if !created[3].closed {
+		t.Fatal("middleware created by a failed reload should be closed")
+	}
+	if got := strings.Join(servePipeline(r, "/").Header().Values("X-Order"), ","); got != "a,c" {
+		t.Fatalf("failed reload changed the pipeline: %q", got)
+	}
+}
+
+func TestPipelineReloadMetrics(t *testing.T) {
+	p, err := prom.NewPromWithConfig(&prom.Config{Namespace: "rum"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := NewRegistry(logtest.New(), WithRegistryProm(p))
+	pipelineConfig := func(debug bool, buckets []float64) *PipelineConfig {
+		return &PipelineConfig{
+			Global: []MiddlewareConfig{
+				{Name: "metrics", Options: map[string]interface{}{"latency_buckets": buckets}},
+				{Name: "recovery", Options: map[string]interface{}{"metrics": true, "debug": debug}},
+			},
+		}
+	}
+	pipeline, err := NewPipeline(pipelineConfig(false, []float64{1}), registry, logtest.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := pipeline.Engine()
+	r.GET("/panic", func(c *gin.Context) { panic("boom") })
+
+	if err := pipeline.Reload(pipelineConfig(true, []float64{1, 2})); err != nil {
+		t.Fatalf("reloading metrics middlewares should not register duplicate collectors: %v", err)
+	}
+	servePipeline(r, "/panic")
+
+	// 创建失败时指标仍然使用原来的中间件
+	failed := pipelineConfig(false, []float64{1, 2, 3})
+	failed.Global[1].Options["unknown"] = true
+	if err := pipeline.Reload(failed); err == nil {
+		t.Fatal("expected invalid options error")
+	}
+	servePipeline(r, "/panic")
+
+	families, err := p.Registry().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]bool{}
+	for _, family := range families {
+		switch family.GetName() {
+		case "rum_panics_total":
+			found["panics"] = family.GetMetric()[0].GetCounter().GetValue() == 2
+		case "rum_http_request_duration_seconds":
+			found["buckets"] = len(family.GetMetric()[0].GetHistogram().GetBucket()) == 2
+		}
+	}
+	if !found["panics"] || !found["buckets"] {
+		t.Fatalf("metrics should come from the reloaded middlewares: %v", found)
+	}
+}
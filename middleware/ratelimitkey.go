@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"strings"
+)
+
+// RateLimitKeyFunc 生成限速 key 的函数，相同 key 的请求共享同一个限额
+type RateLimitKeyFunc func(c *gin.Context) string
+
+// KeyByRoute 按 method + 路由模板限速，比如 GET:/users/:id，带路径参数的路由只会生成一个 key
+// 未匹配到路由的请求共用一个 key，避免 404 扫描产生大量限速器
+func KeyByRoute() RateLimitKeyFunc {
+	return func(c *gin.Context) string {
+		return c.Request.Method + ":" + c.FullPath()
+	}
+}
+
+// KeyByPath 按 method + 原始路径限速，与旧版本行为一致。路径参数会导致 key 数量无上限，需配合 MaxEntries 使用
+func KeyByPath() RateLimitKeyFunc {
+	return func(c *gin.Context) string {
+		return c.Request.Method + ":" + c.Request.URL.Path
+	}
+}
+
+// KeyByClientIP 按客户端IP限速
+func KeyByClientIP() RateLimitKeyFunc {
+	return func(c *gin.Context) string {
+		return c.ClientIP()
+	}
+}
+
+// KeyByHeader 按请求头限速，比如 API Key
+func KeyByHeader(header string) RateLimitKeyFunc {
+	return func(c *gin.Context) string {
+		return c.GetHeader(header)
+	}
+}
+
+// KeyByContext 按 gin.Context 中保存的值限速，比如认证中间件写入的用户ID
+func KeyByContext(key string) RateLimitKeyFunc {
+	return func(c *gin.Context) string {
+		if v, ok := c.Get(key); ok {
+			return fmt.Sprint(v)
+		}
+		return ""
+	}
+}
+
+// KeyComposite 组合多个 key，比如每个用户在每个路由上单独限速
+func KeyComposite(keyFuncs ...RateLimitKeyFunc) RateLimitKeyFunc {
+	return func(c *gin.Context) string {
+		parts := make([]string, 0, len(keyFuncs))
+		for _, keyFunc := range keyFuncs {
+			parts = append(parts, keyFunc(c))
+		}
+		return strings.Join(parts, "|")
+	}
+}
+
+// ParseRateLimitKeyFunc 从配置解析 key 生成函数，多个用 + 连接，比如 route+ip
+// 支持 route、path、ip、header:<name>、context:<key>，空字符串返回 KeyByRoute
+func ParseRateLimitKeyFunc(keyBy string) (RateLimitKeyFunc, error) {
+	if keyBy == "" {
+		return KeyByRoute(), nil
+	}
+	var keyFuncs []RateLimitKeyFunc
+	for _, part := range strings.Split(keyBy, "+") {
+		part = strings.TrimSpace(part)
+		name, arg, _ := strings.Cut(part, ":")
+		switch {
+		case name == "route":
+			keyFuncs = append(keyFuncs, KeyByRoute())
+		case name == "path":
+			keyFuncs = append(keyFuncs, KeyByPath())
+		case name == "ip":
+			keyFuncs = append(keyFuncs, KeyByClientIP())
+		case name == "header" && arg != "":
+			keyFuncs = append(keyFuncs, KeyByHeader(arg))
+		case name == "context" && arg != "":
+			keyFuncs = append(keyFuncs, KeyByContext(arg))
+		default:
+			return nil, fmt.Errorf("unknown rate limit key %q", part)
+		}
+	}
+	if len(keyFuncs) == 1 {
+		return keyFuncs[0], nil
+	}
+	return KeyComposite(keyFuncs...), nil
+}
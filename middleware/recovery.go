@@ -1,50 +1,363 @@
 package middleware
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/yangkushu/rum-go/iface"
 	"github.com/yangkushu/rum-go/log"
+	"github.com/yangkushu/rum-go/messagequeue"
 	"net/http"
 	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
+const (
+	defaultRecoveryDedupWindow   = time.Minute
+	defaultRecoveryReportTimeout = 5 * time.Second
+	recoveryMaxFrames            = 32
+	recoveryFingerprintFrames    = 5 // 指纹使用的栈帧数
+)
+
+// PanicFrame 解析后的栈帧
+type PanicFrame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+// PanicReport 一次 panic 的上报内容
+type PanicReport struct {
+	Fingerprint string       `json:"fingerprint"` // panic 类型和栈顶几帧的哈希，用于去重
+	Value       string       `json:"value"`       // panic 的值，可能包含内部信息，只用于上报，不返回给客户端
+	Type        string       `json:"type"`
+	Frames      []PanicFrame `json:"frames"`
+	Method      string       `json:"method"`
+	Path        string       `json:"path"`
+	Route       string       `json:"route"`
+	ClientIP    string       `json:"client_ip"`
+	UserAgent   string       `json:"user_agent"`
+	RequestID   string       `json:"request_id,omitempty"`
+	Time        time.Time    `json:"time"`
+	Suppressed  int          `json:"suppressed"` // 去重窗口内被忽略的相同 panic 次数，窗口结束时随最后一次被忽略的 panic 上报
+}
+
+// PanicReporter 上报 panic，Recovery 在后台调用，同一指纹在去重窗口内只上报一次
+type PanicReporter interface {
+	Report(ctx context.Context, report *PanicReport) error
+}
+
+// PanicReporterFunc 函数形式的 PanicReporter
+type PanicReporterFunc func(ctx context.Context, report *PanicReport) error
+
+func (f PanicReporterFunc) Report(ctx context.Context, report *PanicReport) error {
+	return f(ctx, report)
+}
+
+// NewLogPanicReporter 把 panic 输出到日志，未设置上报时默认使用
+func NewLogPanicReporter(logger iface.ILogger) PanicReporter {
+	return PanicReporterFunc(func(ctx context.Context, report *PanicReport) error {
+		logger.Error("on recovery",
+			log.String("fingerprint", report.Fingerprint),
+			log.String("panic", report.Value),
+			log.String("type", report.Type),
+			log.Any("frames", report.Frames),
+			log.String("method", report.Method),
+			log.String("path", report.Path),
+			log.String("client_ip", report.ClientIP),
+			log.String("request_id", report.RequestID),
+			log.Int("suppressed", report.Suppressed),
+		)
+		return nil
+	})
+}
+
+// NewMessageQueuePanicReporter 把 panic 以 JSON 发布到消息队列的 topic，比如 Kafka
+func NewMessageQueuePanicReporter(mq messagequeue.IMessageQueue, topic messagequeue.Topic) PanicReporter {
+	return PanicReporterFunc(func(ctx context.Context, report *PanicReport) error {
+		data, err := json.Marshal(report)
+		if err != nil {
+			return err
+		}
+		return messagequeue.PublishContext(ctx, mq, topic, data)
+	})
+}
+
+// NewWebhookPanicReporter 把 panic 以 JSON POST 到 webhook，client 为空时使用 http.DefaultClient
+func NewWebhookPanicReporter(url string, client *http.Client) PanicReporter {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return PanicReporterFunc(func(ctx context.Context, report *PanicReport) error {
+		data, err := json.Marshal(report)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= http.StatusMultipleChoices {
+			return fmt.Errorf("panic webhook returned status %d", resp.StatusCode)
+		}
+		return nil
+	})
+}
+
+type recoveryDedupEntry struct {
+	suppressed int
+	last       *PanicReport // 最后一次被忽略的 panic
+}
+
 // Recovery 自定义的 Recovery 中间件
+// panic 时解析调用栈，按指纹去重后在后台调用上报，返回 500。
+// 默认不会把 panic 的值返回给客户端，只有 WithRecoveryDebug(true) 时才返回。
+// http.ErrAbortHandler 会继续向上 panic，客户端断开连接（broken pipe）时只记录日志，不写响应
 type Recovery struct {
-	onError func(c *gin.Context, err interface{})
-	log     iface.ILogger
+	onError       func(c *gin.Context, err interface{})
+	log           iface.ILogger
+	reporters     []PanicReporter
+	debug         bool
+	dedupWindow   time.Duration
+	reportTimeout time.Duration
+
+	mu    sync.Mutex
+	dedup map[string]*recoveryDedupEntry
+
+	panics *prometheus.CounterVec
+}
+
+// OptionRecovery 定义配置函数类型
+type OptionRecovery func(*Recovery)
+
+// WithRecoveryReporters 设置 panic 上报，会替换默认的日志上报
+func WithRecoveryReporters(reporters ...PanicReporter) OptionRecovery {
+	return func(r *Recovery) {
+		r.reporters = reporters
+	}
+}
+
+// WithRecoveryDebug 是否在响应中返回 panic 的值，默认不返回，只应在本地开发时开启
+func WithRecoveryDebug(debug bool) OptionRecovery {
+	return func(r *Recovery) {
+		r.debug = debug
+	}
 }
 
-func NewRecovery(onError func(c *gin.Context, err interface{}), log iface.ILogger) *Recovery {
-	return &Recovery{onError: onError, log: log}
+// WithRecoveryDedupWindow 相同指纹的 panic 在窗口内只上报一次，窗口结束时上报被忽略的次数，默认 1 分钟，小于等于 0 不去重
+func WithRecoveryDedupWindow(window time.Duration) OptionRecovery {
+	return func(r *Recovery) {
+		r.dedupWindow = window
+	}
+}
+
+// WithRecoveryReportTimeout 每次上报的超时时间，默认 5 秒
+func WithRecoveryReportTimeout(timeout time.Duration) OptionRecovery {
+	return func(r *Recovery) {
+		r.reportTimeout = timeout
+	}
+}
+
+// WithRecoveryMetrics 统计 panic 次数，需要将 Collectors() 注册到 prom.Prom
+func WithRecoveryMetrics(namespace string) OptionRecovery {
+	return func(r *Recovery) {
+		r.panics = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "panics_total",
+			Help:      "Total number of recovered panics, partitioned by route.",
+		}, []string{"route"})
+	}
+}
+
+func NewRecovery(onError func(c *gin.Context, err interface{}), log iface.ILogger, opts ...OptionRecovery) *Recovery {
+	r := &Recovery{
+		onError:       onError,
+		log:           log,
+		reporters:     []PanicReporter{NewLogPanicReporter(log)},
+		dedupWindow:   defaultRecoveryDedupWindow,
+		reportTimeout: defaultRecoveryReportTimeout,
+		dedup:         make(map[string]*recoveryDedupEntry),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Collectors 返回 panic 指标，未开启指标时返回空
+func (r *Recovery) Collectors() []prometheus.Collector {
+	if r.panics == nil {
+		return nil
+	}
+	return []prometheus.Collector{r.panics}
 }
 
 func (r *Recovery) HandlerFunc() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		defer func() {
-			if err := recover(); err != nil {
-				if e, ok := err.(error); ok {
-					r.log.Error("on recovery", log.ErrorField(e), log.String("stack", getStack()))
-				} else {
-					r.log.Error("on recovery", log.Any("err", err), log.String("stack", getStack()))
-				}
-				if r.onError != nil {
-					r.onError(c, err)
-				} else {
-					var errMsg string
-					if errStr, ok := err.(string); ok {
-						errMsg = errStr
-					} else {
-						errMsg = "Internal Server Error"
-					}
-					c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": errMsg})
-					return
-				}
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			if isBrokenPipe(err) {
+				r.log.Warn("client connection broken",
+					log.Any("err", err),
+					log.String("path", c.Request.URL.Path),
+					log.String("request_id", GetRequestID(c)),
+				)
+				c.Abort()
+				return
+			}
+
+			report := newPanicReport(c, err, callerFrames(3))
+			if r.panics != nil {
+				r.panics.WithLabelValues(report.Route).Inc()
+			}
+			r.report(report)
+
+			if r.onError != nil {
+				r.onError(c, err)
+				return
+			}
+			message := "Internal Server Error"
+			if r.debug {
+				message = report.Value
+			}
+			body := gin.H{"error": "internal_error", "message": message}
+			if report.RequestID != "" {
+				body["request_id"] = report.RequestID
 			}
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, body)
 		}()
 		c.Next()
 	}
 }
 
+// report 去重后在后台调用上报，重复的 panic 只记录一条简短的日志
+func (r *Recovery) report(report *PanicReport) {
+	if r.dedupWindow > 0 {
+		r.mu.Lock()
+		if entry, found := r.dedup[report.Fingerprint]; found {
+			entry.suppressed++
+			entry.last = report
+			r.mu.Unlock()
+			r.log.Error("on recovery (duplicate)",
+				log.String("fingerprint", report.Fingerprint),
+				log.String("path", report.Path),
+				log.String("request_id", report.RequestID),
+			)
+			return
+		}
+		entry := &recoveryDedupEntry{}
+		r.dedup[report.Fingerprint] = entry
+		r.mu.Unlock()
+		time.AfterFunc(r.dedupWindow, func() { r.expire(report.Fingerprint, entry) })
+	}
+	r.dispatch(report)
+}
+
+// expire 去重窗口结束，删除记录，窗口内有被忽略的 panic 时上报最后一次和忽略的次数
+func (r *Recovery) expire(fingerprint string, entry *recoveryDedupEntry) {
+	r.mu.Lock()
+	delete(r.dedup, fingerprint)
+	suppressed, last := entry.suppressed, entry.last
+	r.mu.Unlock()
+	if suppressed > 0 {
+		last.Suppressed = suppressed
+		r.dispatch(last)
+	}
+}
+
+// dispatch 在后台调用所有上报
+func (r *Recovery) dispatch(report *PanicReport) {
+	for _, reporter := range r.reporters {
+		go func(reporter PanicReporter) {
+			ctx, cancel := context.WithTimeout(context.Background(), r.reportTimeout)
+			defer cancel()
+			if err := reporter.Report(ctx, report); err != nil {
+				r.log.Warn("report panic failed", log.ErrorField(err), log.String("fingerprint", report.Fingerprint))
+			}
+		}(reporter)
+	}
+}
+
+func newPanicReport(c *gin.Context, value interface{}, frames []PanicFrame) *PanicReport {
+	report := &PanicReport{
+		Value:     fmt.Sprint(value),
+		Type:      fmt.Sprintf("%T", value),
+		Frames:    frames,
+		Method:    c.Request.Method,
+		Path:      c.Request.URL.Path,
+		Route:     c.FullPath(),
+		ClientIP:  c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		RequestID: GetRequestID(c),
+		Time:      time.Now(),
+	}
+	if report.Route == "" {
+		report.Route = "unknown"
+	}
+	h := sha256.New()
+	h.Write([]byte(report.Type))
+	for i, frame := range frames {
+		if i == recoveryFingerprintFrames {
+			break
+		}
+		_, _ = fmt.Fprintf(h, "\n%s:%d", frame.Function, frame.Line)
+	}
+	report.Fingerprint = hex.EncodeToString(h.Sum(nil))[:16]
+	return report
+}
+
+// callerFrames 解析调用栈，跳过 recover 所在的函数和 runtime 中处理 panic 的栈帧
+func callerFrames(skip int) []PanicFrame {
+	pcs := make([]uintptr, recoveryMaxFrames+8)
+	n := runtime.Callers(skip, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	result := make([]PanicFrame, 0, n)
+	for {
+		frame, more := frames.Next()
+		if len(result) > 0 || !strings.HasPrefix(frame.Function, "runtime.") {
+			result = append(result, PanicFrame{Function: frame.Function, File: frame.File, Line: frame.Line})
+		}
+		if !more || len(result) == recoveryMaxFrames {
+			break
+		}
+	}
+	return result
+}
+
+// isBrokenPipe 客户端断开连接后写响应产生的 panic
+func isBrokenPipe(value interface{}) bool {
+	err, ok := value.(error)
+	if !ok {
+		return false
+	}
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
+}
+
 // 获取堆栈信息
 func getStack() string {
 	buf := make([]byte, 1<<16)
@@ -0,0 +1,183 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/yangkushu/rum-go/log/logtest"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRecoveryReport(t *testing.T) {
+	logger := logtest.New()
+	reports := make(chan *PanicReport, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var report PanicReport
+		body, _ := io.ReadAll(req.Body)
+		if err := json.Unmarshal(body, &report); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reports <- &report
+	}))
+	defer webhook.Close()
+
+	recovery := NewRecovery(nil, logger,
+		WithRecoveryReporters(NewWebhookPanicReporter(webhook.URL, nil)),
+		WithRecoveryMetrics("test"),
+	)
+	r := gin.New()
+	r.Use(NewRequestID().HandlerFunc(), recovery.HandlerFunc())
+	r.GET("/users/:id", func(c *gin.Context) {
+		panic(fmt.Sprintf("secret dsn for user %s", c.Param("id")))
+	})
+
+	for _, id := range []string{"1", "2", "3"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/"+id, nil))
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("expected 500, got %d", w.Code)
+		}
+		if strings.Contains(w.Body.String(), "secret") || !strings.Contains(w.Body.String(), `"request_id"`) {
+			t.Errorf("unexpected response body %s", w.Body.String())
+		}
+	}
+
+	var report *PanicReport
+	select {
+	case report = <-reports:
+	case <-time.After(time.Second):
+		t.Fatal("expected panic report")
+	}
+	if report.Value != "secret dsn for user 1" || report.Route != "/users/:id" || report.RequestID == "" || report.Fingerprint == "" {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if len(report.Frames) == 0 || !strings.Contains(report.Frames[0].Function, "TestRecoveryReport") {
+		t.Errorf("expected first frame in the panicking handler, got %+v", report.Frames)
+	}
+	// 相同指纹的 panic 在窗口内只上报一次
+	select {
+	case report = <-reports:
+		t.Errorf("unexpected duplicate report %+v", report)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if n := len(logger.ByMessage("on recovery (duplicate)")); n != 2 {
+		t.Errorf("expected 2 duplicate logs, got %d", n)
+	}
+	if v := testutil.ToFloat64(recovery.panics.WithLabelValues("/users/:id")); v != 3 {
+		t.Errorf("expected 3 panics, got %v", v)
+	}
+}
+
+func TestRecoveryHidesPanicValueInGinDebugMode(t *testing.T) {
+	gin.SetMode(gin.DebugMode)
+	defer gin.SetMode(gin.TestMode)
+
+	recovery := NewRecovery(nil, logtest.New(), WithRecoveryReporters())
+	r := gin.New()
+	r.Use(recovery.HandlerFunc())
+	r.GET("/panic", func(c *gin.Context) { panic(fmt.Errorf("query failed: %w", errors.New("password=secret"))) })
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("expected panic value hidden by default, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRecoveryDedupWindow(t *testing.T) {
+	reports := make(chan *PanicReport, 10)
+	recovery := NewRecovery(nil, logtest.New(),
+		WithRecoveryReporters(PanicReporterFunc(func(ctx context.Context, report *PanicReport) error {
+			reports <- report
+			return nil
+		})),
+		WithRecoveryDedupWindow(20*time.Millisecond),
+		WithRecoveryDebug(true),
+	)
+	r := gin.New()
+	r.Use(recovery.HandlerFunc())
+	r.GET("/panic", func(c *gin.Context) { panic("boom") })
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+		return w
+	}
+
+	if w := serve(); !strings.Contains(w.Body.String(), "boom") {
+		t.Errorf("expected panic value in debug mode, got %s", w.Body.String())
+	}
+	serve()
+	serve()
+	if report := <-reports; report.Suppressed != 0 {
+		t.Errorf("expected first report without suppressed panics, got %d", report.Suppressed)
+	}
+	// 窗口结束时即使没有新的 panic 也上报被忽略的次数，并删除记录
+	select {
+	case report := <-reports:
+		if report.Suppressed != 2 {
+			t.Errorf("expected 2 suppressed panics, got %d", report.Suppressed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected suppressed count reported when the dedup window expires")
+	}
+	recovery.mu.Lock()
+	entries := len(recovery.dedup)
+	recovery.mu.Unlock()
+	if entries != 0 {
+		t.Errorf("expected expired dedup entries pruned, got %d", entries)
+	}
+
+	serve()
+	if report := <-reports; report.Suppressed != 0 {
+		t.Errorf("expected new window to start without suppressed panics, got %d", report.Suppressed)
+	}
+}
+
+func TestRecoveryAbortAndBrokenPipe(t *testing.T) {
+	logger := logtest.New()
+	reported := make(chan struct{}, 1)
+	recovery := NewRecovery(nil, logger, WithRecoveryReporters(PanicReporterFunc(func(ctx context.Context, report *PanicReport) error {
+		reported <- struct{}{}
+		return nil
+	})))
+	r := gin.New()
+	r.Use(recovery.HandlerFunc())
+	r.GET("/abort", func(c *gin.Context) { panic(http.ErrAbortHandler) })
+	r.GET("/pipe", func(c *gin.Context) {
+		panic(&net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EPIPE)})
+	})
+
+	func() {
+		defer func() {
+			if err := recover(); err != http.ErrAbortHandler {
+				t.Errorf("expected http.ErrAbortHandler to be re-panicked, got %v", err)
+			}
+		}()
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/abort", nil))
+	}()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/pipe", nil))
+	if w.Body.Len() != 0 {
+		t.Errorf("expected no response body for broken pipe, got %s", w.Body.String())
+	}
+	if len(logger.ByMessage("client connection broken")) != 1 {
+		t.Error("expected broken pipe to be logged")
+	}
+	select {
+	case <-reported:
+		t.Error("broken pipe and abort should not be reported")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
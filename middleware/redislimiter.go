@@ -0,0 +1,288 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	goRedis "github.com/redis/go-redis/v9"
+	"github.com/yangkushu/rum-go/iface"
+	"github.com/yangkushu/rum-go/log"
+	"github.com/yangkushu/rum-go/redis"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	RateLimitAlgorithmSlidingWindow = "sliding_window"
+	RateLimitAlgorithmGCRA          = "gcra"
+
+	defaultRedisRateLimiterPrefix  = "ratelimit"
+	defaultRedisRateLimiterTimeout = 50 * time.Millisecond
+)
+
+// slidingWindowScript 滑动窗口日志算法，使用 zset 记录窗口内的请求
+// 返回 {是否允许, 剩余次数, 重置时间(毫秒)}
+var slidingWindowScript = goRedis.NewScript(`
+local key = KEYS[1]
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+redis.call('ZREMRANGEBYSCORE', key, 0, now - window)
+local count = redis.call('ZCARD', key)
+if count < limit then
+	redis.call('ZADD', key, now, now .. ':' .. ARGV[3])
+	redis.call('PEXPIRE', key, window)
+	return {1, limit - count - 1, window}
+end
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+local reset = window
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+return {0, 0, reset}
+`)
+
+// gcraScript 通用信元速率算法（令牌桶的等价实现），只需保存一个理论到达时间
+// 返回 {是否允许, 剩余次数, 重置时间(毫秒)}
+var gcraScript = goRedis.NewScript(`
+local key = KEYS[1]
+local period = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + tonumber(t[2]) / 1000
+local emission = period / limit
+local tolerance = emission * burst
+local tat = tonumber(redis.call('GET', key))
+if not tat or tat < now then
+	tat = now
+end
+local newTat = tat + emission
+local diff = now - (newTat - tolerance)
+if diff < 0 then
+	return {0, 0, math.ceil(-diff)}
+end
+redis.call('SET', key, tostring(newTat), 'PX', math.ceil(newTat - now))
+return {1, math.floor(diff / emission), math.ceil(newTat - now)}
+`)
+
+// rateLimitResult 一次限速判断的结果
+type rateLimitResult struct {
+	allowed   bool
+	limit     int
+	remaining int
+	reset     time.Duration // 拒绝时为需要等待的时间，允许时为配额完全恢复的时间
+}
+
+// RedisRateLimiter 基于 redis 的分布式限速器，多个副本共享同一份限额
+type RedisRateLimiter struct {
+	client     *redis.Client
+	algorithm  string
+	limit      int           // 每个周期允许的请求数
+	period     time.Duration // 周期
+	burst      int           // gcra 算法允许的突发请求数，默认等于 limit
+	prefix     string
+	timeout    time.Duration // 单次 redis 调用的超时时间
+	failOpen   bool          // redis 不可用且没有本地限速器时是否放行
+	fallback   *LocalRateLimiter
+	keyFunc    RateLimitKeyFunc
+	prohibitFn func(c *gin.Context)
+	log        iface.ILogger
+}
+
+// OptionRedisRateLimiter 定义配置函数类型
+type OptionRedisRateLimiter func(*RedisRateLimiter)
+
+// WithRedisRateLimiterAlgorithm 设置限速算法，sliding_window 或 gcra，默认 sliding_window
+func WithRedisRateLimiterAlgorithm(algorithm string) OptionRedisRateLimiter {
+	return func(r *RedisRateLimiter) {
+		r.algorithm = algorithm
+	}
+}
+
+// WithRedisRateLimiterBurst 设置 gcra 算法允许的突发请求数
+func WithRedisRateLimiterBurst(burst int) OptionRedisRateLimiter {
+	return func(r *RedisRateLimiter) {
+		r.burst = burst
+	}
+}
+
+// WithRedisRateLimiterPrefix 设置 redis key 的前缀
+func WithRedisRateLimiterPrefix(prefix string) OptionRedisRateLimiter {
+	return func(r *RedisRateLimiter) {
+		r.prefix = prefix
+	}
+}
+
+// WithRedisRateLimiterTimeout 设置单次 redis 调用的超时时间
+func WithRedisRateLimiterTimeout(timeout time.Duration) OptionRedisRateLimiter {
+	return func(r *RedisRateLimiter) {
+		r.timeout = timeout
+	}
+}
+
+// WithRedisRateLimiterFailOpen redis 不可用时放行(true)还是拒绝(false)，设置了本地限速器时优先使用本地限速器
+func WithRedisRateLimiterFailOpen(failOpen bool) OptionRedisRateLimiter {
+	return func(r *RedisRateLimiter) {
+		r.failOpen = failOpen
+	}
+}
+
+// WithRedisRateLimiterFallback redis 不可用时降级使用的本地限速器
+func WithRedisRateLimiterFallback(fallback *LocalRateLimiter) OptionRedisRateLimiter {
+	return func(r *RedisRateLimiter) {
+		r.fallback = fallback
+	}
+}
+
+// WithRedisRateLimiterKeyFunc 设置限速 key 的生成函数，默认 KeyByRoute
+func WithRedisRateLimiterKeyFunc(keyFunc RateLimitKeyFunc) OptionRedisRateLimiter {
+	return func(r *RedisRateLimiter) {
+		r.keyFunc = keyFunc
+	}
+}
+
+// WithRedisRateLimiterProhibitFn 设置超过限制时的处理函数，默认返回 429
+func WithRedisRateLimiterProhibitFn(prohibitFn func(c *gin.Context)) OptionRedisRateLimiter {
+	return func(r *RedisRateLimiter) {
+		r.prohibitFn = prohibitFn
+	}
+}
+
+// WithRedisRateLimiterLogger 设置日志，用于记录 redis 错误
+func WithRedisRateLimiterLogger(logger iface.ILogger) OptionRedisRateLimiter {
+	return func(r *RedisRateLimiter) {
+		r.log = logger
+	}
+}
+
+// NewRedisRateLimiter 创建一个分布式限速器，每个 key 在 period 内最多允许 limit 个请求，limit、period 或 burst 不大于 0 时返回错误
+func NewRedisRateLimiter(client *redis.Client, limit int, period time.Duration, options ...OptionRedisRateLimiter) (*RedisRateLimiter, error) {
+	r := &RedisRateLimiter{
+		client:    client,
+		algorithm: RateLimitAlgorithmSlidingWindow,
+		limit:     limit,
+		period:    period,
+		burst:     limit,
+		prefix:    defaultRedisRateLimiterPrefix,
+		timeout:   defaultRedisRateLimiterTimeout,
+		failOpen:  true,
+		keyFunc:   KeyByRoute(),
+	}
+	for _, option := range options {
+		option(r)
+	}
+	if r.limit <= 0 || r.period <= 0 || r.burst <= 0 {
+		return nil, fmt.Errorf("invalid redis rate limit: limit %d, period %s, burst %d", r.limit, r.period, r.burst)
+	}
+	return r, nil
+}
+
+func (r *RedisRateLimiter) HandlerFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := r.keyFunc(c)
+		result, err := r.allow(c.Request.Context(), key)
+		if err != nil {
+			if r.log != nil {
+				r.log.Warn("redis rate limiter unavailable", log.String("key", key), log.ErrorField(err))
+			}
+			r.handleUnavailable(c, key)
+			return
+		}
+
+		setRateLimitHeaders(c, result)
+		if !result.allowed {
+			r.reject(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// Allow 判断 key 是否允许通过，可以在中间件以外使用，比如限制消息消费速度
+func (r *RedisRateLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	result, err := r.allow(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	return result.allowed, nil
+}
+
+func (r *RedisRateLimiter) allow(ctx context.Context, key string) (*rateLimitResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	// 使用 hash tag 保证同一个 key 在集群模式下落在同一个 slot
+	redisKey := fmt.Sprintf("%s:{%s}", r.prefix, key)
+	period := r.period.Milliseconds()
+
+	var values []interface{}
+	var err error
+	switch r.algorithm {
+	case RateLimitAlgorithmGCRA:
+		values, err = gcraScript.Run(ctx, r.client, []string{redisKey}, period, r.limit, r.burst).Slice()
+	default:
+		member := strconv.FormatInt(rand.Int63(), 36)
+		values, err = slidingWindowScript.Run(ctx, r.client, []string{redisKey}, period, r.limit, member).Slice()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != 3 {
+		return nil, fmt.Errorf("unexpected rate limit script result: %v", values)
+	}
+
+	limit := r.limit
+	if r.algorithm == RateLimitAlgorithmGCRA {
+		limit = r.burst
+	}
+	allowed, _ := values[0].(int64)
+	remaining, _ := values[1].(int64)
+	reset, _ := values[2].(int64)
+	return &rateLimitResult{
+		allowed:   allowed == 1,
+		limit:     limit,
+		remaining: int(remaining),
+		reset:     time.Duration(reset) * time.Millisecond,
+	}, nil
+}
+
+// handleUnavailable redis 不可用时的处理：优先降级到本地限速器，否则按 failOpen 放行或拒绝
+func (r *RedisRateLimiter) handleUnavailable(c *gin.Context, key string) {
+	if r.fallback != nil {
+		if !r.fallback.allow(key) {
+			r.reject(c)
+			return
+		}
+		c.Next()
+		return
+	}
+	if r.failOpen {
+		c.Next()
+		return
+	}
+	c.AbortWithStatus(http.StatusServiceUnavailable)
+}
+
+func (r *RedisRateLimiter) reject(c *gin.Context) {
+	if r.prohibitFn != nil {
+		r.prohibitFn(c)
+		return
+	}
+	c.AbortWithStatus(http.StatusTooManyRequests)
+}
+
+// setRateLimitHeaders 设置标准的 RateLimit-* 响应头，拒绝时额外设置 Retry-After
+func setRateLimitHeaders(c *gin.Context, result *rateLimitResult) {
+	reset := int(math.Ceil(result.reset.Seconds()))
+	c.Header("RateLimit-Limit", strconv.Itoa(result.limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(result.remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(reset))
+	if !result.allowed {
+		c.Header("Retry-After", strconv.Itoa(reset))
+	}
+}
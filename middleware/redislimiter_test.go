@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	goRedis "github.com/redis/go-redis/v9"
+	"github.com/yangkushu/rum-go/log/logtest"
+	"github.com/yangkushu/rum-go/redis"
+	"github.com/yangkushu/rum-go/redis/redistest"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unreachableRedis 返回一个连不上的 redis 客户端，用来测试降级逻辑
+func unreachableRedis() *redis.Client {
+	return &redis.Client{UniversalClient: goRedis.NewUniversalClient(&goRedis.UniversalOptions{
+		Addrs:       []string{"127.0.0.1:1"},
+		DialTimeout: 10 * time.Millisecond,
+		MaxRetries:  -1,
+	})}
+}
+
+func newTestRedisRateLimiter(t *testing.T, client *redis.Client, limit int, period time.Duration, options ...OptionRedisRateLimiter) *RedisRateLimiter {
+	t.Helper()
+	limiter, err := NewRedisRateLimiter(client, limit, period, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return limiter
+}
+
+func serveStatus(r *gin.Engine) int {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ping", nil))
+	return w.Code
+}
+
+func TestRedisRateLimiterInvalidConfig(t *testing.T) {
+	client := unreachableRedis()
+	for name, build := range map[string]func() (*RedisRateLimiter, error){
+		"zero limit":  func() (*RedisRateLimiter, error) { return NewRedisRateLimiter(client, 0, time.Second) },
+		"zero period": func() (*RedisRateLimiter, error) { return NewRedisRateLimiter(client, 1, 0) },
+		"zero burst": func() (*RedisRateLimiter, error) {
+			return NewRedisRateLimiter(client, 1, time.Second, WithRedisRateLimiterAlgorithm(RateLimitAlgorithmGCRA), WithRedisRateLimiterBurst(0))
+		},
+	} {
+		if _, err := build(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	registry := NewRegistry(logtest.New(), WithRegistryRedis(client))
+	for _, options := range []map[string]interface{}{{"limit": 0}, {"limit": 1, "burst": -1}} {
+		if _, err := registry.Build("redis_rate_limit", options); err == nil || !strings.Contains(err.Error(), "invalid redis rate limit") {
+			t.Errorf("%v: expected invalid redis rate limit error, got %v", options, err)
+		}
+	}
+}
+
+func TestRedisRateLimiterUnavailable(t *testing.T) {
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+
+	logger := logtest.New()
+	r := gin.New()
+	r.Use(newTestRedisRateLimiter(t, unreachableRedis(), 1, time.Second, WithRedisRateLimiterLogger(logger)).HandlerFunc())
+	r.GET("/ping", ok)
+	if code := serveStatus(r); code != http.StatusOK {
+		t.Errorf("fail open: expected 200, got %d", code)
+	}
+	logger.AssertContainsMessage(t, "redis rate limiter unavailable")
+
+	r = gin.New()
+	r.Use(newTestRedisRateLimiter(t, unreachableRedis(), 1, time.Second, WithRedisRateLimiterFailOpen(false)).HandlerFunc())
+	r.GET("/ping", ok)
+	if code := serveStatus(r); code != http.StatusServiceUnavailable {
+		t.Errorf("fail closed: expected 503, got %d", code)
+	}
+
+	r = gin.New()
+	r.Use(newTestRedisRateLimiter(t, unreachableRedis(), 1, time.Second,
+		WithRedisRateLimiterFallback(NewLocalRateLimiter(1, 1, nil))).HandlerFunc())
+	r.GET("/ping", ok)
+	if code := serveStatus(r); code != http.StatusOK {
+		t.Errorf("fallback: expected first request 200, got %d", code)
+	}
+	if code := serveStatus(r); code != http.StatusTooManyRequests {
+		t.Errorf("fallback: expected second request 429, got %d", code)
+	}
+}
+
+// rateLimitHeaders 发送一个带 X-Api-Key 的请求，返回状态码和限速响应头
+func rateLimitHeaders(r *gin.Engine, apiKey string) []string {
+	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	req.Header.Set("X-Api-Key", apiKey)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	h := w.Header()
+	return []string{http.StatusText(w.Code), h.Get("RateLimit-Limit"), h.Get("RateLimit-Remaining"), h.Get("RateLimit-Reset"), h.Get("Retry-After")}
+}
+
+// newRedisRateLimiterEngine 每个周期 2 个请求、周期 10 秒，返回的 advance 同时推进 TIME 命令返回的时间和 key 的过期时间
+func newRedisRateLimiterEngine(t *testing.T, options ...OptionRedisRateLimiter) (*gin.Engine, *miniredis.Miniredis, func(time.Duration)) {
+	client, mr := redistest.New(t)
+	now := time.Unix(1700000000, 0)
+	mr.SetTime(now)
+	advance := func(d time.Duration) {
+		now = now.Add(d)
+		mr.SetTime(now)
+		mr.FastForward(d)
+	}
+	options = append(options, WithRedisRateLimiterKeyFunc(func(c *gin.Context) string { return c.GetHeader("X-Api-Key") }), WithRedisRateLimiterPrefix("rl"))
+	r := gin.New()
+	r.Use(newTestRedisRateLimiter(t, client, 2, 10*time.Second, options...).HandlerFunc())
+	r.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r, mr, advance
+}
+
+func TestRedisRateLimiterSlidingWindow(t *testing.T) {
+	r, mr, advance := newRedisRateLimiterEngine(t)
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"first request", []string{"OK", "2", "1", "10", ""}},
+		{"last allowed request", []string{"OK", "2", "0", "10", ""}},
+		{"over limit", []string{"Too Many Requests", "2", "0", "10", "10"}},
+	}
+	for _, tt := range tests {
+		if got := rateLimitHeaders(r, "k1"); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+	if got := rateLimitHeaders(r, "k2"); got[0] != "OK" {
+		t.Fatalf("other keys should have their own limit, got %v", got)
+	}
+	if keys := mr.Keys(); !reflect.DeepEqual(keys, []string{"rl:{k1}", "rl:{k2}"}) {
+		t.Fatalf("expected hash tagged keys, got %v", keys)
+	}
+
+	advance(10 * time.Second)
+	if got := rateLimitHeaders(r, "k1"); !reflect.DeepEqual(got, []string{"OK", "2", "1", "10", ""}) {
+		t.Fatalf("window should have expired, got %v", got)
+	}
+}
+
+func TestRedisRateLimiterGCRA(t *testing.T) {
+	r, mr, advance := newRedisRateLimiterEngine(t, WithRedisRateLimiterAlgorithm(RateLimitAlgorithmGCRA))
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"first request", []string{"OK", "2", "1", "5", ""}},
+		{"burst exhausted", []string{"OK", "2", "0", "10", ""}},
+		{"over limit", []string{"Too Many Requests", "2", "0", "5", "5"}},
+	}
+	for _, tt := range tests {
+		if got := rateLimitHeaders(r, "k1"); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+	if keys := mr.Keys(); !reflect.DeepEqual(keys, []string{"rl:{k1}"}) {
+		t.Fatalf("expected hash tagged key, got %v", keys)
+	}
+
+	// 每 5 秒恢复一个请求
+	advance(5 * time.Second)
+	if got := rateLimitHeaders(r, "k1"); !reflect.DeepEqual(got, []string{"OK", "2", "0", "10", ""}) {
+		t.Fatalf("one request should be allowed after an emission interval, got %v", got)
+	}
+	if got := rateLimitHeaders(r, "k1"); got[0] != "Too Many Requests" {
+		t.Fatalf("expected 429, got %v", got)
+	}
+
+	advance(10 * time.Second)
+	if got := rateLimitHeaders(r, "k1"); !reflect.DeepEqual(got, []string{"OK", "2", "1", "5", ""}) {
+		t.Fatalf("full burst should be restored, got %v", got)
+	}
+}
@@ -0,0 +1,721 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mitchellh/mapstructure"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yangkushu/rum-go/flags"
+	"github.com/yangkushu/rum-go/iface"
+	"github.com/yangkushu/rum-go/prom"
+	"github.com/yangkushu/rum-go/proxy"
+	"github.com/yangkushu/rum-go/redis"
+	"github.com/yangkushu/rum-go/sessions"
+	"github.com/yangkushu/rum-go/tracing"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MiddlewareFactory 根据配置创建中间件
+type MiddlewareFactory func(options *MiddlewareOptions) (iface.IMiddleware, error)
+
+// MiddlewareOptions 创建中间件时的参数，包含配置中的 options 和注册表提供的依赖
+type MiddlewareOptions struct {
+	Name   string                 // 中间件名称
+	Raw    map[string]interface{} // 配置中的 options
+	Logger iface.ILogger
+	Redis  *redis.Client // 未设置时为空，依赖 redis 的中间件会返回错误
+	Prom   *prom.Prom    // 未设置时为空，metrics 中间件和开启了 metrics 的中间件会返回错误
+
+	registry *Registry
+	updates  *collectorUpdates // 流水线重新加载时不为空
+}
+
+// Decode 把 options 解码到 out，out 通常是中间件的配置结构体指针，未知的字段会返回错误
+func (o *MiddlewareOptions) Decode(out interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           out,
+		ErrorUnused:      true,
+		WeaklyTypedInput: true,
+		TagName:          "mapstructure",
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(o.Raw)
+}
+
+// RegisterCollectors 把中间件的指标注册到 Prom。
+// 流水线重新加载时同名的指标不会重复注册，重新加载成功后切换到新创建的中间件的指标
+func (o *MiddlewareOptions) RegisterCollectors(collectors ...prometheus.Collector) error {
+	if o.Prom == nil {
+		return errRegistryPromRequired
+	}
+	r := o.registry
+	r.collectorsMu.Lock()
+	defer r.collectorsMu.Unlock()
+	for _, collector := range collectors {
+		key := collectorKey(collector)
+		if shared, found := r.collectors[key]; found {
+			if o.updates == nil {
+				return fmt.Errorf("metrics collector %s already registered by another middleware", key)
+			}
+			next := collector
+			o.updates.commits = append(o.updates.commits, func() { shared.set(next) })
+			continue
+		}
+		shared := &sharedCollector{current: collector}
+		if err := o.Prom.Register(shared); err != nil {
+			return err
+		}
+		r.collectors[key] = shared
+		if o.updates != nil {
+			o.updates.rollbacks = append(o.updates.rollbacks, func() { r.unregisterCollector(key, shared) })
+		}
+	}
+	return nil
+}
+
+// Registry 按名称注册的中间件工厂，NewRegistry 会注册所有内置中间件。
+// 配置只能表达字符串、数字这类参数，自定义的 PanicReporter、CSRFTokenStore 等需要用 Register 覆盖同名的工厂。
+// sessions、feature_flags、proxy 根据配置创建的实例只在流水线内部使用，
+// handler 需要调用实例的方法时（比如 sessions.Manager.Login、flags 的管理接口），在代码中创建实例并 Register 一个返回它的工厂
+type Registry struct {
+	logger iface.ILogger
+	redis  *redis.Client
+	prom   *prom.Prom
+
+	mu        sync.RWMutex
+	factories map[string]MiddlewareFactory
+
+	collectorsMu sync.Mutex
+	collectors   map[string]*sharedCollector // 指标描述 -> 注册到 prom 的指标
+}
+
+// OptionRegistry 定义配置函数类型
+type OptionRegistry func(*Registry)
+
+// WithRegistryRedis 设置依赖 redis 的中间件使用的客户端，比如 idempotency、redis_rate_limit
+func WithRegistryRedis(client *redis.Client) OptionRegistry {
+	return func(r *Registry) {
+		r.redis = client
+	}
+}
+
+// WithRegistryProm 设置 metrics 中间件使用的 prom.Prom
+func WithRegistryProm(p *prom.Prom) OptionRegistry {
+	return func(r *Registry) {
+		r.prom = p
+	}
+}
+
+// NewRegistry 创建注册表并注册内置中间件
+func NewRegistry(logger iface.ILogger, opts ...OptionRegistry) *Registry {
+	r := &Registry{
+		logger:     logger,
+		factories:  make(map[string]MiddlewareFactory),
+		collectors: make(map[string]*sharedCollector),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	for name, factory := range builtinMiddlewares {
+		r.factories[name] = factory
+	}
+	return r
+}
+
+// Register 注册自定义中间件，名称已存在时覆盖
+func (r *Registry) Register(name string, factory MiddlewareFactory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.factories[name] = factory
+}
+
+// Names 返回所有已注册的中间件名称
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Build 根据名称和 options 创建中间件
+func (r *Registry) Build(name string, raw map[string]interface{}) (iface.IMiddleware, error) {
+	return r.build(name, raw, nil)
+}
+
+// build 创建中间件，updates 不为空时记录重新加载需要切换的指标
+func (r *Registry) build(name string, raw map[string]interface{}, updates *collectorUpdates) (iface.IMiddleware, error) {
+	r.mu.RLock()
+	factory, found := r.factories[name]
+	r.mu.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("unknown middleware %q", name)
+	}
+	m, err := factory(&MiddlewareOptions{
+		Name:   name,
+		Raw:    raw,
+		Logger: r.logger,
+		Redis:  r.redis,
+		Prom:   r.prom,
+
+		registry: r,
+		updates:  updates,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("middleware %q: %w", name, err)
+	}
+	return m, nil
+}
+
+func (r *Registry) unregisterCollector(key string, shared *sharedCollector) {
+	r.collectorsMu.Lock()
+	defer r.collectorsMu.Unlock()
+	if r.collectors[key] == shared {
+		r.prom.Registry().Unregister(shared)
+		delete(r.collectors, key)
+	}
+}
+
+// collectorUpdates 重新加载时新创建的中间件的指标，全部中间件创建成功后 commit，否则 rollback
+type collectorUpdates struct {
+	commits   []func()
+	rollbacks []func()
+}
+
+func (u *collectorUpdates) commit() {
+	for _, commit := range u.commits {
+		commit()
+	}
+}
+
+func (u *collectorUpdates) rollback() {
+	for _, rollback := range u.rollbacks {
+		rollback()
+	}
+}
+
+// sharedCollector 注册到 prom 的指标，转发给当前中间件实例的指标，重新加载时切换实例而不是重新注册
+type sharedCollector struct {
+	mu      sync.RWMutex
+	current prometheus.Collector
+}
+
+func (s *sharedCollector) set(collector prometheus.Collector) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current = collector
+}
+
+func (s *sharedCollector) Describe(ch chan<- *prometheus.Desc) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.current.Describe(ch)
+}
+
+func (s *sharedCollector) Collect(ch chan<- prometheus.Metric) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.current.Collect(ch)
+}
+
+// collectorKey 指标的描述，名称和标签相同的指标视为同一个指标
+func collectorKey(collector prometheus.Collector) string {
+	ch := make(chan *prometheus.Desc, 8)
+	go func() {
+		collector.Describe(ch)
+		close(ch)
+	}()
+	var descs []string
+	for desc := range ch {
+		descs = append(descs, desc.String())
+	}
+	return strings.Join(descs, ",")
+}
+
+var (
+	errRegistryRedisRequired = errors.New("requires redis client, see WithRegistryRedis")
+	errRegistryPromRequired  = errors.New("requires prom, see WithRegistryProm")
+)
+
+// builtinMiddlewares 内置中间件，key 为配置中使用的名称
+var builtinMiddlewares = map[string]MiddlewareFactory{
+	"request_id":        newRequestIDFromOptions,
+	"access_log":        newAccessLogFromOptions,
+	"recovery":          newRecoveryFromOptions,
+	"error_handler":     newErrorHandlerFromOptions,
+	"cors":              newCorsFromOptions,
+	"compression":       newCompressionFromOptions,
+	"concurrency_limit": newConcurrencyLimiterFromOptions,
+	"csrf":              newCSRFFromOptions,
+	"idempotency":       newIdempotencyFromOptions,
+	"ip_filter":         newIPFilterFromOptions,
+	"jwt_auth":          newJWTAuthFromOptions,
+	"local_rate_limit":  newLocalRateLimiterFromOptions,
+	"redis_rate_limit":  newRedisRateLimiterFromOptions,
+	"metrics":           newHTTPMetricsFromOptions,
+	"response_cache":    newResponseCacheFromOptions,
+	"security_headers":  newSecurityHeadersFromOptions,
+	"signature":         newSignatureVerifierFromOptions,
+	"timeout":           newTimeoutFromOptions,
+	"tracing":           newTracingFromOptions,
+	"sessions":          newSessionsFromOptions,
+	"feature_flags":     newFeatureFlagsFromOptions,
+	"proxy":             newProxyFromOptions,
+}
+
+func newRequestIDFromOptions(o *MiddlewareOptions) (iface.IMiddleware, error) {
+	var config struct {
+		Header        string `mapstructure:"header"`         // 默认 X-Request-Id
+		Format        string `mapstructure:"format"`         // uuidv7 或 ulid，默认 uuidv7
+		TrustIncoming *bool  `mapstructure:"trust_incoming"` // 默认 true
+	}
+	if err := o.Decode(&config); err != nil {
+		return nil, err
+	}
+	var opts []OptionRequestID
+	if config.Header != "" {
+		opts = append(opts, WithRequestIDHeader(config.Header))
+	}
+	switch config.Format {
+	case "":
+	case RequestIDFormatUUIDv7, RequestIDFormatULID:
+		opts = append(opts, WithRequestIDFormat(config.Format))
+	default:
+		return nil, fmt.Errorf("invalid request id format %q", config.Format)
+	}
+	if config.TrustIncoming != nil {
+		opts = append(opts, WithRequestIDTrustIncoming(*config.TrustIncoming))
+	}
+	return NewRequestID(opts...), nil
+}
+
+func newAccessLogFromOptions(o *MiddlewareOptions) (iface.IMiddleware, error) {
+	var config struct {
+		SkipPaths        []string `mapstructure:"skip_paths"`
+		SampleRate       *float64 `mapstructure:"sample_rate"` // 默认 1
+		SlowThresholdMs  int      `mapstructure:"slow_threshold_ms"`
+		LevelByStatus    *bool    `mapstructure:"level_by_status"` // 默认 true
+		UserIDKey        string   `mapstructure:"user_id_key"`
+		RequestBody      bool     `mapstructure:"request_body"`
+		ResponseBody     bool     `mapstructure:"response_body"`
+		BodyLimit        int      `mapstructure:"body_limit"`
+		BodyContentTypes []string `mapstructure:"body_content_types"`
+	}
+	if err := o.Decode(&config); err != nil {
+		return nil, err
+	}
+	opts := []OptionAccessLog{
+		WithAccessLogSkipPaths(config.SkipPaths...),
+		WithAccessLogRequestBody(config.RequestBody),
+		WithAccessLogResponseBody(config.ResponseBody),
+	}
+	if config.SampleRate != nil {
+		if *config.SampleRate < 0 || *config.SampleRate > 1 {
+			return nil, fmt.Errorf("invalid access log sample rate %v", *config.SampleRate)
+		}
+		opts = append(opts, WithAccessLogSampleRate(*config.SampleRate))
+	}
+	if config.SlowThresholdMs > 0 {
+		opts = append(opts, WithAccessLogSlowThreshold(time.Duration(config.SlowThresholdMs)*time.Millisecond))
+	}
+	if config.LevelByStatus != nil {
+		opts = append(opts, WithAccessLogLevelByStatus(*config.LevelByStatus))
+	}
+	if config.UserIDKey != "" {
+		opts = append(opts, WithAccessLogUserIDKey(config.UserIDKey))
+	}
+	if config.BodyLimit > 0 {
+		opts = append(opts, WithAccessLogBodyLimit(config.BodyLimit))
+	}
+	if len(config.BodyContentTypes) > 0 {
+		opts = append(opts, WithAccessLogBodyContentTypes(config.BodyContentTypes...))
+	}
+	return NewAccessLog(o.Logger, opts...), nil
+}
+
+func newRecoveryFromOptions(o *MiddlewareOptions) (iface.IMiddleware, error) {
+	var config struct {
+		Debug           *bool  `mapstructure:"debug"` // 默认 false
+		DedupWindowMs   int    `mapstructure:"dedup_window_ms"`
+		ReportTimeoutMs int    `mapstructure:"report_timeout_ms"`
+		WebhookURL      string `mapstructure:"webhook_url"` // 除了日志之外把 panic POST 到这个地址
+		Metrics         bool   `mapstructure:"metrics"`     // 统计 panic 次数，需要 WithRegistryProm
+	}
+	if err := o.Decode(&config); err != nil {
+		return nil, err
+	}
+	var opts []OptionRecovery
+	if config.Debug != nil {
+		opts = append(opts, WithRecoveryDebug(*config.Debug))
+	}
+	if config.DedupWindowMs > 0 {
+		opts = append(opts, WithRecoveryDedupWindow(time.Duration(config.DedupWindowMs)*time.Millisecond))
+	}
+	if config.ReportTimeoutMs > 0 {
+		opts = append(opts, WithRecoveryReportTimeout(time.Duration(config.ReportTimeoutMs)*time.Millisecond))
+	}
+	if config.WebhookURL != "" {
+		opts = append(opts, WithRecoveryReporters(NewLogPanicReporter(o.Logger), NewWebhookPanicReporter(config.WebhookURL, nil)))
+	}
+	if config.Metrics {
+		if o.Prom == nil {
+			return nil, errRegistryPromRequired
+		}
+		opts = append(opts, WithRecoveryMetrics(o.Prom.Namespace()))
+	}
+	r := NewRecovery(nil, o.Logger, opts...)
+	if config.Metrics {
+		if err := o.RegisterCollectors(r.Collectors()...); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func newErrorHandlerFromOptions(o *MiddlewareOptions) (iface.IMiddleware, error) {
+	if err := o.Decode(&struct{}{}); err != nil {
+		return nil, err
+	}
+	return NewErrorHandler(o.Logger), nil
+}
+
+func newCorsFromOptions(o *MiddlewareOptions) (iface.IMiddleware, error) {
+	var config CorsConfig
+	if err := o.Decode(&config); err != nil {
+		return nil, err
+	}
+	return NewCorsWithConfig(&config, o.Logger)
+}
+
+func newCompressionFromOptions(o *MiddlewareOptions) (iface.IMiddleware, error) {
+	var config CompressionConfig
+	if err := o.Decode(&config); err != nil {
+		return nil, err
+	}
+	return NewCompression(&config), nil
+}
+
+func newConcurrencyLimiterFromOptions(o *MiddlewareOptions) (iface.IMiddleware, error) {
+	var config struct {
+		ConcurrencyLimiterConfig `mapstructure:",squash"`
+		Metrics                  bool `mapstructure:"metrics"` // 导出并发上限和拒绝次数，需要 WithRegistryProm
+	}
+	if err := o.Decode(&config); err != nil {
+		return nil, err
+	}
+	var opts []OptionConcurrencyLimiter
+	if config.Metrics {
+		if o.Prom == nil {
+			return nil, errRegistryPromRequired
+		}
+		opts = append(opts, WithConcurrencyLimiterMetrics(o.Prom.Namespace()))
+	}
+	l, err := NewConcurrencyLimiter(&config.ConcurrencyLimiterConfig, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if config.Metrics {
+		if err := o.RegisterCollectors(l.Collectors()...); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func newCSRFFromOptions(o *MiddlewareOptions) (iface.IMiddleware, error) {
+	var config struct {
+		CSRFConfig  `mapstructure:",squash"`
+		Cors        *CorsConfig `mapstructure:"cors"`         // 跨域请求的 Origin 被允许时视为可信，通常和 cors 中间件的配置相同
+		TokenKeyBy  string      `mapstructure:"token_key_by"` // synchronizer 模式把 token 保存在 redis 中，按这个 key 区分会话，比如 context:user_id
+		TokenPrefix string      `mapstructure:"token_prefix"` // redis 中 token 的 key 前缀，默认 csrf:
+	}
+	if err := o.Decode(&config); err != nil {
+		return nil, err
+	}
+	var opts []OptionCSRF
+	if config.Cors != nil {
+		cors, err := NewCorsWithConfig(config.Cors, o.Logger)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithCSRFCors(cors))
+	}
+	if config.TokenKeyBy != "" {
+		if o.Redis == nil {
+			return nil, errRegistryRedisRequired
+		}
+		keyFunc, err := ParseRateLimitKeyFunc(config.TokenKeyBy)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithCSRFTokenStore(NewRedisCSRFTokenStore(o.Redis, config.TokenPrefix, keyFunc)))
+	}
+	return NewCSRF(&config.CSRFConfig, o.Logger, opts...)
+}
+
+func newIdempotencyFromOptions(o *MiddlewareOptions) (iface.IMiddleware, error) {
+	var config IdempotencyConfig
+	if err := o.Decode(&config); err != nil {
+		return nil, err
+	}
+	if o.Redis == nil {
+		return nil, errRegistryRedisRequired
+	}
+	return NewIdempotency(&config, o.Redis, o.Logger)
+}
+
+func newIPFilterFromOptions(o *MiddlewareOptions) (iface.IMiddleware, error) {
+	var config IPFilterConfig
+	if err := o.Decode(&config); err != nil {
+		return nil, err
+	}
+	var opts []OptionIPFilter
+	if o.Redis != nil {
+		opts = append(opts, WithIPFilterRedis(o.Redis))
+	}
+	return NewIPFilter(&config, o.Logger, opts...)
+}
+
+func newJWTAuthFromOptions(o *MiddlewareOptions) (iface.IMiddleware, error) {
+	var config JWTAuthConfig
+	if err := o.Decode(&config); err != nil {
+		return nil, err
+	}
+	var opts []OptionJWTAuth
+	if o.Redis != nil {
+		opts = append(opts, WithJWTAuthRedis(o.Redis))
+	}
+	return NewJWTAuth(&config, o.Logger, opts...)
+}
+
+func newLocalRateLimiterFromOptions(o *MiddlewareOptions) (iface.IMiddleware, error) {
+	var config LocalRateLimiterConfig
+	if err := o.Decode(&config); err != nil {
+		return nil, err
+	}
+	if config.Limit <= 0 {
+		return nil, errors.New("local rate limit requires positive limit")
+	}
+	return NewLocalRateLimiterWithConfig(&config, nil)
+}
+
+func newRedisRateLimiterFromOptions(o *MiddlewareOptions) (iface.IMiddleware, error) {
+	var config struct {
+		Limit        int    `mapstructure:"limit"`         // period 内允许的请求数
+		PeriodSecond int    `mapstructure:"period_second"` // 默认 1 秒
+		Algorithm    string `mapstructure:"algorithm"`     // 默认滑动窗口
+		Burst        int    `mapstructure:"burst"`         // 默认等于 limit
+		Prefix       string `mapstructure:"prefix"`
+		TimeoutMs    int    `mapstructure:"timeout_ms"`
+		FailOpen     *bool  `mapstructure:"fail_open"` // redis 不可用时是否放行，默认 true
+		KeyBy        string `mapstructure:"key_by"`
+	}
+	if err := o.Decode(&config); err != nil {
+		return nil, err
+	}
+	if o.Redis == nil {
+		return nil, errRegistryRedisRequired
+	}
+	keyFunc, err := ParseRateLimitKeyFunc(config.KeyBy)
+	if err != nil {
+		return nil, err
+	}
+	period := time.Second
+	if config.PeriodSecond > 0 {
+		period = time.Duration(config.PeriodSecond) * time.Second
+	}
+	opts := []OptionRedisRateLimiter{
+		WithRedisRateLimiterKeyFunc(keyFunc),
+		WithRedisRateLimiterLogger(o.Logger),
+	}
+	if config.Algorithm != "" {
+		opts = append(opts, WithRedisRateLimiterAlgorithm(config.Algorithm))
+	}
+	if config.Burst != 0 {
+		opts = append(opts, WithRedisRateLimiterBurst(config.Burst))
+	}
+	if config.Prefix != "" {
+		opts = append(opts, WithRedisRateLimiterPrefix(config.Prefix))
+	}
+	if config.TimeoutMs > 0 {
+		opts = append(opts, WithRedisRateLimiterTimeout(time.Duration(config.TimeoutMs)*time.Millisecond))
+	}
+	if config.FailOpen != nil {
+		opts = append(opts, WithRedisRateLimiterFailOpen(*config.FailOpen))
+	}
+	return NewRedisRateLimiter(o.Redis, config.Limit, period, opts...)
+}
+
+func newHTTPMetricsFromOptions(o *MiddlewareOptions) (iface.IMiddleware, error) {
+	var config struct {
+		SkipPaths      []string  `mapstructure:"skip_paths"`
+		LatencyBuckets []float64 `mapstructure:"latency_buckets"` // 单位秒
+	}
+	if err := o.Decode(&config); err != nil {
+		return nil, err
+	}
+	if o.Prom == nil {
+		return nil, errRegistryPromRequired
+	}
+	opts := []OptionHTTPMetrics{WithHTTPMetricsSkipPaths(config.SkipPaths...)}
+	if len(config.LatencyBuckets) > 0 {
+		opts = append(opts, WithHTTPMetricsLatencyBuckets(config.LatencyBuckets))
+	}
+	// NewHTTPMetrics 会把指标注册到传入的 prom，这里先注册到临时的 prom，再通过 RegisterCollectors 注册，重新加载时才能切换
+	scratch, err := prom.NewPromWithConfig(&prom.Config{Namespace: o.Prom.Namespace()}, nil)
+	if err != nil {
+		return nil, err
+	}
+	m, err := NewHTTPMetrics(scratch, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.RegisterCollectors(m.Collectors()...); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func newResponseCacheFromOptions(o *MiddlewareOptions) (iface.IMiddleware, error) {
+	var config ResponseCacheConfig
+	if err := o.Decode(&config); err != nil {
+		return nil, err
+	}
+	if o.Redis == nil {
+		return nil, errRegistryRedisRequired
+	}
+	return NewResponseCache(&config, o.Redis, o.Logger)
+}
+
+func newSecurityHeadersFromOptions(o *MiddlewareOptions) (iface.IMiddleware, error) {
+	var config struct {
+		SecurityHeadersConfig `mapstructure:",squash"`
+		SkipPaths             []string `mapstructure:"skip_paths"`
+		TrustForwardedProto   bool     `mapstructure:"trust_forwarded_proto"` // 服务在 TLS 终止的代理后面时开启，否则不会返回 HSTS
+	}
+	if err := o.Decode(&config); err != nil {
+		return nil, err
+	}
+	opts := []OptionSecurityHeaders{WithSecurityHeadersSkipPaths(config.SkipPaths...)}
+	if config.TrustForwardedProto {
+		opts = append(opts, WithSecurityHeadersTrustForwardedProto())
+	}
+	return NewSecurityHeaders(&config.SecurityHeadersConfig, opts...), nil
+}
+
+func newSignatureVerifierFromOptions(o *MiddlewareOptions) (iface.IMiddleware, error) {
+	var config SignatureConfig
+	if err := o.Decode(&config); err != nil {
+		return nil, err
+	}
+	if o.Redis == nil {
+		return nil, errRegistryRedisRequired
+	}
+	return NewSignatureVerifier(&config, o.Redis, o.Logger)
+}
+
+func newTimeoutFromOptions(o *MiddlewareOptions) (iface.IMiddleware, error) {
+	var config TimeoutConfig
+	if err := o.Decode(&config); err != nil {
+		return nil, err
+	}
+	return NewTimeout(&config, o.Logger), nil
+}
+
+func newTracingFromOptions(o *MiddlewareOptions) (iface.IMiddleware, error) {
+	if err := o.Decode(&struct{}{}); err != nil {
+		return nil, err
+	}
+	return tracing.NewMiddleware(), nil
+}
+
+func newSessionsFromOptions(o *MiddlewareOptions) (iface.IMiddleware, error) {
+	var config struct {
+		sessions.Config `mapstructure:",squash"`
+		Store           string `mapstructure:"store"`        // redis 或 memory，默认 redis，memory 只用于单实例和测试
+		RedisPrefix     string `mapstructure:"redis_prefix"` // 默认 session:
+	}
+	if err := o.Decode(&config); err != nil {
+		return nil, err
+	}
+	var store sessions.Store
+	switch config.Store {
+	case "", "redis":
+		if o.Redis == nil {
+			return nil, errRegistryRedisRequired
+		}
+		store = sessions.NewRedisStore(o.Redis, config.RedisPrefix)
+	case "memory":
+		store = sessions.NewMemoryStore()
+	default:
+		return nil, fmt.Errorf("invalid session store %q", config.Store)
+	}
+	return sessions.NewManager(&config.Config, store, o.Logger)
+}
+
+func newFeatureFlagsFromOptions(o *MiddlewareOptions) (iface.IMiddleware, error) {
+	var config struct {
+		flags.Config `mapstructure:",squash"`
+		Metrics      bool `mapstructure:"metrics"` // 统计开关的判断次数，需要 WithRegistryProm
+	}
+	if err := o.Decode(&config); err != nil {
+		return nil, err
+	}
+	var opts []flags.Option
+	if o.Redis != nil {
+		opts = append(opts, flags.WithStore(flags.NewRedisStore(o.Redis, config.RedisPrefix)))
+	}
+	if config.Metrics {
+		if o.Prom == nil {
+			return nil, errRegistryPromRequired
+		}
+		opts = append(opts, flags.WithMetrics(o.Prom.Namespace()))
+	}
+	m, err := flags.NewManager(&config.Config, o.Logger, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if config.Metrics {
+		if err := o.RegisterCollectors(m.Collectors()...); err != nil {
+			_ = m.Close()
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func newProxyFromOptions(o *MiddlewareOptions) (iface.IMiddleware, error) {
+	var config struct {
+		proxy.Config `mapstructure:",squash"`
+		Metrics      bool `mapstructure:"metrics"` // 统计每个上游的请求，需要 WithRegistryProm
+	}
+	if err := o.Decode(&config); err != nil {
+		return nil, err
+	}
+	var opts []proxy.Option
+	if config.Metrics {
+		if o.Prom == nil {
+			return nil, errRegistryPromRequired
+		}
+		opts = append(opts, proxy.WithMetrics(o.Prom.Namespace()))
+	}
+	p, err := proxy.NewProxy(&config.Config, o.Logger, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if config.Metrics {
+		if err := o.RegisterCollectors(p.Collectors()...); err != nil {
+			_ = p.Close()
+			return nil, err
+		}
+	}
+	return p, nil
+}
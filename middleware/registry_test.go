@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/iface"
+	"github.com/yangkushu/rum-go/log/logtest"
+	"github.com/yangkushu/rum-go/prom"
+	"github.com/yangkushu/rum-go/redis/redistest"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryBuiltins(t *testing.T) {
+	names := strings.Join(NewRegistry(logtest.New()).Names(), ",")
+	for _, name := range []string{"tracing", "sessions", "feature_flags", "proxy"} {
+		if !strings.Contains(names, name) {
+			t.Fatalf("%s not registered: %s", name, names)
+		}
+	}
+
+	registry := NewRegistry(logtest.New())
+	cases := map[string]map[string]interface{}{
+		"tracing":       nil,
+		"sessions":      {"secret": "s", "store": "memory"},
+		"feature_flags": {"flags": []map[string]interface{}{{"name": "beta", "enabled": true}}},
+		"proxy":         {"upstreams": []map[string]interface{}{{"url": "http://127.0.0.1:1"}}},
+	}
+	for name, options := range cases {
+		m, err := registry.Build(name, options)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if closer, ok := m.(io.Closer); ok {
+			_ = closer.Close()
+		}
+	}
+	if _, err := registry.Build("sessions", map[string]interface{}{"secret": "s"}); err == nil || !strings.Contains(err.Error(), "requires redis") {
+		t.Fatalf("expected redis required error, got %v", err)
+	}
+}
+
+func TestRegistrySecurityHeadersOptions(t *testing.T) {
+	m, err := NewRegistry(logtest.New()).Build("security_headers", map[string]interface{}{
+		"skip_paths":            []string{"/healthz"},
+		"trust_forwarded_proto": true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.Use(m.HandlerFunc())
+	r.GET("/*path", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	r.ServeHTTP(w, req)
+	if w.Header().Get("Strict-Transport-Security") == "" {
+		t.Fatalf("HSTS should be sent behind a TLS proxy: %v", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Header().Get("X-Frame-Options") != "" {
+		t.Fatalf("skip path should not get security headers: %v", w.Header())
+	}
+}
+
+func TestRegistryCSRFOptions(t *testing.T) {
+	synchronizer := map[string]interface{}{"mode": CSRFModeSynchronizer, "token_key_by": "context:user_id"}
+	if _, err := NewRegistry(logtest.New()).Build("csrf", synchronizer); err == nil || !strings.Contains(err.Error(), "requires redis") {
+		t.Fatalf("expected redis required error, got %v", err)
+	}
+
+	client, _ := redistest.New(t)
+	registry := NewRegistry(logtest.New(), WithRegistryRedis(client))
+	m, err := registry.Build("csrf", map[string]interface{}{
+		"mode":         CSRFModeSynchronizer,
+		"token_key_by": "context:user_id",
+		"cors":         map[string]interface{}{"allowed_origins": []string{"https://app.example.com"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", "u1") }, m.HandlerFunc())
+	r.GET("/form", func(c *gin.Context) { c.String(http.StatusOK, GetCSRFToken(c)) })
+	r.POST("/submit", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/form", nil))
+	token := w.Body.String()
+	if token == "" {
+		t.Fatal("synchronizer token not issued")
+	}
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/submit", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set(defaultCSRFHeader, token)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("request from a cors origin with a valid token should pass, got %d", w.Code)
+	}
+}
+
+func TestRegistryRecoveryMetrics(t *testing.T) {
+	if _, err := NewRegistry(logtest.New()).Build("recovery", map[string]interface{}{"metrics": true}); err == nil || !strings.Contains(err.Error(), "requires prom") {
+		t.Fatalf("expected prom required error, got %v", err)
+	}
+
+	p, err := prom.NewPromWithConfig(&prom.Config{Namespace: "rum"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m iface.IMiddleware
+	if m, err = NewRegistry(logtest.New(), WithRegistryProm(p)).Build("recovery", map[string]interface{}{"metrics": true}); err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.Use(m.HandlerFunc())
+	r.GET("/panic", func(c *gin.Context) { panic("boom") })
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/panic", nil))
+
+	families, err := p.Registry().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == "rum_panics_total" && family.GetMetric()[0].GetCounter().GetValue() == 1 {
+			return
+		}
+	}
+	t.Fatal("panic not counted")
+}
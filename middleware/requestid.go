@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/messagequeue"
+	"github.com/yangkushu/rum-go/reqctx"
+	"github.com/yangkushu/rum-go/utils"
+	"net/http"
+)
+
+const (
+	// RequestIDKey gin.Context 中保存请求ID的 key
+	RequestIDKey = "request_id"
+	// RequestIDHeader 默认的请求ID请求头
+	RequestIDHeader = reqctx.RequestIDHeader
+	// RequestIDMessageHeader kafka 消息头中保存请求ID的 key
+	RequestIDMessageHeader = "x-request-id"
+
+	RequestIDFormatUUIDv7 = "uuidv7"
+	RequestIDFormatULID   = "ulid"
+
+	maxRequestIDLength = 128
+)
+
+// RequestID 请求ID中间件。优先使用请求头中的ID，没有时生成一个新的，并写回响应头
+type RequestID struct {
+	header        string
+	generator     func() string
+	trustIncoming bool // 是否信任客户端传入的请求ID
+}
+
+// OptionRequestID 定义配置函数类型
+type OptionRequestID func(*RequestID)
+
+// WithRequestIDHeader 设置请求ID的请求头，默认 X-Request-Id
+func WithRequestIDHeader(header string) OptionRequestID {
+	return func(r *RequestID) {
+		r.header = header
+	}
+}
+
+// WithRequestIDFormat 设置生成的请求ID格式，支持 uuidv7、ulid，默认 uuidv7
+func WithRequestIDFormat(format string) OptionRequestID {
+	return func(r *RequestID) {
+		switch format {
+		case RequestIDFormatULID:
+			r.generator = utils.NewULID
+		default:
+			r.generator = utils.NewUUIDv7
+		}
+	}
+}
+
+// WithRequestIDGenerator 自定义请求ID生成函数
+func WithRequestIDGenerator(generator func() string) OptionRequestID {
+	return func(r *RequestID) {
+		r.generator = generator
+	}
+}
+
+// WithRequestIDTrustIncoming 是否使用客户端传入的请求ID，默认 true
+func WithRequestIDTrustIncoming(trust bool) OptionRequestID {
+	return func(r *RequestID) {
+		r.trustIncoming = trust
+	}
+}
+
+func NewRequestID(options ...OptionRequestID) *RequestID {
+	r := &RequestID{
+		header:        RequestIDHeader,
+		generator:     utils.NewUUIDv7,
+		trustIncoming: true,
+	}
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+func (r *RequestID) HandlerFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var id string
+		if r.trustIncoming {
+			id = c.GetHeader(r.header)
+		}
+		if !isValidRequestID(id) {
+			id = r.generator()
+		}
+
+		c.Set(RequestIDKey, id)
+		c.Request = c.Request.WithContext(reqctx.ContextWithRequestIDHeader(c.Request.Context(), id, r.header))
+		c.Header(r.header, id)
+		c.Next()
+	}
+}
+
+// GetRequestID 从 gin.Context 中获取请求ID，没有经过 RequestID 中间件时返回空字符串
+func GetRequestID(c *gin.Context) string {
+	return c.GetString(RequestIDKey)
+}
+
+// ContextWithRequestID 将请求ID保存到 context.Context 中，外发请求使用默认的 X-Request-Id 请求头
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return reqctx.ContextWithRequestID(ctx, id)
+}
+
+// RequestIDFromContext 从 context.Context 中获取请求ID
+func RequestIDFromContext(ctx context.Context) string {
+	return reqctx.RequestIDFromContext(ctx)
+}
+
+// RequestIDHeaderFromContext 返回 ctx 中的请求ID使用的请求头，也就是 WithRequestIDHeader 配置的请求头
+func RequestIDHeaderFromContext(ctx context.Context) string {
+	return reqctx.RequestIDHeaderFromContext(ctx)
+}
+
+// InjectRequestID 将 ctx 中的请求ID写入外发请求的请求头，用于跨服务传递，请求头和接收时的一致
+func InjectRequestID(ctx context.Context, req *http.Request) {
+	reqctx.InjectRequestID(ctx, req)
+}
+
+// NewRequestIDMessage 创建一个带请求ID消息头的 kafka 消息，在处理函数中发布消息时使用
+func NewRequestIDMessage(ctx context.Context, key []byte, message []byte) *messagequeue.HeaderMessage {
+	msg := messagequeue.NewHeaderMessage(key, message)
+	if id := RequestIDFromContext(ctx); id != "" {
+		msg.SetHeader(RequestIDMessageHeader, id)
+	}
+	return msg
+}
+
+// isValidRequestID 只接受长度有限的可见 ASCII 字符，防止日志注入
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
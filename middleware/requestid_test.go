@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/log/logtest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestIDGeneratedAndPropagated(t *testing.T) {
+	logger := logtest.New()
+	r := gin.New()
+	r.Use(NewRequestID().HandlerFunc(), NewAccessLog(logger).HandlerFunc())
+
+	var ctxID string
+	var outgoing *http.Request
+	r.GET("/ping", func(c *gin.Context) {
+		ctxID = RequestIDFromContext(c.Request.Context())
+		outgoing, _ = http.NewRequestWithContext(c.Request.Context(), http.MethodGet, "http://example.com", nil)
+		InjectRequestID(c.Request.Context(), outgoing)
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ping", nil))
+
+	id := w.Header().Get(RequestIDHeader)
+	if len(id) != 36 {
+		t.Fatalf("expected a generated uuid, got %q", id)
+	}
+	if ctxID != id {
+		t.Errorf("context request id %q != response header %q", ctxID, id)
+	}
+	if outgoing.Header.Get(RequestIDHeader) != id {
+		t.Errorf("outgoing request id %q != %q", outgoing.Header.Get(RequestIDHeader), id)
+	}
+	logger.AssertField(t, "access log", "request_id", id)
+}
+
+func TestRequestIDIncoming(t *testing.T) {
+	r := gin.New()
+	r.Use(NewRequestID(WithRequestIDHeader("X-Trace"), WithRequestIDFormat(RequestIDFormatULID)).HandlerFunc())
+
+	var msgID string
+	var outgoing *http.Request
+	r.GET("/ping", func(c *gin.Context) {
+		msg := NewRequestIDMessage(c.Request.Context(), nil, []byte("hello"))
+		msgID = msg.GetHeaders()[RequestIDMessageHeader]
+		outgoing, _ = http.NewRequestWithContext(c.Request.Context(), http.MethodGet, "http://example.com", nil)
+		InjectRequestID(c.Request.Context(), outgoing)
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	req.Header.Set("X-Trace", "abc-123")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if got := w.Header().Get("X-Trace"); got != "abc-123" {
+		t.Errorf("expected incoming id to be echoed, got %q", got)
+	}
+	if msgID != "abc-123" {
+		t.Errorf("expected message header abc-123, got %q", msgID)
+	}
+	// 外发请求使用配置的请求头
+	if got := outgoing.Header.Get("X-Trace"); got != "abc-123" || outgoing.Header.Get(RequestIDHeader) != "" {
+		t.Errorf("expected outgoing X-Trace abc-123, got %v", outgoing.Header)
+	}
+
+	// 非法的请求ID会被替换
+	req = httptest.NewRequest(http.MethodGet, "/ping", nil)
+	req.Header.Set("X-Trace", "bad id\n")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if got := w.Header().Get("X-Trace"); len(got) != 26 {
+		t.Errorf("expected a generated ulid, got %q", got)
+	}
+}
@@ -0,0 +1,468 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/iface"
+	"github.com/yangkushu/rum-go/log"
+	"github.com/yangkushu/rum-go/redis"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ResponseCacheStatusHeader 响应头，值为 HIT、STALE、MISS
+	ResponseCacheStatusHeader = "X-Cache"
+
+	responseCacheTagsKey = "response_cache_tags"
+
+	defaultResponseCachePrefix       = "httpcache"
+	defaultResponseCacheTTL          = time.Minute
+	defaultResponseCacheStale        = 30 * time.Second
+	defaultResponseCacheMaxBodySize  = 1 << 20
+	defaultResponseCacheLockExpiry   = 10 * time.Second
+	defaultResponseCacheWait         = time.Second
+	responseCacheWaitInterval        = 50 * time.Millisecond
+	defaultResponseCacheRedisTimeout = 100 * time.Millisecond
+)
+
+// responseCacheTagScript 把缓存 key 加入标签集合，集合的过期时间只会延长，保证不早于其中的缓存过期
+const responseCacheTagScript = `
+redis.call('SADD', KEYS[1], ARGV[1])
+if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 1
+`
+
+// 不保存到缓存中的响应头
+var responseCacheSkipHeaders = map[string]bool{
+	"Date":                    true,
+	"Content-Length":          true,
+	ResponseCacheStatusHeader: true,
+}
+
+// ResponseCacheConfig 响应缓存配置
+type ResponseCacheConfig struct {
+	TTLSecond     int      `mapstructure:"ttl_second" yaml:"ttl_second"`           // 默认缓存时间，handler 返回 Cache-Control max-age 时以 handler 为准，默认 60 秒
+	StaleSecond   int      `mapstructure:"stale_second" yaml:"stale_second"`       // 过期后还可以返回旧数据的时间，期间只有一个请求重新计算，默认 30 秒
+	Prefix        string   `mapstructure:"prefix" yaml:"prefix"`                   // redis key 前缀，默认 httpcache
+	VaryHeaders   []string `mapstructure:"vary_headers" yaml:"vary_headers"`       // 参与缓存 key 的请求头，比如 Accept-Language
+	MaxBodySize   int64    `mapstructure:"max_body_size" yaml:"max_body_size"`     // 超过时不缓存，默认 1MB
+	WaitTimeoutMs int      `mapstructure:"wait_timeout_ms" yaml:"wait_timeout_ms"` // 没有缓存且其他请求正在计算时最多等待的时间，默认 1000 毫秒
+}
+
+// responseCacheEntry 缓存在 redis 中的响应
+type responseCacheEntry struct {
+	Status   int                 `json:"status"`
+	Header   map[string][]string `json:"header"`
+	Body     []byte              `json:"body"`
+	ExpireAt int64               `json:"expire_at"` // 逻辑过期时间，unix 毫秒
+}
+
+// ResponseCache GET 请求的响应缓存中间件
+// 缓存 key 由路由、排序后的查询参数、VaryHeaders 和作用域组成。只缓存 200 响应，
+// handler 返回 Cache-Control: no-store、no-cache、private 或者设置了 Set-Cookie 时不缓存。
+// 没有设置作用域时，带 Authorization 或 Cookie 请求头的请求不走缓存，避免把用户数据缓存给所有人
+type ResponseCache struct {
+	client      *redis.Client
+	log         iface.ILogger
+	ttl         time.Duration
+	stale       time.Duration
+	prefix      string
+	varyHeaders []string
+	maxBodySize int64
+	waitTimeout time.Duration
+	scopeFunc   func(c *gin.Context) string
+}
+
+// OptionResponseCache 定义配置函数类型
+type OptionResponseCache func(*ResponseCache)
+
+// WithResponseCacheScopeFunc 设置缓存的作用域，比如按用户缓存
+func WithResponseCacheScopeFunc(fn func(c *gin.Context) string) OptionResponseCache {
+	return func(r *ResponseCache) {
+		r.scopeFunc = fn
+	}
+}
+
+// NewResponseCache 创建响应缓存中间件，redis 不可用时直接执行 handler
+func NewResponseCache(config *ResponseCacheConfig, client *redis.Client, logger iface.ILogger, opts ...OptionResponseCache) (*ResponseCache, error) {
+	if client == nil {
+		return nil, errors.New("response cache requires redis client")
+	}
+	r := &ResponseCache{
+		client:      client,
+		log:         logger,
+		ttl:         time.Duration(config.TTLSecond) * time.Second,
+		stale:       time.Duration(config.StaleSecond) * time.Second,
+		prefix:      config.Prefix,
+		maxBodySize: config.MaxBodySize,
+		waitTimeout: time.Duration(config.WaitTimeoutMs) * time.Millisecond,
+	}
+	for _, header := range config.VaryHeaders {
+		r.varyHeaders = append(r.varyHeaders, http.CanonicalHeaderKey(header))
+	}
+	if r.ttl <= 0 {
+		r.ttl = defaultResponseCacheTTL
+	}
+	if r.stale <= 0 {
+		r.stale = defaultResponseCacheStale
+	}
+	if r.prefix == "" {
+		r.prefix = defaultResponseCachePrefix
+	}
+	if r.maxBodySize <= 0 {
+		r.maxBodySize = defaultResponseCacheMaxBodySize
+	}
+	if r.waitTimeout <= 0 {
+		r.waitTimeout = defaultResponseCacheWait
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r, nil
+}
+
+// SetResponseCacheTags 在 handler 中给当前响应打标签，之后可以通过 Invalidate 按标签删除缓存
+func SetResponseCacheTags(c *gin.Context, tags ...string) {
+	existing := c.GetStringSlice(responseCacheTagsKey)
+	c.Set(responseCacheTagsKey, append(existing, tags...))
+}
+
+// Invalidate 删除带有任意一个标签的缓存
+func (r *ResponseCache) Invalidate(ctx context.Context, tags ...string) error {
+	for _, tag := range tags {
+		tagKey := r.tagKey(tag)
+		keys, err := r.client.SMembers(ctx, tagKey).Result()
+		if err != nil {
+			return err
+		}
+		// 集群模式下 key 分布在不同的 slot，逐个删除
+		pipe := r.client.Pipeline()
+		for _, key := range keys {
+			pipe.Del(ctx, key)
+		}
+		pipe.Del(ctx, tagKey)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *ResponseCache) HandlerFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet || (r.scopeFunc == nil && (c.GetHeader("Authorization") != "" || c.GetHeader("Cookie") != "")) {
+			c.Next()
+			return
+		}
+		key := r.entryKey(c)
+
+		entry, err := r.load(c.Request.Context(), key)
+		if err != nil {
+			r.log.Warn("response cache load failed", log.String("key", key), log.ErrorField(err))
+			c.Next()
+			return
+		}
+		if entry != nil && time.Now().UnixMilli() < entry.ExpireAt {
+			r.serve(c, entry, "HIT")
+			return
+		}
+
+		// 只有拿到锁的请求重新计算，其余请求返回旧数据或者等待
+		lock := r.client.NewLockWithExpiry(key+":lock", defaultResponseCacheLockExpiry)
+		if err := lock.TryLock(); err != nil {
+			if !redis.IsLockAlreadyExist(err) {
+				r.log.Warn("response cache lock failed", log.String("key", key), log.ErrorField(err))
+				c.Next()
+				return
+			}
+			if entry != nil {
+				r.serve(c, entry, "STALE")
+				return
+			}
+			if entry = r.wait(c.Request.Context(), key); entry != nil {
+				r.serve(c, entry, "HIT")
+				return
+			}
+			r.compute(c, key)
+			return
+		}
+		defer func() {
+			if _, err := lock.Unlock(); err != nil {
+				r.log.Warn("response cache unlock failed", log.String("key", key), log.ErrorField(err))
+			}
+		}()
+		r.compute(c, key)
+	}
+}
+
+// entryKey 缓存 key，使用实际请求路径而不是路由模板，内容做哈希避免 key 过长
+func (r *ResponseCache) entryKey(c *gin.Context) string {
+	h := sha256.New()
+	h.Write([]byte(c.Request.URL.Path + "\n" + c.Request.URL.Query().Encode() + "\n"))
+	for _, header := range r.varyHeaders {
+		h.Write([]byte(header + ":" + c.GetHeader(header) + "\n"))
+	}
+	if r.scopeFunc != nil {
+		h.Write([]byte(r.scopeFunc(c)))
+	}
+	return r.prefix + ":entry:" + hex.EncodeToString(h.Sum(nil))
+}
+
+func (r *ResponseCache) tagKey(tag string) string {
+	return r.prefix + ":tag:" + tag
+}
+
+func (r *ResponseCache) load(ctx context.Context, key string) (*responseCacheEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultResponseCacheRedisTimeout)
+	defer cancel()
+	data, err := r.client.Get(ctx, key).Bytes()
+	if err != nil {
+		if redis.IsKeyNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entry responseCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// wait 等待正在计算的请求写入缓存
+func (r *ResponseCache) wait(ctx context.Context, key string) *responseCacheEntry {
+	deadline := time.Now().Add(r.waitTimeout)
+	ticker := time.NewTicker(responseCacheWaitInterval)
+	defer ticker.Stop()
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if entry, err := r.load(ctx, key); err == nil && entry != nil {
+			return entry
+		}
+	}
+	return nil
+}
+
+// compute 执行 handler，缓存可以缓存的响应，然后写出响应
+func (r *ResponseCache) compute(c *gin.Context, key string) {
+	original := c.Writer
+	writer := &responseCacheWriter{ResponseWriter: original, status: http.StatusOK, limit: r.maxBodySize}
+	c.Writer = writer
+	c.Next()
+	c.Writer = original
+	if writer.passthrough {
+		return
+	}
+
+	header := original.Header()
+	if writer.status == http.StatusOK && header.Get("ETag") == "" {
+		header.Set("ETag", newETag(writer.body.Bytes()))
+	}
+	if ttl, ok := r.cacheTTL(writer.status, header); ok {
+		entry := &responseCacheEntry{
+			Status:   writer.status,
+			Header:   make(map[string][]string),
+			Body:     writer.body.Bytes(),
+			ExpireAt: time.Now().Add(ttl).UnixMilli(),
+		}
+		for name, values := range header {
+			if !responseCacheSkipHeaders[name] {
+				entry.Header[name] = values
+			}
+		}
+		if err := r.save(key, entry, ttl, c.GetStringSlice(responseCacheTagsKey)); err != nil {
+			r.log.Warn("response cache save failed", log.String("key", key), log.ErrorField(err))
+		}
+	}
+
+	header.Set(ResponseCacheStatusHeader, "MISS")
+	if writer.status == http.StatusOK && etagMatch(c.GetHeader("If-None-Match"), header.Get("ETag")) {
+		original.WriteHeader(http.StatusNotModified)
+		original.WriteHeaderNow()
+		return
+	}
+	original.WriteHeader(writer.status)
+	_, _ = original.Write(writer.body.Bytes())
+}
+
+// cacheTTL 根据响应判断是否缓存以及缓存时间，s-maxage 优先于 max-age
+func (r *ResponseCache) cacheTTL(status int, header http.Header) (time.Duration, bool) {
+	if status != http.StatusOK || header.Get("Set-Cookie") != "" {
+		return 0, false
+	}
+	ttl := r.ttl
+	maxAge, sharedMaxAge := -1, -1
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(strings.ToLower(directive)), "=")
+		switch name {
+		case "no-store", "no-cache", "private":
+			return 0, false
+		case "max-age":
+			maxAge, _ = strconv.Atoi(value)
+		case "s-maxage":
+			sharedMaxAge, _ = strconv.Atoi(value)
+		}
+	}
+	if sharedMaxAge >= 0 {
+		maxAge = sharedMaxAge
+	}
+	if maxAge == 0 {
+		return 0, false
+	}
+	if maxAge > 0 {
+		ttl = time.Duration(maxAge) * time.Second
+	}
+	return ttl, true
+}
+
+// save 保存响应，redis 中的过期时间包含可以返回旧数据的时间
+func (r *ResponseCache) save(key string, entry *responseCacheEntry, ttl time.Duration, tags []string) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultResponseCacheRedisTimeout)
+	defer cancel()
+	expiry := ttl + r.stale
+	pipe := r.client.Pipeline()
+	pipe.Set(ctx, key, data, expiry)
+	for _, tag := range tags {
+		tagKey := r.tagKey(tag)
+		pipe.Eval(ctx, responseCacheTagScript, []string{tagKey}, key, expiry.Milliseconds())
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (r *ResponseCache) serve(c *gin.Context, entry *responseCacheEntry, status string) {
+	header := c.Writer.Header()
+	for name, values := range entry.Header {
+		header[name] = values
+	}
+	header.Set(ResponseCacheStatusHeader, status)
+	if entry.Status == http.StatusOK && etagMatch(c.GetHeader("If-None-Match"), header.Get("ETag")) {
+		c.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+	c.Status(entry.Status)
+	_, _ = c.Writer.Write(entry.Body)
+	c.Abort()
+}
+
+// newETag 根据响应内容生成强 ETag
+func newETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatch If-None-Match 使用弱比较，支持多个值和 *
+func etagMatch(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" || etag == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// responseCacheWriter 先把响应缓冲在内存中，超过 limit 或者调用 Flush 后直接写出，不再缓存
+type responseCacheWriter struct {
+	gin.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	limit       int64
+	wroteHeader bool
+	passthrough bool
+}
+
+func (w *responseCacheWriter) WriteHeader(code int) {
+	if w.passthrough {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if code > 0 && !w.wroteHeader {
+		w.status = code
+	}
+}
+
+func (w *responseCacheWriter) WriteHeaderNow() {
+	if w.passthrough {
+		w.ResponseWriter.WriteHeaderNow()
+		return
+	}
+	w.wroteHeader = true
+}
+
+func (w *responseCacheWriter) Write(b []byte) (int, error) {
+	if !w.passthrough && int64(w.body.Len()+len(b)) > w.limit {
+		w.flush()
+	}
+	if w.passthrough {
+		return w.ResponseWriter.Write(b)
+	}
+	w.wroteHeader = true
+	return w.body.Write(b)
+}
+
+func (w *responseCacheWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *responseCacheWriter) Status() int {
+	if w.passthrough {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *responseCacheWriter) Size() int {
+	if w.passthrough {
+		return w.ResponseWriter.Size()
+	}
+	if !w.wroteHeader {
+		return -1
+	}
+	return w.body.Len()
+}
+
+func (w *responseCacheWriter) Written() bool {
+	if w.passthrough {
+		return w.ResponseWriter.Written()
+	}
+	return w.wroteHeader
+}
+
+func (w *responseCacheWriter) Flush() {
+	w.flush()
+	w.ResponseWriter.Flush()
+}
+
+// flush 写出已经缓冲的内容，之后的写入直接透传
+func (w *responseCacheWriter) flush() {
+	if w.passthrough {
+		return
+	}
+	w.passthrough = true
+	w.ResponseWriter.WriteHeader(w.status)
+	_, _ = w.ResponseWriter.Write(w.body.Bytes())
+	w.body.Reset()
+}
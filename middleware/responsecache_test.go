@@ -0,0 +1,208 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/log/logtest"
+	"github.com/yangkushu/rum-go/redis/redistest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type responseCacheFixture struct {
+	engine *gin.Engine
+	cache  *ResponseCache
+	mr     *miniredis.Miniredis
+	calls  atomic.Int32
+}
+
+func newResponseCacheFixture(t *testing.T) *responseCacheFixture {
+	client, mr := redistest.New(t)
+	f := &responseCacheFixture{mr: mr}
+	cache, err := NewResponseCache(&ResponseCacheConfig{}, client, logtest.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.cache = cache
+
+	f.engine = gin.New()
+	f.engine.Use(cache.HandlerFunc())
+	f.engine.GET("/products/:id", func(c *gin.Context) {
+		n := f.calls.Add(1)
+		SetResponseCacheTags(c, "product:"+c.Param("id"))
+		if cc := c.Query("cc"); cc != "" {
+			c.Header("Cache-Control", cc)
+		}
+		if sid := c.Query("set_session"); sid != "" {
+			c.SetCookie("sid", sid, 3600, "/", "", false, true)
+		}
+		user, _ := c.Cookie("sid")
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "version": n, "user": user})
+	})
+	return f
+}
+
+func (f *responseCacheFixture) get(path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	f.engine.ServeHTTP(w, req)
+	return w
+}
+
+// entryKeys 返回 redis 中的缓存 key
+func (f *responseCacheFixture) entryKeys() []string {
+	var keys []string
+	for _, key := range f.mr.Keys() {
+		if strings.HasPrefix(key, defaultResponseCachePrefix+":entry:") && !strings.HasSuffix(key, ":lock") {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func TestResponseCacheHitAndETag(t *testing.T) {
+	f := newResponseCacheFixture(t)
+
+	miss := f.get("/products/1?a=1&b=2", nil)
+	etag := miss.Header().Get("ETag")
+	if miss.Code != http.StatusOK || miss.Header().Get(ResponseCacheStatusHeader) != "MISS" || etag == "" {
+		t.Fatalf("unexpected first response %d %v", miss.Code, miss.Header())
+	}
+
+	// 查询参数顺序不同也命中同一个缓存
+	hit := f.get("/products/1?b=2&a=1", nil)
+	if hit.Header().Get(ResponseCacheStatusHeader) != "HIT" || hit.Body.String() != miss.Body.String() || hit.Header().Get("ETag") != etag {
+		t.Errorf("expected cache hit, got %v %s", hit.Header(), hit.Body.String())
+	}
+	if hit.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+		t.Errorf("expected content type restored, got %q", hit.Header().Get("Content-Type"))
+	}
+
+	if w := f.get("/products/1?a=1&b=2", map[string]string{"If-None-Match": `"x", ` + etag}); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("expected 304 from cache, got %d", w.Code)
+	}
+	if w := f.get("/products/2?cc=no-store", map[string]string{"If-None-Match": newETag([]byte(`{"id":"2","user":"","version":2}`))}); w.Code != http.StatusNotModified {
+		t.Errorf("expected 304 on miss, got %d", w.Code)
+	}
+
+	// 带 Authorization 的请求不走缓存
+	if w := f.get("/products/1?a=1&b=2", map[string]string{"Authorization": "Bearer x"}); w.Header().Get(ResponseCacheStatusHeader) != "" {
+		t.Errorf("expected authorized request to bypass cache, got %v", w.Header())
+	}
+	if n := f.calls.Load(); n != 3 {
+		t.Errorf("expected handler called 3 times, got %d", n)
+	}
+}
+
+func TestResponseCachePathParams(t *testing.T) {
+	f := newResponseCacheFixture(t)
+
+	one := f.get("/products/1", nil)
+	two := f.get("/products/2", nil)
+	if two.Header().Get(ResponseCacheStatusHeader) != "MISS" || !strings.Contains(two.Body.String(), `"id":"2"`) {
+		t.Fatalf("different path params should not share an entry, got %v %s", two.Header(), two.Body.String())
+	}
+	if w := f.get("/products/1", nil); w.Header().Get(ResponseCacheStatusHeader) != "HIT" || w.Body.String() != one.Body.String() {
+		t.Errorf("expected cache hit for /products/1, got %v %s", w.Header(), w.Body.String())
+	}
+	if keys := f.entryKeys(); len(keys) != 2 {
+		t.Fatalf("expected two cached entries, got %v", keys)
+	}
+}
+
+func TestResponseCacheControl(t *testing.T) {
+	f := newResponseCacheFixture(t)
+
+	for _, cc := range []string{"no-store", "private, max-age=60", "max-age=0"} {
+		f.get("/products/1?cc="+url.QueryEscape(cc), nil)
+		if w := f.get("/products/1?cc="+url.QueryEscape(cc), nil); w.Header().Get(ResponseCacheStatusHeader) != "MISS" {
+			t.Errorf("%s: expected response not cached, got %v", cc, w.Header())
+		}
+	}
+	if keys := f.entryKeys(); len(keys) != 0 {
+		t.Fatalf("expected nothing cached, got %v", keys)
+	}
+
+	f.get("/products/1?cc="+url.QueryEscape("public, max-age=120, s-maxage=300"), nil)
+	keys := f.entryKeys()
+	if len(keys) != 1 {
+		t.Fatalf("expected one cached entry, got %v", keys)
+	}
+	// s-maxage 优先，再加上默认 30 秒的旧数据时间
+	if ttl := f.mr.TTL(keys[0]); ttl != 330*time.Second {
+		t.Errorf("expected ttl 330s, got %s", ttl)
+	}
+}
+
+func TestResponseCacheBypassesCookies(t *testing.T) {
+	f := newResponseCacheFixture(t)
+
+	alice := f.get("/products/1", map[string]string{"Cookie": "sid=alice"})
+	bob := f.get("/products/1", map[string]string{"Cookie": "sid=bob"})
+	if alice.Header().Get(ResponseCacheStatusHeader) != "" || bob.Header().Get(ResponseCacheStatusHeader) != "" {
+		t.Fatalf("expected cookie requests to bypass cache, got %v %v", alice.Header(), bob.Header())
+	}
+	if !strings.Contains(alice.Body.String(), `"user":"alice"`) || !strings.Contains(bob.Body.String(), `"user":"bob"`) {
+		t.Fatalf("responses leaked between sessions: %s %s", alice.Body.String(), bob.Body.String())
+	}
+
+	// 设置 cookie 的响应不缓存
+	f.get("/products/1?set_session=carol", nil)
+	if w := f.get("/products/1?set_session=carol", nil); w.Header().Get(ResponseCacheStatusHeader) != "MISS" {
+		t.Errorf("expected Set-Cookie response not cached, got %v", w.Header())
+	}
+	if keys := f.entryKeys(); len(keys) != 0 {
+		t.Fatalf("expected nothing cached, got %v", keys)
+	}
+}
+
+func TestResponseCacheInvalidateAndStale(t *testing.T) {
+	f := newResponseCacheFixture(t)
+	f.get("/products/42", nil)
+	f.get("/products/7", nil)
+
+	if err := f.cache.Invalidate(context.Background(), "product:42"); err != nil {
+		t.Fatal(err)
+	}
+	if w := f.get("/products/42", nil); w.Header().Get(ResponseCacheStatusHeader) != "MISS" {
+		t.Errorf("expected invalidated entry to miss, got %v", w.Header())
+	}
+	if w := f.get("/products/7", nil); w.Header().Get(ResponseCacheStatusHeader) != "HIT" {
+		t.Errorf("expected other entry to hit, got %v", w.Header())
+	}
+
+	// 让缓存逻辑过期，并模拟其他请求正在重新计算
+	keys := f.entryKeys()
+	for _, key := range keys {
+		var entry responseCacheEntry
+		data, _ := f.mr.Get(key)
+		_ = json.Unmarshal([]byte(data), &entry)
+		entry.ExpireAt = time.Now().Add(-time.Second).UnixMilli()
+		updated, _ := json.Marshal(entry)
+		_ = f.mr.Set(key, string(updated))
+		_ = f.mr.Set(key+":lock", "other")
+	}
+	calls := f.calls.Load()
+	if w := f.get("/products/7", nil); w.Header().Get(ResponseCacheStatusHeader) != "STALE" || f.calls.Load() != calls {
+		t.Errorf("expected stale response without recompute, got %v", w.Header())
+	}
+
+	for _, key := range keys {
+		f.mr.Del(key + ":lock")
+	}
+	w := f.get("/products/7", nil)
+	if w.Header().Get(ResponseCacheStatusHeader) != "MISS" || !strings.Contains(w.Body.String(), strconv.Itoa(int(calls+1))) {
+		t.Errorf("expected recompute after lock released, got %v %s", w.Header(), w.Body.String())
+	}
+}
@@ -8,11 +8,11 @@ import (
 	"net/url"
 )
 
-// 创建反向代理处理函数
+// NewReverseProxy 创建单个上游的反向代理处理函数，需要多个上游、健康检查或重试时使用 proxy.NewProxy
 func NewReverseProxy(toUrl *url.URL, log iface.ILogger) gin.HandlerFunc {
 	// 设置目标URL
 	proxy := httputil.NewSingleHostReverseProxy(toUrl)
-	log.Info("reverse proxy to: %s", toUrl)
+	log.Info("reverse proxy to", rumLog.String("target", toUrl.String()))
 
 	return func(c *gin.Context) {
 		u := c.Request.URL
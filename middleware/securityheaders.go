@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"strings"
+)
+
+const (
+	// CSPNonceKey gin.Context 中保存 CSP nonce 的 key，模板中可以通过 GetCSPNonce 获取
+	CSPNonceKey = "csp_nonce"
+	// CSPNoncePlaceholder CSP 中的占位符，每个请求替换为 'nonce-<随机值>'
+	CSPNoncePlaceholder = "{nonce}"
+
+	securityHeaderDisabled = "-"
+
+	defaultFrameOptions       = "DENY"
+	defaultContentTypeOptions = "nosniff"
+	defaultReferrerPolicy     = "strict-origin-when-cross-origin"
+	defaultHSTSMaxAgeSecond   = 365 * 24 * 3600
+)
+
+// SecurityHeadersConfig 安全响应头配置，字符串配置为空时使用默认值，配置为 - 时不返回该响应头
+type SecurityHeadersConfig struct {
+	HSTSMaxAgeSecond      int    `mapstructure:"hsts_max_age_second" yaml:"hsts_max_age_second"`         // Strict-Transport-Security 的 max-age，默认一年，小于 0 不返回，只在 HTTPS 请求中返回
+	HSTSIncludeSubdomains bool   `mapstructure:"hsts_include_subdomains" yaml:"hsts_include_subdomains"` // HSTS 是否包含子域名
+	HSTSPreload           bool   `mapstructure:"hsts_preload" yaml:"hsts_preload"`                       // HSTS 是否加入 preload
+	ContentSecurityPolicy string `mapstructure:"content_security_policy" yaml:"content_security_policy"` // CSP，可以使用 {nonce} 占位符，比如 script-src 'self' {nonce}，默认不返回
+	CSPReportOnly         bool   `mapstructure:"csp_report_only" yaml:"csp_report_only"`                 // 使用 Content-Security-Policy-Report-Only
+	FrameOptions          string `mapstructure:"frame_options" yaml:"frame_options"`                     // X-Frame-Options，默认 DENY
+	ContentTypeOptions    string `mapstructure:"content_type_options" yaml:"content_type_options"`       // X-Content-Type-Options，默认 nosniff
+	ReferrerPolicy        string `mapstructure:"referrer_policy" yaml:"referrer_policy"`                 // Referrer-Policy，默认 strict-origin-when-cross-origin
+	PermissionsPolicy     string `mapstructure:"permissions_policy" yaml:"permissions_policy"`           // Permissions-Policy，比如 camera=(), geolocation=()，默认不返回
+}
+
+// SecurityHeaders 安全响应头中间件
+type SecurityHeaders struct {
+	hsts       string
+	csp        string
+	cspHeader  string
+	cspNonce   bool
+	static     map[string]string
+	skipPaths  map[string]bool
+	trustProto bool
+}
+
+// OptionSecurityHeaders 定义配置函数类型
+type OptionSecurityHeaders func(*SecurityHeaders)
+
+// WithSecurityHeadersSkipPaths 不添加安全响应头的路径
+func WithSecurityHeadersSkipPaths(paths ...string) OptionSecurityHeaders {
+	return func(s *SecurityHeaders) {
+		for _, path := range paths {
+			s.skipPaths[path] = true
+		}
+	}
+}
+
+// WithSecurityHeadersTrustForwardedProto 根据 X-Forwarded-Proto 判断是否为 HTTPS 请求，服务部署在 TLS 终止的代理后面时使用
+func WithSecurityHeadersTrustForwardedProto() OptionSecurityHeaders {
+	return func(s *SecurityHeaders) {
+		s.trustProto = true
+	}
+}
+
+func NewSecurityHeaders(config *SecurityHeadersConfig, opts ...OptionSecurityHeaders) *SecurityHeaders {
+	s := &SecurityHeaders{
+		csp:       config.ContentSecurityPolicy,
+		cspHeader: "Content-Security-Policy",
+		static:    make(map[string]string),
+		skipPaths: make(map[string]bool),
+	}
+	maxAge := config.HSTSMaxAgeSecond
+	if maxAge == 0 {
+		maxAge = defaultHSTSMaxAgeSecond
+	}
+	if maxAge > 0 {
+		s.hsts = fmt.Sprintf("max-age=%d", maxAge)
+		if config.HSTSIncludeSubdomains {
+			s.hsts += "; includeSubDomains"
+		}
+		if config.HSTSPreload {
+			s.hsts += "; preload"
+		}
+	}
+	if config.CSPReportOnly {
+		s.cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	s.cspNonce = strings.Contains(s.csp, CSPNoncePlaceholder)
+
+	headers := []struct {
+		name, value, fallback string
+	}{
+		{"X-Frame-Options", config.FrameOptions, defaultFrameOptions},
+		{"X-Content-Type-Options", config.ContentTypeOptions, defaultContentTypeOptions},
+		{"Referrer-Policy", config.ReferrerPolicy, defaultReferrerPolicy},
+		{"Permissions-Policy", config.PermissionsPolicy, ""},
+	}
+	for _, header := range headers {
+		value := header.value
+		if value == "" {
+			value = header.fallback
+		}
+		if value != "" && value != securityHeaderDisabled {
+			s.static[header.name] = value
+		}
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *SecurityHeaders) HandlerFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.skipPaths[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+		header := c.Writer.Header()
+		for name, value := range s.static {
+			header.Set(name, value)
+		}
+		if s.hsts != "" && s.isHTTPS(c) {
+			header.Set("Strict-Transport-Security", s.hsts)
+		}
+		if s.csp != "" && s.csp != securityHeaderDisabled {
+			csp := s.csp
+			if s.cspNonce {
+				nonce := newCSPNonce()
+				c.Set(CSPNonceKey, nonce)
+				csp = strings.ReplaceAll(csp, CSPNoncePlaceholder, "'nonce-"+nonce+"'")
+			}
+			header.Set(s.cspHeader, csp)
+		}
+		c.Next()
+	}
+}
+
+func (s *SecurityHeaders) isHTTPS(c *gin.Context) bool {
+	if c.Request.TLS != nil {
+		return true
+	}
+	return s.trustProto && strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https")
+}
+
+// GetCSPNonce 获取当前请求的 CSP nonce，用于模板中的 <script nonce="...">
+func GetCSPNonce(c *gin.Context) string {
+	return c.GetString(CSPNonceKey)
+}
+
+func newCSPNonce() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return base64.StdEncoding.EncodeToString(buf)
+}
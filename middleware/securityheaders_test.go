@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	s := NewSecurityHeaders(&SecurityHeadersConfig{
+		HSTSIncludeSubdomains: true,
+		ContentSecurityPolicy: "default-src 'self'; script-src 'self' {nonce}",
+		FrameOptions:          "SAMEORIGIN",
+		ReferrerPolicy:        securityHeaderDisabled,
+		PermissionsPolicy:     "camera=()",
+	})
+	var nonce string
+	r := gin.New()
+	r.Use(s.HandlerFunc())
+	r.GET("/", func(c *gin.Context) {
+		nonce = GetCSPNonce(c)
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	header := w.Header()
+	if nonce == "" || header.Get("Content-Security-Policy") != "default-src 'self'; script-src 'self' 'nonce-"+nonce+"'" {
+		t.Errorf("unexpected csp %q with nonce %q", header.Get("Content-Security-Policy"), nonce)
+	}
+	if header.Get("X-Frame-Options") != "SAMEORIGIN" || header.Get("X-Content-Type-Options") != "nosniff" || header.Get("Permissions-Policy") != "camera=()" {
+		t.Errorf("unexpected headers %v", header)
+	}
+	if header.Get("Referrer-Policy") != "" {
+		t.Error("expected disabled Referrer-Policy")
+	}
+	if header.Get("Strict-Transport-Security") != "" {
+		t.Error("expected no HSTS over plain http")
+	}
+
+	first := nonce
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.TLS = &tls.ConnectionState{}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if hsts := w.Header().Get("Strict-Transport-Security"); hsts != "max-age=31536000; includeSubDomains" {
+		t.Errorf("unexpected hsts %q", hsts)
+	}
+	if nonce == first || !strings.Contains(w.Header().Get("Content-Security-Policy"), nonce) {
+		t.Error("expected a new nonce for each request")
+	}
+}
@@ -0,0 +1,329 @@
+package middleware
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/iface"
+	"github.com/yangkushu/rum-go/log"
+	"github.com/yangkushu/rum-go/redis"
+	"github.com/yangkushu/rum-go/signature"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// SignatureKeyIDKey gin.Context 中保存调用方 key ID 的 key
+	SignatureKeyIDKey = "api_key_id"
+
+	defaultSignatureMaxSkew      = 5 * time.Minute
+	defaultSignatureNoncePrefix  = "signature:nonce"
+	defaultSignatureMaxBodySize  = 1 << 20
+	defaultSignatureRedisTimeout = 50 * time.Millisecond
+	defaultSecretStoreCacheTTL   = time.Minute
+	defaultSecretStoreTable      = "api_keys"
+	defaultSecretStoreCacheSize  = 10000
+	maxSignatureNonceLength      = 128
+	maxSignatureKeyIDLength      = 128
+)
+
+var (
+	ErrSignatureMissing     = errors.New("signature headers are missing")
+	ErrSignatureTimestamp   = errors.New("signature timestamp is invalid or expired")
+	ErrSignatureKeyNotFound = errors.New("signature key not found")
+	ErrSignatureInvalid     = errors.New("signature is invalid")
+	ErrSignatureReplayed    = errors.New("signature nonce has been used")
+	ErrSignatureBodyTooBig  = errors.New("request body is too large")
+)
+
+// SecretStore 根据 key ID 查询签名密钥，key 不存在时返回 ErrSignatureKeyNotFound
+type SecretStore interface {
+	GetSecret(ctx context.Context, keyID string) (string, error)
+}
+
+// StaticSecretStore 从配置中读取密钥，key 为 key ID，value 为密钥
+type StaticSecretStore map[string]string
+
+func (s StaticSecretStore) GetSecret(_ context.Context, keyID string) (string, error) {
+	if secret, ok := s[keyID]; ok {
+		return secret, nil
+	}
+	return "", ErrSignatureKeyNotFound
+}
+
+// DBSecretStore 从数据库表中读取密钥（比如 postgres），查询结果在内存中缓存一段时间
+// 缓存最多保存 defaultSecretStoreCacheSize 个 key ID，超过时淘汰最久未使用的，随机 key ID 不会让内存无限增长
+type DBSecretStore struct {
+	db       *gorm.DB
+	table    string
+	cacheTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]*list.Element // key ID -> order 中的 *dbSecretEntry
+	order *list.List               // 最近使用的在前面
+}
+
+type dbSecretEntry struct {
+	keyID    string
+	secret   string
+	found    bool
+	expireAt time.Time
+}
+
+// NewDBSecretStore 创建数据库密钥存储，table 为空时使用 api_keys，cacheTTL 为 0 时缓存 1 分钟，小于 0 不缓存
+func NewDBSecretStore(db *gorm.DB, table string, cacheTTL time.Duration) *DBSecretStore {
+	if table == "" {
+		table = defaultSecretStoreTable
+	}
+	if cacheTTL == 0 {
+		cacheTTL = defaultSecretStoreCacheTTL
+	}
+	return &DBSecretStore{
+		db:       db,
+		table:    table,
+		cacheTTL: cacheTTL,
+		cache:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (s *DBSecretStore) GetSecret(ctx context.Context, keyID string) (string, error) {
+	if keyID == "" || len(keyID) > maxSignatureKeyIDLength {
+		return "", ErrSignatureKeyNotFound
+	}
+	if entry, ok := s.load(keyID); ok {
+		if !entry.found {
+			return "", ErrSignatureKeyNotFound
+		}
+		return entry.secret, nil
+	}
+
+	var secrets []string
+	err := s.db.WithContext(ctx).Table(s.table).Where("key_id = ?", keyID).Limit(1).Pluck("secret", &secrets).Error
+	if err != nil {
+		return "", fmt.Errorf("query signature secret failed: %w", err)
+	}
+	entry := &dbSecretEntry{keyID: keyID, found: len(secrets) > 0, expireAt: time.Now().Add(s.cacheTTL)}
+	if entry.found {
+		entry.secret = secrets[0]
+	}
+	// 不存在的 key 也缓存，避免被随机 key ID 打穿数据库
+	if s.cacheTTL > 0 {
+		s.store(entry)
+	}
+	if !entry.found {
+		return "", ErrSignatureKeyNotFound
+	}
+	return entry.secret, nil
+}
+
+func (s *DBSecretStore) load(keyID string) (*dbSecretEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	element, ok := s.cache[keyID]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*dbSecretEntry)
+	if !time.Now().Before(entry.expireAt) {
+		s.order.Remove(element)
+		delete(s.cache, keyID)
+		return nil, false
+	}
+	s.order.MoveToFront(element)
+	return entry, true
+}
+
+func (s *DBSecretStore) store(entry *dbSecretEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if element, ok := s.cache[entry.keyID]; ok {
+		element.Value = entry
+		s.order.MoveToFront(element)
+		return
+	}
+	s.cache[entry.keyID] = s.order.PushFront(entry)
+	for s.order.Len() > defaultSecretStoreCacheSize {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.cache, oldest.Value.(*dbSecretEntry).keyID)
+	}
+}
+
+// SignatureConfig 请求签名校验配置
+type SignatureConfig struct {
+	Secrets       map[string]string `mapstructure:"secrets" yaml:"secrets"`                 // key ID -> 密钥，没有设置 SecretStore 时使用
+	MaxSkewSecond int               `mapstructure:"max_skew_second" yaml:"max_skew_second"` // 时间戳允许的最大偏差，默认 300 秒
+	NoncePrefix   string            `mapstructure:"nonce_prefix" yaml:"nonce_prefix"`       // nonce 在 redis 中的 key 前缀，默认 signature:nonce
+	MaxBodySize   int64             `mapstructure:"max_body_size" yaml:"max_body_size"`     // 参与签名的最大 body，默认 1MB
+	FailOpen      bool              `mapstructure:"fail_open" yaml:"fail_open"`             // redis 不可用时是否跳过重放检查
+}
+
+// SignatureVerifier 校验 HMAC-SHA256 请求签名，签名规则见 signature.CanonicalString
+// nonce 在 redis 中保存两倍的时间戳偏差时长，时间窗口内重复的 nonce 会被拒绝
+type SignatureVerifier struct {
+	store       SecretStore
+	redis       *redis.Client
+	log         iface.ILogger
+	maxSkew     time.Duration
+	noncePrefix string
+	maxBodySize int64
+	failOpen    bool
+	skipPaths   map[string]bool
+}
+
+// OptionSignatureVerifier 定义配置函数类型
+type OptionSignatureVerifier func(*SignatureVerifier)
+
+// WithSignatureSecretStore 自定义密钥存储，比如 NewDBSecretStore
+func WithSignatureSecretStore(store SecretStore) OptionSignatureVerifier {
+	return func(v *SignatureVerifier) {
+		v.store = store
+	}
+}
+
+// WithSignatureSkipPaths 跳过签名校验的路径
+func WithSignatureSkipPaths(paths ...string) OptionSignatureVerifier {
+	return func(v *SignatureVerifier) {
+		for _, path := range paths {
+			v.skipPaths[path] = true
+		}
+	}
+}
+
+// NewSignatureVerifier 创建请求签名校验中间件，client 用于保存 nonce 防止重放
+func NewSignatureVerifier(config *SignatureConfig, client *redis.Client, logger iface.ILogger, opts ...OptionSignatureVerifier) (*SignatureVerifier, error) {
+	if client == nil {
+		return nil, errors.New("signature verifier requires redis client")
+	}
+	v := &SignatureVerifier{
+		redis:       client,
+		log:         logger,
+		maxSkew:     time.Duration(config.MaxSkewSecond) * time.Second,
+		noncePrefix: config.NoncePrefix,
+		maxBodySize: config.MaxBodySize,
+		failOpen:    config.FailOpen,
+		skipPaths:   make(map[string]bool),
+	}
+	if v.maxSkew <= 0 {
+		v.maxSkew = defaultSignatureMaxSkew
+	}
+	if v.noncePrefix == "" {
+		v.noncePrefix = defaultSignatureNoncePrefix
+	}
+	if v.maxBodySize <= 0 {
+		v.maxBodySize = defaultSignatureMaxBodySize
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	if v.store == nil {
+		if len(config.Secrets) == 0 {
+			return nil, errors.New("signature verifier requires secrets or secret store")
+		}
+		v.store = StaticSecretStore(config.Secrets)
+	}
+	return v, nil
+}
+
+func (v *SignatureVerifier) HandlerFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if v.skipPaths[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+		keyID, err := v.verify(c)
+		if err != nil {
+			v.abort(c, keyID, err)
+			return
+		}
+		c.Set(SignatureKeyIDKey, keyID)
+		c.Next()
+	}
+}
+
+// verify 依次校验请求头、时间戳、签名和 nonce，签名通过后才记录 nonce，避免伪造请求占用 nonce
+func (v *SignatureVerifier) verify(c *gin.Context) (string, error) {
+	keyID := c.GetHeader(signature.HeaderKeyID)
+	timestamp := c.GetHeader(signature.HeaderTimestamp)
+	nonce := c.GetHeader(signature.HeaderNonce)
+	sig := c.GetHeader(signature.HeaderSignature)
+	if keyID == "" || timestamp == "" || nonce == "" || sig == "" || len(nonce) > maxSignatureNonceLength {
+		return keyID, ErrSignatureMissing
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return keyID, ErrSignatureTimestamp
+	}
+	if skew := time.Since(time.Unix(seconds, 0)); skew > v.maxSkew || skew < -v.maxSkew {
+		return keyID, ErrSignatureTimestamp
+	}
+
+	secret, err := v.store.GetSecret(c.Request.Context(), keyID)
+	if err != nil {
+		return keyID, err
+	}
+
+	body, err := v.readBody(c)
+	if err != nil {
+		return keyID, err
+	}
+	canonical := signature.CanonicalString(c.Request.Method, c.Request.URL.EscapedPath(), c.Request.URL.Query(), signature.BodyHash(body), timestamp, nonce)
+	if !signature.Equal(signature.Compute(secret, canonical), sig) {
+		return keyID, ErrSignatureInvalid
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), defaultSignatureRedisTimeout)
+	defer cancel()
+	ok, err := v.redis.SetNX(ctx, v.noncePrefix+":"+keyID+":"+nonce, 1, 2*v.maxSkew).Result()
+	if err != nil {
+		v.log.Error("signature check nonce failed", log.String("key_id", keyID), log.ErrorField(err))
+		if v.failOpen {
+			return keyID, nil
+		}
+		return keyID, err
+	}
+	if !ok {
+		return keyID, ErrSignatureReplayed
+	}
+	return keyID, nil
+}
+
+// readBody 读取 body 计算哈希，并还原给后续的 handler
+func (v *SignatureVerifier) readBody(c *gin.Context) ([]byte, error) {
+	if c.Request.Body == nil || c.Request.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, v.maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > v.maxBodySize {
+		return nil, ErrSignatureBodyTooBig
+	}
+	_ = c.Request.Body.Close()
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+func (v *SignatureVerifier) abort(c *gin.Context, keyID string, err error) {
+	v.log.Debug("signature rejected", log.String("key_id", keyID), log.String("path", c.Request.URL.Path), log.ErrorField(err))
+	switch {
+	case errors.Is(err, ErrSignatureBodyTooBig):
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request_too_large", "message": err.Error()})
+	case errors.Is(err, ErrSignatureMissing), errors.Is(err, ErrSignatureTimestamp), errors.Is(err, ErrSignatureKeyNotFound),
+		errors.Is(err, ErrSignatureInvalid), errors.Is(err, ErrSignatureReplayed):
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized", "message": err.Error()})
+	default:
+		// 查询密钥或 nonce 失败
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "service_unavailable", "message": "unable to verify signature"})
+	}
+}
@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/log/logtest"
+	"github.com/yangkushu/rum-go/redis/redistest"
+	"github.com/yangkushu/rum-go/signature"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSignatureServer(t *testing.T) (*httptest.Server, *miniredis.Miniredis) {
+	client, mr := redistest.New(t)
+	verifier, err := NewSignatureVerifier(&SignatureConfig{
+		Secrets:       map[string]string{"partner": "s3cret"},
+		MaxSkewSecond: 60,
+	}, client, logtest.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.Use(verifier.HandlerFunc())
+	r.POST("/orders", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, c.GetString(SignatureKeyIDKey)+"|"+string(body))
+	})
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return server, mr
+}
+
+func TestSignatureVerifierWithTransport(t *testing.T) {
+	server, _ := newSignatureServer(t)
+	client := &http.Client{Transport: signature.NewTransport("partner", "s3cret", nil)}
+
+	resp, err := client.Post(server.URL+"/orders?b=2&a=1&a=0", "application/json", strings.NewReader(`{"id":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != `partner|{"id":1}` {
+		t.Errorf("expected 200 with body restored, got %d %s", resp.StatusCode, body)
+	}
+
+	client = &http.Client{Transport: signature.NewTransport("partner", "wrong", nil)}
+	resp, err = client.Post(server.URL+"/orders", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 with wrong secret, got %d", resp.StatusCode)
+	}
+}
+
+func TestSignatureVerifierRejects(t *testing.T) {
+	server, mr := newSignatureServer(t)
+
+	signed := func(keyID, secret, body string) *http.Request {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/orders?x=1", strings.NewReader(body))
+		if err := signature.SignRequest(req, keyID, secret); err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+	send := func(req *http.Request) int {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// 同一个请求第二次发送被视为重放
+	req := signed("partner", "s3cret", "a")
+	replay := req.Clone(req.Context())
+	replay.Body, _ = req.GetBody()
+	if code := send(req); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if code := send(replay); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for replay, got %d", code)
+	}
+
+	// 篡改 body
+	req = signed("partner", "s3cret", "a")
+	req.Body = io.NopCloser(strings.NewReader("b"))
+	req.ContentLength = 1
+	if code := send(req); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for tampered body, got %d", code)
+	}
+
+	// 过期的时间戳
+	req = signed("partner", "s3cret", "a")
+	req.Header.Set(signature.HeaderTimestamp, strconv.FormatInt(time.Now().Add(-2*time.Minute).Unix(), 10))
+	if code := send(req); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for stale timestamp, got %d", code)
+	}
+
+	if code := send(signed("unknown", "s3cret", "a")); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for unknown key, got %d", code)
+	}
+
+	req, _ = http.NewRequest(http.MethodPost, server.URL+"/orders", nil)
+	if code := send(req); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without headers, got %d", code)
+	}
+
+	// redis 不可用时默认拒绝
+	mr.Close()
+	if code := send(signed("partner", "s3cret", "a")); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 when redis is down, got %d", code)
+	}
+}
+
+func TestDBSecretStoreBoundedCache(t *testing.T) {
+	// DryRun 不访问数据库，查询结果总是为空
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewDBSecretStore(db, "", 0)
+	ctx := context.Background()
+	for i := 0; i < defaultSecretStoreCacheSize+100; i++ {
+		if _, err := store.GetSecret(ctx, "random-"+strconv.Itoa(i)); !errors.Is(err, ErrSignatureKeyNotFound) {
+			t.Fatalf("expected ErrSignatureKeyNotFound, got %v", err)
+		}
+	}
+	if len(store.cache) != defaultSecretStoreCacheSize || store.order.Len() != defaultSecretStoreCacheSize {
+		t.Fatalf("expected cache bounded to %d, got %d", defaultSecretStoreCacheSize, len(store.cache))
+	}
+	if _, found := store.cache["random-0"]; found {
+		t.Error("expected the oldest key ID evicted")
+	}
+	if _, err := store.GetSecret(ctx, strings.Repeat("k", maxSignatureKeyIDLength+1)); !errors.Is(err, ErrSignatureKeyNotFound) {
+		t.Fatalf("expected overlong key ID rejected, got %v", err)
+	}
+	if len(store.cache) != defaultSecretStoreCacheSize {
+		t.Error("overlong key ID should not be cached")
+	}
+}
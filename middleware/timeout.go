@@ -0,0 +1,240 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/iface"
+	"github.com/yangkushu/rum-go/log"
+	"github.com/yangkushu/rum-go/reqctx"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// RequestTimeoutHeader 传递剩余超时时间（毫秒）的请求头，调用下游时用 PropagateDeadline 设置
+	RequestTimeoutHeader = reqctx.RequestTimeoutHeader
+
+	defaultRequestTimeout     = 5 * time.Second
+	defaultTimeoutMaxBodySize = 1 << 20
+)
+
+// TimeoutConfig 请求超时配置
+type TimeoutConfig struct {
+	TimeoutMs   int            `mapstructure:"timeout_ms" yaml:"timeout_ms"`       // 默认超时时间，默认 5 秒，小于 0 不限制
+	Routes      []TimeoutRoute `mapstructure:"routes" yaml:"routes"`               // 按路由覆盖超时时间
+	TrustHeader bool           `mapstructure:"trust_header" yaml:"trust_header"`   // 使用上游传递的 X-Request-Timeout-Ms，取和配置中较小的一个，只在内部服务之间开启
+	MaxBodySize int64          `mapstructure:"max_body_size" yaml:"max_body_size"` // 缓冲的最大响应大小，超过后直接写出，之后超时不再返回 504，默认 1MB
+}
+
+// TimeoutRoute 路由的超时时间，TimeoutMs 小于 0 表示不限制，比如 SSE、文件下载
+type TimeoutRoute struct {
+	Method    string `mapstructure:"method" yaml:"method"` // 为空或 * 匹配所有方法
+	Path      string `mapstructure:"path" yaml:"path"`     // 路由模板，比如 /users/:id
+	TimeoutMs int    `mapstructure:"timeout_ms" yaml:"timeout_ms"`
+}
+
+// Timeout 请求超时中间件
+// 把带截止时间的 context 设置到 c.Request 上，handler 需要把 c.Request.Context() 传给 gorm（db.WithContext）、
+// redis 和 messagequeue.PublishContext，超时后下游调用会返回 context.DeadlineExceeded。
+// 响应在超时前先缓冲，handler 返回时已经超时则丢弃缓冲的内容，返回 504，不会写出部分响应
+type Timeout struct {
+	timeout     time.Duration
+	routes      map[string]time.Duration
+	trustHeader bool
+	maxBodySize int64
+	log         iface.ILogger
+}
+
+func NewTimeout(config *TimeoutConfig, logger iface.ILogger) *Timeout {
+	t := &Timeout{
+		timeout:     time.Duration(config.TimeoutMs) * time.Millisecond,
+		routes:      make(map[string]time.Duration),
+		trustHeader: config.TrustHeader,
+		maxBodySize: config.MaxBodySize,
+		log:         logger,
+	}
+	if config.TimeoutMs == 0 {
+		t.timeout = defaultRequestTimeout
+	}
+	if t.maxBodySize <= 0 {
+		t.maxBodySize = defaultTimeoutMaxBodySize
+	}
+	for _, route := range config.Routes {
+		method := strings.ToUpper(route.Method)
+		if method == "" {
+			method = "*"
+		}
+		t.routes[method+":"+route.Path] = time.Duration(route.TimeoutMs) * time.Millisecond
+	}
+	return t
+}
+
+// budget 返回请求的超时时间，小于等于 0 表示不限制
+func (t *Timeout) budget(c *gin.Context) time.Duration {
+	timeout := t.timeout
+	if d, ok := t.routes[c.Request.Method+":"+c.FullPath()]; ok {
+		timeout = d
+	} else if d, ok := t.routes["*:"+c.FullPath()]; ok {
+		timeout = d
+	}
+	if t.trustHeader {
+		if ms, err := strconv.Atoi(c.GetHeader(RequestTimeoutHeader)); err == nil && ms > 0 {
+			if upstream := time.Duration(ms) * time.Millisecond; timeout <= 0 || upstream < timeout {
+				timeout = upstream
+			}
+		}
+	}
+	return timeout
+}
+
+func (t *Timeout) HandlerFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeout := t.budget(c)
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		original := c.Writer
+		header := original.Header().Clone()
+		writer := &timeoutWriter{ResponseWriter: original, status: http.StatusOK, limit: t.maxBodySize}
+		c.Writer = writer
+		c.Next()
+		c.Writer = original
+
+		if writer.passthrough || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			writer.flush()
+			return
+		}
+
+		t.log.Warn("request timeout",
+			log.String("method", c.Request.Method),
+			log.String("path", c.Request.URL.Path),
+			log.Int64("timeout_ms", timeout.Milliseconds()),
+			log.String("request_id", GetRequestID(c)),
+		)
+		// 丢弃 handler 设置的响应头
+		responseHeader := original.Header()
+		for name := range responseHeader {
+			if _, found := header[name]; !found {
+				delete(responseHeader, name)
+			}
+		}
+		for name, values := range header {
+			responseHeader[name] = values
+		}
+		c.AbortWithStatusJSON(http.StatusGatewayTimeout, gin.H{"error": "timeout", "message": "request timed out"})
+	}
+}
+
+// Deadline 返回请求的截止时间
+func Deadline(c *gin.Context) (time.Time, bool) {
+	return c.Request.Context().Deadline()
+}
+
+// RemainingBudget 返回 ctx 剩余的时间，没有截止时间时返回 -1，已经超时时返回 0
+func RemainingBudget(ctx context.Context) time.Duration {
+	return reqctx.RemainingBudget(ctx)
+}
+
+// PropagateDeadline 把 ctx 剩余的时间写入下游请求的 X-Request-Timeout-Ms，没有截止时间时不设置
+func PropagateDeadline(ctx context.Context, header http.Header) {
+	reqctx.PropagateDeadline(ctx, header)
+}
+
+// timeoutWriter 先把响应缓冲在内存中，超过 limit 或者调用 Flush 后直接写出
+type timeoutWriter struct {
+	gin.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	limit       int64
+	wroteHeader bool
+	passthrough bool
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	if w.passthrough {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if code > 0 && !w.wroteHeader {
+		w.status = code
+	}
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	if w.passthrough {
+		w.ResponseWriter.WriteHeaderNow()
+		return
+	}
+	w.wroteHeader = true
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	if !w.passthrough && int64(w.body.Len()+len(b)) > w.limit {
+		w.flush()
+	}
+	if w.passthrough {
+		return w.ResponseWriter.Write(b)
+	}
+	w.wroteHeader = true
+	return w.body.Write(b)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *timeoutWriter) Status() int {
+	if w.passthrough {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *timeoutWriter) Size() int {
+	if w.passthrough {
+		return w.ResponseWriter.Size()
+	}
+	if !w.wroteHeader {
+		return -1
+	}
+	return w.body.Len()
+}
+
+func (w *timeoutWriter) Written() bool {
+	if w.passthrough {
+		return w.ResponseWriter.Written()
+	}
+	return w.wroteHeader
+}
+
+func (w *timeoutWriter) Flush() {
+	w.flush()
+	w.ResponseWriter.Flush()
+}
+
+// flush 写出已经缓冲的内容，之后的写入直接透传
+func (w *timeoutWriter) flush() {
+	if w.passthrough {
+		return
+	}
+	w.passthrough = true
+	if !w.wroteHeader {
+		// handler 没有写响应，交给外层的中间件处理
+		if w.status != http.StatusOK {
+			w.ResponseWriter.WriteHeader(w.status)
+		}
+		return
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	_, _ = w.ResponseWriter.Write(w.body.Bytes())
+	w.body.Reset()
+}
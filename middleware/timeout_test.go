@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/log/logtest"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	timeout := NewTimeout(&TimeoutConfig{
+		TimeoutMs: 50,
+		Routes: []TimeoutRoute{
+			{Method: "GET", Path: "/stream", TimeoutMs: -1},
+			{Path: "/report", TimeoutMs: 1000},
+		},
+		TrustHeader: true,
+	}, logtest.New())
+
+	r := gin.New()
+	r.Use(timeout.HandlerFunc())
+	r.GET("/slow", func(c *gin.Context) {
+		c.Header("X-Partial", "1")
+		c.String(http.StatusOK, "partial")
+		// 模拟遵守 context 的下游调用
+		<-c.Request.Context().Done()
+		c.String(http.StatusOK, " more")
+	})
+	r.GET("/fast", func(c *gin.Context) {
+		c.Header("X-Fast", "1")
+		c.String(http.StatusCreated, "ok")
+	})
+	r.GET("/report", func(c *gin.Context) {
+		c.String(http.StatusOK, strconv.FormatInt(RemainingBudget(c.Request.Context()).Milliseconds(), 10))
+	})
+	r.GET("/stream", func(c *gin.Context) {
+		_, ok := Deadline(c)
+		c.String(http.StatusOK, strconv.FormatBool(ok))
+	})
+	serve := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/slow", nil)
+	if w.Code != http.StatusGatewayTimeout || w.Header().Get("X-Partial") != "" {
+		t.Errorf("expected 504 without partial response, got %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+	if w := serve("/fast", nil); w.Code != http.StatusCreated || w.Body.String() != "ok" || w.Header().Get("X-Fast") != "1" {
+		t.Errorf("unexpected fast response %d %s", w.Code, w.Body.String())
+	}
+	if w := serve("/stream", nil); w.Body.String() != "false" {
+		t.Error("expected no deadline for disabled route")
+	}
+	if ms, _ := strconv.Atoi(serve("/report", nil).Body.String()); ms < 900 || ms > 1000 {
+		t.Errorf("expected route budget about 1000ms, got %d", ms)
+	}
+	w = serve("/report", http.Header{RequestTimeoutHeader: {"200"}})
+	if ms, _ := strconv.Atoi(w.Body.String()); ms > 200 || ms < 100 {
+		t.Errorf("expected upstream budget to apply, got %s", w.Body.String())
+	}
+}
+
+func TestPropagateDeadline(t *testing.T) {
+	header := http.Header{}
+	PropagateDeadline(context.Background(), header)
+	if header.Get(RequestTimeoutHeader) != "" {
+		t.Error("expected no header without deadline")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	PropagateDeadline(ctx, header)
+	if ms, _ := strconv.Atoi(header.Get(RequestTimeoutHeader)); ms <= 900 || ms > 1000 {
+		t.Errorf("unexpected propagated budget %q", header.Get(RequestTimeoutHeader))
+	}
+}
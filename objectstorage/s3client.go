@@ -9,6 +9,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/yangkushu/rum-go/httpclient"
 	"io"
 	"net"
 	"net/http"
@@ -46,20 +47,28 @@ func selfDialContext(ctx context.Context, network, address string) (net.Conn, er
 	return (&net.Dialer{}).DialContext(ctx, network, address)
 }
 
-func createCustomHTTPClient() *http.Client {
-	return &http.Client{
-		Transport: &http.Transport{
-			DialContext: (&net.Dialer{
-				Timeout:   30 * time.Second,
-				KeepAlive: 30 * time.Second,
-			}).DialContext,
-			ForceAttemptHTTP2:     true,
-			MaxIdleConns:          100,
-			IdleConnTimeout:       90 * time.Second,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
-		},
+// createCustomHTTPClient 配置了 HTTP 时使用 httpclient 的连接池配置，未配置时保持原来的连接池参数
+func createCustomHTTPClient(c *S3Config) (*http.Client, error) {
+	if c.HTTP == nil {
+		return &http.Client{
+			Transport: &http.Transport{
+				DialContext: (&net.Dialer{
+					Timeout:   30 * time.Second,
+					KeepAlive: 30 * time.Second,
+				}).DialContext,
+				ForceAttemptHTTP2:     true,
+				MaxIdleConns:          100,
+				IdleConnTimeout:       90 * time.Second,
+				TLSHandshakeTimeout:   10 * time.Second,
+				ExpectContinueTimeout: 1 * time.Second,
+			},
+		}, nil
 	}
+	transport, err := httpclient.NewTransport(c.HTTP)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: transport}, nil
 }
 
 type S3Client struct {
@@ -70,12 +79,18 @@ type S3Client struct {
 // NewS3Client 新的构造函数接收S3Config和bucketName
 func NewS3Client(c *S3Config) (IObjectStorage, error) {
 
+	httpClient, err := createCustomHTTPClient(c)
+	if err != nil {
+		return nil, err
+	}
+
 	config := &aws.Config{
 		Credentials:      credentials.NewStaticCredentials(c.AccessKeyID, c.SecretAccessKey, ""),
 		Endpoint:         aws.String(c.Endpoint),
 		Region:           aws.String(c.Region),
 		DisableSSL:       aws.Bool(true),
 		S3ForcePathStyle: aws.Bool(c.ForcePathStyle),
+		HTTPClient:       httpClient,
 	}
 	sess := session.Must(session.NewSession(config))
 	s3Client := s3.New(sess)
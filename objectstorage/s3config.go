@@ -1,10 +1,13 @@
 package objectstorage
 
+import "github.com/yangkushu/rum-go/httpclient"
+
 type S3Config struct {
-	Endpoint        string `mapstructure:"endpoint" yaml:"endpoint"`
-	AccessKeyID     string `mapstructure:"access_key_id" yaml:"access_key_id"`
-	SecretAccessKey string `mapstructure:"secret_access_key" yaml:"secret_access_key"`
-	Bucket          string `mapstructure:"bucket" yaml:"bucket"`
-	Region          string `mapstructure:"region" yaml:"region"`
-	ForcePathStyle  bool   `mapstructure:"force_path_style" yaml:"force_path_style"`
+	Endpoint        string                     `mapstructure:"endpoint" yaml:"endpoint"`
+	AccessKeyID     string                     `mapstructure:"access_key_id" yaml:"access_key_id"`
+	SecretAccessKey string                     `mapstructure:"secret_access_key" yaml:"secret_access_key"`
+	Bucket          string                     `mapstructure:"bucket" yaml:"bucket"`
+	Region          string                     `mapstructure:"region" yaml:"region"`
+	ForcePathStyle  bool                       `mapstructure:"force_path_style" yaml:"force_path_style"`
+	HTTP            *httpclient.UpstreamConfig `mapstructure:"http" yaml:"http"` // 连接池、代理和超时配置，为空时不使用代理，连接超时 30 秒，TLS 握手超时 10 秒
 }
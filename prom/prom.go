@@ -1,10 +1,20 @@
 package prom
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"sync"
+)
 
 type Prom struct {
-	registry *prometheus.Registry
-	metrics  []prometheus.Collector
+	registry    *prometheus.Registry
+	metrics     []prometheus.Collector
+	namespace   string
+	onceRuntime sync.Once
+	runtimeErr  error
 }
 
 func NewProm(collectors []prometheus.Collector) (*Prom, error) {
@@ -20,6 +30,58 @@ func NewProm(collectors []prometheus.Collector) (*Prom, error) {
 	return c, nil
 }
 
+// NewPromWithConfig 创建 Prom，使用配置中的 Namespace 作为内置指标的前缀
+func NewPromWithConfig(config *Config, collectors []prometheus.Collector) (*Prom, error) {
+	p, err := NewProm(collectors)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		p.namespace = config.Namespace
+	}
+	return p, nil
+}
+
 func (p *Prom) Registry() *prometheus.Registry {
 	return p.registry
 }
+
+// Namespace 指标的命名空间
+func (p *Prom) Namespace() string {
+	return p.namespace
+}
+
+// Register 注册指标，已经注册过的同一个指标会被忽略
+func (p *Prom) Register(collectors ...prometheus.Collector) error {
+	for _, collector := range collectors {
+		if err := p.registry.Register(collector); err != nil {
+			var are prometheus.AlreadyRegisteredError
+			if errors.As(err, &are) && are.ExistingCollector == collector {
+				continue
+			}
+			return err
+		}
+		p.metrics = append(p.metrics, collector)
+	}
+	return nil
+}
+
+// RegisterRuntimeCollectors 注册 Go 运行时和进程指标，多次调用只会注册一次
+func (p *Prom) RegisterRuntimeCollectors() error {
+	p.onceRuntime.Do(func() {
+		p.runtimeErr = p.Register(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		)
+	})
+	return p.runtimeErr
+}
+
+// Handler 返回暴露 registry 的 gin 处理函数，会同时注册 Go 运行时和进程指标
+func (p *Prom) Handler() gin.HandlerFunc {
+	_ = p.RegisterRuntimeCollectors()
+	h := promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{Registry: p.registry})
+	return func(c *gin.Context) {
+		h.ServeHTTP(c.Writer, c.Request)
+	}
+}
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const virtualNodesPerWeight = 100
+
+// balancer 负载均衡策略。exclude 是本次请求已经尝试失败的上游，重试时跳过
+type balancer interface {
+	pick(key string, exclude map[*upstream]bool) *upstream
+}
+
+func newBalancer(name string, upstreams []*upstream) (balancer, error) {
+	switch name {
+	case "", BalancerRoundRobin:
+		return newRoundRobin(upstreams), nil
+	case BalancerLeastConn:
+		return &leastConn{upstreams: upstreams}, nil
+	case BalancerConsistentHash:
+		return newConsistentHash(upstreams), nil
+	default:
+		return nil, fmt.Errorf("unknown balancer %q", name)
+	}
+}
+
+func usable(u *upstream, now time.Time, exclude map[*upstream]bool) bool {
+	return !exclude[u] && u.available(now)
+}
+
+// roundRobin 加权轮询，按权重展开后轮询
+type roundRobin struct {
+	slots []*upstream
+	next  atomic.Uint64
+}
+
+func newRoundRobin(upstreams []*upstream) *roundRobin {
+	r := &roundRobin{}
+	for _, u := range upstreams {
+		for i := 0; i < u.weight; i++ {
+			r.slots = append(r.slots, u)
+		}
+	}
+	return r
+}
+
+func (r *roundRobin) pick(_ string, exclude map[*upstream]bool) *upstream {
+	now := time.Now()
+	n := uint64(len(r.slots))
+	start := r.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		if u := r.slots[(start+i)%n]; usable(u, now, exclude) {
+			return u
+		}
+	}
+	return nil
+}
+
+// leastConn 选择正在处理请求最少的上游
+type leastConn struct {
+	upstreams []*upstream
+}
+
+func (l *leastConn) pick(_ string, exclude map[*upstream]bool) *upstream {
+	now := time.Now()
+	var best *upstream
+	for _, u := range l.upstreams {
+		if !usable(u, now, exclude) {
+			continue
+		}
+		if best == nil || u.active.Load() < best.active.Load() {
+			best = u
+		}
+	}
+	return best
+}
+
+// consistentHash 一致性哈希，同一个 key 尽量落到同一个上游，上游不可用时顺延到下一个节点
+type consistentHash struct {
+	hashes []uint32
+	nodes  map[uint32]*upstream
+}
+
+func newConsistentHash(upstreams []*upstream) *consistentHash {
+	c := &consistentHash{nodes: make(map[uint32]*upstream)}
+	for _, u := range upstreams {
+		for i := 0; i < u.weight*virtualNodesPerWeight; i++ {
+			h := crc32.ChecksumIEEE([]byte(u.name + "#" + strconv.Itoa(i)))
+			if _, exists := c.nodes[h]; exists {
+				continue
+			}
+			c.nodes[h] = u
+			c.hashes = append(c.hashes, h)
+		}
+	}
+	sort.Slice(c.hashes, func(i, j int) bool { return c.hashes[i] < c.hashes[j] })
+	return c
+}
+
+func (c *consistentHash) pick(key string, exclude map[*upstream]bool) *upstream {
+	if len(c.hashes) == 0 {
+		return nil
+	}
+	now := time.Now()
+	h := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(len(c.hashes), func(i int) bool { return c.hashes[i] >= h })
+	checked := make(map[*upstream]bool)
+	for i := 0; i < len(c.hashes); i++ {
+		u := c.nodes[c.hashes[(start+i)%len(c.hashes)]]
+		if checked[u] {
+			continue
+		}
+		if usable(u, now, exclude) {
+			return u
+		}
+		checked[u] = true
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package proxy
+
+const (
+	BalancerRoundRobin     = "round_robin"
+	BalancerLeastConn      = "least_conn"
+	BalancerConsistentHash = "consistent_hash"
+)
+
+type Config struct {
+	Upstreams     []UpstreamConfig  `mapstructure:"upstreams" yaml:"upstreams"`
+	Balancer      string            `mapstructure:"balancer" yaml:"balancer"`             // round_robin、least_conn、consistent_hash，默认 round_robin
+	HashKey       string            `mapstructure:"hash_key" yaml:"hash_key"`             // consistent_hash 使用的 key：ip、path、header:<name>，默认 ip
+	Retries       int               `mapstructure:"retries" yaml:"retries"`               // 幂等请求失败后换一个上游重试的次数
+	StripPrefix   string            `mapstructure:"strip_prefix" yaml:"strip_prefix"`     // 转发前去掉的路径前缀，比如 /api/user
+	RewritePrefix string            `mapstructure:"rewrite_prefix" yaml:"rewrite_prefix"` // 去掉前缀后再加上的前缀，比如 /v1
+	AddHeaders    map[string]string `mapstructure:"add_headers" yaml:"add_headers"`       // 转发时添加的请求头
+	RemoveHeaders []string          `mapstructure:"remove_headers" yaml:"remove_headers"` // 转发时删除的请求头
+	TimeoutMs     int               `mapstructure:"timeout_ms" yaml:"timeout_ms"`         // 默认超时时间，单位毫秒，0 不限制
+	RouteTimeouts []RouteTimeout    `mapstructure:"route_timeouts" yaml:"route_timeouts"` // 按路径前缀设置超时时间，最长前缀优先
+	MaxRetryBody  int64             `mapstructure:"max_retry_body" yaml:"max_retry_body"` // 允许重试的最大请求 body，超过时不重试，默认 1MB
+	HealthCheck   *HealthCheck      `mapstructure:"health_check" yaml:"health_check"`     // 主动健康检查，不配置时不检查
+	PassiveHealth *PassiveHealth    `mapstructure:"passive_health" yaml:"passive_health"` // 被动健康检查，不配置时使用默认值
+}
+
+type UpstreamConfig struct {
+	URL    string `mapstructure:"url" yaml:"url"`       // 比如 http://10.0.0.1:8080，可以包含路径前缀
+	Weight int    `mapstructure:"weight" yaml:"weight"` // round_robin 和 consistent_hash 的权重，默认 1
+}
+
+type RouteTimeout struct {
+	PathPrefix string `mapstructure:"path_prefix" yaml:"path_prefix"`
+	TimeoutMs  int    `mapstructure:"timeout_ms" yaml:"timeout_ms"`
+}
+
+type HealthCheck struct {
+	Path               string `mapstructure:"path" yaml:"path"`                               // 健康检查路径，比如 /health
+	IntervalSecond     int    `mapstructure:"interval_second" yaml:"interval_second"`         // 默认 10 秒
+	TimeoutMs          int    `mapstructure:"timeout_ms" yaml:"timeout_ms"`                   // 默认 2000 毫秒
+	HealthyThreshold   int    `mapstructure:"healthy_threshold" yaml:"healthy_threshold"`     // 连续成功多少次后恢复，默认 2
+	UnhealthyThreshold int    `mapstructure:"unhealthy_threshold" yaml:"unhealthy_threshold"` // 连续失败多少次后摘除，默认 3
+}
+
+type PassiveHealth struct {
+	MaxFails          int `mapstructure:"max_fails" yaml:"max_fails"`                     // 连续失败多少次后暂时摘除，默认 3，小于 0 不启用
+	FailTimeoutSecond int `mapstructure:"fail_timeout_second" yaml:"fail_timeout_second"` // 摘除多长时间，默认 30 秒
+}
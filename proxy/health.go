@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"context"
+	"github.com/yangkushu/rum-go/log"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 2 * time.Second
+	defaultHealthyThreshold    = 2
+	defaultUnhealthyThreshold  = 3
+)
+
+// startHealthCheck 启动主动健康检查，定期请求每个上游的健康检查路径
+func (p *Proxy) startHealthCheck(cfg *HealthCheck) {
+	interval := time.Duration(cfg.IntervalSecond) * time.Second
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+	timeout := time.Duration(cfg.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	healthyThreshold := cfg.HealthyThreshold
+	if healthyThreshold <= 0 {
+		healthyThreshold = defaultHealthyThreshold
+	}
+	unhealthyThreshold := cfg.UnhealthyThreshold
+	if unhealthyThreshold <= 0 {
+		unhealthyThreshold = defaultUnhealthyThreshold
+	}
+	client := &http.Client{Transport: p.transport, Timeout: timeout}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			for _, u := range p.upstreams {
+				ok := p.check(client, u, cfg.Path)
+				if u.recordCheck(ok, healthyThreshold, unhealthyThreshold) {
+					p.log.Warn("proxy upstream health changed", log.String("upstream", u.name), log.Bool("healthy", ok))
+					p.setHealthy(u, ok)
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// check 请求一次健康检查路径，2xx 和 3xx 视为健康
+func (p *Proxy) check(client *http.Client, u *upstream, path string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), client.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, joinURLPath(u.url.String(), path), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 400
+}
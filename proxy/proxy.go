@@ -0,0 +1,459 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yangkushu/rum-go/iface"
+	"github.com/yangkushu/rum-go/log"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxRetryBody = 1 << 20
+	defaultMaxFails     = 3
+	defaultFailTimeout  = 30 * time.Second
+)
+
+// ErrNoUpstream 没有可用的上游
+var ErrNoUpstream = errors.New("no available upstream")
+
+type proxyContextKey struct{}
+
+// proxyState 一次请求在 handler 和 RoundTrip 之间传递的状态
+type proxyState struct {
+	ginContext *gin.Context
+	hashKey    string
+	retryable  bool
+}
+
+// Proxy 负载均衡反向代理，支持多个上游、健康检查和幂等请求重试
+type Proxy struct {
+	config       *Config
+	upstreams    []*upstream
+	balancer     balancer
+	transport    http.RoundTripper
+	reverseProxy *httputil.ReverseProxy
+	errorHandler func(c *gin.Context, err error)
+	log          iface.ILogger
+	maxFails     int
+	failTimeout  time.Duration
+	routeTimeout []RouteTimeout // 按前缀长度倒序
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	healthy  *prometheus.GaugeVec
+
+	stop      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// Option 定义配置函数类型
+type Option func(*Proxy)
+
+// WithTransport 设置请求上游使用的 Transport，默认 http.DefaultTransport 的副本
+func WithTransport(transport http.RoundTripper) Option {
+	return func(p *Proxy) {
+		p.transport = transport
+	}
+}
+
+// WithErrorHandler 设置请求上游失败时的处理函数，默认返回 502/504 JSON
+func WithErrorHandler(errorHandler func(c *gin.Context, err error)) Option {
+	return func(p *Proxy) {
+		p.errorHandler = errorHandler
+	}
+}
+
+// WithMetrics 开启每个上游的请求指标，需要将 Collectors() 注册到 prom.Prom
+func WithMetrics(namespace string) Option {
+	return func(p *Proxy) {
+		p.requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "proxy_upstream_requests_total",
+			Help:      "Requests sent to proxy upstreams, partitioned by upstream and status class.",
+		}, []string{"upstream", "status"})
+		p.duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "proxy_upstream_request_duration_seconds",
+			Help:      "Latency of requests sent to proxy upstreams.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"upstream"})
+		p.healthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "proxy_upstream_healthy",
+			Help:      "Whether the proxy upstream is considered healthy (1) or not (0).",
+		}, []string{"upstream"})
+	}
+}
+
+// NewProxy 创建反向代理，配置了主动健康检查时会启动后台检查，使用完需要调用 Close
+func NewProxy(config *Config, logger iface.ILogger, options ...Option) (*Proxy, error) {
+	if len(config.Upstreams) == 0 {
+		return nil, errors.New("proxy upstreams is empty")
+	}
+
+	p := &Proxy{
+		config:      config,
+		log:         logger,
+		transport:   http.DefaultTransport.(*http.Transport).Clone(),
+		maxFails:    defaultMaxFails,
+		failTimeout: defaultFailTimeout,
+		stop:        make(chan struct{}),
+	}
+	p.errorHandler = p.defaultErrorHandler
+	for _, option := range options {
+		option(p)
+	}
+
+	for _, uc := range config.Upstreams {
+		u, err := url.Parse(uc.URL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy upstream url %q", uc.URL)
+		}
+		p.upstreams = append(p.upstreams, newUpstream(u, uc.Weight))
+	}
+
+	var err error
+	if p.balancer, err = newBalancer(config.Balancer, p.upstreams); err != nil {
+		return nil, err
+	}
+
+	if ph := config.PassiveHealth; ph != nil {
+		if ph.MaxFails != 0 {
+			p.maxFails = ph.MaxFails
+		}
+		if ph.FailTimeoutSecond > 0 {
+			p.failTimeout = time.Duration(ph.FailTimeoutSecond) * time.Second
+		}
+	}
+
+	p.routeTimeout = append(p.routeTimeout, config.RouteTimeouts...)
+	sort.Slice(p.routeTimeout, func(i, j int) bool {
+		return len(p.routeTimeout[i].PathPrefix) > len(p.routeTimeout[j].PathPrefix)
+	})
+
+	p.reverseProxy = &httputil.ReverseProxy{
+		Director:     p.director,
+		Transport:    roundTripperFunc(p.roundTrip),
+		ErrorHandler: p.handleError,
+	}
+
+	for _, u := range p.upstreams {
+		p.setHealthy(u, true)
+	}
+	if config.HealthCheck != nil && config.HealthCheck.Path != "" {
+		p.startHealthCheck(config.HealthCheck)
+	}
+
+	p.log.Info("reverse proxy created", log.Any("upstreams", config.Upstreams), log.String("balancer", config.Balancer))
+	return p, nil
+}
+
+func (p *Proxy) HandlerFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if timeout := p.timeout(c.Request.URL.Path); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		state := &proxyState{
+			ginContext: c,
+			hashKey:    p.hashKey(c),
+			retryable:  p.config.Retries > 0 && isIdempotent(c.Request.Method),
+		}
+		req := c.Request.WithContext(context.WithValue(ctx, proxyContextKey{}, state))
+		if state.retryable && !p.bufferBody(req) {
+			state.retryable = false
+		}
+
+		p.reverseProxy.ServeHTTP(c.Writer, req)
+	}
+}
+
+// Collectors 返回代理的指标，未开启指标时返回空
+func (p *Proxy) Collectors() []prometheus.Collector {
+	if p.requests == nil {
+		return nil
+	}
+	return []prometheus.Collector{p.requests, p.duration, p.healthy}
+}
+
+// Close 停止主动健康检查
+func (p *Proxy) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.stop)
+	})
+	p.wg.Wait()
+	return nil
+}
+
+// director 改写路径和请求头，上游地址在 roundTrip 中选择
+func (p *Proxy) director(req *http.Request) {
+	if prefix := p.config.StripPrefix; prefix != "" && strings.HasPrefix(req.URL.Path, prefix) {
+		path := p.config.RewritePrefix + strings.TrimPrefix(req.URL.Path, prefix)
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		req.URL.Path = path
+		req.URL.RawPath = ""
+	}
+	for _, header := range p.config.RemoveHeaders {
+		req.Header.Del(header)
+	}
+	for k, v := range p.config.AddHeaders {
+		req.Header.Set(k, v)
+	}
+	if _, ok := req.Header["User-Agent"]; !ok {
+		// 避免 net/http 添加默认的 User-Agent
+		req.Header.Set("User-Agent", "")
+	}
+}
+
+// roundTrip 选择上游发送请求，幂等请求失败时换一个上游重试
+func (p *Proxy) roundTrip(req *http.Request) (*http.Response, error) {
+	state, _ := req.Context().Value(proxyContextKey{}).(*proxyState)
+	if state == nil {
+		state = &proxyState{}
+	}
+
+	attempts := 1
+	if state.retryable {
+		attempts += p.config.Retries
+	}
+
+	tried := make(map[*upstream]bool)
+	var lastErr error
+	var next *upstream
+	for attempt := 0; attempt < attempts; attempt++ {
+		u := next
+		if u == nil {
+			u = p.balancer.pick(state.hashKey, tried)
+		}
+		next = nil
+		if u == nil {
+			break
+		}
+		tried[u] = true
+
+		outreq := req.Clone(req.Context())
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			outreq.Body = body
+		}
+		outreq.URL.Scheme = u.url.Scheme
+		outreq.URL.Host = u.url.Host
+		outreq.URL.Path = joinURLPath(u.url.Path, req.URL.Path)
+		outreq.URL.RawPath = ""
+		outreq.Host = ""
+
+		start := time.Now()
+		u.active.Add(1)
+		resp, err := p.transport.RoundTrip(outreq)
+		p.observe(u, resp, err, time.Since(start))
+
+		if err != nil {
+			u.active.Add(-1)
+			// 请求被取消或超时不是上游的问题，也不再重试
+			if req.Context().Err() != nil {
+				return nil, err
+			}
+			p.onFailure(u, req, attempt, err)
+			lastErr = err
+			continue
+		}
+
+		if isUpstreamUnavailable(resp.StatusCode) {
+			p.onFailure(u, req, attempt, fmt.Errorf("upstream responded %d", resp.StatusCode))
+			// 有其他上游可以重试时才丢弃这个响应，否则返回上游真实的响应
+			if attempt < attempts-1 {
+				if next = p.balancer.pick(state.hashKey, tried); next != nil {
+					_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+					_ = resp.Body.Close()
+					u.active.Add(-1)
+					lastErr = fmt.Errorf("upstream %s responded %d", u.name, resp.StatusCode)
+					continue
+				}
+			}
+		} else {
+			u.markSuccess()
+		}
+		// 响应 body 读完关闭后才算请求结束，least_conn 依赖这个计数
+		resp.Body = &activeBody{ReadCloser: resp.Body, upstream: u}
+		return resp, nil
+	}
+
+	if lastErr == nil {
+		lastErr = ErrNoUpstream
+	}
+	return nil, lastErr
+}
+
+func (p *Proxy) onFailure(u *upstream, req *http.Request, attempt int, err error) {
+	p.log.Warn("proxy upstream request failed",
+		log.String("upstream", u.name),
+		log.String("method", req.Method),
+		log.String("path", req.URL.Path),
+		log.Int("attempt", attempt+1),
+		log.ErrorField(err),
+	)
+	if u.markFailure(p.maxFails, p.failTimeout) {
+		p.log.Warn("proxy upstream marked down", log.String("upstream", u.name), log.Any("duration", p.failTimeout))
+	}
+}
+
+// handleError ReverseProxy 的错误处理，转交给 errorHandler
+func (p *Proxy) handleError(w http.ResponseWriter, req *http.Request, err error) {
+	state, _ := req.Context().Value(proxyContextKey{}).(*proxyState)
+	if state == nil || state.ginContext == nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	p.errorHandler(state.ginContext, err)
+}
+
+func (p *Proxy) defaultErrorHandler(c *gin.Context, err error) {
+	status := http.StatusBadGateway
+	if errors.Is(err, context.DeadlineExceeded) {
+		status = http.StatusGatewayTimeout
+	} else if errors.Is(err, context.Canceled) {
+		// 客户端已经断开，不需要响应
+		c.Abort()
+		return
+	}
+	p.log.Error("proxy request failed", log.String("path", c.Request.URL.Path), log.Int("status", status), log.ErrorField(err))
+	c.AbortWithStatusJSON(status, gin.H{"error": http.StatusText(status)})
+}
+
+// timeout 按最长前缀匹配超时时间
+func (p *Proxy) timeout(path string) time.Duration {
+	for _, rt := range p.routeTimeout {
+		if strings.HasPrefix(path, rt.PathPrefix) {
+			return time.Duration(rt.TimeoutMs) * time.Millisecond
+		}
+	}
+	return time.Duration(p.config.TimeoutMs) * time.Millisecond
+}
+
+// hashKey consistent_hash 使用的 key
+func (p *Proxy) hashKey(c *gin.Context) string {
+	if p.config.Balancer != BalancerConsistentHash {
+		return ""
+	}
+	key := p.config.HashKey
+	switch {
+	case key == "path":
+		return c.Request.URL.Path
+	case strings.HasPrefix(key, "header:"):
+		return c.GetHeader(strings.TrimPrefix(key, "header:"))
+	default:
+		return c.ClientIP()
+	}
+}
+
+// bufferBody 读取请求 body 以便重试，body 过大时返回 false
+func (p *Proxy) bufferBody(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody {
+		return true
+	}
+	limit := p.config.MaxRetryBody
+	if limit <= 0 {
+		limit = defaultMaxRetryBody
+	}
+	if req.ContentLength > limit {
+		return false
+	}
+	buf, err := io.ReadAll(io.LimitReader(req.Body, limit+1))
+	if err != nil {
+		return false
+	}
+	if int64(len(buf)) > limit {
+		// 未声明长度的 body 超过上限，把已读的部分拼回去，不再重试
+		req.Body = io.NopCloser(io.MultiReader(bytes.NewReader(buf), req.Body))
+		return false
+	}
+	req.Body = io.NopCloser(bytes.NewReader(buf))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf)), nil
+	}
+	return true
+}
+
+func (p *Proxy) observe(u *upstream, resp *http.Response, err error, duration time.Duration) {
+	if p.requests == nil {
+		return
+	}
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode/100) + "xx"
+	}
+	p.requests.WithLabelValues(u.name, status).Inc()
+	p.duration.WithLabelValues(u.name).Observe(duration.Seconds())
+}
+
+func (p *Proxy) setHealthy(u *upstream, healthy bool) {
+	if p.healthy == nil {
+		return
+	}
+	value := 0.0
+	if healthy {
+		value = 1
+	}
+	p.healthy.WithLabelValues(u.name).Set(value)
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func isUpstreamUnavailable(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+func joinURLPath(base, path string) string {
+	if base == "" || base == "/" {
+		return path
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+// activeBody 关闭时减少上游的活跃请求数
+type activeBody struct {
+	io.ReadCloser
+	upstream *upstream
+	once     sync.Once
+}
+
+func (b *activeBody) Close() error {
+	b.once.Do(func() {
+		b.upstream.active.Add(-1)
+	})
+	return b.ReadCloser.Close()
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
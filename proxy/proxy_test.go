@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/log/logtest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newBackend 返回一个在响应中带上自身名字和收到的路径的上游
+func newBackend(t *testing.T, name string) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Header().Set("X-Backend", name)
+		w.Header().Set("X-Path", r.URL.Path)
+		w.Header().Set("X-Tenant", r.Header.Get("X-Tenant"))
+		w.Header().Set("X-Secret", r.Header.Get("X-Secret"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newEngine(t *testing.T, cfg *Config) (*gin.Engine, *logtest.Logger) {
+	logger := logtest.New()
+	p, err := NewProxy(cfg, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = p.Close() })
+	r := gin.New()
+	r.Any("/api/*path", p.HandlerFunc())
+	return r, logger
+}
+
+// closeNotifyRecorder 让 httptest.ResponseRecorder 满足 httputil.ReverseProxy 需要的 http.CloseNotifier
+type closeNotifyRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (closeNotifyRecorder) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func get(r *gin.Engine, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := closeNotifyRecorder{httptest.NewRecorder()}
+	r.ServeHTTP(w, req)
+	return w.ResponseRecorder
+}
+
+func TestProxyRoundRobinAndRewrite(t *testing.T) {
+	a, b := newBackend(t, "a"), newBackend(t, "b")
+	r, _ := newEngine(t, &Config{
+		Upstreams:     []UpstreamConfig{{URL: a.URL}, {URL: b.URL}},
+		StripPrefix:   "/api",
+		RewritePrefix: "/v1",
+		AddHeaders:    map[string]string{"X-Tenant": "rum"},
+		RemoveHeaders: []string{"X-Secret"},
+	})
+
+	seen := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		w := get(r, "/api/users", map[string]string{"X-Secret": "token"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+		if w.Header().Get("X-Path") != "/v1/users" {
+			t.Errorf("expected rewritten path /v1/users, got %q", w.Header().Get("X-Path"))
+		}
+		if w.Header().Get("X-Tenant") != "rum" || w.Header().Get("X-Secret") != "" {
+			t.Errorf("unexpected forwarded headers %v", w.Header())
+		}
+		seen[w.Header().Get("X-Backend")]++
+	}
+	if seen["a"] != 2 || seen["b"] != 2 {
+		t.Errorf("expected requests spread evenly, got %v", seen)
+	}
+}
+
+func TestProxyRetryAndPassiveHealth(t *testing.T) {
+	a := newBackend(t, "a")
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	r, logger := newEngine(t, &Config{
+		Upstreams:     []UpstreamConfig{{URL: down.URL}, {URL: a.URL}},
+		Retries:       1,
+		PassiveHealth: &PassiveHealth{MaxFails: 1, FailTimeoutSecond: 60},
+	})
+
+	for i := 0; i < 3; i++ {
+		w := get(r, "/api/ping", nil)
+		if w.Code != http.StatusOK || w.Header().Get("X-Backend") != "a" {
+			t.Fatalf("request %d: expected 200 from a, got %d %v", i, w.Code, w.Header())
+		}
+	}
+	// 失败一次后被摘除，后续请求不会再打到挂掉的上游
+	logger.AssertCount(t, logtest.LevelWarn, 2)
+	logger.AssertContainsMessage(t, "proxy upstream marked down")
+}
+
+func TestProxyKeepsUnavailableResponseWithoutRetry(t *testing.T) {
+	busy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("maintenance"))
+	}))
+	t.Cleanup(busy.Close)
+
+	// 只有一个上游时没有可以重试的目标，返回上游真实的 503
+	r, _ := newEngine(t, &Config{Upstreams: []UpstreamConfig{{URL: busy.URL}}, Retries: 2})
+	w := get(r, "/api/ping", nil)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "3" || w.Body.String() != "maintenance" {
+		t.Fatalf("expected upstream 503 response, got %d %v %q", w.Code, w.Header(), w.Body.String())
+	}
+}
+
+func TestProxyErrors(t *testing.T) {
+	a := newBackend(t, "a")
+	r, _ := newEngine(t, &Config{
+		Upstreams:     []UpstreamConfig{{URL: a.URL}},
+		StripPrefix:   "/api",
+		RewritePrefix: "/v1",
+		RouteTimeouts: []RouteTimeout{{PathPrefix: "/api/slow", TimeoutMs: 50}},
+	})
+	if w := get(r, "/api/slow", nil); w.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected 504, got %d", w.Code)
+	}
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	r, _ = newEngine(t, &Config{Upstreams: []UpstreamConfig{{URL: down.URL}}})
+	if w := get(r, "/api/ping", nil); w.Code != http.StatusBadGateway {
+		t.Errorf("expected 502, got %d", w.Code)
+	}
+}
+
+func TestProxyConsistentHash(t *testing.T) {
+	a, b, c := newBackend(t, "a"), newBackend(t, "b"), newBackend(t, "c")
+	r, _ := newEngine(t, &Config{
+		Upstreams: []UpstreamConfig{{URL: a.URL}, {URL: b.URL}, {URL: c.URL}},
+		Balancer:  BalancerConsistentHash,
+		HashKey:   "header:X-User",
+	})
+	for _, user := range []string{"u1", "u2", "u3", "u4"} {
+		first := get(r, "/api/ping", map[string]string{"X-User": user}).Header().Get("X-Backend")
+		for i := 0; i < 3; i++ {
+			if got := get(r, "/api/ping", map[string]string{"X-User": user}).Header().Get("X-Backend"); got != first {
+				t.Errorf("user %s: expected sticky upstream %s, got %s", user, first, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// upstream 一个上游服务
+type upstream struct {
+	url    *url.URL
+	name   string // url 的字符串形式，用作日志和指标的标签
+	weight int
+
+	active atomic.Int64 // 正在处理的请求数，least_conn 使用
+
+	mu          sync.Mutex
+	checkFailed bool      // 主动健康检查的结果
+	checkStreak int       // 主动健康检查连续成功或失败的次数，正数为成功，负数为失败
+	fails       int       // 被动健康检查连续失败次数
+	downUntil   time.Time // 被动健康检查摘除的截止时间
+}
+
+func newUpstream(u *url.URL, weight int) *upstream {
+	if weight <= 0 {
+		weight = 1
+	}
+	return &upstream{url: u, name: u.String(), weight: weight}
+}
+
+// available 上游是否可以接收请求
+func (u *upstream) available(now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return !u.checkFailed && !now.Before(u.downUntil)
+}
+
+// markSuccess 请求成功，清空被动健康检查的失败计数
+func (u *upstream) markSuccess() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.fails = 0
+}
+
+// markFailure 请求失败，连续失败达到 maxFails 次后摘除 failTimeout，返回是否被摘除
+func (u *upstream) markFailure(maxFails int, failTimeout time.Duration) bool {
+	if maxFails <= 0 {
+		return false
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.fails++
+	if u.fails < maxFails {
+		return false
+	}
+	u.fails = 0
+	u.downUntil = time.Now().Add(failTimeout)
+	return true
+}
+
+// recordCheck 记录一次主动健康检查的结果，返回健康状态是否发生变化
+func (u *upstream) recordCheck(ok bool, healthyThreshold, unhealthyThreshold int) (changed bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if ok {
+		if u.checkStreak < 0 {
+			u.checkStreak = 0
+		}
+		u.checkStreak++
+		if u.checkFailed && u.checkStreak >= healthyThreshold {
+			u.checkFailed = false
+			return true
+		}
+		return false
+	}
+	if u.checkStreak > 0 {
+		u.checkStreak = 0
+	}
+	u.checkStreak--
+	if !u.checkFailed && -u.checkStreak >= unhealthyThreshold {
+		u.checkFailed = true
+		return true
+	}
+	return false
+}
@@ -0,0 +1,17 @@
+package redistest
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	goRedis "github.com/redis/go-redis/v9"
+	"github.com/yangkushu/rum-go/redis"
+	"testing"
+)
+
+// New 启动 miniredis 并返回连接它的客户端，测试结束时关闭
+func New(t testing.TB) (*redis.Client, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := &redis.Client{UniversalClient: goRedis.NewClient(&goRedis.Options{Addr: mr.Addr()})}
+	t.Cleanup(func() { _ = client.Close() })
+	return client, mr
+}
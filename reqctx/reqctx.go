@@ -0,0 +1,86 @@
+// Package reqctx 跨服务传递的请求上下文：请求ID、剩余超时时间和幂等键。
+// 只依赖标准库，middleware 在接收请求时写入，httpclient 等客户端在外发请求时读取
+package reqctx
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// RequestIDHeader 默认的请求ID请求头
+	RequestIDHeader = "X-Request-Id"
+	// RequestTimeoutHeader 传递剩余超时时间（毫秒）的请求头，调用下游时用 PropagateDeadline 设置
+	RequestTimeoutHeader = "X-Request-Timeout-Ms"
+	// IdempotencyKeyHeader 默认的幂等键请求头
+	IdempotencyKeyHeader = "Idempotency-Key"
+)
+
+type requestIDContextKey struct{}
+
+// requestIDValue context 中保存的请求ID和它使用的请求头，外发请求时使用同一个请求头
+type requestIDValue struct {
+	id     string
+	header string
+}
+
+// ContextWithRequestID 将请求ID保存到 context.Context 中，外发请求使用默认的 X-Request-Id 请求头
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return ContextWithRequestIDHeader(ctx, id, RequestIDHeader)
+}
+
+// ContextWithRequestIDHeader 将请求ID和接收时使用的请求头保存到 context.Context 中
+func ContextWithRequestIDHeader(ctx context.Context, id, header string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, requestIDValue{id: id, header: header})
+}
+
+// RequestIDFromContext 从 context.Context 中获取请求ID
+func RequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	v, _ := ctx.Value(requestIDContextKey{}).(requestIDValue)
+	return v.id
+}
+
+// RequestIDHeaderFromContext 返回 ctx 中的请求ID使用的请求头，没有时返回 X-Request-Id
+func RequestIDHeaderFromContext(ctx context.Context) string {
+	if ctx != nil {
+		if v, ok := ctx.Value(requestIDContextKey{}).(requestIDValue); ok && v.header != "" {
+			return v.header
+		}
+	}
+	return RequestIDHeader
+}
+
+// InjectRequestID 将 ctx 中的请求ID写入外发请求的请求头，用于跨服务传递，请求头和接收时的一致
+func InjectRequestID(ctx context.Context, req *http.Request) {
+	if id := RequestIDFromContext(ctx); id != "" {
+		req.Header.Set(RequestIDHeaderFromContext(ctx), id)
+	}
+}
+
+// RemainingBudget 返回 ctx 剩余的时间，没有截止时间时返回 -1，已经超时时返回 0
+func RemainingBudget(ctx context.Context) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return -1
+	}
+	if remaining := time.Until(deadline); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// PropagateDeadline 把 ctx 剩余的时间写入下游请求的 X-Request-Timeout-Ms，没有截止时间时不设置
+func PropagateDeadline(ctx context.Context, header http.Header) {
+	if remaining := RemainingBudget(ctx); remaining >= 0 {
+		ms := remaining.Milliseconds()
+		if ms == 0 && remaining > 0 {
+			ms = 1
+		}
+		header.Set(RequestTimeoutHeader, strconv.FormatInt(ms, 10))
+	}
+}
@@ -0,0 +1,48 @@
+package reqctx
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestInjectRequestID(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	InjectRequestID(context.Background(), req)
+	if len(req.Header) != 0 {
+		t.Fatalf("unexpected headers %v", req.Header)
+	}
+
+	InjectRequestID(ContextWithRequestID(context.Background(), "req-1"), req)
+	if req.Header.Get(RequestIDHeader) != "req-1" {
+		t.Fatalf("unexpected headers %v", req.Header)
+	}
+
+	req.Header = http.Header{}
+	ctx := ContextWithRequestIDHeader(context.Background(), "req-2", "X-Trace")
+	InjectRequestID(ctx, req)
+	if req.Header.Get("X-Trace") != "req-2" || req.Header.Get(RequestIDHeader) != "" {
+		t.Fatalf("unexpected headers %v", req.Header)
+	}
+	if RequestIDFromContext(ctx) != "req-2" || RequestIDHeaderFromContext(ctx) != "X-Trace" {
+		t.Fatal("request id not stored in context")
+	}
+}
+
+func TestPropagateDeadline(t *testing.T) {
+	header := http.Header{}
+	PropagateDeadline(context.Background(), header)
+	if header.Get(RequestTimeoutHeader) != "" {
+		t.Fatal("header should not be set without a deadline")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	PropagateDeadline(ctx, header)
+	ms, err := strconv.Atoi(header.Get(RequestTimeoutHeader))
+	if err != nil || ms <= 0 || ms > 1000 {
+		t.Fatalf("unexpected timeout header %q", header.Get(RequestTimeoutHeader))
+	}
+}
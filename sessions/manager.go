@@ -0,0 +1,316 @@
+package sessions
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/iface"
+	"github.com/yangkushu/rum-go/log"
+	"github.com/yangkushu/rum-go/utils"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCookieName      = "session_id"
+	defaultIdleTimeout     = 30 * time.Minute
+	defaultAbsoluteTimeout = 24 * time.Hour
+	// 距离上次访问超过这个时间才刷新过期时间，避免每个请求都写存储
+	touchInterval = time.Minute
+	csrfValueKey  = "_csrf_token"
+)
+
+// Config 会话配置
+type Config struct {
+	Secret                string `mapstructure:"secret" yaml:"secret"`                                   // 对 cookie 中的会话ID签名，必填
+	EncryptionKey         string `mapstructure:"encryption_key" yaml:"encryption_key"`                   // 不为空时使用 AES 加密 cookie，长度为 16、24 或 32
+	CookieName            string `mapstructure:"cookie_name" yaml:"cookie_name"`                         // 默认 session_id
+	CookieDomain          string `mapstructure:"cookie_domain" yaml:"cookie_domain"`                     // cookie 的域名
+	CookiePath            string `mapstructure:"cookie_path" yaml:"cookie_path"`                         // cookie 的路径，默认 /
+	CookieSecure          bool   `mapstructure:"cookie_secure" yaml:"cookie_secure"`                     // 只通过 HTTPS 发送 cookie
+	CookieSameSite        string `mapstructure:"cookie_same_site" yaml:"cookie_same_site"`               // lax、strict、none，默认 lax
+	IdleTimeoutSecond     int    `mapstructure:"idle_timeout_second" yaml:"idle_timeout_second"`         // 滑动过期时间，超过这个时间没有访问会话失效，默认 30 分钟
+	AbsoluteTimeoutSecond int    `mapstructure:"absolute_timeout_second" yaml:"absolute_timeout_second"` // 绝对过期时间，从创建开始计算，默认 24 小时
+}
+
+// Manager 会话管理，HandlerFunc 从 cookie 中加载会话，请求结束后保存修改
+type Manager struct {
+	config          Config
+	store           Store
+	log             iface.ILogger
+	secret          []byte
+	encryptionKey   []byte
+	sameSite        http.SameSite
+	idleTimeout     time.Duration
+	absoluteTimeout time.Duration
+	now             func() time.Time
+}
+
+// NewManager 创建会话管理
+func NewManager(config *Config, store Store, logger iface.ILogger) (*Manager, error) {
+	m := &Manager{
+		config:          *config,
+		store:           store,
+		log:             logger,
+		secret:          []byte(config.Secret),
+		encryptionKey:   []byte(config.EncryptionKey),
+		idleTimeout:     time.Duration(config.IdleTimeoutSecond) * time.Second,
+		absoluteTimeout: time.Duration(config.AbsoluteTimeoutSecond) * time.Second,
+		now:             time.Now,
+	}
+	if len(m.secret) == 0 {
+		return nil, errors.New("session secret is empty")
+	}
+	switch len(m.encryptionKey) {
+	case 0, 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("session encryption key must be 16, 24 or 32 bytes, got %d", len(m.encryptionKey))
+	}
+	if m.config.CookieName == "" {
+		m.config.CookieName = defaultCookieName
+	}
+	if m.config.CookiePath == "" {
+		m.config.CookiePath = "/"
+	}
+	switch strings.ToLower(m.config.CookieSameSite) {
+	case "", "lax":
+		m.sameSite = http.SameSiteLaxMode
+	case "strict":
+		m.sameSite = http.SameSiteStrictMode
+	case "none":
+		m.sameSite = http.SameSiteNoneMode
+	default:
+		return nil, fmt.Errorf("invalid session cookie same site %q", m.config.CookieSameSite)
+	}
+	if m.idleTimeout <= 0 {
+		m.idleTimeout = defaultIdleTimeout
+	}
+	if m.absoluteTimeout <= 0 {
+		m.absoluteTimeout = defaultAbsoluteTimeout
+	}
+	return m, nil
+}
+
+func (m *Manager) HandlerFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s := &Session{manager: m, c: c}
+		if id := m.readCookie(c); id != "" {
+			record, err := m.store.Load(c.Request.Context(), id)
+			switch {
+			case err == nil && m.expired(record):
+				_ = m.store.Delete(c.Request.Context(), id)
+			case err == nil:
+				s.record = record
+			case err != ErrNotFound:
+				// 存储不可用时按未登录处理，不覆盖原来的会话
+				m.log.Error("session load failed", log.ErrorField(err))
+			}
+		}
+		c.Set(ContextKey, s)
+
+		c.Next()
+
+		m.save(c.Request.Context(), s)
+	}
+}
+
+// expired 检查绝对过期和滑动过期
+func (m *Manager) expired(record *Record) bool {
+	now := m.now()
+	return now.Sub(record.CreatedAt) >= m.absoluteTimeout || now.Sub(record.LastAccess) >= m.idleTimeout
+}
+
+func (m *Manager) save(ctx context.Context, s *Session) {
+	if s.record == nil || s.deleted {
+		return
+	}
+	now := m.now()
+	if !s.dirty && now.Sub(s.record.LastAccess) < touchInterval {
+		return
+	}
+	s.record.LastAccess = now
+	if err := m.store.Save(ctx, s.record, m.ttl(s.record)); err != nil {
+		m.log.Error("session save failed", log.ErrorField(err), log.String("user_id", s.record.UserID))
+	}
+}
+
+// ttl 取滑动过期和绝对过期中较早的一个
+func (m *Manager) ttl(record *Record) time.Duration {
+	ttl := m.idleTimeout
+	if remaining := record.CreatedAt.Add(m.absoluteTimeout).Sub(m.now()); remaining < ttl {
+		ttl = remaining
+	}
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	return ttl
+}
+
+// Login 登录后调用，更换会话ID防止会话固定攻击，保留会话中的值并写入用户ID
+func (m *Manager) Login(c *gin.Context, userID string) error {
+	s := Get(c)
+	if s == nil {
+		return errors.New("session middleware is not installed")
+	}
+	oldID := s.ID()
+	s.ensure()
+	if oldID != "" {
+		if err := m.store.Delete(c.Request.Context(), oldID); err != nil {
+			return err
+		}
+		now := m.now()
+		s.record.ID = newSessionID()
+		s.record.CreatedAt = now
+		s.record.LastAccess = now
+		m.setCookie(c, s.record.ID)
+	}
+	s.record.UserID = userID
+	s.dirty = true
+	// 立即保存，登录接口返回后客户端可能马上发起其他请求
+	return m.store.Save(c.Request.Context(), s.record, m.ttl(s.record))
+}
+
+// Destroy 删除当前会话并清除 cookie，用于退出登录
+func (m *Manager) Destroy(c *gin.Context) error {
+	s := Get(c)
+	if s == nil || s.record == nil {
+		return nil
+	}
+	s.deleted = true
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     m.config.CookieName,
+		Value:    "",
+		Path:     m.config.CookiePath,
+		Domain:   m.config.CookieDomain,
+		MaxAge:   -1,
+		Secure:   m.config.CookieSecure,
+		HttpOnly: true,
+		SameSite: m.sameSite,
+	})
+	return m.store.Delete(c.Request.Context(), s.record.ID)
+}
+
+// UserSessions 返回用户所有有效的会话
+func (m *Manager) UserSessions(ctx context.Context, userID string) ([]*Record, error) {
+	ids, err := m.store.UserSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]*Record, 0, len(ids))
+	for _, id := range ids {
+		record, err := m.store.Load(ctx, id)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !m.expired(record) {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// Revoke 删除指定的会话，比如在会话列表中踢出某个设备
+func (m *Manager) Revoke(ctx context.Context, id string) error {
+	return m.store.Delete(ctx, id)
+}
+
+// RevokeUser 删除用户的所有会话，比如修改密码后，返回删除的数量
+func (m *Manager) RevokeUser(ctx context.Context, userID string) (int, error) {
+	ids, err := m.store.UserSessions(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	for i, id := range ids {
+		if err := m.store.Delete(ctx, id); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+// Token 实现 middleware.CSRFTokenStore，CSRF token 保存在会话中
+func (m *Manager) Token(c *gin.Context) (string, error) {
+	s := Get(c)
+	if s == nil {
+		return "", nil
+	}
+	return s.GetString(csrfValueKey), nil
+}
+
+// SaveToken 实现 middleware.CSRFTokenStore，token 跟随会话过期，忽略 ttl
+func (m *Manager) SaveToken(c *gin.Context, token string, ttl time.Duration) error {
+	s := Get(c)
+	if s == nil {
+		return errors.New("session middleware is not installed")
+	}
+	return s.Set(csrfValueKey, token)
+}
+
+// setCookie cookie 的值为 会话ID.签名，配置了加密密钥时整体再用 AES 加密
+func (m *Manager) setCookie(c *gin.Context, id string) {
+	value := id + "." + base64.RawURLEncoding.EncodeToString(m.sign(id))
+	if len(m.encryptionKey) > 0 {
+		encrypted, err := utils.AesEncrypt(value, m.encryptionKey)
+		if err != nil {
+			m.log.Error("session encrypt cookie failed", log.ErrorField(err))
+			return
+		}
+		value = encrypted
+	}
+	// 和 gin 的 SetCookie 一样转义，c.Cookie 读取时会反转义
+	value = url.QueryEscape(value)
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     m.config.CookieName,
+		Value:    value,
+		Path:     m.config.CookiePath,
+		Domain:   m.config.CookieDomain,
+		MaxAge:   int(m.absoluteTimeout / time.Second),
+		Secure:   m.config.CookieSecure,
+		HttpOnly: true,
+		SameSite: m.sameSite,
+	})
+}
+
+// readCookie 返回签名有效的会话ID
+func (m *Manager) readCookie(c *gin.Context) string {
+	value, err := c.Cookie(m.config.CookieName)
+	if err != nil || value == "" {
+		return ""
+	}
+	if len(m.encryptionKey) > 0 {
+		if value, err = utils.AesDecrypt(value, m.encryptionKey); err != nil {
+			return ""
+		}
+	}
+	id, signature, found := strings.Cut(value, ".")
+	if !found {
+		return ""
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, m.sign(id)) {
+		return ""
+	}
+	return id
+}
+
+func (m *Manager) sign(id string) []byte {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(id))
+	return mac.Sum(nil)
+}
+
+func newSessionID() string {
+	buf := make([]byte, 32)
+	_, _ = rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
@@ -0,0 +1,108 @@
+package sessions
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	data      []byte
+	userID    string
+	expiresAt time.Time
+}
+
+// MemoryStore 内存会话存储，用于测试和单机开发
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]*memoryEntry
+	users    map[string]map[string]struct{}
+	now      func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: make(map[string]*memoryEntry),
+		users:    make(map[string]map[string]struct{}),
+		now:      time.Now,
+	}
+}
+
+// loadLocked 返回未过期的会话，过期的会话会被删除
+func (s *MemoryStore) loadLocked(id string) *memoryEntry {
+	entry, found := s.sessions[id]
+	if !found {
+		return nil
+	}
+	if !s.now().Before(entry.expiresAt) {
+		s.deleteLocked(id)
+		return nil
+	}
+	return entry
+}
+
+func (s *MemoryStore) deleteLocked(id string) {
+	entry, found := s.sessions[id]
+	if !found {
+		return
+	}
+	delete(s.sessions, id)
+	if ids := s.users[entry.userID]; ids != nil {
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(s.users, entry.userID)
+		}
+	}
+}
+
+func (s *MemoryStore) Load(ctx context.Context, id string) (*Record, error) {
+	s.mu.Lock()
+	entry := s.loadLocked(id)
+	s.mu.Unlock()
+	if entry == nil {
+		return nil, ErrNotFound
+	}
+	record := &Record{}
+	if err := json.Unmarshal(entry.data, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s *MemoryStore) Save(ctx context.Context, record *Record, ttl time.Duration) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteLocked(record.ID)
+	s.sessions[record.ID] = &memoryEntry{data: data, userID: record.UserID, expiresAt: s.now().Add(ttl)}
+	if record.UserID != "" {
+		if s.users[record.UserID] == nil {
+			s.users[record.UserID] = make(map[string]struct{})
+		}
+		s.users[record.UserID][record.ID] = struct{}{}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteLocked(id)
+	return nil
+}
+
+func (s *MemoryStore) UserSessions(ctx context.Context, userID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.users[userID]))
+	for id := range s.users[userID] {
+		if s.loadLocked(id) != nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,107 @@
+package sessions
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/yangkushu/rum-go/redis"
+	"time"
+)
+
+const defaultRedisPrefix = "session:"
+
+// RedisStore 把会话保存在 redis 中
+// 会话为 <prefix><id>，用户索引为 <prefix>user:<userID> 集合。
+// 所有命令都只操作单个 key，不依赖事务和多 key 脚本，可以在集群模式下使用。需要 redis 7.0 以上（EXPIRE GT/NX）
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore 创建 redis 会话存储，prefix 为空时使用 session:
+func NewRedisStore(client *redis.Client, prefix string) *RedisStore {
+	if prefix == "" {
+		prefix = defaultRedisPrefix
+	}
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+func (s *RedisStore) sessionKey(id string) string {
+	return s.prefix + id
+}
+
+func (s *RedisStore) userKey(userID string) string {
+	return s.prefix + "user:" + userID
+}
+
+func (s *RedisStore) Load(ctx context.Context, id string) (*Record, error) {
+	data, err := s.client.Get(ctx, s.sessionKey(id)).Bytes()
+	if redis.IsKeyNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	record := &Record{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s *RedisStore) Save(ctx context.Context, record *Record, ttl time.Duration) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := s.client.Set(ctx, s.sessionKey(record.ID), data, ttl).Err(); err != nil {
+		return err
+	}
+	if record.UserID == "" {
+		return nil
+	}
+	// 索引的过期时间只延长不缩短，保证不早于用户任何一个会话过期，过期的 ID 在 UserSessions 中清理
+	userKey := s.userKey(record.UserID)
+	pipe := s.client.Pipeline()
+	pipe.SAdd(ctx, userKey, record.ID)
+	pipe.ExpireNX(ctx, userKey, ttl)
+	pipe.ExpireGT(ctx, userKey, ttl)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *RedisStore) Delete(ctx context.Context, id string) error {
+	record, err := s.Load(ctx, id)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.client.Del(ctx, s.sessionKey(id)).Err(); err != nil {
+		return err
+	}
+	if record.UserID != "" {
+		return s.client.SRem(ctx, s.userKey(record.UserID), id).Err()
+	}
+	return nil
+}
+
+func (s *RedisStore) UserSessions(ctx context.Context, userID string) ([]string, error) {
+	ids, err := s.client.SMembers(ctx, s.userKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	valid := make([]string, 0, len(ids))
+	for _, id := range ids {
+		n, err := s.client.Exists(ctx, s.sessionKey(id)).Result()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			s.client.SRem(ctx, s.userKey(userID), id)
+			continue
+		}
+		valid = append(valid, id)
+	}
+	return valid, nil
+}
@@ -0,0 +1,131 @@
+package sessions
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"time"
+)
+
+// ContextKey gin.Context 中保存当前会话的 key
+const ContextKey = "session"
+
+// Session 当前请求的会话，值以 JSON 保存，请求结束后有修改时写回存储
+type Session struct {
+	manager *Manager
+	c       *gin.Context
+	record  *Record
+	isNew   bool
+	dirty   bool
+	deleted bool
+}
+
+// Get 获取当前请求的会话，没有使用 Manager 中间件时返回 nil
+func Get(c *gin.Context) *Session {
+	if v, ok := c.Get(ContextKey); ok {
+		if s, ok := v.(*Session); ok {
+			return s
+		}
+	}
+	return nil
+}
+
+// ID 会话ID，新会话在第一次写入前为空
+func (s *Session) ID() string {
+	if s.record == nil {
+		return ""
+	}
+	return s.record.ID
+}
+
+// UserID 登录用户的ID，未登录时为空
+func (s *Session) UserID() string {
+	if s.record == nil {
+		return ""
+	}
+	return s.record.UserID
+}
+
+// CreatedAt 会话创建时间
+func (s *Session) CreatedAt() time.Time {
+	if s.record == nil {
+		return time.Time{}
+	}
+	return s.record.CreatedAt
+}
+
+// Get 把 key 对应的值解析到 out，返回是否存在
+func (s *Session) Get(key string, out interface{}) (bool, error) {
+	if s.record == nil {
+		return false, nil
+	}
+	data, found := s.record.Values[key]
+	if !found {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, out)
+}
+
+// GetString 获取字符串，不存在或者类型不匹配时返回空字符串
+func (s *Session) GetString(key string) string {
+	var v string
+	_, _ = s.Get(key, &v)
+	return v
+}
+
+// GetInt64 获取整数，不存在或者类型不匹配时返回 0
+func (s *Session) GetInt64(key string) int64 {
+	var v int64
+	_, _ = s.Get(key, &v)
+	return v
+}
+
+// GetBool 获取布尔值，不存在或者类型不匹配时返回 false
+func (s *Session) GetBool(key string) bool {
+	var v bool
+	_, _ = s.Get(key, &v)
+	return v
+}
+
+// Set 保存值，value 需要能被 JSON 序列化。新会话在第一次写入时创建并下发 cookie
+func (s *Session) Set(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	s.ensure()
+	s.record.Values[key] = data
+	s.dirty = true
+	return nil
+}
+
+// Delete 删除值
+func (s *Session) Delete(key string) {
+	if s.record == nil {
+		return
+	}
+	if _, found := s.record.Values[key]; found {
+		delete(s.record.Values, key)
+		s.dirty = true
+	}
+}
+
+// Clear 删除所有值，保留会话和登录状态
+func (s *Session) Clear() {
+	if s.record == nil || len(s.record.Values) == 0 {
+		return
+	}
+	s.record.Values = make(map[string]json.RawMessage)
+	s.dirty = true
+}
+
+// ensure 创建新会话
+func (s *Session) ensure() {
+	if s.record != nil {
+		return
+	}
+	now := s.manager.now()
+	s.record = &Record{ID: newSessionID(), Values: make(map[string]json.RawMessage), CreatedAt: now, LastAccess: now}
+	s.isNew = true
+	s.dirty = true
+	s.manager.setCookie(s.c, s.record.ID)
+}
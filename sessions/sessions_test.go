@@ -0,0 +1,261 @@
+package sessions
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/gin-gonic/gin"
+	"github.com/yangkushu/rum-go/log/logtest"
+	"github.com/yangkushu/rum-go/redis/redistest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+type cart struct {
+	Items []string `json:"items"`
+}
+
+func newTestEngine(t *testing.T, m *Manager) *gin.Engine {
+	t.Helper()
+	r := gin.New()
+	r.Use(m.HandlerFunc())
+	r.POST("/cart/:item", func(c *gin.Context) {
+		s := Get(c)
+		var v cart
+		if _, err := s.Get("cart", &v); err != nil {
+			t.Error(err)
+		}
+		v.Items = append(v.Items, c.Param("item"))
+		if err := s.Set("cart", v); err != nil {
+			t.Error(err)
+		}
+		_ = s.Set("visits", s.GetInt64("visits")+1)
+	})
+	r.GET("/cart", func(c *gin.Context) {
+		s := Get(c)
+		var v cart
+		_, _ = s.Get("cart", &v)
+		c.String(http.StatusOK, "%s|%s|%d", s.UserID(), strings.Join(v.Items, ","), s.GetInt64("visits"))
+	})
+	r.POST("/login/:user", func(c *gin.Context) {
+		if err := m.Login(c, c.Param("user")); err != nil {
+			t.Error(err)
+		}
+	})
+	r.POST("/logout", func(c *gin.Context) {
+		if err := m.Destroy(c); err != nil {
+			t.Error(err)
+		}
+	})
+	return r
+}
+
+// client 模拟浏览器保存 cookie
+type client struct {
+	r      *gin.Engine
+	cookie *http.Cookie
+}
+
+func (cl *client) do(method, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if cl.cookie != nil {
+		req.AddCookie(cl.cookie)
+	}
+	w := httptest.NewRecorder()
+	cl.r.ServeHTTP(w, req)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == defaultCookieName {
+			if cookie.MaxAge < 0 {
+				cl.cookie = nil
+			} else {
+				cl.cookie = cookie
+			}
+		}
+	}
+	return w
+}
+
+func testSessionFlow(t *testing.T, store Store, config *Config) {
+	m, err := NewManager(config, store, logtest.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := &client{r: newTestEngine(t, m)}
+
+	if w := cl.do(http.MethodGet, "/cart"); cl.cookie != nil || w.Body.String() != "||0" {
+		t.Fatalf("reading an empty session should not create one, got %q", w.Body.String())
+	}
+	cl.do(http.MethodPost, "/cart/apple")
+	cl.do(http.MethodPost, "/cart/pear")
+	if w := cl.do(http.MethodGet, "/cart"); w.Body.String() != "|apple,pear|2" {
+		t.Fatalf("unexpected session values %q", w.Body.String())
+	}
+	if !cl.cookie.HttpOnly || strings.Contains(cl.cookie.Value, m.readCookieID(cl.cookie.Value)) == (config.EncryptionKey != "") {
+		t.Errorf("unexpected cookie %+v", cl.cookie)
+	}
+
+	// 登录后更换会话ID，保留购物车
+	anonymous := cl.cookie
+	cl.do(http.MethodPost, "/login/u1")
+	if cl.cookie.Value == anonymous.Value {
+		t.Fatal("expected session id rotation on login")
+	}
+	if w := cl.do(http.MethodGet, "/cart"); w.Body.String() != "u1|apple,pear|2" {
+		t.Errorf("unexpected session after login %q", w.Body.String())
+	}
+	old := &client{r: cl.r, cookie: anonymous}
+	if w := old.do(http.MethodGet, "/cart"); w.Body.String() != "||0" {
+		t.Errorf("old session id should be invalid, got %q", w.Body.String())
+	}
+
+	// 另一个设备登录同一个用户
+	other := &client{r: cl.r}
+	other.do(http.MethodPost, "/login/u1")
+	ctx := context.Background()
+	records, err := m.UserSessions(ctx, "u1")
+	if err != nil || len(records) != 2 {
+		t.Fatalf("expected 2 sessions, got %d %v", len(records), err)
+	}
+
+	cl.do(http.MethodPost, "/logout")
+	if cl.cookie != nil {
+		t.Error("expected cookie to be cleared on logout")
+	}
+	if records, _ := m.UserSessions(ctx, "u1"); len(records) != 1 {
+		t.Errorf("expected 1 session after logout, got %d", len(records))
+	}
+	if n, err := m.RevokeUser(ctx, "u1"); err != nil || n != 1 {
+		t.Errorf("expected 1 revoked session, got %d %v", n, err)
+	}
+	if w := other.do(http.MethodGet, "/cart"); w.Body.String() != "||0" {
+		t.Errorf("revoked session should be invalid, got %q", w.Body.String())
+	}
+}
+
+// readCookieID 测试中解析 cookie 中的会话ID
+func (m *Manager) readCookieID(value string) string {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	c.Request.AddCookie(&http.Cookie{Name: m.config.CookieName, Value: value})
+	return m.readCookie(c)
+}
+
+func TestSessionsMemoryStore(t *testing.T) {
+	testSessionFlow(t, NewMemoryStore(), &Config{Secret: "secret"})
+}
+
+func TestSessionsRedisStore(t *testing.T) {
+	client, mr := redistest.New(t)
+	testSessionFlow(t, NewRedisStore(client, "test:session:"), &Config{Secret: "secret", EncryptionKey: "0123456789abcdef0123456789abcdef"})
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Errorf("expected all session keys to be removed, got %v", keys)
+	}
+}
+
+func TestSessionsRedisStoreUserIndexTTL(t *testing.T) {
+	client, mr := redistest.New(t)
+	store := NewRedisStore(client, "test:session:")
+	ctx := context.Background()
+
+	if err := store.Save(ctx, &Record{ID: "a", UserID: "u1"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	// 快到绝对过期时间的会话不能缩短索引的过期时间
+	if err := store.Save(ctx, &Record{ID: "b", UserID: "u1"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL("test:session:user:u1"); ttl != time.Hour {
+		t.Fatalf("expected index ttl 1h, got %s", ttl)
+	}
+	if err := store.Save(ctx, &Record{ID: "c", UserID: "u1"}, 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL("test:session:user:u1"); ttl != 2*time.Hour {
+		t.Fatalf("expected index ttl extended to 2h, got %s", ttl)
+	}
+
+	mr.FastForward(90 * time.Minute)
+	ids, err := store.UserSessions(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != "c" {
+		t.Fatalf("expected only session c, got %v", ids)
+	}
+}
+
+func TestSessionsExpiry(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	m, err := NewManager(&Config{Secret: "secret", IdleTimeoutSecond: 600, AbsoluteTimeoutSecond: 3600}, store, logtest.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.now = store.now
+	cl := &client{r: newTestEngine(t, m)}
+	cl.do(http.MethodPost, "/cart/apple")
+
+	// 滑动过期：每次访问都会延长
+	for i := 0; i < 5; i++ {
+		now = now.Add(9 * time.Minute)
+		if w := cl.do(http.MethodGet, "/cart"); w.Body.String() != "|apple|1" {
+			t.Fatalf("session should be kept alive by access, got %q", w.Body.String())
+		}
+	}
+	// 绝对过期
+	now = now.Add(16 * time.Minute)
+	if w := cl.do(http.MethodGet, "/cart"); w.Body.String() != "||0" {
+		t.Errorf("session should expire after absolute timeout, got %q", w.Body.String())
+	}
+
+	cl = &client{r: cl.r}
+	cl.do(http.MethodPost, "/cart/pear")
+	now = now.Add(11 * time.Minute)
+	if w := cl.do(http.MethodGet, "/cart"); w.Body.String() != "||0" {
+		t.Errorf("session should expire after idle timeout, got %q", w.Body.String())
+	}
+}
+
+func TestSessionsTamperedCookie(t *testing.T) {
+	m, err := NewManager(&Config{Secret: "secret"}, NewMemoryStore(), logtest.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := &client{r: newTestEngine(t, m)}
+	cl.do(http.MethodPost, "/cart/apple")
+	id, _, _ := strings.Cut(cl.cookie.Value, ".")
+	cl.cookie = &http.Cookie{Name: defaultCookieName, Value: id + ".forged"}
+	if w := cl.do(http.MethodGet, "/cart"); w.Body.String() != "||0" {
+		t.Errorf("tampered cookie should be rejected, got %q", w.Body.String())
+	}
+
+	// 加密的 cookie 被篡改时不能 panic
+	m, err = NewManager(&Config{Secret: "secret", EncryptionKey: "0123456789abcdef"}, NewMemoryStore(), logtest.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl = &client{r: newTestEngine(t, m)}
+	forged := []string{
+		"AAAA~AQ==~" + base64.StdEncoding.EncodeToString(make([]byte, 16)),
+		"AAAA~" + base64.StdEncoding.EncodeToString(make([]byte, 12)) + "~AQ==",
+		"not-encrypted",
+	}
+	for _, value := range forged {
+		cl.cookie = &http.Cookie{Name: defaultCookieName, Value: url.QueryEscape(value)}
+		if w := cl.do(http.MethodGet, "/cart"); w.Code != http.StatusOK || w.Body.String() != "||0" {
+			t.Errorf("tampered encrypted cookie %q should be rejected, got %d %q", value, w.Code, w.Body.String())
+		}
+	}
+
+	if _, err := NewManager(&Config{}, NewMemoryStore(), logtest.New()); err == nil {
+		t.Error("expected error without secret")
+	}
+}
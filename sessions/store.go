@@ -0,0 +1,32 @@
+package sessions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrNotFound 会话不存在或已过期
+var ErrNotFound = errors.New("session not found")
+
+// Record 保存在存储中的会话数据
+type Record struct {
+	ID         string                     `json:"id"`
+	UserID     string                     `json:"user_id,omitempty"`
+	Values     map[string]json.RawMessage `json:"values,omitempty"`
+	CreatedAt  time.Time                  `json:"created_at"`
+	LastAccess time.Time                  `json:"last_access"`
+}
+
+// Store 会话存储
+type Store interface {
+	// Load 读取会话，不存在时返回 ErrNotFound
+	Load(ctx context.Context, id string) (*Record, error)
+	// Save 保存会话，ttl 为会话剩余的有效期；有 UserID 时同时加入用户的会话索引
+	Save(ctx context.Context, record *Record, ttl time.Duration) error
+	// Delete 删除会话，同时从用户的会话索引中移除
+	Delete(ctx context.Context, id string) error
+	// UserSessions 返回用户所有有效会话的 ID
+	UserSessions(ctx context.Context, userID string) ([]string, error)
+}
@@ -0,0 +1,132 @@
+package signature
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/yangkushu/rum-go/utils"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderKeyID     = "X-Api-Key"
+	HeaderTimestamp = "X-Timestamp" // unix 秒
+	HeaderNonce     = "X-Nonce"
+	HeaderSignature = "X-Signature" // 十六进制的 HMAC-SHA256
+)
+
+// CanonicalString 生成待签名字符串，每一项占一行：
+// 请求方法、转义后的路径、按 key 和 value 排序的查询参数、body 的 sha256、时间戳、nonce
+func CanonicalString(method, path string, query url.Values, bodyHash, timestamp, nonce string) string {
+	if path == "" {
+		path = "/"
+	}
+	return strings.Join([]string{
+		strings.ToUpper(method),
+		path,
+		sortedQuery(query),
+		bodyHash,
+		timestamp,
+		nonce,
+	}, "\n")
+}
+
+func sortedQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			if b.Len() > 0 {
+				b.WriteByte('&')
+			}
+			b.WriteString(url.QueryEscape(k))
+			b.WriteByte('=')
+			b.WriteString(url.QueryEscape(v))
+		}
+	}
+	return b.String()
+}
+
+// BodyHash 返回 body 的十六进制 sha256，空 body 也会计算
+func BodyHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Compute 计算待签名字符串的签名
+func Compute(secret, canonical string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(canonical))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Equal 常量时间比较两个签名
+func Equal(a, b string) bool {
+	return hmac.Equal([]byte(a), []byte(b))
+}
+
+// SignRequest 给请求加上签名相关的请求头，会读取并还原 body
+func SignRequest(req *http.Request, keyID, secret string) error {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return err
+		}
+		_ = req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := utils.NewUUIDv7()
+	canonical := CanonicalString(req.Method, req.URL.EscapedPath(), req.URL.Query(), BodyHash(body), timestamp, nonce)
+
+	req.Header.Set(HeaderKeyID, keyID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderSignature, Compute(secret, canonical))
+	return nil
+}
+
+// Transport 给每个请求签名的 http.RoundTripper，重试时每次都会生成新的时间戳和 nonce
+type Transport struct {
+	KeyID  string
+	Secret string
+	Base   http.RoundTripper // 为空时使用 http.DefaultTransport
+}
+
+// NewTransport 创建签名 Transport
+func NewTransport(keyID, secret string, base http.RoundTripper) *Transport {
+	return &Transport{KeyID: keyID, Secret: secret, Base: base}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTripper 不能修改传入的请求
+	signed := req.Clone(req.Context())
+	if err := SignRequest(signed, t.KeyID, t.Secret); err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, err
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(signed)
+}
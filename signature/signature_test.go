@@ -0,0 +1,50 @@
+package signature
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalString(t *testing.T) {
+	query := url.Values{"b": {"2"}, "a": {"z", "1"}, "c d": {"&"}}
+	got := CanonicalString("post", "/v1/orders", query, BodyHash(nil), "1700000000", "n1")
+	want := strings.Join([]string{
+		"POST",
+		"/v1/orders",
+		"a=1&a=z&b=2&c+d=%26",
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		"1700000000",
+		"n1",
+	}, "\n")
+	if got != want {
+		t.Errorf("unexpected canonical string:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSignRequest(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPut, "http://example.com/a?y=1&x=2", strings.NewReader("body"))
+	if err := SignRequest(req, "k1", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	for _, header := range []string{HeaderKeyID, HeaderTimestamp, HeaderNonce, HeaderSignature} {
+		if req.Header.Get(header) == "" {
+			t.Errorf("expected header %s", header)
+		}
+	}
+	canonical := CanonicalString(http.MethodPut, "/a", url.Values{"x": {"2"}, "y": {"1"}}, BodyHash([]byte("body")),
+		req.Header.Get(HeaderTimestamp), req.Header.Get(HeaderNonce))
+	if !Equal(Compute("secret", canonical), req.Header.Get(HeaderSignature)) {
+		t.Error("signature does not match canonical string")
+	}
+
+	// body 需要还原，重试时可以通过 GetBody 重新读取
+	body, _ := req.GetBody()
+	buf := new(strings.Builder)
+	_, _ = io.Copy(buf, body)
+	if buf.String() != "body" {
+		t.Errorf("expected body restored, got %q", buf.String())
+	}
+}
@@ -0,0 +1,24 @@
+package tracing
+
+const (
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterMemory = "memory" // 保存在内存中，用于测试
+
+	SamplerAlwaysOn  = "always_on"
+	SamplerAlwaysOff = "always_off"
+	SamplerRatio     = "ratio"
+)
+
+// Config 链路追踪配置
+type Config struct {
+	Enabled     bool              `mapstructure:"enabled" yaml:"enabled"`           // 关闭时不设置全局的 TracerProvider，埋点不产生 span
+	ServiceName string            `mapstructure:"service_name" yaml:"service_name"` // 服务名称，默认 rum
+	Exporter    string            `mapstructure:"exporter" yaml:"exporter"`         // otlp、stdout、memory，默认 otlp
+	Endpoint    string            `mapstructure:"endpoint" yaml:"endpoint"`         // OTLP HTTP 地址，比如 otel-collector:4318，默认 localhost:4318
+	URLPath     string            `mapstructure:"url_path" yaml:"url_path"`         // OTLP HTTP 路径，默认 /v1/traces
+	Insecure    bool              `mapstructure:"insecure" yaml:"insecure"`         // 使用 HTTP 而不是 HTTPS 连接 OTLP
+	Headers     map[string]string `mapstructure:"headers" yaml:"headers"`           // OTLP 请求头，比如认证信息
+	Sampler     string            `mapstructure:"sampler" yaml:"sampler"`           // always_on、always_off、ratio，默认 ratio；都遵循上游的采样结果
+	SampleRatio float64           `mapstructure:"sample_ratio" yaml:"sample_ratio"` // ratio 的采样比例 0-1，0 表示默认值 1
+}
@@ -0,0 +1,63 @@
+package tracing
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// TraceIDKey gin.Context 中保存 trace ID 的 key
+const TraceIDKey = "trace_id"
+
+// Middleware gin 链路追踪中间件，从请求头中恢复上游的上下文，为每个请求创建 server span。
+// span 保存在 c.Request 的 context 中，handler 把它传给 redis、gorm、kafka 等调用即可串联。
+// 需要放在中间件链的最前面，后面的中间件才能记录 trace ID
+type Middleware struct {
+	options *options
+}
+
+func NewMiddleware(opts ...Option) *Middleware {
+	return &Middleware{options: newOptions(opts)}
+}
+
+func (m *Middleware) HandlerFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := m.options.textMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		route := c.FullPath()
+		name := c.Request.Method
+		if route != "" {
+			name += " " + route
+		}
+		ctx, span := m.options.tracer().Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Request.Method),
+				semconv.URLPath(c.Request.URL.Path),
+				semconv.ClientAddress(c.ClientIP()),
+				semconv.UserAgentOriginal(c.Request.UserAgent()),
+			),
+		)
+		defer span.End()
+		if route != "" {
+			span.SetAttributes(semconv.HTTPRoute(route))
+		}
+		if sc := span.SpanContext(); sc.IsValid() {
+			c.Set(TraceIDKey, sc.TraceID().String())
+		}
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if err := c.Errors.Last(); err != nil {
+			span.RecordError(err.Err)
+		}
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
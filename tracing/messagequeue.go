@@ -0,0 +1,175 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/yangkushu/rum-go/messagequeue"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// messageHeaderGetter 可以读取消息头的消息，比如 *messagequeue.KafKaMessage
+type messageHeaderGetter interface {
+	GetHeader(key string) string
+}
+
+// messageHeaderCarrier 把消息头适配为 propagation.TextMapCarrier
+type messageHeaderCarrier struct {
+	get     func(key string) string
+	headers map[string]string
+}
+
+func (c messageHeaderCarrier) Get(key string) string {
+	if c.get != nil {
+		return c.get(key)
+	}
+	return c.headers[key]
+}
+
+func (c messageHeaderCarrier) Set(key, value string) {
+	c.headers[key] = value
+}
+
+func (c messageHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.headers))
+	for key := range c.headers {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// MessageQueue 给消息队列加上链路追踪
+// 发布时创建 producer span，并把上下文写入消息头（traceparent）；消费时从消息头恢复上下文，创建 consumer span。
+// 处理函数中使用 MessageContext(message) 获取带 span 的 context
+type MessageQueue struct {
+	messagequeue.IMessageQueue
+	options *options
+}
+
+// WrapMessageQueue 包装消息队列，比如 messagequeue.NewKafka 的返回值
+func WrapMessageQueue(mq messagequeue.IMessageQueue, opts ...Option) *MessageQueue {
+	return &MessageQueue{IMessageQueue: mq, options: newOptions(opts)}
+}
+
+func (q *MessageQueue) Publish(topic messagequeue.Topic, message interface{}) error {
+	return q.PublishContext(context.Background(), topic, message)
+}
+
+// PublishContext 使用 ctx 中的 span 作为父 span 发布消息
+func (q *MessageQueue) PublishContext(ctx context.Context, topic messagequeue.Topic, message interface{}) error {
+	ctx, span := q.options.tracer().Start(ctx, string(topic)+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(semconv.MessagingSystemKafka, semconv.MessagingOperationPublish, semconv.MessagingDestinationName(string(topic))),
+	)
+	defer span.End()
+
+	msg, err := headerMessage(message)
+	if err != nil {
+		recordError(span, err)
+		return err
+	}
+	q.options.textMapPropagator().Inject(ctx, messageHeaderCarrier{headers: msg.GetHeaders()})
+	if err := messagequeue.PublishContext(ctx, q.IMessageQueue, topic, msg); err != nil {
+		recordError(span, err)
+		return err
+	}
+	return nil
+}
+
+// headerMessage 把消息转换为带消息头的消息，不修改原来的消息
+func headerMessage(message interface{}) (*messagequeue.HeaderMessage, error) {
+	switch msg := message.(type) {
+	case string:
+		return messagequeue.NewHeaderMessage(nil, []byte(msg)), nil
+	case []byte:
+		return messagequeue.NewHeaderMessage(nil, msg), nil
+	case messagequeue.IHeaderMessage:
+		data, err := msg.GetMessageData()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get message data:%w", err)
+		}
+		result := messagequeue.NewHeaderMessage(msg.GetKey(), data)
+		for key, value := range msg.GetHeaders() {
+			result.SetHeader(key, value)
+		}
+		return result, nil
+	case messagequeue.IKeyMessage:
+		data, err := msg.GetMessageData()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get message data:%w", err)
+		}
+		return messagequeue.NewHeaderMessage(msg.GetKey(), data), nil
+	default:
+		data, err := json.Marshal(message)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal message to JSON:%w", err)
+		}
+		return messagequeue.NewHeaderMessage(nil, data), nil
+	}
+}
+
+func (q *MessageQueue) Subscribe(topic messagequeue.Topic, groupId string, handler messagequeue.IMessageSubscriber) error {
+	return q.IMessageQueue.Subscribe(topic, groupId, &tracedSubscriber{
+		IMessageSubscriber: handler,
+		queue:              q,
+		topic:              string(topic),
+		group:              groupId,
+	})
+}
+
+type tracedSubscriber struct {
+	messagequeue.IMessageSubscriber
+	queue *MessageQueue
+	topic string
+	group string
+}
+
+func (s *tracedSubscriber) HandleMessage(message messagequeue.IMessage) bool {
+	ctx := context.Background()
+	if getter, ok := message.(messageHeaderGetter); ok {
+		ctx = s.queue.options.textMapPropagator().Extract(ctx, messageHeaderCarrier{get: getter.GetHeader})
+	}
+	ctx, span := s.queue.options.tracer().Start(ctx, s.topic+" receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(semconv.MessagingSystemKafka, semconv.MessagingOperationReceive,
+			semconv.MessagingDestinationName(s.topic), semconv.MessagingKafkaConsumerGroup(s.group)),
+	)
+	defer span.End()
+	commit := s.IMessageSubscriber.HandleMessage(&Message{IMessage: message, ctx: ctx})
+	span.SetAttributes(attribute.Bool("messaging.commit", commit))
+	return commit
+}
+
+// Message 带 context 的消费消息，包装原来的消息
+type Message struct {
+	messagequeue.IMessage
+	ctx context.Context
+}
+
+// Context 返回带 consumer span 的 context
+func (m *Message) Context() context.Context {
+	return m.ctx
+}
+
+// Unwrap 返回原来的消息
+func (m *Message) Unwrap() messagequeue.IMessage {
+	return m.IMessage
+}
+
+// GetHeader 读取原来消息的消息头
+func (m *Message) GetHeader(key string) string {
+	if getter, ok := m.IMessage.(messageHeaderGetter); ok {
+		return getter.GetHeader(key)
+	}
+	return ""
+}
+
+// MessageContext 返回消费消息时的 context，消息没有经过 MessageQueue 时返回 context.Background()
+func MessageContext(message messagequeue.IMessage) context.Context {
+	if m, ok := message.(*Message); ok {
+		return m.ctx
+	}
+	return context.Background()
+}
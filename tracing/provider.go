@@ -0,0 +1,141 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"github.com/yangkushu/rum-go/iface"
+	"github.com/yangkushu/rum-go/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"strings"
+)
+
+const (
+	defaultServiceName  = "rum"
+	instrumentationName = "github.com/yangkushu/rum-go/tracing"
+)
+
+// Provider 链路追踪，创建后设置为全局的 TracerProvider 和 W3C traceparent、baggage 传播，
+// 本包的埋点默认使用全局的设置。退出前需要调用 Shutdown 发送剩余的 span
+type Provider struct {
+	provider trace.TracerProvider
+	sdk      *sdktrace.TracerProvider
+	memory   *tracetest.InMemoryExporter
+}
+
+// NewProvider 根据配置创建链路追踪，未开启时返回不产生 span 的 Provider
+func NewProvider(config *Config, logger iface.ILogger) (*Provider, error) {
+	p := &Provider{}
+	if config == nil || !config.Enabled {
+		p.provider = noop.NewTracerProvider()
+		return p, nil
+	}
+
+	sampler, err := newSampler(config)
+	if err != nil {
+		return nil, err
+	}
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("tracing resource: %w", err)
+	}
+	options := []sdktrace.TracerProviderOption{sdktrace.WithSampler(sampler), sdktrace.WithResource(res)}
+
+	switch strings.ToLower(config.Exporter) {
+	case "", ExporterOTLP:
+		httpOptions := []otlptracehttp.Option{}
+		if config.Endpoint != "" {
+			httpOptions = append(httpOptions, otlptracehttp.WithEndpoint(config.Endpoint))
+		}
+		if config.URLPath != "" {
+			httpOptions = append(httpOptions, otlptracehttp.WithURLPath(config.URLPath))
+		}
+		if config.Insecure {
+			httpOptions = append(httpOptions, otlptracehttp.WithInsecure())
+		}
+		if len(config.Headers) > 0 {
+			httpOptions = append(httpOptions, otlptracehttp.WithHeaders(config.Headers))
+		}
+		// 不会连接 collector，发送失败时由 otel 的错误处理记录
+		exporter, err := otlptracehttp.New(context.Background(), httpOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("tracing otlp exporter: %w", err)
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	case ExporterStdout:
+		exporter, err := stdouttrace.New()
+		if err != nil {
+			return nil, fmt.Errorf("tracing stdout exporter: %w", err)
+		}
+		options = append(options, sdktrace.WithSyncer(exporter))
+	case ExporterMemory:
+		p.memory = tracetest.NewInMemoryExporter()
+		options = append(options, sdktrace.WithSyncer(p.memory))
+	default:
+		return nil, fmt.Errorf("unsupported tracing exporter %q", config.Exporter)
+	}
+
+	p.sdk = sdktrace.NewTracerProvider(options...)
+	p.provider = p.sdk
+	otel.SetTracerProvider(p.sdk)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Warn("tracing error", log.ErrorField(err))
+	}))
+	return p, nil
+}
+
+func newSampler(config *Config) (sdktrace.Sampler, error) {
+	switch strings.ToLower(config.Sampler) {
+	case SamplerAlwaysOn:
+		return sdktrace.ParentBased(sdktrace.AlwaysSample()), nil
+	case SamplerAlwaysOff:
+		return sdktrace.ParentBased(sdktrace.NeverSample()), nil
+	case "", SamplerRatio:
+		ratio := config.SampleRatio
+		if ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("tracing sample ratio must be between 0 and 1, got %v", ratio)
+		}
+		if ratio == 0 {
+			ratio = 1
+		}
+		return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio)), nil
+	default:
+		return nil, fmt.Errorf("unsupported tracing sampler %q", config.Sampler)
+	}
+}
+
+// TracerProvider 返回 TracerProvider，可以通过 WithTracerProvider 传给埋点
+func (p *Provider) TracerProvider() trace.TracerProvider {
+	return p.provider
+}
+
+// Tracer 创建业务代码使用的 Tracer
+func (p *Provider) Tracer(name string) trace.Tracer {
+	return p.provider.Tracer(name)
+}
+
+// MemoryExporter 返回内存中的 span，只有 exporter 为 memory 时不为空
+func (p *Provider) MemoryExporter() *tracetest.InMemoryExporter {
+	return p.memory
+}
+
+// Shutdown 发送剩余的 span 并停止
+func (p *Provider) Shutdown(ctx context.Context) error {
+	if p.sdk == nil {
+		return nil
+	}
+	return p.sdk.Shutdown(ctx)
+}
@@ -0,0 +1,56 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	goRedis "github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RedisHook redis 链路追踪，每个命令和 pipeline 创建一个 client span。
+// 只记录命令名称，不记录参数，避免把业务数据写入 span。
+// 通过 redis.NewClientWithHook(config, []goRedis.Hook{tracing.NewRedisHook()}) 使用
+type RedisHook struct {
+	options *options
+}
+
+func NewRedisHook(opts ...Option) *RedisHook {
+	return &RedisHook{options: newOptions(opts)}
+}
+
+func (h *RedisHook) DialHook(next goRedis.DialHook) goRedis.DialHook {
+	return next
+}
+
+func (h *RedisHook) ProcessHook(next goRedis.ProcessHook) goRedis.ProcessHook {
+	return func(ctx context.Context, cmd goRedis.Cmder) error {
+		ctx, span := h.options.tracer().Start(ctx, "redis "+cmd.Name(),
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemRedis, semconv.DBOperation(cmd.Name())),
+		)
+		defer span.End()
+		err := next(ctx, cmd)
+		if err != nil && !errors.Is(err, goRedis.Nil) {
+			recordError(span, err)
+		}
+		return err
+	}
+}
+
+func (h *RedisHook) ProcessPipelineHook(next goRedis.ProcessPipelineHook) goRedis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []goRedis.Cmder) error {
+		ctx, span := h.options.tracer().Start(ctx, "redis pipeline",
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemRedis, semconv.DBOperation("pipeline"),
+				attribute.Int("db.redis.pipeline_length", len(cmds))),
+		)
+		defer span.End()
+		err := next(ctx, cmds)
+		if err != nil && !errors.Is(err, goRedis.Nil) {
+			recordError(span, err)
+		}
+		return err
+	}
+}
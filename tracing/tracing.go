@@ -0,0 +1,115 @@
+package tracing
+
+import (
+	"context"
+	"github.com/yangkushu/rum-go/iface"
+	"github.com/yangkushu/rum-go/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type options struct {
+	provider   trace.TracerProvider
+	propagator propagation.TextMapPropagator
+}
+
+// Option 埋点的配置函数类型
+type Option func(*options)
+
+// WithTracerProvider 设置 TracerProvider，默认使用全局的
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(o *options) {
+		o.provider = provider
+	}
+}
+
+// WithPropagator 设置跨服务传递上下文的方式，默认使用全局的
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return func(o *options) {
+		o.propagator = propagator
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// tracer 在使用时才取全局的 TracerProvider，埋点可以在 NewProvider 之前创建
+func (o *options) tracer() trace.Tracer {
+	provider := o.provider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return provider.Tracer(instrumentationName)
+}
+
+func (o *options) textMapPropagator() propagation.TextMapPropagator {
+	if o.propagator != nil {
+		return o.propagator
+	}
+	return otel.GetTextMapPropagator()
+}
+
+// TraceID 返回 ctx 中的 trace ID，没有时返回空字符串
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// LogFields 返回 ctx 中的 trace_id 和 span_id 日志字段，没有 span 时返回空
+func LogFields(ctx context.Context) []iface.Field {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return nil
+	}
+	return []iface.Field{log.String("trace_id", sc.TraceID().String()), log.String("span_id", sc.SpanID().String())}
+}
+
+// Logger 返回一个在每条日志中加上 ctx 的 trace_id 和 span_id 的 logger
+func Logger(ctx context.Context, logger iface.ILogger) iface.ILogger {
+	fields := LogFields(ctx)
+	if len(fields) == 0 {
+		return logger
+	}
+	return &traceLogger{ILogger: logger, fields: fields}
+}
+
+type traceLogger struct {
+	iface.ILogger
+	fields []iface.Field
+}
+
+func (l *traceLogger) with(fields []iface.Field) []iface.Field {
+	return append(append(make([]iface.Field, 0, len(fields)+len(l.fields)), fields...), l.fields...)
+}
+
+func (l *traceLogger) Info(msg string, fields ...iface.Field) {
+	l.ILogger.Info(msg, l.with(fields)...)
+}
+
+func (l *traceLogger) Warn(msg string, fields ...iface.Field) {
+	l.ILogger.Warn(msg, l.with(fields)...)
+}
+
+func (l *traceLogger) Error(msg string, fields ...iface.Field) {
+	l.ILogger.Error(msg, l.with(fields)...)
+}
+
+func (l *traceLogger) Debug(msg string, fields ...iface.Field) {
+	l.ILogger.Debug(msg, l.with(fields)...)
+}
+
+// recordError 记录错误并把 span 标记为失败
+func recordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
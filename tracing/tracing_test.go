@@ -0,0 +1,274 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	goRedis "github.com/redis/go-redis/v9"
+	"github.com/yangkushu/rum-go/log/logtest"
+	"github.com/yangkushu/rum-go/messagequeue"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func newTestProvider(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	p, err := NewProvider(&Config{Enabled: true, Exporter: ExporterMemory}, logtest.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = p.Shutdown(context.Background()) })
+	return p.MemoryExporter()
+}
+
+func findSpan(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	t.Helper()
+	for _, span := range spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	t.Fatalf("span %q not found in %d spans", name, len(spans))
+	return tracetest.SpanStub{}
+}
+
+func spanAttribute(span tracetest.SpanStub, key string) string {
+	for _, kv := range span.Attributes {
+		if string(kv.Key) == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestProviderConfig(t *testing.T) {
+	p, err := NewProvider(&Config{}, logtest.New())
+	if err != nil || p.MemoryExporter() != nil {
+		t.Fatal("expected disabled provider")
+	}
+	if _, span := p.Tracer("test").Start(context.Background(), "noop"); span.SpanContext().IsValid() {
+		t.Error("expected no span when disabled")
+	}
+	if _, err := NewProvider(&Config{Enabled: true, Exporter: "jaeger"}, logtest.New()); err == nil {
+		t.Error("expected unsupported exporter error")
+	}
+	if _, err := NewProvider(&Config{Enabled: true, Exporter: ExporterMemory, SampleRatio: 2}, logtest.New()); err == nil {
+		t.Error("expected sample ratio error")
+	}
+}
+
+func TestGinAndTransport(t *testing.T) {
+	exporter := newTestProvider(t)
+	var downstreamTraceParent string
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downstreamTraceParent = r.Header.Get("traceparent")
+	}))
+	defer downstream.Close()
+	client := &http.Client{Transport: NewTransport(nil)}
+
+	logger := logtest.New()
+	r := gin.New()
+	r.Use(NewMiddleware().HandlerFunc())
+	r.GET("/users/:id", func(c *gin.Context) {
+		req, _ := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, downstream.URL+"/profile?token=secret", nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+		Logger(c.Request.Context(), logger).Info("loaded user")
+		c.String(http.StatusOK, c.GetString(TraceIDKey))
+	})
+	r.GET("/fail", func(c *gin.Context) {
+		_ = c.Error(errors.New("boom"))
+		c.Status(http.StatusInternalServerError)
+	})
+
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set("traceparent", parent)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Body.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected upstream trace id, got %q", w.Body.String())
+	}
+
+	spans := exporter.GetSpans()
+	server := findSpan(t, spans, "GET /users/:id")
+	if server.SpanKind != trace.SpanKindServer || server.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("unexpected server span %v %v", server.SpanKind, server.Parent.SpanID())
+	}
+	if spanAttribute(server, "http.route") != "/users/:id" || spanAttribute(server, "http.response.status_code") != "200" {
+		t.Errorf("unexpected server attributes %v", server.Attributes)
+	}
+	clientSpan := findSpan(t, spans, "HTTP GET")
+	if clientSpan.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Error("expected client span to be a child of the server span")
+	}
+	if spanAttribute(clientSpan, "url.full") != downstream.URL+"/profile" {
+		t.Errorf("expected url without query, got %s", spanAttribute(clientSpan, "url.full"))
+	}
+	if downstreamTraceParent == "" || downstreamTraceParent[3:35] != server.SpanContext.TraceID().String() {
+		t.Errorf("expected trace context propagated downstream, got %q", downstreamTraceParent)
+	}
+	if !logger.HasField("loaded user", "trace_id", server.SpanContext.TraceID().String()) {
+		t.Error("expected trace id in log")
+	}
+
+	exporter.Reset()
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", nil))
+	fail := findSpan(t, exporter.GetSpans(), "GET /fail")
+	if fail.Status.Code != codes.Error || len(fail.Events) == 0 {
+		t.Errorf("expected error span, got %v", fail.Status)
+	}
+}
+
+func TestRedisHook(t *testing.T) {
+	exporter := newTestProvider(t)
+	mr := miniredis.RunT(t)
+	client := goRedis.NewClient(&goRedis.Options{Addr: mr.Addr()})
+	client.AddHook(NewRedisHook())
+	ctx := context.Background()
+
+	if err := client.Get(ctx, "missing").Err(); !errors.Is(err, goRedis.Nil) {
+		t.Fatal(err)
+	}
+	pipe := client.Pipeline()
+	pipe.Set(ctx, "a", "1", 0)
+	pipe.Incr(ctx, "b")
+	if _, err := pipe.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.GetSpans()
+	get := findSpan(t, spans, "redis get")
+	if get.Status.Code == codes.Error || spanAttribute(get, "db.system") != "redis" {
+		t.Errorf("unexpected get span %v %v", get.Status, get.Attributes)
+	}
+	if pipeline := findSpan(t, spans, "redis pipeline"); spanAttribute(pipeline, "db.redis.pipeline_length") != "2" {
+		t.Errorf("unexpected pipeline attributes %v", pipeline.Attributes)
+	}
+}
+
+type testMessage struct {
+	data    []byte
+	headers map[string]string
+}
+
+func (m *testMessage) GetMessageData() []byte      { return m.data }
+func (m *testMessage) GetTopic() string            { return "orders" }
+func (m *testMessage) GetHeader(key string) string { return m.headers[key] }
+
+type testQueue struct {
+	published []interface{}
+	handler   messagequeue.IMessageSubscriber
+}
+
+func (q *testQueue) Publish(topic messagequeue.Topic, message interface{}) error {
+	q.published = append(q.published, message)
+	return nil
+}
+
+func (q *testQueue) Subscribe(topic messagequeue.Topic, groupId string, handler messagequeue.IMessageSubscriber) error {
+	q.handler = handler
+	return nil
+}
+
+func (q *testQueue) Close() error { return nil }
+
+type testSubscriber struct {
+	ctx context.Context
+}
+
+func (s *testSubscriber) HandleMessage(message messagequeue.IMessage) bool {
+	s.ctx = MessageContext(message)
+	return true
+}
+
+func (s *testSubscriber) HandleError(message messagequeue.IMessage, err error) {}
+
+func TestMessageQueue(t *testing.T) {
+	exporter := newTestProvider(t)
+	inner := &testQueue{}
+	mq := WrapMessageQueue(inner)
+	subscriber := &testSubscriber{}
+	if err := mq.Subscribe("orders", "billing", subscriber); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	original := messagequeue.NewHeaderMessage([]byte("k"), []byte(`{"id":1}`)).SetHeader("x-request-id", "r1")
+	if err := messagequeue.PublishContext(ctx, mq, "orders", original); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+	if _, found := original.GetHeaders()["traceparent"]; found {
+		t.Error("expected original message not to be modified")
+	}
+	sent := inner.published[0].(messagequeue.IHeaderMessage)
+	headers := sent.GetHeaders()
+	if headers["x-request-id"] != "r1" || headers["traceparent"] == "" || string(sent.GetKey()) != "k" {
+		t.Fatalf("unexpected published headers %v", headers)
+	}
+
+	if !inner.handler.HandleMessage(&testMessage{data: []byte(`{"id":1}`), headers: headers}) {
+		t.Error("expected commit")
+	}
+	if TraceID(subscriber.ctx) != parent.SpanContext().TraceID().String() {
+		t.Error("expected consumer context to continue the producer trace")
+	}
+
+	spans := exporter.GetSpans()
+	publish := findSpan(t, spans, "orders publish")
+	receive := findSpan(t, spans, "orders receive")
+	if receive.Parent.SpanID() != publish.SpanContext.SpanID() || spanAttribute(receive, "messaging.kafka.consumer.group") != "billing" {
+		t.Errorf("unexpected receive span %v %v", receive.Parent, receive.Attributes)
+	}
+}
+
+type gormUser struct {
+	ID   int64
+	Name string
+}
+
+func TestGormPlugin(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(NewGormPlugin(WithTracerProvider(provider))); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	var user gormUser
+	db.WithContext(ctx).Where("name = ?", "secret").First(&user)
+	parent.End()
+
+	span := findSpan(t, exporter.GetSpans(), "gorm query")
+	if span.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Error("expected gorm span to be a child of the request span")
+	}
+	if spanAttribute(span, "db.system") != "postgresql" || spanAttribute(span, "db.sql.table") != "gorm_users" {
+		t.Errorf("unexpected gorm attributes %v", span.Attributes)
+	}
+	if statement := spanAttribute(span, "db.statement"); statement != `SELECT * FROM "gorm_users" WHERE name = $1 ORDER BY "gorm_users"."id" LIMIT $2` {
+		t.Errorf("unexpected statement %q", statement)
+	}
+}
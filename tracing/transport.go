@@ -0,0 +1,63 @@
+package tracing
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// Transport HTTP 客户端链路追踪，每个请求创建一个 client span，并把上下文写入请求头。
+// 请求需要带上 context（http.NewRequestWithContext）才能串联到调用方的 span
+type Transport struct {
+	base       http.RoundTripper
+	name       string
+	attributes []attribute.KeyValue
+	options    *options
+}
+
+// NewTransport 包装 base，base 为空时使用 http.DefaultTransport
+func NewTransport(base http.RoundTripper, opts ...Option) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{base: base, name: "HTTP", options: newOptions(opts)}
+}
+
+// NewElasticsearchTransport 用于 elasticsearch 客户端，通过 elasticsearch.WithTransport 使用
+func NewElasticsearchTransport(base http.RoundTripper, opts ...Option) *Transport {
+	t := NewTransport(base, opts...)
+	t.name = "elasticsearch"
+	t.attributes = []attribute.KeyValue{semconv.DBSystemElasticsearch}
+	return t
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// 不记录 query 和用户信息，避免泄露参数和密码
+	url := req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+	ctx, span := t.options.tracer().Start(req.Context(), t.name+" "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(t.attributes...),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.URLFull(url),
+			semconv.ServerAddress(req.URL.Hostname()),
+		),
+	)
+	defer span.End()
+
+	req = req.Clone(ctx)
+	t.options.textMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+	}
+	return resp, nil
+}
@@ -66,7 +66,10 @@ func AesDecrypt(encryptedData string, key []byte) (string, error) {
 		return "", err
 	}
 
-	aesgcm.Seal(nil, iv, nil, nil) // Only to set the tag size
+	// 长度不对时 GCM 会 panic，密文可能来自客户端
+	if len(iv) != aesgcm.NonceSize() {
+		return "", fmt.Errorf("invalid nonce length %d", len(iv))
+	}
 	ciphertext := append(enc, authTag...)
 
 	plaintext, err := aesgcm.Open(nil, iv, ciphertext, nil)
@@ -4,8 +4,11 @@ import (
 	"github.com/google/wire"
 	"github.com/pkg/errors"
 	"github.com/yangkushu/rum-go/config"
+	"github.com/yangkushu/rum-go/httpclient"
 	"github.com/yangkushu/rum-go/log"
 	"github.com/yangkushu/rum-go/postgres"
+	"github.com/yangkushu/rum-go/tracing"
+	"net/http"
 )
 
 func ProvideConfig() (*Config, error) {
@@ -41,3 +44,25 @@ var PostgresWithOptionSet = wire.NewSet(
 func ProvideDefaultPostgresOptions() []postgres.Option {
 	return []postgres.Option{}
 }
+
+// TracingSet 提供链路追踪，依赖日志
+var TracingSet = wire.NewSet(
+	wire.FieldsOf(new(*Config), "tracing"),
+	tracing.NewProvider,
+)
+
+// HTTPClientSet 提供上游 HTTP 客户端，依赖日志（默认开启链路追踪）
+var HTTPClientSet = wire.NewSet(
+	wire.FieldsOf(new(*Config), "HTTPClient"),
+	httpclient.NewClients,
+	ProvideDefaultHTTPClientOptions,
+)
+
+// ProvideDefaultHTTPClientOptions 提供默认选项，每次请求创建 client span 并传递 trace 上下文
+func ProvideDefaultHTTPClientOptions() []httpclient.Option {
+	return []httpclient.Option{
+		httpclient.WithTransportWrapper(func(transport http.RoundTripper) http.RoundTripper {
+			return tracing.NewTransport(transport)
+		}),
+	}
+}